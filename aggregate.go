@@ -0,0 +1,80 @@
+package treport
+
+import (
+	"context"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+)
+
+// series returns the last scanned commit and the results of the plugins for all commits in commit order.
+// The last commit is nil if no commit has the results.
+func (c *resultCollector) series(plugins []string) (*CommitReport, map[string]*treportproto.Series) {
+	commits := c.sortedCommits()
+	series := map[string]*treportproto.Series{}
+	for _, plugin := range plugins {
+		series[plugin] = &treportproto.Series{}
+	}
+	if len(commits) == 0 {
+		return nil, series
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, commit := range commits {
+		for _, plugin := range plugins {
+			if result, exists := commit.Results[plugin]; exists && result.response != nil {
+				series[plugin].Results = append(series[plugin].Results, result.response)
+			}
+		}
+	}
+	return commits[len(commits)-1], series
+}
+
+// aggregate passes the plugin the results of the upstream plugins for all commits scanned by the walk,
+// and records its result for the last commit. The steps it depends on have finished the walk before it.
+func (s *Scanner) aggregate(ctx context.Context, plg *Plugin, repo *PipelineRepository, progress *pluginProgress) (e error) {
+	last, series := repo.results.series(plg.depNames())
+	if last == nil {
+		progress.started(0)
+		return nil
+	}
+	progress.started(1)
+	scanctx := &ScanContext{
+		Commit: last.Commit,
+		// the plugin reduces the results, so the files of the commit aren't passed.
+		Snapshot:     &Snapshot{Hash: last.Commit.TreeHash},
+		Repository:   repo.Repository,
+		Branch:       repo.scanBranch,
+		TopoIndex:    last.TopoIndex,
+		Data:         map[string]*treportproto.ScanResponse{},
+		pluginToType: map[string]string{},
+		series:       series,
+	}
+	ctx, span := startCommitSpan(ctx, plg, scanctx)
+	defer func() { endSpan(span, e) }()
+	start := time.Now()
+	timing := &ScanTiming{}
+	var res *treportproto.ScanResponse
+	if err := plg.Retry.do(ctx, func() error {
+		client, err := plg.client()
+		if err != nil {
+			return err
+		}
+		res, err = client.scan(ctx, scanctx, plg.scanOptions(""), timing)
+		return err
+	}); err != nil {
+		if isPluginError(err, PluginErrorSkipCommit) {
+			loggerFrom(ctx).Warn("skipped aggregation", "plugin", plg.Name, "repo", repo.cfg.identity(), "error", err)
+			progress.commitScanned(scanctx, false, time.Since(start), timing)
+			return nil
+		}
+		return errors.Wrapf(err, "failed to aggregate by %s", plg.Name)
+	}
+	for _, warning := range res.Warnings {
+		loggerFrom(ctx).Warn(warning, "plugin", plg.Name, "commit", scanctx.Commit.Hash)
+	}
+	repo.results.record(plg.Name, scanctx)
+	progress.commitScanned(scanctx, false, time.Since(start), timing)
+	return nil
+}
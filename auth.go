@@ -0,0 +1,213 @@
+package treport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/goccy/go-yaml"
+)
+
+type AuthSource string
+
+const (
+	// AuthEnv reads the credentials from the environment variables of user and password ( default ).
+	AuthEnv AuthSource = "env"
+	// AuthNetrc reads the credentials of the host from the netrc file at $NETRC or ~/.netrc.
+	AuthNetrc AuthSource = "netrc"
+	// AuthCredentialHelper asks the credentials to the git credential helper by `git credential fill`.
+	AuthCredentialHelper AuthSource = "credentialHelper"
+)
+
+const credentialHelperTimeout = 30 * time.Second
+
+func (s AuthSource) valid() bool {
+	switch s {
+	case "", AuthEnv, AuthNetrc, AuthCredentialHelper:
+		return true
+	}
+	return false
+}
+
+type authDefinition struct {
+	UserEnv     string     `yaml:"user"`
+	PasswordEnv string     `yaml:"password"`
+	Source      AuthSource `yaml:"source"`
+}
+
+func (c *AuthConfig) UnmarshalYAML(b []byte) error {
+	var profile string
+	if err := yaml.Unmarshal(b, &profile); err == nil {
+		c.Profile = profile
+		return nil
+	}
+	var v authDefinition
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	c.UserEnv = v.UserEnv
+	c.PasswordEnv = v.PasswordEnv
+	c.Source = v.Source
+	return nil
+}
+
+func (c *AuthConfig) MarshalYAML() (interface{}, error) {
+	if c.Profile != "" {
+		return c.Profile, nil
+	}
+	return &authDefinition{UserEnv: c.UserEnv, PasswordEnv: c.PasswordEnv, Source: c.Source}, nil
+}
+
+// resolveAuthProfiles replaces the auth written as the profile name by the profile.
+// The unknown profiles are left as they are and reported by the validation.
+func (c *Config) resolveAuthProfiles() {
+	resolve := func(auth *AuthConfig) *AuthConfig {
+		if auth == nil || auth.Profile == "" {
+			return auth
+		}
+		if profile, exists := c.Auth[auth.Profile]; exists && profile != nil && profile.Profile == "" {
+			return profile
+		}
+		return auth
+	}
+	if c.Defaults != nil {
+		c.Defaults.Auth = resolve(c.Defaults.Auth)
+	}
+	if c.Plugin != nil {
+		for _, repoCfgs := range [][]*RepositoryConfig{c.Plugin.Scanner, c.Plugin.Storer} {
+			for _, repoCfg := range repoCfgs {
+				repoCfg.Auth = resolve(repoCfg.Auth)
+			}
+		}
+	}
+	for _, pipelineCfg := range c.Pipelines {
+		for _, repoCfg := range pipelineCfg.Repository {
+			repoCfg.Auth = resolve(repoCfg.Auth)
+		}
+	}
+}
+
+// netrcPath returns the path of the netrc file. It's _netrc on Windows as git does.
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcAuth returns the credentials of the host of the repository url in the netrc file.
+// The default entry is used if the host isn't found.
+func netrcAuth(repoURL string) *http.BasicAuth {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil
+	}
+	content, err := ioutil.ReadFile(netrcPath())
+	if err != nil {
+		return nil
+	}
+	return parseNetrc(content, u.Hostname())
+}
+
+func parseNetrc(content []byte, host string) *http.BasicAuth {
+	var found, fallback, cur *http.BasicAuth
+	fields := strings.Fields(string(content))
+	for i := 0; i < len(fields); i++ {
+		token := fields[i]
+		switch token {
+		case "default":
+			cur = nil
+			if fallback == nil {
+				fallback = &http.BasicAuth{}
+				cur = fallback
+			}
+			continue
+		case "macdef":
+			// the macro continues until the empty line which Fields drops, so the rest isn't parsed.
+			i = len(fields)
+			continue
+		}
+		if i+1 >= len(fields) {
+			break
+		}
+		i++
+		value := fields[i]
+		switch token {
+		case "machine":
+			cur = nil
+			if value == host && found == nil {
+				found = &http.BasicAuth{}
+				cur = found
+			}
+		case "login":
+			if cur != nil {
+				cur.Username = value
+			}
+		case "password":
+			if cur != nil {
+				cur.Password = value
+			}
+		}
+	}
+	for _, auth := range []*http.BasicAuth{found, fallback} {
+		if auth != nil && auth.Username != "" && auth.Password != "" {
+			return auth
+		}
+	}
+	return nil
+}
+
+// credentialHelperAuth asks the credentials of the repository url to the git credential helper.
+// It doesn't prompt on the terminal, so it returns nil if the helper doesn't have the credentials.
+func credentialHelperAuth(repoURL string) *http.BasicAuth {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf(
+		"protocol=%s\nhost=%s\npath=%s\n\n", u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"),
+	))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	auth := &http.BasicAuth{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			auth.Username = kv[1]
+		case "password":
+			auth.Password = kv[1]
+		}
+	}
+	if auth.Username == "" || auth.Password == "" {
+		return nil
+	}
+	return auth
+}
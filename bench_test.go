@@ -0,0 +1,145 @@
+package treport_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/treporttest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var benchRepositoryOptions = &treporttest.RepositoryOptions{
+	Commits:          50,
+	Files:            1000,
+	ChangesPerCommit: 20,
+	FileSize:         256,
+}
+
+func newBenchRepository(b *testing.B) (*git.Repository, *treport.Repository, func()) {
+	b.Helper()
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		b.Fatal(err)
+	}
+	gitRepo, err := treporttest.GenerateRepository(dir, benchRepositoryOptions)
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatalf("%+v", err)
+	}
+	repo, err := treport.NewRepository(context.Background(), dir, &treport.RepositoryConfig{Path: dir})
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatalf("%+v", err)
+	}
+	return gitRepo, repo, func() { os.RemoveAll(dir) }
+}
+
+func BenchmarkAllCommits(b *testing.B) {
+	_, repo, cleanup := newBenchRepository(b)
+	defer cleanup()
+	for _, workers := range []int{0, 4} {
+		workers := workers
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				opt := &treport.WalkOptions{IncludeRoot: true, DiffWorkers: workers}
+				if err := repo.AllCommits(context.Background(), opt, func(*treport.ScanContext) error {
+					return nil
+				}); err != nil {
+					b.Fatalf("%+v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDiffConversion(b *testing.B) {
+	gitRepo, _, cleanup := newBenchRepository(b)
+	defer cleanup()
+	head, err := gitRepo.Head()
+	if err != nil {
+		b.Fatal(err)
+	}
+	commit, err := gitRepo.CommitObject(head.Hash())
+	if err != nil {
+		b.Fatal(err)
+	}
+	var trees []*object.Tree
+	for {
+		tree, err := commit.Tree()
+		if err != nil {
+			b.Fatal(err)
+		}
+		trees = append(trees, tree)
+		if commit.NumParents() == 0 {
+			break
+		}
+		if commit, err = commit.Parent(0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx := i % (len(trees) - 1)
+		if _, err := treport.ConvertDiff(context.Background(), trees[idx+1], trees[idx]); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+}
+
+type benchScanner struct{}
+
+func (s *benchScanner) Scan(ctx *treport.ScanContext) (*treport.Response, error) {
+	return treport.ToResponse(wrapperspb.Int64(int64(len(ctx.Snapshot.Entries))))
+}
+
+// BenchmarkPluginRPC measures the overhead of Scan RPC with the snapshot and the changes of each commit.
+// The plugin is served in the process, so the time of the process boundary isn't included.
+func BenchmarkPluginRPC(b *testing.B) {
+	_, repo, cleanup := newBenchRepository(b)
+	defer cleanup()
+	var scanctxs []*treport.ScanContext
+	if err := repo.AllCommits(context.Background(), &treport.WalkOptions{IncludeRoot: true}, func(scanctx *treport.ScanContext) error {
+		scanctxs = append(scanctxs, scanctx)
+		return nil
+	}); err != nil {
+		b.Fatalf("%+v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	if err := (&treport.ScannerPlugin{Scanner: &benchScanner{}}).GRPCServer(nil, server); err != nil {
+		b.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	c, err := (&treport.ScannerPlugin{}).GRPCClient(context.Background(), nil, conn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	client := c.(*treport.Client)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Scan(context.Background(), scanctxs[i%len(scanctxs)]); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package treport
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/utils/binary"
+)
+
+// binaryCache caches whether the blobs are binary by the hash, since the same blobs appear in many snapshots.
+type binaryCache struct {
+	mu    sync.Mutex
+	blobs map[string]bool
+}
+
+func newBinaryCache() *binaryCache {
+	return &binaryCache{blobs: map[string]bool{}}
+}
+
+func (c *binaryCache) get(hash string) (bool, bool) {
+	if c == nil {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	isBinary, exists := c.blobs[hash]
+	return isBinary, exists
+}
+
+func (c *binaryCache) set(hash string, isBinary bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blobs[hash] = isBinary
+}
+
+// detectBinaries sets IsBinary of the regular files by the content of the blobs.
+func (r *Repository) detectBinaries(ctx context.Context, files []*File) error {
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file == nil || file.Type != RegularFile {
+			continue
+		}
+		isBinary, err := r.isBinary(file.Hash)
+		if err != nil {
+			return err
+		}
+		file.IsBinary = isBinary
+	}
+	return nil
+}
+
+func (r *Repository) isBinary(hash string) (bool, error) {
+	if isBinary, exists := r.binaries.get(hash); exists {
+		return isBinary, nil
+	}
+	blob, err := r.BlobObject(plumbing.NewHash(hash))
+	if err != nil {
+		return false, err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	isBinary, err := binary.IsBinary(reader)
+	if err != nil {
+		return false, err
+	}
+	r.binaries.set(hash, isBinary)
+	return isBinary, nil
+}
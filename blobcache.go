@@ -0,0 +1,253 @@
+package treport
+
+import (
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// BlobCacheConfig caches the contents of the blobs read for the plugins, like the files written by checkout,
+// by their hashes. The same blobs appear in many commits, so they aren't read from the object store every time.
+type BlobCacheConfig struct {
+	// MemorySize is the max bytes of the blobs kept in memory. Zero disables the memory cache.
+	MemorySize int64 `yaml:"memorySize"`
+	// DiskSize is the max bytes of the blobs written under the mount path. Zero disables the disk cache.
+	// They are kept across the runs, and the least recently used ones are removed first.
+	DiskSize int64 `yaml:"diskSize"`
+}
+
+func (c *CacheConfig) blobCache() *BlobCacheConfig {
+	if c == nil || c.Blob == nil || (c.Blob.MemorySize <= 0 && c.Blob.DiskSize <= 0) {
+		return nil
+	}
+	return c.Blob
+}
+
+// blobCache is the content addressed cache of the blobs. The blobs larger than the size of the memory or the disk
+// aren't kept by it. The cache of the same path is shared by the pipelines in the process.
+type blobCache struct {
+	path string
+	cfg  *BlobCacheConfig
+	refs int
+
+	mu         sync.Mutex
+	memory     *list.List
+	memoryHash map[string]*list.Element
+	memoryUsed int64
+	disk       *list.List
+	diskHash   map[string]*list.Element
+	diskUsed   int64
+}
+
+type blobEntry struct {
+	hash string
+	size int64
+	data []byte
+}
+
+var (
+	blobCachesMu sync.Mutex
+	blobCaches   = map[string]*blobCache{}
+)
+
+func openBlobCache(path string, cfg *BlobCacheConfig) (*blobCache, error) {
+	blobCachesMu.Lock()
+	defer blobCachesMu.Unlock()
+	if cache, exists := blobCaches[path]; exists {
+		cache.refs++
+		return cache, nil
+	}
+	cache := &blobCache{
+		path:       path,
+		cfg:        cfg,
+		refs:       1,
+		memory:     list.New(),
+		memoryHash: map[string]*list.Element{},
+		disk:       list.New(),
+		diskHash:   map[string]*list.Element{},
+	}
+	if cfg.DiskSize > 0 {
+		if err := cache.load(); err != nil {
+			return nil, errors.Wrapf(err, "failed to load blob cache %s", path)
+		}
+	}
+	blobCaches[path] = cache
+	return cache, nil
+}
+
+// load indexes the blobs written by the previous runs in the order of their modification time.
+func (c *blobCache) load() error {
+	if err := mkdirIfNotExists(c.path); err != nil {
+		return err
+	}
+	var entries []*blobEntry
+	var mtimes []int64
+	if err := filepath.Walk(c.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if filepath.Ext(path) == ".tmp" {
+			// the blob which a crashed process was writing.
+			return os.Remove(path)
+		}
+		entries = append(entries, &blobEntry{hash: filepath.Base(filepath.Dir(path)) + info.Name(), size: info.Size()})
+		mtimes = append(mtimes, info.ModTime().UnixNano())
+		return nil
+	}); err != nil {
+		return err
+	}
+	idx := make([]int, len(entries))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return mtimes[idx[i]] > mtimes[idx[j]] })
+	for _, i := range idx {
+		c.diskHash[entries[i].hash] = c.disk.PushBack(entries[i])
+		c.diskUsed += entries[i].size
+	}
+	return c.evictDisk()
+}
+
+// Close releases the cache when all users of it close it.
+func (c *blobCache) Close() {
+	blobCachesMu.Lock()
+	defer blobCachesMu.Unlock()
+	c.refs--
+	if c.refs > 0 {
+		return
+	}
+	delete(blobCaches, c.path)
+}
+
+func (c *blobCache) blobPath(hash string) string {
+	return filepath.Join(c.path, hash[:2], hash[2:])
+}
+
+// get returns the contents of the blob. open reads it from the object store if it isn't cached.
+func (c *blobCache) get(hash string, open func() (io.ReadCloser, error)) ([]byte, error) {
+	if data, exists := c.fromMemory(hash); exists {
+		return data, nil
+	}
+	if data, exists := c.fromDisk(hash); exists {
+		c.toMemory(hash, data)
+		return data, nil
+	}
+	reader, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	c.toMemory(hash, data)
+	if err := c.toDisk(hash, data); err != nil {
+		return nil, errors.Wrapf(err, "failed to write blob %s to cache", hash)
+	}
+	return data, nil
+}
+
+func (c *blobCache) fromMemory(hash string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, exists := c.memoryHash[hash]
+	if !exists {
+		return nil, false
+	}
+	c.memory.MoveToFront(elem)
+	return elem.Value.(*blobEntry).data, true
+}
+
+func (c *blobCache) toMemory(hash string, data []byte) {
+	size := int64(len(data))
+	if size > c.cfg.MemorySize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.memoryHash[hash]; exists {
+		return
+	}
+	c.memoryHash[hash] = c.memory.PushFront(&blobEntry{hash: hash, size: size, data: data})
+	c.memoryUsed += size
+	for c.memoryUsed > c.cfg.MemorySize {
+		entry := c.memory.Remove(c.memory.Back()).(*blobEntry)
+		delete(c.memoryHash, entry.hash)
+		c.memoryUsed -= entry.size
+	}
+}
+
+func (c *blobCache) fromDisk(hash string) ([]byte, bool) {
+	c.mu.Lock()
+	elem, exists := c.diskHash[hash]
+	if exists {
+		c.disk.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !exists {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(c.blobPath(hash))
+	if err != nil {
+		// the blob is removed by another process sharing the cache. it's read from the object store again.
+		c.mu.Lock()
+		if elem, exists := c.diskHash[hash]; exists {
+			c.disk.Remove(elem)
+			delete(c.diskHash, hash)
+			c.diskUsed -= elem.Value.(*blobEntry).size
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	// the modification time is the order of the eviction by the next runs.
+	now := time.Now()
+	os.Chtimes(c.blobPath(hash), now, now)
+	return data, true
+}
+
+func (c *blobCache) toDisk(hash string, data []byte) error {
+	size := int64(len(data))
+	if size > c.cfg.DiskSize {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.diskHash[hash]; exists {
+		return nil
+	}
+	path := c.blobPath(hash)
+	if err := mkdirIfNotExists(filepath.Dir(path)); err != nil {
+		return err
+	}
+	// the blob is renamed after it's written, so the other readers don't read the partial one.
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	c.diskHash[hash] = c.disk.PushFront(&blobEntry{hash: hash, size: size})
+	c.diskUsed += size
+	return c.evictDisk()
+}
+
+// evictDisk removes the least recently used blobs until the cache fits in the disk size. c.mu must be held.
+func (c *blobCache) evictDisk() error {
+	for c.diskUsed > c.cfg.DiskSize {
+		entry := c.disk.Remove(c.disk.Back()).(*blobEntry)
+		delete(c.diskHash, entry.hash)
+		c.diskUsed -= entry.size
+		if err := os.Remove(c.blobPath(entry.hash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package treport
+
+// NewConfig prepares the config constructed as the structs for scanning as LoadConfig does.
+// The auth profiles and the defaults are resolved, and the config is validated,
+// so the problems are reported as ValidationErrors before any cloning starts.
+func NewConfig(cfg *Config) (*Config, error) {
+	cfg.resolveAuthProfiles()
+	cfg.applyDefaults()
+	cfg.resolveRemotes()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ConfigBuilder builds the config for the library use.
+//
+//	cfg, err := treport.NewConfigBuilder().
+//		MountPath(dir).
+//		Pipeline(
+//			treport.NewPipelineBuilder("size").
+//				Strategy(treport.AllMergeCommit).
+//				Repository("https://github.com/goccy/go-json").
+//				Step("size").
+//				Build(),
+//		).
+//		Build()
+type ConfigBuilder struct {
+	cfg *Config
+}
+
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{cfg: &Config{}}
+}
+
+// MountPath sets the directory which has the clones and the caches ( project.path ).
+func (b *ConfigBuilder) MountPath(path string) *ConfigBuilder {
+	b.cfg.Project.Path = path
+	return b
+}
+
+func (b *ConfigBuilder) Defaults(defaults *DefaultsConfig) *ConfigBuilder {
+	b.cfg.Defaults = defaults
+	return b
+}
+
+// Auth adds the auth profile which is referenced by the name.
+func (b *ConfigBuilder) Auth(name string, auth *AuthConfig) *ConfigBuilder {
+	if b.cfg.Auth == nil {
+		b.cfg.Auth = map[string]*AuthConfig{}
+	}
+	b.cfg.Auth[name] = auth
+	return b
+}
+
+// Scanner adds the scanner plugins. The plugin is the name of the builtin plugin or the repository of the plugin.
+func (b *ConfigBuilder) Scanner(plugins ...*RepositoryConfig) *ConfigBuilder {
+	plugin := b.plugin()
+	plugin.Scanner = append(plugin.Scanner, plugins...)
+	return b
+}
+
+// Storer adds the storer plugins. The plugin is the name of the builtin plugin or the repository of the plugin.
+func (b *ConfigBuilder) Storer(plugins ...*RepositoryConfig) *ConfigBuilder {
+	plugin := b.plugin()
+	plugin.Storer = append(plugin.Storer, plugins...)
+	return b
+}
+
+func (b *ConfigBuilder) plugin() *PluginConfig {
+	if b.cfg.Plugin == nil {
+		b.cfg.Plugin = &PluginConfig{}
+	}
+	return b.cfg.Plugin
+}
+
+func (b *ConfigBuilder) Pipeline(pipelines ...*PipelineConfig) *ConfigBuilder {
+	b.cfg.Pipelines = append(b.cfg.Pipelines, pipelines...)
+	return b
+}
+
+func (b *ConfigBuilder) Notification(notifications ...*NotificationConfig) *ConfigBuilder {
+	b.cfg.Notifications = append(b.cfg.Notifications, notifications...)
+	return b
+}
+
+// Build returns the config prepared by NewConfig.
+func (b *ConfigBuilder) Build() (*Config, error) {
+	cfg := *b.cfg
+	return NewConfig(&cfg)
+}
+
+// PipelineBuilder builds the config of the pipeline for ConfigBuilder.
+type PipelineBuilder struct {
+	cfg *PipelineConfig
+}
+
+func NewPipelineBuilder(name string) *PipelineBuilder {
+	return &PipelineBuilder{cfg: &PipelineConfig{Name: name}}
+}
+
+func (b *PipelineBuilder) Desc(desc string) *PipelineBuilder {
+	b.cfg.Desc = desc
+	return b
+}
+
+func (b *PipelineBuilder) Strategy(strategy Strategy) *PipelineBuilder {
+	b.cfg.Strategy = strategy
+	return b
+}
+
+func (b *PipelineBuilder) DiffMode(mode DiffMode) *PipelineBuilder {
+	b.cfg.DiffMode = mode
+	return b
+}
+
+// Schedule sets the interval like 1h or the cron expression used by daemon mode.
+func (b *PipelineBuilder) Schedule(schedule string) *PipelineBuilder {
+	b.cfg.Schedule = schedule
+	return b
+}
+
+func (b *PipelineBuilder) Tags(tags ...string) *PipelineBuilder {
+	b.cfg.Tags = append(b.cfg.Tags, tags...)
+	return b
+}
+
+func (b *PipelineBuilder) Filter(filter *FilterConfig) *PipelineBuilder {
+	b.cfg.Filter = filter
+	return b
+}
+
+func (b *PipelineBuilder) Ignore(ignore *IgnoreConfig) *PipelineBuilder {
+	b.cfg.Ignore = ignore
+	return b
+}
+
+// Clock sets the time which the results are recorded against.
+func (b *PipelineBuilder) Clock(clock Clock) *PipelineBuilder {
+	b.cfg.Clock = clock
+	return b
+}
+
+// SkipEmptyChanges doesn't pass the commits which have no changes to the plugins.
+func (b *PipelineBuilder) SkipEmptyChanges() *PipelineBuilder {
+	b.cfg.SkipEmptyChanges = true
+	return b
+}
+
+// Repository adds the repositories by the URLs.
+func (b *PipelineBuilder) Repository(repos ...string) *PipelineBuilder {
+	for _, repo := range repos {
+		b.cfg.Repository = append(b.cfg.Repository, &RepositoryConfig{Repo: repo})
+	}
+	return b
+}
+
+// RepositoryConfig adds the repositories which have the settings like auth and branch.
+func (b *PipelineBuilder) RepositoryConfig(repos ...*RepositoryConfig) *PipelineBuilder {
+	b.cfg.Repository = append(b.cfg.Repository, repos...)
+	return b
+}
+
+// Step adds the step which runs the plugins concurrently. It depends on all previous steps.
+func (b *PipelineBuilder) Step(plugins ...string) *PipelineBuilder {
+	step := &StepConfig{}
+	for _, plugin := range plugins {
+		step.Plugins = append(step.Plugins, &PluginExecConfig{Name: plugin})
+	}
+	b.cfg.Steps = append(b.cfg.Steps, step)
+	return b
+}
+
+// StepConfig adds the steps which have the name, the dependencies or the arguments of the plugins.
+func (b *PipelineBuilder) StepConfig(steps ...*StepConfig) *PipelineBuilder {
+	b.cfg.Steps = append(b.cfg.Steps, steps...)
+	return b
+}
+
+// Build returns the config of the pipeline. It's validated by ConfigBuilder.Build.
+func (b *PipelineBuilder) Build() *PipelineConfig {
+	cfg := *b.cfg
+	return &cfg
+}
@@ -0,0 +1,645 @@
+package treport
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/proto"
+)
+
+// CacheStats is the size of the cache DB of the plugin for the repository of the pipeline.
+type CacheStats struct {
+	Pipeline string
+	Repo     string
+	Branch   string
+	Plugin   string
+	Path     string
+	// Entries is the number of the scanned commits. Size is the bytes of the files of the DB.
+	Entries int
+	Size    int64
+}
+
+// CacheFilter selects the caches of the plugins. The zero value selects all caches.
+type CacheFilter struct {
+	Pipeline string
+	Plugin   string
+	// Since and Until select the results of the commits committed in [Since, Until). Zero value means unbounded.
+	Since time.Time
+	Until time.Time
+}
+
+func (f *CacheFilter) match(src *resultSource) bool {
+	if f == nil {
+		return true
+	}
+	if f.Pipeline != "" && f.Pipeline != src.pipeline {
+		return false
+	}
+	return f.Plugin == "" || f.Plugin == src.plugin
+}
+
+func (f *CacheFilter) hasRange() bool {
+	return f != nil && (!f.Since.IsZero() || !f.Until.IsZero())
+}
+
+func (f *CacheFilter) inRange(res *treportproto.ScanResponse) bool {
+	if !f.hasRange() {
+		return true
+	}
+	var commitTime time.Time
+	if res.CommitTime != nil {
+		commitTime, _ = ptypes.Timestamp(res.CommitTime)
+	}
+	if !f.Since.IsZero() && commitTime.Before(f.Since) {
+		return false
+	}
+	return f.Until.IsZero() || commitTime.Before(f.Until)
+}
+
+// cacheSources returns the caches selected by the filter which exist on the disk.
+func cacheSources(cfg *Config, filter *CacheFilter) ([]*resultSource, error) {
+	sources, err := resultSources(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get cache sources")
+	}
+	selected := []*resultSource{}
+	for _, src := range sources {
+		if !filter.match(src) || !existsPath(src.path) {
+			continue
+		}
+		selected = append(selected, src)
+	}
+	return selected, nil
+}
+
+// CacheStatsOf returns the sizes of the cache DBs of the plugins selected by the filter.
+func CacheStatsOf(cfg *Config, filter *CacheFilter) ([]*CacheStats, error) {
+	sources, err := cacheSources(cfg, filter)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]*CacheStats, 0, len(sources))
+	for _, src := range sources {
+		entries, err := countCacheEntries(src.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to count entries of %s", src.path)
+		}
+		stats = append(stats, &CacheStats{
+			Pipeline: src.pipeline,
+			Repo:     src.repo,
+			Branch:   src.branch,
+			Plugin:   src.plugin,
+			Path:     src.path,
+			Entries:  entries,
+			Size:     dirSize(src.path),
+		})
+	}
+	return stats, nil
+}
+
+func countCacheEntries(path string) (int, error) {
+	db, err := badger.Open(badger.DefaultOptions(path).WithReadOnly(true))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var entries int
+	if err := db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := tx.NewIterator(opts)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			entries++
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return entries, nil
+}
+
+// ClearCache removes the caches of the plugins selected by the filter, and returns the number of the removed entries.
+// If the filter has the range of the commits, only their results are removed and the next scan restores the others.
+// The caches are changed under the lock of the mount paths, so the scans of the other processes don't use them at the same time.
+func ClearCache(ctx context.Context, cfg *Config, filter *CacheFilter) (int, error) {
+	unlock, err := cfg.lockMounts(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	sources, err := cacheSources(cfg, filter)
+	if err != nil {
+		return 0, err
+	}
+	var removed int
+	for _, src := range sources {
+		if !filter.hasRange() {
+			entries, err := countCacheEntries(src.path)
+			if err != nil {
+				return removed, errors.Wrapf(err, "failed to count entries of %s", src.path)
+			}
+			if err := os.RemoveAll(src.path); err != nil {
+				return removed, errors.Wrapf(err, "failed to remove cache %s", src.path)
+			}
+			if err := os.RemoveAll(src.path + ".mark.json"); err != nil {
+				return removed, errors.Wrapf(err, "failed to remove high water mark of %s", src.path)
+			}
+			removed += entries
+			continue
+		}
+		n, err := clearCacheRange(src.path, filter)
+		if err != nil {
+			return removed, errors.Wrapf(err, "failed to clear cache %s", src.path)
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// ClearAllCaches removes all caches of the config. The lock files of the cache paths are kept,
+// so the scans of the other processes waiting for them lock the same files.
+func ClearAllCaches(ctx context.Context, cfg *Config) error {
+	unlock, err := cfg.lockMounts(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, path := range cfg.CachePaths() {
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errors.Wrapf(err, "failed to read cache %s", path)
+		}
+		for _, file := range files {
+			if file.Name() == mountLockName {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(path, file.Name())); err != nil {
+				return errors.Wrapf(err, "failed to remove cache %s", path)
+			}
+		}
+	}
+	return nil
+}
+
+func clearCacheRange(path string, filter *CacheFilter) (int, error) {
+	db, err := badger.Open(badger.DefaultOptions(path))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	keys := [][]byte{}
+	if err := db.View(func(tx *badger.Txn) error {
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			item := iter.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var res treportproto.ScanResponse
+			if err := proto.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			if filter.inRange(&res) {
+				keys = append(keys, item.KeyCopy(nil))
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// GCCache rewrites the value logs of the cache DBs selected by the filter to reclaim the space of the removed entries.
+func GCCache(ctx context.Context, cfg *Config, filter *CacheFilter) error {
+	unlock, err := cfg.lockMounts(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	sources, err := cacheSources(cfg, filter)
+	if err != nil {
+		return err
+	}
+	for _, src := range sources {
+		if err := gcCache(src.path); err != nil {
+			return errors.Wrapf(err, "failed to run gc of cache %s", src.path)
+		}
+	}
+	return nil
+}
+
+func gcCache(path string) error {
+	db, err := badger.Open(badger.DefaultOptions(path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Flatten(1); err != nil {
+		return err
+	}
+	for {
+		// RunValueLogGC rewrites at most one file at once.
+		if err := db.RunValueLogGC(0.5); err != nil {
+			if err == badger.ErrNoRewrite {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// cacheArchiveEntry identifies the cache in the archive, so it's imported to the cache of the same plugin
+// even if the paths are different.
+type cacheArchiveEntry struct {
+	Name     string `json:"name"`
+	Pipeline string `json:"pipeline"`
+	Repo     string `json:"repo"`
+	Branch   string `json:"branch,omitempty"`
+	Plugin   string `json:"plugin"`
+}
+
+const cacheArchiveManifest = "manifest.json"
+
+// ExportCache writes the caches selected by the filter to w as the gzipped tar archive of badger backups.
+// The range of the filter isn't applied to the export. It returns the number of the exported caches.
+func ExportCache(cfg *Config, filter *CacheFilter, w io.Writer) (int, error) {
+	sources, err := cacheSources(cfg, filter)
+	if err != nil {
+		return 0, err
+	}
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	entries := make([]*cacheArchiveEntry, 0, len(sources))
+	for idx, src := range sources {
+		entry := &cacheArchiveEntry{
+			Name:     fmt.Sprintf("%04d.backup", idx),
+			Pipeline: src.pipeline,
+			Repo:     src.repo,
+			Branch:   src.branch,
+			Plugin:   src.plugin,
+		}
+		if err := backupCache(tw, src.path, entry.Name); err != nil {
+			return 0, errors.Wrapf(err, "failed to export cache %s", src.path)
+		}
+		entries = append(entries, entry)
+	}
+	manifest, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to encode manifest")
+	}
+	if err := writeTarFile(tw, cacheArchiveManifest, manifest); err != nil {
+		return 0, err
+	}
+	if err := tw.Close(); err != nil {
+		return 0, errors.Wrapf(err, "failed to write archive")
+	}
+	if err := gw.Close(); err != nil {
+		return 0, errors.Wrapf(err, "failed to write archive")
+	}
+	return len(entries), nil
+}
+
+// backupCache writes the backup of the cache DB to the archive. tar requires the size before the contents,
+// so the backup is written to the temporary file once.
+func backupCache(tw *tar.Writer, path, name string) error {
+	db, err := badger.Open(badger.DefaultOptions(path).WithReadOnly(true))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	f, err := ioutil.TempFile("", "treport-cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := db.Backup(f, 0); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now()}); err != nil {
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+	return nil
+}
+
+// ImportCache loads the caches of the archive written by ExportCache to the caches of the same plugins of the config.
+// The entries are merged with the existing ones. It returns the number of the imported caches and the skipped ones,
+// which aren't in the config or aren't selected by the filter.
+func ImportCache(ctx context.Context, cfg *Config, filter *CacheFilter, r io.Reader) (int, int, error) {
+	unlock, err := cfg.lockMounts(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+	sources, err := resultSources(cfg)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to get cache sources")
+	}
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to read archive")
+	}
+	defer gr.Close()
+	// the manifest is written at the end of the archive, so the backups are extracted to the temporary directory first.
+	dir, err := ioutil.TempDir("", "treport-cache")
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+	var entries []*cacheArchiveEntry
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "failed to read archive")
+		}
+		if hdr.Name == cacheArchiveManifest {
+			if err := json.NewDecoder(tr).Decode(&entries); err != nil {
+				return 0, 0, errors.Wrapf(err, "failed to decode manifest")
+			}
+			continue
+		}
+		if filepath.Base(hdr.Name) != hdr.Name {
+			return 0, 0, fmt.Errorf("invalid entry %q in archive", hdr.Name)
+		}
+		if err := extractTarFile(tr, filepath.Join(dir, hdr.Name)); err != nil {
+			return 0, 0, err
+		}
+	}
+	var imported, skipped int
+	for _, entry := range entries {
+		src := findResultSource(sources, entry)
+		if src == nil || !filter.match(src) {
+			skipped++
+			continue
+		}
+		if err := loadCache(src.path, filepath.Join(dir, filepath.Base(entry.Name))); err != nil {
+			return imported, skipped, errors.Wrapf(err, "failed to import cache of %s", entry.Plugin)
+		}
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+func findResultSource(sources []*resultSource, entry *cacheArchiveEntry) *resultSource {
+	for _, src := range sources {
+		if src.pipeline == entry.Pipeline && src.repo == entry.Repo && src.branch == entry.Branch && src.plugin == entry.Plugin {
+			return src
+		}
+	}
+	return nil
+}
+
+func extractTarFile(r io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", path)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrapf(err, "failed to extract %s", path)
+	}
+	return nil
+}
+
+func loadCache(path, backup string) error {
+	f, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := mkdirIfNotExists(filepath.Dir(path)); err != nil {
+		return err
+	}
+	db, err := badger.Open(badger.DefaultOptions(path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Load(f, 256)
+}
+
+// OrphanCache is the cache which no pipeline of the config uses, like the one of the renamed pipeline.
+type OrphanCache struct {
+	Path string
+	Size int64
+}
+
+// usedCaches are the cache directories of the pipelines and the repositories used by the config.
+type usedCaches struct {
+	// pipelines has the directories of the repositories keyed by the directory of the pipeline.
+	// It's nil if the directories of the repositories aren't known, because the branches aren't cloned yet.
+	pipelines map[string]map[string]struct{}
+	diffs     map[string]struct{}
+}
+
+func (u *usedCaches) add(pluginPath string, knownRepos bool) {
+	repoPath := filepath.Dir(filepath.Dir(pluginPath))
+	pipelinePath := filepath.Dir(repoPath)
+	repos, exists := u.pipelines[pipelinePath]
+	if !exists {
+		repos = map[string]struct{}{}
+		u.pipelines[pipelinePath] = repos
+	}
+	if !knownRepos {
+		u.pipelines[pipelinePath] = nil
+		return
+	}
+	if repos != nil {
+		repos[repoPath] = struct{}{}
+	}
+}
+
+// usedCachesOf returns the caches used by all pipelines of the config including the disabled ones.
+// The caches named by the older IDs are used too, because the pipelines migrate them.
+func usedCachesOf(cfg *Config) (*usedCaches, error) {
+	used := &usedCaches{pipelines: map[string]map[string]struct{}{}, diffs: map[string]struct{}{}}
+	for _, pipelineCfg := range cfg.Pipelines {
+		pipelineIDs := map[string]struct{}{}
+		for _, repoCfg := range pipelineCfg.Repository {
+			diffPath, err := cfg.diffCachePathOf(pipelineCfg, repoCfg)
+			if err != nil {
+				return nil, err
+			}
+			used.diffs[diffPath] = struct{}{}
+			repoPath, err := repositoryPath(cfg.repoPathOf(pipelineCfg, repoCfg), repoCfg)
+			if err != nil {
+				return nil, err
+			}
+			branches, err := localBranches(repoPath, repoCfg)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to find branches of %s", repoCfg.identity())
+			}
+			knownRepos := len(branches) > 0
+			if !knownRepos {
+				// the directory of the pipeline is used though the ones of the branches aren't known.
+				branches = []string{""}
+			}
+			for _, branch := range branches {
+				for idx, stepCfg := range pipelineCfg.Steps {
+					for _, pluginExecCfg := range stepCfg.Plugins {
+						path, err := resultPath(DefaultIDScheme, cfg, pipelineCfg, repoCfg, branch, idx, pluginExecCfg.Name)
+						if err != nil {
+							return nil, err
+						}
+						legacyPaths, err := legacyResultPaths(cfg, pipelineCfg, repoCfg, branch, idx, pluginExecCfg.Name)
+						if err != nil {
+							return nil, err
+						}
+						for _, path := range append([]string{path}, legacyPaths...) {
+							used.add(path, knownRepos)
+							pipelineIDs[filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(path))))] = struct{}{}
+						}
+					}
+				}
+			}
+		}
+		// the directory of the pipeline under its own cache path is used by the migration of the legacy caches
+		// even if the repositories have the other cache paths.
+		for id := range pipelineIDs {
+			pipelinePath := filepath.Join(cfg.cachePathOf(pipelineCfg, nil), id)
+			if _, exists := used.pipelines[pipelinePath]; !exists {
+				used.pipelines[pipelinePath] = map[string]struct{}{}
+			}
+		}
+	}
+	return used, nil
+}
+
+// OrphanCaches returns the caches of the pipelines and the repositories under the cache paths of the config
+// which no pipeline uses. They are left by renaming the pipelines, changing the plugins and removing the repositories.
+func OrphanCaches(cfg *Config) ([]*OrphanCache, error) {
+	used, err := usedCachesOf(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get caches used by config")
+	}
+	orphans := []*OrphanCache{}
+	addOrphan := func(path string) {
+		orphans = append(orphans, &OrphanCache{Path: path, Size: dirSize(path)})
+	}
+	for _, cachePath := range cfg.CachePaths() {
+		pipelineDirs, err := subdirs(cachePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read cache %s", cachePath)
+		}
+		for _, pipelinePath := range pipelineDirs {
+			if filepath.Base(pipelinePath) == "diff" {
+				diffDirs, err := subdirs(pipelinePath)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to read cache %s", pipelinePath)
+				}
+				for _, diffPath := range diffDirs {
+					if _, exists := used.diffs[diffPath]; !exists {
+						addOrphan(diffPath)
+					}
+				}
+				continue
+			}
+			repos, exists := used.pipelines[pipelinePath]
+			if !exists {
+				addOrphan(pipelinePath)
+				continue
+			}
+			if repos == nil {
+				continue
+			}
+			repoDirs, err := subdirs(pipelinePath)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read cache %s", pipelinePath)
+			}
+			for _, repoPath := range repoDirs {
+				if _, exists := repos[repoPath]; !exists {
+					addOrphan(repoPath)
+				}
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// RemoveOrphanCaches removes the caches which no pipeline of the config uses, and returns the removed ones.
+func RemoveOrphanCaches(ctx context.Context, cfg *Config) ([]*OrphanCache, error) {
+	unlock, err := cfg.lockMounts(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	orphans, err := OrphanCaches(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, orphan := range orphans {
+		if err := os.RemoveAll(orphan.Path); err != nil {
+			return nil, errors.Wrapf(err, "failed to remove cache %s", orphan.Path)
+		}
+	}
+	return orphans, nil
+}
+
+// subdirs returns the directories in the directory. It's empty if the directory doesn't exist.
+func subdirs(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	dirs := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, file.Name()))
+		}
+	}
+	return dirs, nil
+}
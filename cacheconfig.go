@@ -0,0 +1,93 @@
+package treport
+
+import (
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
+)
+
+type CachePreset string
+
+const (
+	CachePresetDefault CachePreset = "default"
+	// CachePresetLowMemory keeps the memory and the goroutines of each cache DB small for CI runners.
+	CachePresetLowMemory CachePreset = "lowMemory"
+)
+
+type CacheCompression string
+
+const (
+	CacheCompressionNone   CacheCompression = "none"
+	CacheCompressionSnappy CacheCompression = "snappy"
+	CacheCompressionZSTD   CacheCompression = "zstd"
+)
+
+// CacheConfig tunes the cache DBs of the plugins and the diffs. The fields override the preset.
+type CacheConfig struct {
+	Preset CachePreset `yaml:"preset"`
+	// MemTableSize is the bytes of a memtable. badger holds multiple memtables per DB.
+	MemTableSize int64            `yaml:"memTableSize"`
+	Compression  CacheCompression `yaml:"compression"`
+	// InMemory keeps the caches only while the scan runs, so the next run scans all commits again.
+	InMemory bool `yaml:"inMemory"`
+	// ValueThreshold is the bytes of the value stored in the LSM tree instead of the value log.
+	ValueThreshold int `yaml:"valueThreshold"`
+	// WriteBatch is the number of the commits whose results are written to the cache DB of the plugin at once.
+	// The high-water mark is written with them, so the interrupted scan continues from the last written commit.
+	// 1 writes each commit.
+	WriteBatch int `yaml:"writeBatch"`
+	// Blob caches the contents of the blobs by their hashes. It's disabled by default.
+	Blob *BlobCacheConfig `yaml:"blob"`
+}
+
+// maxCacheValueThreshold is the limit of badger.
+const maxCacheValueThreshold = 1 << 20
+
+// options returns the options of the cache DB at the path.
+func (c *CacheConfig) options(path string) badger.Options {
+	if c == nil {
+		return badger.DefaultOptions(path)
+	}
+	opts := badger.DefaultOptions(path)
+	if c.InMemory {
+		opts = badger.DefaultOptions("").WithInMemory(true)
+	}
+	if c.Preset == CachePresetLowMemory {
+		opts = opts.
+			WithMaxTableSize(8 << 20).
+			WithNumMemtables(1).
+			WithNumLevelZeroTables(1).
+			WithNumLevelZeroTablesStall(2).
+			WithValueLogFileSize(16 << 20).
+			WithTableLoadingMode(options.FileIO).
+			WithValueLogLoadingMode(options.FileIO)
+	}
+	if c.MemTableSize > 0 {
+		opts = opts.WithMaxTableSize(c.MemTableSize)
+	}
+	switch c.Compression {
+	case CacheCompressionNone:
+		opts = opts.WithCompression(options.None)
+	case CacheCompressionSnappy:
+		opts = opts.WithCompression(options.Snappy)
+	case CacheCompressionZSTD:
+		opts = opts.WithCompression(options.ZSTD)
+	}
+	if c.ValueThreshold > 0 {
+		opts = opts.WithValueThreshold(c.ValueThreshold)
+	}
+	return opts
+}
+
+// inMemory reports whether the cache DBs don't exist on the disk.
+func (c *CacheConfig) inMemory() bool {
+	return c != nil && c.InMemory
+}
+
+const defaultCacheWriteBatch = 100
+
+func (c *CacheConfig) writeBatch() int {
+	if c == nil || c.WriteBatch <= 0 {
+		return defaultCacheWriteBatch
+	}
+	return c.WriteBatch
+}
@@ -0,0 +1,126 @@
+package treport
+
+import (
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+func newChange(from, to *File, action ActionType) *Change {
+	change := &Change{From: from, To: to, Action: action}
+	if from != nil {
+		change.OldMode = from.Mode
+	}
+	if to != nil {
+		change.NewMode = to.Mode
+	}
+	change.ModeChanged = from != nil && to != nil && from.Mode != to.Mode
+	return change
+}
+
+// ModeOnly reports whether only the mode of the file is changed, like the file which becomes executable.
+func (c *Change) ModeOnly() bool {
+	return c.ModeChanged && c.From.Hash == c.To.Hash
+}
+
+// ModeChanged returns the changes of the files whose modes are changed.
+func (c Changes) ModeChanged() Changes {
+	result := make(Changes, 0, len(c))
+	for _, change := range c {
+		if change.ModeChanged {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// FilterByExt returns the changes of the files which have one of the extensions like ".go" or "go".
+// The renamed file matches by either path.
+func (c Changes) FilterByExt(exts ...string) Changes {
+	extMap := map[string]struct{}{}
+	for _, ext := range exts {
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extMap[ext] = struct{}{}
+	}
+	return c.filter(func(file *File) bool {
+		_, exists := extMap[path.Ext(file.Name)]
+		return exists
+	})
+}
+
+// FilterByPathGlob returns the changes of the files which match the patterns written in the syntax of .gitignore
+// like "*.go" or "internal/**/testdata". The later pattern which starts with "!" excludes the files matched before.
+// The renamed file matches by either path.
+func (c Changes) FilterByPathGlob(patterns ...string) Changes {
+	parsed := make([]gitignore.Pattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		parsed = append(parsed, gitignore.ParsePattern(pattern, nil))
+	}
+	matcher := gitignore.NewMatcher(parsed)
+	return c.filter(func(file *File) bool {
+		return matcher.Match(strings.Split(file.Name, "/"), false)
+	})
+}
+
+func (c Changes) filter(match func(*File) bool) Changes {
+	result := make(Changes, 0, len(c))
+	for _, change := range c {
+		if change.From != nil && match(change.From) || change.To != nil && match(change.To) {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// Added returns the changes of the added files.
+func (c Changes) Added() Changes {
+	return c.byAction(Added)
+}
+
+// Deleted returns the changes of the deleted files.
+func (c Changes) Deleted() Changes {
+	return c.byAction(Deleted)
+}
+
+// Updated returns the changes of the updated files.
+func (c Changes) Updated() Changes {
+	return c.byAction(Updated)
+}
+
+func (c Changes) byAction(action ActionType) Changes {
+	result := make(Changes, 0, len(c))
+	for _, change := range c {
+		if change.Action == action {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// TotalSizeDelta returns the bytes the changes add to the snapshot. It's negative if the snapshot gets smaller.
+// The commits of the submodules reported by SubmodulePointer don't have the size.
+func (c Changes) TotalSizeDelta() int64 {
+	var delta int64
+	for _, change := range c {
+		if change.Action == SubmoduleUpdated {
+			continue
+		}
+		delta += change.SizeDelta()
+	}
+	return delta
+}
+
+// SizeDelta returns the bytes the change adds to the file.
+func (c *Change) SizeDelta() int64 {
+	var delta int64
+	if c.To != nil {
+		delta += c.To.Size
+	}
+	if c.From != nil {
+		delta -= c.From.Size
+	}
+	return delta
+}
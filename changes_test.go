@@ -0,0 +1,106 @@
+package treport_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/goccy/treport"
+)
+
+func TestChanges(t *testing.T) {
+	changes := treport.Changes{
+		{Action: treport.Added, To: &treport.File{Name: "main.go", Size: 10}},
+		{Action: treport.Deleted, From: &treport.File{Name: "internal/a/a.go", Size: 4}},
+		{Action: treport.Updated, From: &treport.File{Name: "README.md", Size: 8}, To: &treport.File{Name: "README.md", Size: 5}},
+		{Action: treport.Updated, From: &treport.File{Name: "internal/testdata/b.go", Size: 1}, To: &treport.File{Name: "internal/testdata/b.go", Size: 2}},
+		{Action: treport.SubmoduleUpdated, From: &treport.File{Name: "vendor/lib"}, To: &treport.File{Name: "vendor/lib"}},
+	}
+	paths := func(changes treport.Changes) []string {
+		var paths []string
+		for _, change := range changes {
+			paths = append(paths, change.Path())
+		}
+		return paths
+	}
+	for _, test := range []struct {
+		name     string
+		changes  treport.Changes
+		expected []string
+	}{
+		{"ext", changes.FilterByExt("go"), []string{"main.go", "internal/a/a.go", "internal/testdata/b.go"}},
+		{"glob", changes.FilterByPathGlob("internal/**", "!testdata"), []string{"internal/a/a.go"}},
+		{"added", changes.Added(), []string{"main.go"}},
+		{"deleted", changes.Deleted(), []string{"internal/a/a.go"}},
+		{"updated", changes.FilterByExt(".md").Updated(), []string{"README.md"}},
+	} {
+		got := paths(test.changes)
+		if len(got) != len(test.expected) {
+			t.Fatalf("%s: expected %v but got %v", test.name, test.expected, got)
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Fatalf("%s: expected %v but got %v", test.name, test.expected, got)
+			}
+		}
+	}
+	if delta := changes.TotalSizeDelta(); delta != 10-4-3+1 {
+		t.Fatalf("unexpected size delta %d", delta)
+	}
+}
+
+func TestModeChanged(t *testing.T) {
+	storage := memory.NewStorage()
+	store := func(obj interface {
+		Encode(plumbing.EncodedObject) error
+	}) plumbing.Hash {
+		encoded := storage.NewEncodedObject()
+		if err := obj.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := storage.SetEncodedObject(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	blob := storage.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("#!/bin/sh\n")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	blobHash, err := storage.SetEncodedObject(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := func(mode filemode.FileMode) *object.Tree {
+		hash := store(&object.Tree{Entries: []object.TreeEntry{{Name: "run.sh", Mode: mode, Hash: blobHash}}})
+		tree, err := object.GetTree(storage, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+	changes, err := treport.ConvertDiff(context.Background(), tree(filemode.Regular), tree(filemode.Executable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change but got %d", len(changes))
+	}
+	change := changes[0]
+	if !change.ModeChanged || change.OldMode != uint32(filemode.Regular) || change.NewMode != uint32(filemode.Executable) {
+		t.Fatalf("unexpected mode change %v", change)
+	}
+	if change.From.Hash != change.To.Hash || !change.To.Executable {
+		t.Fatalf("expected only mode to be changed %v", change)
+	}
+}
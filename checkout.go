@@ -0,0 +1,91 @@
+package treport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// checkout writes the files of the tree of the commit to the temporary directory under scratchPath,
+// and returns the directory. The caller must remove it. The submodules aren't checked out.
+func (c *ScanContext) checkout(ctx context.Context, scratchPath string) (string, error) {
+	if c.Repository == nil || c.Repository.Repository == nil {
+		return "", fmt.Errorf("the repository of %s isn't known", c.Commit.Hash)
+	}
+	commit, err := c.Repository.CommitObject(plumbing.NewHash(c.Commit.Hash))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get commit object")
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get tree")
+	}
+	dir, err := ioutil.TempDir(scratchPath, "treport-checkout-")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create checkout directory")
+	}
+	if err := tree.Files().ForEach(func(f *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return checkoutFile(dir, f, c.Repository.blobs)
+	}); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// checkoutFile writes the file under dir. If blobs is set, the contents are read through it.
+func checkoutFile(dir string, f *object.File, blobs *blobCache) error {
+	path := filepath.Join(dir, filepath.FromSlash(f.Name))
+	if err := mkdirIfNotExists(filepath.Dir(path)); err != nil {
+		return errors.Wrapf(err, "failed to create directory for %s", f.Name)
+	}
+	perm := os.FileMode(0644)
+	if f.Mode == filemode.Executable {
+		perm = 0755
+	}
+	if blobs != nil {
+		data, err := blobs.get(f.Hash.String(), f.Reader)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", f.Name)
+		}
+		if f.Mode == filemode.Symlink {
+			return os.Symlink(string(data), path)
+		}
+		if err := ioutil.WriteFile(path, data, perm); err != nil {
+			return errors.Wrapf(err, "failed to write %s", f.Name)
+		}
+		return nil
+	}
+	if f.Mode == filemode.Symlink {
+		target, err := f.Contents()
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", f.Name)
+		}
+		return os.Symlink(target, path)
+	}
+	src, err := f.Reader()
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", f.Name)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", f.Name)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return errors.Wrapf(err, "failed to write %s", f.Name)
+	}
+	return dst.Close()
+}
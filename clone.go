@@ -0,0 +1,455 @@
+package treport
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/goccy/treport/internal/errors"
+)
+
+const defaultCloneBatchSize = 100
+
+// CloneProgress is the progress of the clone, the fetch or the pull of the repository.
+// Batch is 0 while the base branch is cloned. ETA is estimated from the progress of the current batch.
+// Stage is the stage reported by the remote like "Counting objects", or "Receiving objects" and "Resolving deltas"
+// while the pack is received and indexed. BytesReceived is the size of the pack received so far.
+// It's measured on the disk, so it's always 0 for the repository in memory.
+type CloneProgress struct {
+	Repo          string
+	Operation     CloneOperation
+	Batch         int
+	Batches       int
+	Stage         string
+	Percent       int
+	BytesReceived int64
+	Elapsed       time.Duration
+	ETA           time.Duration
+}
+
+// CloneOperation is the git operation reporting CloneProgress.
+type CloneOperation string
+
+const (
+	CloneOperationClone CloneOperation = "clone"
+	CloneOperationFetch CloneOperation = "fetch"
+	CloneOperationPull  CloneOperation = "pull"
+)
+
+const (
+	// CloneStageReceiving and CloneStageResolving are the stages of the pack measured on the disk. Percent isn't known for them.
+	CloneStageReceiving = "Receiving objects"
+	CloneStageResolving = "Resolving deltas"
+	// tmpPackPrefix is the prefix of the pack written by go-git while it's received and indexed.
+	tmpPackPrefix = "tmp_pack_"
+)
+
+// clonePackInterval is the interval to measure the pack received from the remote.
+var clonePackInterval = time.Second
+
+type cloneProgressKey struct{}
+
+// WithCloneProgress returns the context which reports the clone progress to fn.
+func WithCloneProgress(ctx context.Context, fn func(*CloneProgress)) context.Context {
+	return context.WithValue(ctx, cloneProgressKey{}, fn)
+}
+
+func cloneProgressFunc(ctx context.Context) func(*CloneProgress) {
+	fn, _ := ctx.Value(cloneProgressKey{}).(func(*CloneProgress))
+	return fn
+}
+
+var cloneProgressMatcher = regexp.MustCompile(`([^:\r\n]+):\s+(\d+)%`)
+
+// cloneProgressWriter parses the human readable progress sent by the remote.
+// The remote doesn't report the pack being received, so it's measured by watchPack.
+type cloneProgressWriter struct {
+	mu       sync.Mutex
+	progress *CloneProgress
+	start    time.Time
+	fn       func(*CloneProgress)
+}
+
+func newCloneProgressWriter(ctx context.Context, repo string, op CloneOperation, batch, batches int) *cloneProgressWriter {
+	fn := cloneProgressFunc(ctx)
+	if fn == nil {
+		return nil
+	}
+	return &cloneProgressWriter{
+		progress: &CloneProgress{Repo: repo, Operation: op, Batch: batch, Batches: batches},
+		start:    time.Now(),
+		fn:       fn,
+	}
+}
+
+func (w *cloneProgressWriter) Write(p []byte) (int, error) {
+	matches := cloneProgressMatcher.FindAllSubmatch(p, -1)
+	if len(matches) == 0 {
+		return len(p), nil
+	}
+	last := matches[len(matches)-1]
+	percent, _ := strconv.Atoi(string(last[2]))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	elapsed := time.Since(w.start)
+	w.progress.Stage = strings.TrimSpace(string(last[1]))
+	w.progress.Percent = percent
+	w.progress.Elapsed = elapsed
+	w.progress.ETA = 0
+	if percent > 0 {
+		w.progress.ETA = elapsed * time.Duration(100-percent) / time.Duration(percent)
+	}
+	w.report()
+	return len(p), nil
+}
+
+// report calls fn with the copy of the progress. The caller must hold mu, so the progress is reported in order.
+func (w *cloneProgressWriter) report() {
+	progress := *w.progress
+	w.fn(&progress)
+}
+
+// watchPack reports the size of the pack written under repoPath until stop is called.
+// The pack is received while it grows, and its deltas are resolved after it stops growing until it's renamed.
+// The writer may be nil, and the repository in memory whose repoPath is empty isn't watched.
+func (w *cloneProgressWriter) watchPack(repoPath string) (stop func()) {
+	if w == nil || repoPath == "" {
+		return func() {}
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(clonePackInterval)
+		defer ticker.Stop()
+		var received int64
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			size, found := tmpPackSize(filepath.Join(gitDir(repoPath), "objects", "pack"))
+			if !found {
+				continue
+			}
+			stage := CloneStageReceiving
+			if size == received {
+				stage = CloneStageResolving
+			}
+			received = size
+			w.mu.Lock()
+			if w.progress.Stage != stage || w.progress.BytesReceived != size {
+				w.progress.Stage = stage
+				w.progress.Percent = 0
+				w.progress.BytesReceived = size
+				w.progress.Elapsed = time.Since(w.start)
+				w.progress.ETA = 0
+				w.report()
+			}
+			w.mu.Unlock()
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// tmpPackSize returns the size of the packs being written in the directory.
+func tmpPackSize(packDir string) (int64, bool) {
+	infos, err := ioutil.ReadDir(packDir)
+	if err != nil {
+		return 0, false
+	}
+	var (
+		size  int64
+		found bool
+	)
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), tmpPackPrefix) {
+			size += info.Size()
+			found = true
+		}
+	}
+	return size, found
+}
+
+// cloneCheckpoint is the state of the incremental clone. It is stored next to the repository,
+// because the repository directory is removed if the clone of the base branch is interrupted.
+type cloneCheckpoint struct {
+	BaseCloned  bool     `json:"baseCloned"`
+	FetchedRefs []string `json:"fetchedRefs"`
+	Complete    bool     `json:"complete"`
+}
+
+func cloneCheckpointPath(repoPath string) string {
+	return repoPath + ".clone.json"
+}
+
+func readCloneCheckpoint(repoPath string) (*cloneCheckpoint, error) {
+	b, err := ioutil.ReadFile(cloneCheckpointPath(repoPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cp cloneCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (cp *cloneCheckpoint) save(repoPath string) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cloneCheckpointPath(repoPath), b, 0644)
+}
+
+// cloneIncrementally clones the base branch first, and then fetches the other refs by batch.
+// go-git can't deepen a shallow clone, so increments are split by refs instead of depth.
+func cloneIncrementally(ctx context.Context, repoPath string, cfg *RepositoryConfig) (*git.Repository, error) {
+	cp, err := readCloneCheckpoint(repoPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read clone checkpoint")
+	}
+	if existsPath(repoPath) && (cp == nil || cp.Complete) {
+		return openRepo(repoPath)
+	}
+	if !existsPath(repoPath) || !cp.BaseCloned {
+		// the clone of the base branch was interrupted or the repository was removed.
+		if err := os.RemoveAll(repoPath); err != nil {
+			return nil, errors.Wrapf(err, "failed to remove interrupted clone")
+		}
+		cp = &cloneCheckpoint{}
+	}
+	if !cp.BaseCloned {
+		if err := cp.save(repoPath); err != nil {
+			return nil, errors.Wrapf(err, "failed to save clone checkpoint")
+		}
+		if err := cloneBaseBranch(ctx, repoPath, cfg); err != nil {
+			return nil, err
+		}
+		cp.BaseCloned = true
+		if err := cp.save(repoPath); err != nil {
+			return nil, errors.Wrapf(err, "failed to save clone checkpoint")
+		}
+	}
+	repo, err := openRepo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := fetchRefsByBatch(ctx, repo, repoPath, cfg, cp); err != nil {
+		return nil, err
+	}
+	cp.Complete = true
+	if err := cp.save(repoPath); err != nil {
+		return nil, errors.Wrapf(err, "failed to save clone checkpoint")
+	}
+	return repo, nil
+}
+
+func cloneBaseBranch(ctx context.Context, repoPath string, cfg *RepositoryConfig) error {
+	if err := mkdirForClone(repoPath); err != nil {
+		return errors.Wrap(err, "failed to create directory for cloning repository")
+	}
+	opt := &git.CloneOptions{
+		URL:          cfg.Repo,
+		Auth:         cfg.basicAuth(),
+		SingleBranch: true,
+	}
+	if cfg.Branch != "" {
+		opt.ReferenceName = plumbing.NewBranchReferenceName(cfg.Branch)
+	} else {
+		head, err := remoteHead(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		opt.ReferenceName = head
+	}
+	w := newCloneProgressWriter(ctx, cfg.Repo, CloneOperationClone, 0, 0)
+	if w != nil {
+		opt.Progress = w
+	}
+	stop := w.watchPack(repoPath)
+	defer stop()
+	if err := cfg.remote(ctx, func() error {
+		if _, err := git.PlainCloneContext(ctx, repoPath, cfg.Clone.bare(), opt); err != nil {
+			_ = os.RemoveAll(repoPath)
+			return gitError("clone base branch", err)
+		}
+		return nil
+	}); err != nil {
+		if err == transport.ErrEmptyRemoteRepository {
+			return ErrEmptyRepository(cfg.Repo)
+		}
+		return errors.Wrapf(err, "failed to clone base branch of %s", cfg.Repo)
+	}
+	return nil
+}
+
+// remoteHead returns the branch which HEAD of the remote points to.
+// It's required because single branch clone of go-git uses master by default.
+func remoteHead(ctx context.Context, cfg *RepositoryConfig) (plumbing.ReferenceName, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{cfg.Repo},
+	})
+	var refs []*plumbing.Reference
+	err := cfg.remote(ctx, func() error {
+		r, err := remote.List(&git.ListOptions{Auth: cfg.basicAuth()})
+		if err != nil {
+			return gitError("list remote refs", err)
+		}
+		refs = r
+		return nil
+	})
+	if err != nil {
+		if err == transport.ErrEmptyRemoteRepository {
+			return "", ErrEmptyRepository(cfg.Repo)
+		}
+		return "", errors.Wrapf(err, "failed to list remote refs of %s", cfg.Repo)
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target(), nil
+		}
+	}
+	return "", ErrBranchNotFound(cfg.Repo, "")
+}
+
+func fetchRefsByBatch(ctx context.Context, repo *git.Repository, repoPath string, cfg *RepositoryConfig, cp *cloneCheckpoint) error {
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get remote")
+	}
+	remoteRefs, err := remote.List(&git.ListOptions{Auth: cfg.basicAuth()})
+	if err != nil {
+		return errors.Wrapf(err, "failed to list remote refs")
+	}
+	specs := cfg.fetchRefSpecs(git.DefaultRemoteName)
+	fetched := map[string]struct{}{}
+	for _, ref := range cp.FetchedRefs {
+		fetched[ref] = struct{}{}
+	}
+	refs := []string{}
+	for _, ref := range remoteRefs {
+		if ref.Type() != plumbing.HashReference || ref.Name() == plumbing.HEAD {
+			continue
+		}
+		if _, exists := fetched[ref.Name().String()]; exists {
+			continue
+		}
+		if _, fetches := localRefName(specs, ref.Name()); !fetches {
+			continue
+		}
+		refs = append(refs, ref.Name().String())
+	}
+	sort.Strings(refs)
+	batchSize := defaultCloneBatchSize
+	if cfg.Clone.BatchSize > 0 {
+		batchSize = cfg.Clone.BatchSize
+	}
+	interval, err := cfg.Clone.interval()
+	if err != nil {
+		return err
+	}
+	batches := (len(refs) + batchSize - 1) / batchSize
+	for batch := 0; batch < batches; batch++ {
+		if batch > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		end := (batch + 1) * batchSize
+		if end > len(refs) {
+			end = len(refs)
+		}
+		batchRefs := refs[batch*batchSize : end]
+		// use the same mapping as fetch.
+		refSpecs := make([]config.RefSpec, 0, len(batchRefs))
+		for _, ref := range batchRefs {
+			dst, _ := localRefName(specs, plumbing.ReferenceName(ref))
+			refSpecs = append(refSpecs, config.RefSpec("+"+ref+":"+string(dst)))
+		}
+		opt := &git.FetchOptions{
+			RemoteName: git.DefaultRemoteName,
+			RefSpecs:   refSpecs,
+			Tags:       cfg.fetchTags(),
+			Auth:       cfg.basicAuth(),
+		}
+		w := newCloneProgressWriter(ctx, cfg.Repo, CloneOperationFetch, batch+1, batches)
+		if w != nil {
+			opt.Progress = w
+		}
+		stop := w.watchPack(repoPath)
+		err := cfg.remote(ctx, func() error {
+			if err := repo.FetchContext(ctx, opt); err != nil && err != git.NoErrAlreadyUpToDate {
+				return gitError("fetch refs", err)
+			}
+			return nil
+		})
+		stop()
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch refs of %s", cfg.Repo)
+		}
+		cp.FetchedRefs = append(cp.FetchedRefs, batchRefs...)
+		if err := cp.save(repoPath); err != nil {
+			return errors.Wrapf(err, "failed to save clone checkpoint")
+		}
+	}
+	return nil
+}
+
+// cloneInMemory clones the repository to the memory storage without the worktree.
+func cloneInMemory(ctx context.Context, cfg *RepositoryConfig) (*git.Repository, error) {
+	opt := &git.CloneOptions{
+		URL:  cfg.Repo,
+		Auth: cfg.basicAuth(),
+	}
+	if w := newCloneProgressWriter(ctx, cfg.Repo, CloneOperationClone, 0, 0); w != nil {
+		opt.Progress = w
+	}
+	var repo *git.Repository
+	if err := cfg.remote(ctx, func() error {
+		r, err := git.CloneContext(ctx, memory.NewStorage(), nil, opt)
+		if err != nil {
+			return gitError("clone", err)
+		}
+		repo = r
+		return nil
+	}); err != nil {
+		if err == transport.ErrEmptyRemoteRepository {
+			return nil, ErrEmptyRepository(cfg.Repo)
+		}
+		return nil, errors.Wrapf(err, "failed to clone repository %s in memory", cfg.Repo)
+	}
+	return repo, nil
+}
+
+func openRepo(repoPath string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open repository")
+	}
+	return repo, nil
+}
@@ -0,0 +1,69 @@
+package treport_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/treport"
+)
+
+func TestWatchPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	packDir := filepath.Join(dir, "objects", "pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu       sync.Mutex
+		progress []*treport.CloneProgress
+	)
+	ctx := treport.WithCloneProgress(context.Background(), func(p *treport.CloneProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress = append(progress, p)
+	})
+	waitStage := func(stage string, size int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			for _, p := range progress {
+				if p.Stage == stage && p.BytesReceived == size {
+					mu.Unlock()
+					return
+				}
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s of %d bytes isn't reported: %+v", stage, size, progress)
+	}
+	stop := treport.WatchPack(ctx, dir, 20*time.Millisecond)
+	defer stop()
+	pack := filepath.Join(packDir, "tmp_pack_1")
+	if err := ioutil.WriteFile(pack, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitStage(treport.CloneStageReceiving, 100)
+	waitStage(treport.CloneStageResolving, 100)
+	if err := ioutil.WriteFile(pack, make([]byte, 300), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitStage(treport.CloneStageReceiving, 300)
+	mu.Lock()
+	for _, p := range progress {
+		if p.Operation != treport.CloneOperationFetch || p.Repo != dir {
+			t.Fatalf("unexpected progress %+v", p)
+		}
+	}
+	mu.Unlock()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+)
+
+func init() {
+	register(&command{
+		name:  "cache",
+		usage: "manage scan cache ( path, stats, clear, gc, orphans, prune, export, import )",
+		run:   runCache,
+	})
+}
+
+func runCache(ctx context.Context, args []string) error {
+	fs, opts := newFlagSet("cache")
+	pipeline := fs.String("pipeline", "", "select the caches of the pipeline")
+	plugin := fs.String("plugin", "", "select the caches of the plugin")
+	since := fs.String("since", "", "clear only the results of the commits committed at or after the time ( RFC3339 or 2006-01-02 )")
+	until := fs.String("until", "", "clear only the results of the commits committed before the time ( RFC3339 or 2006-01-02 )")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return errUsage("usage: treport cache [flags] <path|stats|clear|gc|orphans|prune|export|import> [archive]")
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	filter := &treport.CacheFilter{Pipeline: *pipeline, Plugin: *plugin}
+	if filter.Since, err = parseTime(*since); err != nil {
+		return errUsage("invalid -since: %s", err)
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		return errUsage("invalid -until: %s", err)
+	}
+	selected := *pipeline != "" || *plugin != "" || *since != "" || *until != ""
+	switch fs.Arg(0) {
+	case "path":
+		for _, path := range cfg.CachePaths() {
+			fmt.Println(path)
+		}
+	case "stats":
+		stats, err := treport.CacheStatsOf(cfg, filter)
+		if err != nil {
+			return err
+		}
+		return printCacheStats(stats)
+	case "clear":
+		if !selected {
+			return treport.ClearAllCaches(ctx, cfg)
+		}
+		removed, err := treport.ClearCache(ctx, cfg, filter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d entries\n", removed)
+	case "gc":
+		return treport.GCCache(ctx, cfg, filter)
+	case "orphans":
+		orphans, err := treport.OrphanCaches(cfg)
+		if err != nil {
+			return err
+		}
+		return printOrphanCaches(orphans)
+	case "prune":
+		orphans, err := treport.RemoveOrphanCaches(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d orphan caches\n", len(orphans))
+	case "export":
+		if fs.NArg() != 2 {
+			return errUsage("usage: treport cache [flags] export <archive>")
+		}
+		return exportCache(cfg, filter, fs.Arg(1))
+	case "import":
+		if fs.NArg() != 2 {
+			return errUsage("usage: treport cache [flags] import <archive>")
+		}
+		return importCache(ctx, cfg, filter, fs.Arg(1))
+	default:
+		return errUsage("unknown cache command %q", fs.Arg(0))
+	}
+	return nil
+}
+
+// parseTime parses RFC3339 or the date. Empty string is the zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func printCacheStats(stats []*treport.CacheStats) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PIPELINE\tREPOSITORY\tPLUGIN\tENTRIES\tSIZE\tPATH")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", s.Pipeline, repoName(s.Repo, s.Branch), s.Plugin, s.Entries, s.Size, s.Path)
+	}
+	return w.Flush()
+}
+
+func printOrphanCaches(orphans []*treport.OrphanCache) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tPATH")
+	for _, orphan := range orphans {
+		fmt.Fprintf(w, "%d\t%s\n", orphan.Size, orphan.Path)
+	}
+	return w.Flush()
+}
+
+func exportCache(cfg *treport.Config, filter *treport.CacheFilter, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", path)
+	}
+	defer f.Close()
+	exported, err := treport.ExportCache(cfg, filter, f)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	fmt.Printf("exported %d caches\n", exported)
+	return nil
+}
+
+func importCache(ctx context.Context, cfg *treport.Config, filter *treport.CacheFilter, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+	imported, skipped, err := treport.ImportCache(ctx, cfg, filter, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d caches, skipped %d caches\n", imported, skipped)
+	return nil
+}
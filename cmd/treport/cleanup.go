@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/goccy/treport"
+)
+
+func init() {
+	register(&command{
+		name:  "cleanup",
+		usage: "remove the clones of repositories which no pipeline references",
+		run:   runCleanup,
+	})
+}
+
+func runCleanup(ctx context.Context, args []string) error {
+	fs, opts := newFlagSet("cleanup")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	removed, err := treport.Cleanup(ctx, cfg)
+	for _, path := range removed {
+		fmt.Println(path)
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+	"github.com/hashicorp/go-hclog"
+)
+
+func init() {
+	register(&command{
+		name:  "daemon",
+		usage: "keep scanning pipelines by their schedule",
+		run:   runDaemon,
+	})
+}
+
+func runDaemon(ctx context.Context, args []string) error {
+	fs, opts := newFlagSet("daemon")
+	webhookAddr := fs.String("webhook-addr", "", "address to listen on for push and pull request webhooks ( disabled if empty )")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	level := hclog.Info
+	if opts.logLevel != "" {
+		level, err = opts.level()
+		if err != nil {
+			return err
+		}
+	}
+	logger := hclog.New(&hclog.LoggerOptions{Name: "treport", Level: level})
+	daemon := treport.NewDaemon(cfg, logger)
+	if *webhookAddr != "" {
+		if cfg.Webhook == nil {
+			return fmt.Errorf("webhook section with the secret is required to listen on %s for webhooks", *webhookAddr)
+		}
+		server := &http.Server{Addr: *webhookAddr, Handler: daemon.WebhookHandler(ctx)}
+		defer server.Shutdown(context.Background())
+		go func() {
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				logger.Error("failed to serve webhooks", "error", errors.Wrapf(err, "failed to listen on %s", *webhookAddr))
+			}
+		}()
+		fmt.Fprintf(os.Stderr, "listening on %s for webhooks\n", *webhookAddr)
+	}
+	return daemon.Run(ctx)
+}
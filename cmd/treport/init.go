@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/goccy/treport"
+)
+
+func init() {
+	register(&command{
+		name:  "init",
+		usage: "generate the project of a new scanner plugin ( plugin <name> )",
+		run:   runInit,
+	})
+}
+
+func runInit(ctx context.Context, args []string) error {
+	fs, _ := newFlagSet("init")
+	dir := fs.String("dir", "", "directory of the project ( the plugin name by default )")
+	module := fs.String("module", "", "module path of the project ( the plugin name by default )")
+	replace := fs.String("replace", "", "replace github.com/goccy/treport with the local directory")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || fs.Arg(0) != "plugin" {
+		return errUsage("usage: treport init [flags] plugin <name>")
+	}
+	name := fs.Arg(1)
+	if *dir == "" {
+		*dir = name
+	}
+	paths, err := treport.GeneratePlugin(*dir, &treport.PluginScaffold{
+		Name:    name,
+		Module:  *module,
+		Replace: *replace,
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fmt.Println("created", path)
+	}
+	fmt.Printf("run go generate, go mod tidy and go test in %s to build the plugin\n", *dir)
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitConfig
+	exitAuth
+	exitGit
+	exitPlugin
+	exitCache
+)
+
+// exitCodes are the exit codes of the classified errors. The other errors exit with exitError.
+var exitCodes = map[errors.ErrorCode]int{
+	errors.CodeConfig: exitConfig,
+	errors.CodeAuth:   exitAuth,
+	errors.CodeGit:    exitGit,
+	errors.CodePlugin: exitPlugin,
+	errors.CodeCache:  exitCache,
+}
+
+const defaultConfigPath = "treport.yaml"
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]*command{}
+
+func register(cmd *command) {
+	commands[cmd.name] = cmd
+}
+
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func errUsage(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// options are flags shared by all subcommands.
+type options struct {
+	configPath string
+	mountPath  string
+	strict     bool
+	logLevel   string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	opts := &options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.configPath, "config", defaultConfigPath, "path to config file")
+	fs.StringVar(&opts.mountPath, "mount", "", "override the mount path ( project.path )")
+	fs.BoolVar(&opts.strict, "strict", false, "reject unknown keys in config file")
+	fs.StringVar(&opts.logLevel, "log-level", "", "print the logs of the level ( trace, debug, info, warn or error ) to stderr")
+	return fs, opts
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		// the error message and usage have already been printed by flag package.
+		return &usageError{}
+	}
+	return nil
+}
+
+func (o *options) loadConfig() (*treport.Config, error) {
+	var loadOpts []treport.LoadOption
+	if o.strict {
+		loadOpts = append(loadOpts, treport.StrictConfig())
+	}
+	cfg, err := treport.LoadConfig(o.configPath, loadOpts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load config %s", o.configPath)
+	}
+	if o.mountPath != "" {
+		cfg.Project.Path = o.mountPath
+	}
+	return cfg, nil
+}
+
+// newScanner returns the scanner which logs by -log-level.
+func (o *options) newScanner(cfg *treport.Config) (*treport.Scanner, error) {
+	scanner := treport.NewScanner(cfg)
+	if o.logLevel == "" {
+		return scanner, nil
+	}
+	level, err := o.level()
+	if err != nil {
+		return nil, err
+	}
+	scanner.SetLogger(hclog.New(&hclog.LoggerOptions{Name: "treport", Level: level}))
+	return scanner, nil
+}
+
+func (o *options) level() (hclog.Level, error) {
+	level := hclog.LevelFromString(o.logLevel)
+	if level == hclog.NoLevel {
+		return level, errUsage("unknown log level %q", o.logLevel)
+	}
+	return level, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: treport <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigCh)
+	}()
+	return ctx, cancel
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	cmd, exists := commands[args[0]]
+	if !exists {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			usage()
+			return exitOK
+		}
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		usage()
+		return exitUsage
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	// kill the plugin processes left by the interrupted scan.
+	defer plugin.CleanupClients()
+	if err := cmd.run(ctx, args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		if _, ok := err.(*usageError); ok {
+			if err.Error() != "" {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+			return exitUsage
+		}
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		if code, exists := exitCodes[errors.Code(err)]; exists {
+			return code
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
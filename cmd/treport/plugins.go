@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/goccy/treport"
+)
+
+func init() {
+	register(&command{
+		name:  "plugins",
+		usage: "manage builtin and configured plugins ( list, install, update, verify )",
+		run:   runPlugins,
+	})
+}
+
+func runPlugins(ctx context.Context, args []string) error {
+	fs, opts := newFlagSet("plugins")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	subcommand := "list"
+	if fs.NArg() > 0 {
+		subcommand = fs.Arg(0)
+	}
+	var names []string
+	if fs.NArg() > 1 {
+		names = fs.Args()[1:]
+	}
+	switch subcommand {
+	case "list":
+		plugins, err := treport.ListPlugins(ctx, cfg, names...)
+		if err != nil {
+			return err
+		}
+		return printPlugins(plugins)
+	case "install":
+		plugins, err := treport.InstallPlugins(ctx, cfg, names...)
+		if err != nil {
+			return err
+		}
+		return printPlugins(plugins)
+	case "update":
+		plugins, err := treport.UpdatePlugins(ctx, cfg, names...)
+		if err != nil {
+			return err
+		}
+		return printPlugins(plugins)
+	case "verify":
+		return verifyPlugins(ctx, cfg, names)
+	default:
+		return errUsage("unknown plugins command %q", subcommand)
+	}
+}
+
+func printPlugins(plugins []*treport.PluginStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tKIND\tREPOSITORY\tREV\tCOMMIT\tINSTALLED\tVERSION")
+	for _, plg := range plugins {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%d\n",
+			plg.Name, plg.Kind, orDash(plg.Repo), orDash(plg.Rev), orDash(shortHash(plg.Commit)), plg.Installed, plg.Version,
+		)
+	}
+	return w.Flush()
+}
+
+// verifyPlugins prints the result of each plugin, and fails if any of them isn't verified.
+func verifyPlugins(ctx context.Context, cfg *treport.Config, names []string) error {
+	plugins, err := treport.ListPlugins(ctx, cfg, names...)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, plg := range plugins {
+		if err := plg.Verify(); err != nil {
+			failed++
+			fmt.Printf("%s: %s\n", plg.Name, err)
+			continue
+		}
+		fmt.Printf("%s: ok %s\n", plg.Name, plg.Checksum)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d plugins failed verification", failed)
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
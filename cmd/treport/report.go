@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+)
+
+func init() {
+	register(&command{
+		name:  "report",
+		usage: "print scanned results or regenerate the reports from cache without scanning",
+		run:   runReport,
+	})
+}
+
+func runReport(ctx context.Context, args []string) error {
+	fs, opts := newFlagSet("report")
+	repo := fs.String("repo", "", "repository url")
+	plugin := fs.String("plugin", "", "plugin name")
+	format := fs.String("format", "", "write the report of all results in the format ( json, csv, markdown or html )")
+	output := fs.String("output", "", "write the report of -format to the file instead of stdout")
+	reports := fs.Bool("reports", false, "regenerate the reports of the config")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*repo == "") != (*plugin == "") {
+		return errUsage("both -repo and -plugin must be specified")
+	}
+	if (*format != "" || *reports) && *repo != "" {
+		return errUsage("-format and -reports can't be used with -repo and -plugin")
+	}
+	if *format != "" && *reports {
+		return errUsage("-format can't be used with -reports")
+	}
+	if *output != "" && *format == "" {
+		return errUsage("-output requires -format")
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	db, err := treport.OpenResultDB(cfg)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open result db")
+	}
+	defer db.Close()
+	if *format != "" || *reports {
+		report, err := db.Report()
+		if err != nil {
+			return errors.Wrapf(err, "failed to build report")
+		}
+		if *reports {
+			return treport.WriteReports(cfg.Reports, report)
+		}
+		return treport.WriteReports([]*treport.ReportConfig{{Name: *format, Format: treport.ReportFormat(*format), Output: *output}}, report)
+	}
+
+	var results []*treport.Result
+	if *repo != "" {
+		results, err = db.Commits(*repo, *plugin)
+	} else {
+		results, err = db.Range(time.Time{}, time.Time{})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get results")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+)
+
+func init() {
+	register(&command{
+		name:  "runs",
+		usage: "manage recorded runs ( list, show <id>, reproduce <id>, history [pipeline] )",
+		run:   runRuns,
+	})
+}
+
+func runRuns(ctx context.Context, args []string) error {
+	fs, opts := newFlagSet("runs")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errUsage("usage: treport runs [flags] <list|show|reproduce|history> [id|pipeline]")
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	switch fs.Arg(0) {
+	case "list":
+		runs, err := treport.Runs(cfg)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			status := "ok"
+			switch {
+			case run.FinishedAt.IsZero():
+				status = "unfinished"
+			case run.Interrupted:
+				status = "interrupted"
+			case run.Error != "":
+				status = "failed"
+			}
+			fmt.Printf("%s\t%s\t%s\n", run.ID, run.StartedAt.Format("2006-01-02 15:04:05"), status)
+		}
+	case "show":
+		if fs.NArg() != 2 {
+			return errUsage("usage: treport runs [flags] show <id>")
+		}
+		run, err := treport.LoadRun(cfg, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(run)
+	case "reproduce":
+		if fs.NArg() != 2 {
+			return errUsage("usage: treport runs [flags] reproduce <id>")
+		}
+		run, err := treport.LoadRun(cfg, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		runCfg, err := run.LoadConfig()
+		if err != nil {
+			return err
+		}
+		scanner, err := opts.newScanner(runCfg)
+		if err != nil {
+			return err
+		}
+		if err := scanner.Reproduce(ctx, run); err != nil {
+			return errors.Wrapf(err, "failed to reproduce run %s", run.ID)
+		}
+	case "history":
+		if fs.NArg() > 2 {
+			return errUsage("usage: treport runs [flags] history [pipeline]")
+		}
+		db, err := treport.OpenResultDB(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		records, err := db.History(fs.Arg(1), "")
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			for _, repo := range record.Repos {
+				status := "ok"
+				if record.Error != "" {
+					status = "failed"
+				}
+				fmt.Printf("%s\t%s\t%s\t%s\t%d commits\t%s\n",
+					record.RunID, record.Pipeline, repo.Repo, record.StartedAt.Format("2006-01-02 15:04:05"), repo.Commits, status,
+				)
+			}
+		}
+	default:
+		return errUsage("unknown runs command %q", fs.Arg(0))
+	}
+	return nil
+}
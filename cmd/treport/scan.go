@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+)
+
+func init() {
+	register(&command{
+		name:  "scan",
+		usage: "scan repositories by all pipelines",
+		run:   runScan,
+	})
+}
+
+func runScan(ctx context.Context, args []string) (e error) {
+	fs, opts := newFlagSet("scan")
+	resume := fs.Bool("resume", false, "continue the last scan interrupted by cancellation or crash")
+	progress := fs.Bool("progress", false, "print the progress of the scan to stderr")
+	pipeline := fs.String("pipeline", "", "scan only the pipeline")
+	repos := fs.String("repo", "", "comma separated repositories of the pipeline to scan ( requires -pipeline )")
+	tags := fs.String("tag", "", "comma separated tags. scan only the enabled pipelines which have any of them")
+	dryRun := fs.Bool("dry-run", false, "print what would be scanned without cloning repositories and running plugins")
+	diff := fs.String("diff", "", "print the caches dropped and the plugins rescanned by the changes of the config since the run ( last for the last run ) without scanning")
+	summary := fs.Bool("summary", false, "print the summary of the scan to stderr")
+	profile := fs.Bool("profile", false, "print the slowest plugins and commits of the scan to stderr")
+	wait := fs.Bool("wait", false, "wait for the other process scanning the same mount path instead of failing")
+	output := fs.String("output", "", "stream the result of each commit and plugin as JSON Lines to the file while scanning ( - for stdout )")
+	ordered := fs.Bool("ordered", false, "write the results of -output for each repository in the order of the config after the repository is scanned")
+	prepareTimeout := fs.Duration("prepare-timeout", 0, "clone and sync the repositories and set up the plugins before scanning within the timeout like 10m")
+	scanTimeout := fs.Duration("scan-timeout", 0, "scan within the timeout like 1h after the repositories and the plugins are prepared")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if *ordered && *output == "" {
+		return errUsage("-ordered requires -output")
+	}
+	if *repos != "" && *pipeline == "" {
+		return errUsage("-repo requires -pipeline")
+	}
+	if *tags != "" && *pipeline != "" {
+		return errUsage("-tag can't be used with -pipeline")
+	}
+	if *diff != "" && (*tags != "" || *pipeline != "") {
+		// the pipelines which aren't selected would be reported as removed.
+		return errUsage("-diff can't be used with -pipeline and -tag")
+	}
+	if *tags != "" {
+		cfg = cfg.SelectTags(strings.Split(*tags, ",")...)
+	}
+	if *pipeline != "" {
+		var repoNames []string
+		if *repos != "" {
+			repoNames = strings.Split(*repos, ",")
+		}
+		selected, err := cfg.SelectPipeline(*pipeline, repoNames...)
+		if err != nil {
+			return err
+		}
+		cfg = selected
+	}
+	if *wait {
+		lock := treport.LockConfig{}
+		if cfg.Project.Lock != nil {
+			lock = *cfg.Project.Lock
+		}
+		lock.Wait = true
+		cfg.Project.Lock = &lock
+	}
+	scanner, err := opts.newScanner(cfg)
+	if err != nil {
+		return err
+	}
+	if *diff != "" {
+		previous, err := runConfig(cfg, *diff)
+		if err != nil {
+			return err
+		}
+		d, err := scanner.Diff(ctx, previous)
+		if err != nil {
+			return errors.Wrapf(err, "failed to diff config")
+		}
+		return printDiff(d)
+	}
+	if *dryRun {
+		plan, err := scanner.Plan(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "failed to plan scan")
+		}
+		return printPlan(plan)
+	}
+	if *progress {
+		scanner.OnProgress(printProgress)
+	}
+	if *output != "" {
+		w := os.Stdout
+		if *output != "-" {
+			f, err := os.Create(*output)
+			if err != nil {
+				return errors.Wrapf(err, "failed to create %s", *output)
+			}
+			defer f.Close()
+			w = f
+		}
+		results := treport.NewResultWriter(w)
+		if *ordered {
+			scanner.SubscribeOrdered(results.Handle)
+		} else {
+			scanner.Subscribe(results.Handle)
+		}
+		defer func() {
+			if err := results.Err(); err != nil && e == nil {
+				e = err
+			}
+		}()
+	}
+	if *profile {
+		defer func() {
+			if s := scanner.Summary(); s != nil {
+				fmt.Fprint(os.Stderr, s.Profile())
+			}
+		}()
+	}
+	if *summary {
+		// print the summary also for the failed scan to show what was done.
+		defer func() {
+			if s := scanner.Summary(); s != nil {
+				fmt.Fprint(os.Stderr, s)
+			}
+		}()
+	}
+	if *prepareTimeout > 0 {
+		if err := prepareScan(ctx, scanner, *prepareTimeout); err != nil {
+			return err
+		}
+		defer scanner.Close()
+	}
+	if *scanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *scanTimeout)
+		defer cancel()
+	}
+	if *resume {
+		if err := scanner.Resume(ctx); err != nil {
+			return errors.Wrapf(err, "failed to resume scan")
+		}
+		return nil
+	}
+	if err := scanner.Scan(ctx); err != nil {
+		return errors.Wrapf(err, "failed to scan")
+	}
+	return nil
+}
+
+func prepareScan(ctx context.Context, scanner *treport.Scanner, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := scanner.Prepare(ctx); err != nil {
+		return errors.Wrapf(err, "failed to prepare scan")
+	}
+	return nil
+}
+
+func printProgress(ev *treport.ProgressEvent) {
+	switch ev.Type {
+	case treport.ProgressClone:
+		printCloneProgress(ev.Clone)
+	case treport.ProgressSynced:
+		fmt.Fprintf(os.Stderr, "%s: synced in %s\n", ev.Repo, ev.Elapsed)
+	case treport.ProgressPipelineStarted:
+		fmt.Fprintf(os.Stderr, "pipeline %s: started\n", ev.Pipeline)
+	case treport.ProgressPipelineFinished:
+		if ev.Err != nil {
+			fmt.Fprintf(os.Stderr, "pipeline %s: failed: %s\n", ev.Pipeline, ev.Err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "pipeline %s: finished\n", ev.Pipeline)
+	case treport.ProgressPluginFinished:
+		fmt.Fprintf(os.Stderr, "pipeline %s: %s: %s: %d commits in %s\n", ev.Pipeline, repoName(ev.Repo, ev.Branch), ev.Plugin, ev.Scanned, ev.Elapsed)
+	case treport.ProgressCommitScanned:
+		fmt.Fprintf(os.Stderr, "pipeline %s: %s: %s: [%d/%d] %s\n", ev.Pipeline, repoName(ev.Repo, ev.Branch), ev.Plugin, ev.Scanned, ev.Total, ev.Commit)
+	}
+}
+
+func printCloneProgress(p *treport.CloneProgress) {
+	batch := ""
+	if p.Batch > 0 {
+		batch = fmt.Sprintf(" [batch %d/%d]", p.Batch, p.Batches)
+	}
+	if p.Stage == treport.CloneStageReceiving || p.Stage == treport.CloneStageResolving {
+		fmt.Fprintf(os.Stderr, "%s: %s%s: %s: %d bytes in %s\n", p.Repo, p.Operation, batch, p.Stage, p.BytesReceived, p.Elapsed.Round(time.Second))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s%s: %s: %d%% (eta %s)\n", p.Repo, p.Operation, batch, p.Stage, p.Percent, p.ETA.Round(time.Second))
+}
+
+// repoName returns the repository with the branch scanned as a separate stream.
+func repoName(repo, branch string) string {
+	if branch == "" {
+		return repo
+	}
+	return repo + "@" + branch
+}
+
+func printPlan(plan *treport.Plan) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PIPELINE\tSTRATEGY\tREPOSITORY\tACTION\tCOMMITS\tSTEP\tPLUGIN\tCACHE\tCACHED\tPENDING")
+	for _, pipeline := range plan.Pipelines {
+		for _, repo := range pipeline.Repos {
+			commits := fmt.Sprint(len(repo.Commits))
+			if repo.Action == treport.RepositoryClone {
+				commits = "-"
+			}
+			for _, plg := range repo.Plugins {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%d\t%d\n",
+					pipeline.Name, pipeline.Strategy, repoName(repo.Repo, repo.Branch), repo.Action, commits,
+					plg.Step, plg.Name, plg.Cache, plg.Cached, plg.Pending,
+				)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// runConfig returns the config of the recorded run. id is the ID of the run or last.
+func runConfig(cfg *treport.Config, id string) (*treport.Config, error) {
+	if id == "last" {
+		runs, err := treport.Runs(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) == 0 {
+			return nil, fmt.Errorf("no run is recorded")
+		}
+		id = runs[len(runs)-1].ID
+	}
+	run, err := treport.LoadRun(cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	return run.LoadConfig()
+}
+
+func printDiff(diff *treport.ConfigDiff) error {
+	for _, pipeline := range diff.Pipelines {
+		fmt.Printf("pipeline %s: %s\n", pipeline.Name, pipeline.Status)
+		for _, change := range pipeline.Changes {
+			fmt.Printf("  change: %s\n", change)
+		}
+		for _, warning := range pipeline.Warnings {
+			fmt.Printf("  warning: %s\n", warning)
+		}
+		for _, cache := range pipeline.Dropped {
+			fmt.Printf("  drop: %s: steps[%d] %s: %s\n", repoName(cache.Repo, cache.Branch), cache.Step, cache.Plugin, cache.Path)
+		}
+		for _, cache := range pipeline.Rescanned {
+			fmt.Printf("  rescan: %s: steps[%d] %s: %d commits\n", repoName(cache.Repo, cache.Branch), cache.Step, cache.Plugin, cache.Pending)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+)
+
+func init() {
+	register(&command{
+		name:  "schema",
+		usage: "print JSON Schema of config file",
+		run:   runSchema,
+	})
+}
+
+func runSchema(ctx context.Context, args []string) error {
+	fs, _ := newFlagSet("schema")
+	output := fs.String("o", "", "write the schema to the file instead of stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	schema, err := (&treport.Config{}).JSONSchema()
+	if err != nil {
+		return errors.Wrapf(err, "failed to generate schema")
+	}
+	schema = append(schema, '\n')
+	if *output == "" {
+		_, err := os.Stdout.Write(schema)
+		return err
+	}
+	return ioutil.WriteFile(*output, schema, 0644)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+	"google.golang.org/grpc"
+)
+
+func init() {
+	register(&command{
+		name:  "serve",
+		usage: "serve HTTP ( and gRPC ) API for results and on-demand scans",
+		run:   runServe,
+	})
+}
+
+func runServe(ctx context.Context, args []string) error {
+	fs, opts := newFlagSet("serve")
+	addr := fs.String("addr", ":8080", "address to listen on")
+	grpcAddr := fs.String("grpc-addr", "", "address to listen on for gRPC control API ( disabled if empty )")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	handler := treport.NewServer(cfg)
+	defer handler.Close()
+	server := &http.Server{Addr: *addr, Handler: handler}
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *addr)
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return errors.Wrapf(err, "failed to listen on %s", *grpcAddr)
+		}
+		grpcServer := grpc.NewServer()
+		handler.RegisterControlService(grpcServer)
+		defer grpcServer.Stop()
+		go func() {
+			errCh <- grpcServer.Serve(lis)
+		}()
+		fmt.Fprintf(os.Stderr, "listening on %s for gRPC\n", *grpcAddr)
+	}
+	select {
+	case err := <-errCh:
+		return errors.Wrapf(err, "failed to serve")
+	case <-ctx.Done():
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		return errors.Wrapf(err, "failed to shutdown server")
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func init() {
+	register(&command{
+		name:  "validate",
+		usage: "validate config file",
+		run:   runValidate,
+	})
+}
+
+func runValidate(ctx context.Context, args []string) error {
+	fs, opts := newFlagSet("validate")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	fmt.Printf("%s is valid\n", opts.configPath)
+	return nil
+}
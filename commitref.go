@@ -0,0 +1,87 @@
+package treport
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// mergePullRequestMatcher matches the message of the merge commit created by GitHub.
+	mergePullRequestMatcher = regexp.MustCompile(`^Merge pull request #(\d+)\b`)
+	// mergeRequestMatcher matches the trailer of the merge commit created by GitLab.
+	mergeRequestMatcher = regexp.MustCompile(`(?m)^See merge request \S*!(\d+)\s*$`)
+	// squashPullRequestMatcher matches the suffix of the subject of the squashed or rebased pull request on GitHub.
+	squashPullRequestMatcher = regexp.MustCompile(`\(#(\d+)\)\s*$`)
+	// issueReferenceMatcher matches #123, GH-123 and org/repo#123.
+	issueReferenceMatcher = regexp.MustCompile(`(?:^|[^\w/#.:-])(?:[\w.-]+/[\w.-]+)?(?:#|GH-)(\d+)\b`)
+)
+
+// parseCommitReferences returns the number of the pull request which merged the commit by the message written
+// by GitHub or GitLab, and the issue and pull request numbers referenced by the message in the order of appearance.
+// The number of the merging pull request isn't included in the references.
+func parseCommitReferences(message string) (int64, []int64) {
+	subject := strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+	var pullRequest int64
+	for _, matches := range [][]string{
+		mergePullRequestMatcher.FindStringSubmatch(subject),
+		mergeRequestMatcher.FindStringSubmatch(message),
+		squashPullRequestMatcher.FindStringSubmatch(subject),
+	} {
+		if len(matches) == 2 {
+			pullRequest, _ = strconv.ParseInt(matches[1], 10, 64)
+			break
+		}
+	}
+	references := []int64{}
+	seen := map[int64]struct{}{pullRequest: {}}
+	for _, matches := range issueReferenceMatcher.FindAllStringSubmatch(message, -1) {
+		number, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, exists := seen[number]; exists {
+			continue
+		}
+		seen[number] = struct{}{}
+		references = append(references, number)
+	}
+	return pullRequest, references
+}
+
+// linkPullRequests loads the numbers of the pull requests keyed by the hashes of the commits which merged them
+// by the API of the provider if linkCommits of the config is enabled.
+func (r *Repository) linkPullRequests(ctx context.Context) error {
+	if !r.cfg.PullRequest.linkCommits() {
+		return nil
+	}
+	merged, err := r.mergedPullRequests(ctx)
+	if err != nil {
+		return err
+	}
+	r.linkedPullRequests = make(map[string]int64, len(merged))
+	for hash, pr := range merged {
+		r.linkedPullRequests[hash] = pr.Number
+	}
+	return nil
+}
+
+// linkPullRequest sets the number of the pull request found by the API, which is preferred to the one written in the message.
+func (r *Repository) linkPullRequest(commit *Commit) {
+	number, exists := r.linkedPullRequests[commit.Hash]
+	if !exists || number == commit.PullRequestNumber {
+		return
+	}
+	references := []int64{}
+	if commit.PullRequestNumber != 0 {
+		references = append(references, commit.PullRequestNumber)
+	}
+	for _, ref := range commit.References {
+		if ref != number {
+			references = append(references, ref)
+		}
+	}
+	commit.PullRequestNumber = number
+	commit.References = references
+}
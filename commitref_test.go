@@ -0,0 +1,30 @@
+package treport_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestParseCommitReferences(t *testing.T) {
+	for _, test := range []struct {
+		message     string
+		pullRequest int64
+		references  []int64
+	}{
+		{message: "Merge pull request #12 from goccy/feature\n\nFix #3", pullRequest: 12, references: []int64{3}},
+		{message: "Add scanner (#34)\n\nCloses #5, refs GH-6 and goccy/go-yaml#7", pullRequest: 34, references: []int64{5, 6, 7}},
+		{message: "Merge branch 'feature' into 'master'\n\nFix #8\n\nSee merge request goccy/treport!9", pullRequest: 9, references: []int64{8}},
+		{message: "Fix #1 and #1 again", references: []int64{1}},
+		{message: "Update README\n\nsee https://example.com/page#10 and issue#11", references: []int64{}},
+	} {
+		pullRequest, references := treport.ParseCommitReferences(test.message)
+		if pullRequest != test.pullRequest {
+			t.Errorf("%q: expected pull request %d but got %d", test.message, test.pullRequest, pullRequest)
+		}
+		if !reflect.DeepEqual(references, test.references) {
+			t.Errorf("%q: expected references %v but got %v", test.message, test.references, references)
+		}
+	}
+}
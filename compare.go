@@ -0,0 +1,168 @@
+package treport
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// Comparison is the structured diff of the plugin results between two refs.
+type Comparison struct {
+	Repo    string
+	RefA    string
+	RefB    string
+	A       *CommitReport
+	B       *CommitReport
+	Plugins []*PluginComparison
+}
+
+type PluginComparison struct {
+	Plugin string
+	A      *PluginResult
+	B      *PluginResult
+	// Delta has the difference ( B - A ) of each numeric field of the result.
+	Delta map[string]float64
+}
+
+// Compare scans refA and refB of the repository by the configured plugins and compares their results.
+func (s *Scanner) Compare(ctx context.Context, repo, refA, refB string) (*Comparison, error) {
+	if err := s.setupMountPoint(); err != nil {
+		return nil, errors.Wrapf(err, "failed to setup mount point")
+	}
+	pipelines, err := CreatePipelines(ctx, s.cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create pipelines")
+	}
+	defer func() {
+		for _, pipeline := range pipelines {
+			pipeline.Cleanup()
+		}
+	}()
+	target := findPipelineRepository(pipelines, repo)
+	if target == nil {
+		return nil, fmt.Errorf("failed to find repository %s in pipelines", repo)
+	}
+	if skip, err := s.syncBaseBranch(ctx, target); err != nil {
+		return nil, errors.Stack(err)
+	} else if skip {
+		return nil, ErrEmptyRepository(repo)
+	}
+	a, err := s.scanRevision(ctx, target, refA)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to scan %s", refA)
+	}
+	b, err := s.scanRevision(ctx, target, refB)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to scan %s", refB)
+	}
+	return newComparison(repo, refA, refB, a, b), nil
+}
+
+func findPipelineRepository(pipelines []*Pipeline, repo string) *PipelineRepository {
+	for _, pipeline := range pipelines {
+		for _, pipelineRepo := range pipeline.Repos {
+			if pipelineRepo.cfg.Repo == repo {
+				return pipelineRepo
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) scanRevision(ctx context.Context, repo *PipelineRepository, rev string) (*CommitReport, error) {
+	var report *CommitReport
+	if err := repo.Revision(ctx, rev, func(scanctx *ScanContext) error {
+		report = &CommitReport{
+			Commit:  scanctx.Commit,
+			Results: map[string]*PluginResult{},
+		}
+		for _, step := range repo.Steps {
+			for _, plg := range step.Plugins {
+				if plg.Stage == StageAggregate {
+					// the plugin needs the results of the walk.
+					continue
+				}
+				if err := plg.Scan(ctx, scanctx); err != nil {
+					return errors.Wrapf(err, "failed to scan by %s", plg.Name)
+				}
+				if res, exists := scanctx.pluginResponse(plg.Name); exists {
+					report.Results[plg.Name] = newPluginResult(plg.Name, res)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Stack(err)
+	}
+	return report, nil
+}
+
+func newComparison(repo, refA, refB string, a, b *CommitReport) *Comparison {
+	pluginNames := []string{}
+	for name := range a.Results {
+		pluginNames = append(pluginNames, name)
+	}
+	for name := range b.Results {
+		if _, exists := a.Results[name]; !exists {
+			pluginNames = append(pluginNames, name)
+		}
+	}
+	sort.Strings(pluginNames)
+	plugins := make([]*PluginComparison, 0, len(pluginNames))
+	for _, name := range pluginNames {
+		resultA := a.Results[name]
+		resultB := b.Results[name]
+		plugins = append(plugins, &PluginComparison{
+			Plugin: name,
+			A:      resultA,
+			B:      resultB,
+			Delta:  numericDelta(resultA, resultB),
+		})
+	}
+	return &Comparison{
+		Repo:    repo,
+		RefA:    refA,
+		RefB:    refB,
+		A:       a,
+		B:       b,
+		Plugins: plugins,
+	}
+}
+
+func numericDelta(a, b *PluginResult) map[string]float64 {
+	fieldsA := numericFields(a)
+	fieldsB := numericFields(b)
+	delta := map[string]float64{}
+	for name, v := range fieldsB {
+		delta[name] = v - fieldsA[name]
+	}
+	for name, v := range fieldsA {
+		if _, exists := fieldsB[name]; !exists {
+			delta[name] = -v
+		}
+	}
+	return delta
+}
+
+// numericFields returns top level numeric fields of the result.
+// 64bit integers are encoded as string by protobuf JSON mapping, so numeric strings are also accepted.
+func numericFields(result *PluginResult) map[string]float64 {
+	fields := map[string]float64{}
+	if result == nil {
+		return fields
+	}
+	for name, v := range result.Data {
+		switch vv := v.(type) {
+		case float64:
+			fields[name] = vv
+		case string:
+			if f, err := strconv.ParseFloat(vv, 64); err == nil {
+				fields[name] = f
+			}
+		}
+	}
+	return fields
+}
@@ -1,12 +1,19 @@
 package treport
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger/v2"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/goccy/go-yaml"
 	"github.com/goccy/treport/internal/errors"
@@ -17,18 +24,45 @@ const (
 	treportRepoPath = "github.com/goccy/treport"
 )
 
-var (
-	defaultMountPath = filepath.Join(
-		os.Getenv("HOME"),
-		".treport.d",
-	)
-	urlMatcher = regexp.MustCompile(`^http?s://(.+)$`)
-)
-
 type Config struct {
-	Project   ProjectConfig     `yaml:"project"`
-	Plugin    *PluginConfig     `yaml:"plugin"`
-	Pipelines []*PipelineConfig `yaml:"pipelines"`
+	Project       ProjectConfig                `yaml:"project"`
+	Defaults      *DefaultsConfig              `yaml:"defaults"`
+	Auth          map[string]*AuthConfig       `yaml:"auth"`
+	Plugin        *PluginConfig                `yaml:"plugin"`
+	Cache         *CacheConfig                 `yaml:"cache"`
+	Pipelines     []*PipelineConfig            `yaml:"pipelines"`
+	Reports       []*ReportConfig              `yaml:"reports"`
+	Webhook       *WebhookConfig               `yaml:"webhook"`
+	Notifications []*NotificationConfig        `yaml:"notifications"`
+	Messages      map[string]*MessageTemplates `yaml:"messages"`
+	source        []byte
+	// allPipelines are the pipelines of the loaded config kept by the copies selecting some of them,
+	// so the pipelines read the cache of the unselected ones by cacheFrom.
+	allPipelines []*PipelineConfig
+}
+
+// pipelineConfig returns the pipeline of the loaded config by the name including the ones not selected by the copy.
+func (c *Config) pipelineConfig(name string) *PipelineConfig {
+	pipelines := c.allPipelines
+	if pipelines == nil {
+		pipelines = c.Pipelines
+	}
+	for _, pipelineCfg := range pipelines {
+		if pipelineCfg.Name == name {
+			return pipelineCfg
+		}
+	}
+	return nil
+}
+
+// selectPipelines returns the copy of the config which has only the pipelines.
+func (c *Config) selectPipelines(pipelines ...*PipelineConfig) *Config {
+	cfg := *c
+	if cfg.allPipelines == nil {
+		cfg.allPipelines = c.Pipelines
+	}
+	cfg.Pipelines = pipelines
+	return &cfg
 }
 
 func (c *Config) MountPath() string {
@@ -43,6 +77,80 @@ func (c *Config) CachePath() string {
 	return filepath.Join(c.MountPath(), "cache")
 }
 
+// repoPathOf returns the directory which the repository of the pipeline is cloned under.
+// The mount path of the repository takes precedence over the one of the pipeline and project.path.
+func (c *Config) repoPathOf(pipelineCfg *PipelineConfig, repoCfg *RepositoryConfig) string {
+	switch {
+	case repoCfg != nil && repoCfg.MountPath != "":
+		return filepath.Join(repoCfg.MountPath, "repo")
+	case pipelineCfg != nil && pipelineCfg.MountPath != "":
+		return filepath.Join(pipelineCfg.MountPath, "repo")
+	}
+	return c.RepoPath()
+}
+
+// cachePathOf returns the cache directory of the repository of the pipeline.
+// If repoCfg is nil, it returns the one of the pipeline.
+func (c *Config) cachePathOf(pipelineCfg *PipelineConfig, repoCfg *RepositoryConfig) string {
+	switch {
+	case repoCfg != nil && repoCfg.CachePath != "":
+		return repoCfg.CachePath
+	case repoCfg != nil && repoCfg.MountPath != "":
+		return filepath.Join(repoCfg.MountPath, "cache")
+	case pipelineCfg != nil && pipelineCfg.CachePath != "":
+		return pipelineCfg.CachePath
+	case pipelineCfg != nil && pipelineCfg.MountPath != "":
+		return filepath.Join(pipelineCfg.MountPath, "cache")
+	}
+	return c.CachePath()
+}
+
+// CachePaths returns all cache directories including the ones overridden by pipelines and repositories.
+func (c *Config) CachePaths() []string {
+	paths := []string{c.CachePath()}
+	exists := map[string]struct{}{c.CachePath(): {}}
+	for _, pipelineCfg := range c.Pipelines {
+		repoCfgs := append([]*RepositoryConfig{nil}, pipelineCfg.Repository...)
+		for _, repoCfg := range repoCfgs {
+			path := c.cachePathOf(pipelineCfg, repoCfg)
+			if _, found := exists[path]; found {
+				continue
+			}
+			exists[path] = struct{}{}
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// diffCachePathOf returns the path of the diff cache shared by the branches of the repository.
+func (c *Config) diffCachePathOf(pipelineCfg *PipelineConfig, repoCfg *RepositoryConfig) (string, error) {
+	repoID, err := repositoryID(DefaultIDScheme, c.repoPathOf(pipelineCfg, repoCfg), repoCfg, "")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.cachePathOf(pipelineCfg, repoCfg), "diff", repoID), nil
+}
+
+// ScratchPath is the directory of the temporary files of the runs. Each run has its own directory removed after the run.
+func (c *Config) ScratchPath() string {
+	return filepath.Join(c.MountPath(), "scratch")
+}
+
+func (c *Config) RunPath() string {
+	return filepath.Join(c.MountPath(), "runs")
+}
+
+// HistoryPath is the DB of the scan records of the pipelines.
+func (c *Config) HistoryPath() string {
+	return filepath.Join(c.MountPath(), "history")
+}
+
+// BlobCachePath is the disk cache of the blob contents shared by the pipelines.
+func (c *Config) BlobCachePath() string {
+	return filepath.Join(c.MountPath(), "blob")
+}
+
 func (c *Config) PluginPath() string {
 	return filepath.Join(c.MountPath(), "plugin")
 }
@@ -59,44 +167,149 @@ func (c *Config) PluginVersionDB() (*PluginVersionDB, error) {
 	return &PluginVersionDB{db: db}, nil
 }
 
+// readOnlyPluginVersionDB opens the db for plugin version without modifying it.
+// It returns nil if the db doesn't exist yet.
+func (c *Config) readOnlyPluginVersionDB() (*PluginVersionDB, error) {
+	dbPath := filepath.Join(c.PluginPath(), "version")
+	if !existsPath(dbPath) {
+		return nil, nil
+	}
+	db, err := badger.Open(badger.DefaultOptions(dbPath).WithReadOnly(true))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open db for plugin version")
+	}
+	return &PluginVersionDB{db: db}, nil
+}
+
 type ProjectConfig struct {
 	Path string `yaml:"path"`
+	// DiskQuota is the max bytes of the repository clones. The least recently used clones are removed to fit in it.
+	DiskQuota int64 `yaml:"diskQuota"`
+	// RateLimit limits the remote operations of all pipelines.
+	RateLimit *RateLimitConfig `yaml:"rateLimit"`
+	// Transport is the transport of the repositories which don't have the transport.
+	Transport *TransportConfig `yaml:"transport"`
+	// Lock is the lock of the mount paths taken by the scans.
+	Lock *LockConfig `yaml:"lock"`
 }
 
 func (c *ProjectConfig) MountPath() string {
 	if c.Path != "" {
 		return c.Path
 	}
-	return defaultMountPath
+	return defaultMountPath()
+}
+
+// defaultMountPath is .treport.d in the home directory, or in the current directory if the home isn't known.
+func defaultMountPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".treport.d"
+	}
+	return filepath.Join(home, ".treport.d")
 }
 
 type PluginConfig struct {
 	Scanner []*RepositoryConfig `yaml:"scanner"`
 	Storer  []*RepositoryConfig `yaml:"storer"`
+	GRPC    *GRPCConfig         `yaml:"grpc"`
+	// Keepalive checks the health of the plugins, and stops the plugins kept by daemon mode after they're idle.
+	Keepalive *KeepaliveConfig `yaml:"keepalive"`
+	// Startup limits the time to start the plugins.
+	Startup *StartupConfig `yaml:"startup"`
+}
+
+// repositories returns the scanner plugins and the storer plugins.
+func (c *PluginConfig) repositories() []*RepositoryConfig {
+	if c == nil {
+		return nil
+	}
+	return append(append([]*RepositoryConfig{}, c.Scanner...), c.Storer...)
 }
 
 type RepositoryConfig struct {
-	Name   string      `yaml:"name"`
-	Repo   string      `yaml:"repo"`
-	Path   string      `yaml:"path"`
-	Branch string      `yaml:"branch"`
-	Rev    string      `yaml:"rev"`
-	Auth   *AuthConfig `yaml:"auth"`
+	Name         string             `yaml:"name"`
+	Repo         string             `yaml:"repo"`
+	Path         string             `yaml:"path"`
+	Branch       string             `yaml:"branch"`
+	Rev          string             `yaml:"rev"`
+	Auth         *AuthConfig        `yaml:"auth"`
+	SkipBranches []string           `yaml:"skipBranches"`
+	Clone        *CloneConfig       `yaml:"clone"`
+	OnError      OnError            `yaml:"onError"`
+	Retry        *RetryConfig       `yaml:"retry"`
+	PullRequest  *PullRequestConfig `yaml:"pullRequest"`
+	// Branches are glob patterns of branches like release/*. If it's set, each matching branch is scanned
+	// as a separate stream with its own cache instead of the base branch.
+	Branches   []string      `yaml:"branches"`
+	Submodules SubmoduleMode `yaml:"submodules"`
+	Storage    StorageType   `yaml:"storage"`
+	// Keyring is the path to the armored public keyring which verifies the commit signatures.
+	Keyring string `yaml:"keyring"`
+	// MountPath is the directory which has the clone and the caches of the repository instead of project.path,
+	// and CachePath is the directory of the caches only.
+	MountPath string `yaml:"mountPath"`
+	CachePath string `yaml:"cachePath"`
+	// Transport is the proxy and the TLS settings of the remote operations over HTTP(S).
+	Transport *TransportConfig `yaml:"transport"`
+	// limiter is the rate limiter of project.rateLimit shared by all repositories.
+	limiter *rateLimiter
+}
+
+// IsLocal reports whether the repository is the local one at path. It's opened in place instead of cloned,
+// and scanned as it is without syncing with the remote.
+func (c *RepositoryConfig) IsLocal() bool {
+	return c.Path != ""
+}
+
+// identity returns the url of the repository, or the path of the local repository.
+func (c *RepositoryConfig) identity() string {
+	if c.IsLocal() {
+		return c.Path
+	}
+	return c.Repo
+}
+
+// isBare reports whether the repository has no worktree.
+func (c *RepositoryConfig) isBare() bool {
+	return c.Clone.bare() || c.Storage == StorageMemory
+}
+
+// IsSkippedBranch reports whether the branch matches one of glob patterns in skipBranches.
+func (c *RepositoryConfig) IsSkippedBranch(branch string) bool {
+	return matchBranch(c.SkipBranches, branch)
+}
+
+// IsScannedBranch reports whether the branch is scanned as a separate stream by branches.
+func (c *RepositoryConfig) IsScannedBranch(branch string) bool {
+	return matchBranch(c.Branches, branch) && !c.IsSkippedBranch(branch)
+}
+
+func validBranchPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
 }
 
+func matchBranch(patterns []string, branch string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, branch); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// RepoPath returns the relative path of the clone of the repository under the mount path.
 func (c *RepositoryConfig) RepoPath() (string, error) {
 	if c.Repo == "" {
 		c.Repo = treportRepoURL
 		return treportRepoPath, nil
 	}
-	matches := urlMatcher.FindAllStringSubmatch(c.Repo, -1)
-	if len(matches) == 0 {
-		return "", ErrInvalidRepositoryPath(c.Repo)
-	}
-	if len(matches[0]) != 2 {
-		return "", ErrInvalidRepositoryPath(c.Repo)
+	u, err := ParseRepositoryURL(c.Repo)
+	if err != nil {
+		return "", err
 	}
-	return matches[0][1], nil
+	return filepath.FromSlash(u.DiskPath()), nil
 }
 
 func (c *RepositoryConfig) tryUnmarshalNameOnly(b []byte) bool {
@@ -114,12 +327,24 @@ func (c *RepositoryConfig) UnmarshalYAML(b []byte) error {
 		return nil
 	}
 	var v struct {
-		Name   string      `yaml:"name"`
-		Repo   string      `yaml:"repo"`
-		Path   string      `yaml:"path"`
-		Branch string      `yaml:"branch"`
-		Rev    string      `yaml:"rev"`
-		Auth   *AuthConfig `yaml:"auth"`
+		Name         string             `yaml:"name"`
+		Repo         string             `yaml:"repo"`
+		Path         string             `yaml:"path"`
+		Branch       string             `yaml:"branch"`
+		Rev          string             `yaml:"rev"`
+		Auth         *AuthConfig        `yaml:"auth"`
+		SkipBranches []string           `yaml:"skipBranches"`
+		Clone        *CloneConfig       `yaml:"clone"`
+		OnError      OnError            `yaml:"onError"`
+		Retry        *RetryConfig       `yaml:"retry"`
+		PullRequest  *PullRequestConfig `yaml:"pullRequest"`
+		Branches     []string           `yaml:"branches"`
+		Submodules   SubmoduleMode      `yaml:"submodules"`
+		Storage      StorageType        `yaml:"storage"`
+		Keyring      string             `yaml:"keyring"`
+		MountPath    string             `yaml:"mountPath"`
+		CachePath    string             `yaml:"cachePath"`
+		Transport    *TransportConfig   `yaml:"transport"`
 	}
 	if err := yaml.Unmarshal(b, &v); err != nil {
 		return err
@@ -130,15 +355,71 @@ func (c *RepositoryConfig) UnmarshalYAML(b []byte) error {
 	c.Branch = v.Branch
 	c.Rev = v.Rev
 	c.Auth = v.Auth
-	if c.Repo == "" {
+	c.SkipBranches = v.SkipBranches
+	c.Clone = v.Clone
+	c.OnError = v.OnError
+	c.Retry = v.Retry
+	c.PullRequest = v.PullRequest
+	c.Branches = v.Branches
+	c.Submodules = v.Submodules
+	c.Storage = v.Storage
+	c.Keyring = v.Keyring
+	c.MountPath = v.MountPath
+	c.CachePath = v.CachePath
+	c.Transport = v.Transport
+	if c.Repo == "" && c.Path == "" {
 		c.Repo = treportRepoURL
 	}
 	return nil
 }
 
+// CloneConfig enables the incremental clone for very large repositories.
+// The base branch is cloned first, and then the other refs are fetched by batchSize refs with checkpoints,
+// so an interrupted clone resumes from the last fetched batch instead of from scratch.
+// interval is the wait time between batches to limit the load of the remote.
+// bare clones the repository without the worktree, so Sync only fetches and moves the base branch.
+// prune deletes the fetched refs which are deleted in the remote after each fetch.
+type CloneConfig struct {
+	Incremental bool   `yaml:"incremental"`
+	BatchSize   int    `yaml:"batchSize"`
+	Interval    string `yaml:"interval"`
+	Bare        bool   `yaml:"bare"`
+	Prune       bool   `yaml:"prune"`
+	// RefSpecs are the refspecs of fetch like +refs/heads/*:refs/remotes/origin/*.
+	// The branches and the heads of the pull requests are fetched to the remote-tracking refs by default.
+	RefSpecs []string `yaml:"refspecs"`
+	// Tags fetches all tags of the remote. Only the tags pointing to the fetched commits are fetched by default.
+	Tags bool `yaml:"tags"`
+}
+
+func (c *CloneConfig) bare() bool {
+	return c != nil && c.Bare
+}
+
+func (c *CloneConfig) prune() bool {
+	return c != nil && c.Prune
+}
+
+func (c *CloneConfig) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse clone interval %s", c.Interval)
+	}
+	return interval, nil
+}
+
+// AuthConfig is the names of the environment variables which have the credentials.
+// It's also written as the name of the profile defined in the auth section of the config like `auth: github-bot`.
+// If source is netrc or credentialHelper, the credentials are read from ~/.netrc or the git credential helper instead.
 type AuthConfig struct {
-	UserEnv     string `yaml:"user"`
-	PasswordEnv string `yaml:"password"`
+	UserEnv     string     `yaml:"user"`
+	PasswordEnv string     `yaml:"password"`
+	Source      AuthSource `yaml:"source"`
+	// Profile is the name of the auth profile. It's replaced by the profile on loading the config.
+	Profile string `yaml:"-"`
 }
 
 func (c *AuthConfig) User() string {
@@ -165,24 +446,390 @@ func (c *AuthConfig) BasicAuth() *http.BasicAuth {
 	}
 }
 
+// BasicAuthFor returns the credentials for the repository url by the source.
+// It returns nil if the credentials aren't found.
+func (c *AuthConfig) BasicAuthFor(repoURL string) *http.BasicAuth {
+	if c == nil {
+		return nil
+	}
+	switch c.Source {
+	case AuthNetrc:
+		return netrcAuth(repoURL)
+	case AuthCredentialHelper:
+		return credentialHelperAuth(repoURL)
+	}
+	return c.BasicAuth()
+}
+
+// basicAuth returns the credentials of the http(s) repository. It's nil for the other schemes,
+// so ssh uses the default auth such as ssh-agent.
+func (c *RepositoryConfig) basicAuth() transport.AuthMethod {
+	if u, err := ParseRepositoryURL(c.Repo); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+	if auth := c.Auth.BasicAuthFor(c.Repo); auth != nil {
+		return auth
+	}
+	return nil
+}
+
+// WebhookConfig is the config of the webhook endpoints of daemon and serve mode.
+// secret is the name of the environment variable which has the secret shared with GitHub or GitLab.
+// It's required, and the events which aren't signed with it are rejected.
+type WebhookConfig struct {
+	SecretEnv string `yaml:"secret"`
+}
+
+func (c *WebhookConfig) Secret() string {
+	if c == nil {
+		return ""
+	}
+	return os.Getenv(c.SecretEnv)
+}
+
 type Strategy string
 
 const (
 	AllMergeCommit Strategy = "allMergeCommit"
 	AllCommit      Strategy = "allCommit"
 	HeadOnly       Strategy = "headOnly"
+	// FirstParent scans the first-parent chain of the base branch.
+	FirstParent Strategy = "firstParent"
+	// Worktree scans the working tree including the staged and unstaged changes relative to HEAD without syncing.
+	Worktree Strategy = "worktree"
+	// EachPullRequest scans the merged pull requests found as allMergeCommit does, and passes each of them as the unit
+	// with the changes since it forked from the mainline and ScanContext.PullRequest.
+	EachPullRequest Strategy = "pullRequest"
+	// Periodic scans the last commit of each period on the first-parent chain of the base branch.
+	// Each of them is diffed against the previous one, so the changes are the ones of the period.
+	Periodic Strategy = "periodic"
+	// Metadata scans all commits as allCommit does, but passes only the metadata of the commits like the message,
+	// the author and the signature. No trees are read or diffed, so it's much faster for the plugins which don't need the files.
+	Metadata Strategy = "metadata"
+)
+
+// Valid reports whether the strategy is one of the known strategies.
+func (s Strategy) Valid() bool {
+	switch s {
+	case AllMergeCommit, AllCommit, HeadOnly, FirstParent, Worktree, EachPullRequest, Periodic, Metadata:
+		return true
+	}
+	return false
+}
+
+// Period is the length of the period sampled by periodic strategy. The periods are bounded in UTC.
+type Period string
+
+const (
+	PeriodDay Period = "day"
+	// PeriodWeek is the ISO week which starts on Monday.
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+)
+
+// Valid reports whether the period is one of the known periods.
+func (p Period) Valid() bool {
+	switch p {
+	case PeriodDay, PeriodWeek, PeriodMonth:
+		return true
+	}
+	return false
+}
+
+// key returns the identifier of the period which t belongs to.
+func (p Period) key(t time.Time) string {
+	t = t.UTC()
+	switch p {
+	case PeriodWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case PeriodMonth:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// DiffMode is the diff semantics used to compute changes of merge commits.
+type DiffMode string
+
+const (
+	// DiffPrevious diffs against the previous scanned merge commit ( default ).
+	DiffPrevious DiffMode = "previous"
+	// DiffFirstParent diffs against the first parent of the merge commit.
+	DiffFirstParent DiffMode = "firstParent"
+	// DiffMergeBase diffs the merge base of the parents against the merged head.
+	DiffMergeBase DiffMode = "mergeBase"
+	// DiffCombined contains only changes that differ from all parents like `git diff --cc`.
+	DiffCombined DiffMode = "combined"
+)
+
+// Clock is the time which the results of the pipeline are recorded against.
+type Clock string
+
+const (
+	// ClockCommit is the committer time of the commit ( default ).
+	ClockCommit Clock = "commit"
+	// ClockAuthor is the author time of the commit, which is kept by rebase and cherry-pick.
+	ClockAuthor Clock = "author"
+	// ClockScan is the time the plugin scanned the commit. The results restored from cache keep the time of the scan.
+	ClockScan Clock = "scan"
+)
+
+// Valid reports whether the clock is one of the known clocks.
+func (c Clock) Valid() bool {
+	switch c {
+	case ClockCommit, ClockAuthor, ClockScan:
+		return true
+	}
+	return false
+}
+
+// timeOf returns the time of the clock. The commit time is used if the result doesn't have the time of the clock.
+func (c Clock) timeOf(commitTime, authorTime, scanTime time.Time) time.Time {
+	switch c {
+	case ClockAuthor:
+		if !authorTime.IsZero() {
+			return authorTime
+		}
+	case ClockScan:
+		if !scanTime.IsZero() {
+			return scanTime
+		}
+	}
+	return commitTime
+}
+
+// WalkOrder is the order of the commits passed to the plugins by allCommit and firstParent strategies.
+type WalkOrder string
+
+const (
+	OldestFirst WalkOrder = "oldestFirst"
+	// NewestFirst walks from HEAD backwards and stops at the first cached commit or after maxUncached commits,
+	// so the re-scan of the active repository doesn't walk the whole history.
+	NewestFirst WalkOrder = "newestFirst"
 )
 
 type PipelineConfig struct {
-	Name       string              `yaml:"name"`
-	Desc       string              `yaml:"desc"`
-	Strategy   Strategy            `yaml:"strategy"`
-	Repository []*RepositoryConfig `yaml:"repository"`
-	Steps      []*StepConfig       `yaml:"steps"`
+	Name             string                      `yaml:"name"`
+	Desc             string                      `yaml:"desc"`
+	Enabled          *bool                       `yaml:"enabled"`
+	Tags             []string                    `yaml:"tags"`
+	MountPath        string                      `yaml:"mountPath"`
+	CachePath        string                      `yaml:"cachePath"`
+	Strategy         Strategy                    `yaml:"strategy"`
+	Period           Period                      `yaml:"period"`
+	CacheFrom        string                      `yaml:"cacheFrom"`
+	DiffMode         DiffMode                    `yaml:"diffMode"`
+	IncludeRoot      *bool                       `yaml:"includeRoot"`
+	DiffWorkers      int                         `yaml:"diffWorkers"`
+	CacheDiffs       bool                        `yaml:"cacheDiffs"`
+	SkipEmptyChanges bool                        `yaml:"skipEmptyChanges"`
+	Clock            Clock                       `yaml:"clock"`
+	Order            WalkOrder                   `yaml:"order"`
+	MaxUncached      int                         `yaml:"maxUncached"`
+	Schedule         string                      `yaml:"schedule"`
+	Limits           *LimitsConfig               `yaml:"limits"`
+	Ignore           *IgnoreConfig               `yaml:"ignore"`
+	Filter           *FilterConfig               `yaml:"filter"`
+	Notification     *PipelineNotificationConfig `yaml:"notification"`
+	Repository       []*RepositoryConfig         `yaml:"repository"`
+	Steps            []*StepConfig               `yaml:"steps"`
+	Before           HookCommands                `yaml:"before"`
+	After            HookCommands                `yaml:"after"`
+}
+
+// IsEnabled reports whether the pipeline is scanned. The disabled pipeline is scanned only when it's selected by the name.
+func (c *PipelineConfig) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
 }
 
+// HasTag reports whether the pipeline has any of the tags.
+func (c *PipelineConfig) HasTag(tags ...string) bool {
+	for _, tag := range tags {
+		for _, t := range c.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StepDependencies returns the indexes of the steps which each step directly depends on.
+// The step without dependsOn depends on all previous steps.
+func (c *PipelineConfig) StepDependencies() ([][]int, error) {
+	indexes := map[string]int{}
+	deps := make([][]int, 0, len(c.Steps))
+	for idx, stepCfg := range c.Steps {
+		if stepCfg.DependsOn == nil {
+			stepDeps := make([]int, 0, idx)
+			for i := 0; i < idx; i++ {
+				stepDeps = append(stepDeps, i)
+			}
+			deps = append(deps, stepDeps)
+		} else {
+			stepDeps := make([]int, 0, len(stepCfg.DependsOn))
+			for _, name := range stepCfg.DependsOn {
+				i, exists := indexes[name]
+				if !exists {
+					return nil, fmt.Errorf("steps[%d] depends on unknown step %q. dependencies must be defined before the step", idx, name)
+				}
+				stepDeps = append(stepDeps, i)
+			}
+			sort.Ints(stepDeps)
+			deps = append(deps, stepDeps)
+		}
+		if stepCfg.Name != "" {
+			indexes[stepCfg.Name] = idx
+		}
+	}
+	return deps, nil
+}
+
+// stepName returns the name of the step, or steps[idx] if it isn't named.
+func (c *PipelineConfig) stepName(idx int) string {
+	if name := c.Steps[idx].Name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("steps[%d]", idx)
+}
+
+// dependencyIDs returns the parts of the pipeline id for the explicit step dependencies.
+// The results of the plugins depend on the data passed from other steps, so the cache is separated by them.
+func (c *PipelineConfig) dependencyIDs() []string {
+	var ids []string
+	for idx, stepCfg := range c.Steps {
+		if stepCfg.DependsOn == nil {
+			continue
+		}
+		ids = append(ids, fmt.Sprintf("%03d:dependsOn:%s", idx, strings.Join(stepCfg.DependsOn, ",")))
+	}
+	return ids
+}
+
+// argsIDs returns the parts of the pipeline id for the args of the plugins. The results depend on them.
+func (c *PipelineConfig) argsIDs() []string {
+	var ids []string
+	for idx, stepCfg := range c.Steps {
+		stepIDs := []string{}
+		for _, pluginExecCfg := range stepCfg.Plugins {
+			if len(pluginExecCfg.Args) == 0 {
+				continue
+			}
+			stepIDs = append(stepIDs, fmt.Sprintf("%03d:args:%s:%s", idx, pluginExecCfg.Name, strings.Join(pluginExecCfg.Args, "\x00")))
+		}
+		// the order of the plugins in the step doesn't change the results.
+		sort.Strings(stepIDs)
+		ids = append(ids, stepIDs...)
+	}
+	return ids
+}
+
+// ResultClock returns the clock of the results. It's the commit time by default.
+func (c *PipelineConfig) ResultClock() Clock {
+	if c.Clock == "" {
+		return ClockCommit
+	}
+	return c.Clock
+}
+
+func (c *PipelineConfig) MergeDiffMode() DiffMode {
+	if c.DiffMode == "" {
+		return DiffPrevious
+	}
+	return c.DiffMode
+}
+
+// IncludesRoot reports whether the root commit is scanned. It's true unless includeRoot is false.
+func (c *PipelineConfig) IncludesRoot() bool {
+	return c.IncludeRoot == nil || *c.IncludeRoot
+}
+
+func (c *PipelineConfig) WalkOptions() *WalkOptions {
+	return &WalkOptions{
+		DiffMode:    c.MergeDiffMode(),
+		IncludeRoot: c.IncludesRoot(),
+		DiffWorkers: c.DiffWorkers,
+		NewestFirst: c.Order == NewestFirst,
+		MaxUncached: c.MaxUncached,
+		Period:      c.Period,
+		Filter:      c.Filter,
+		// the metadata strategy walks all commits without reading the trees.
+		MetadataOnly: c.Strategy == Metadata,
+	}
+}
+
+// LimitsConfig bounds the size of the scan context passed to plugins. Zero means unlimited.
+// If a commit exceeds the limit, the context is downgraded to the largest entries and Truncated is set by default.
+// The files larger than maxFileSize are excluded from the snapshot and the changes.
+type LimitsConfig struct {
+	MaxSnapshotEntries int         `yaml:"maxSnapshotEntries"`
+	MaxChanges         int         `yaml:"maxChanges"`
+	MaxFileSize        int64       `yaml:"maxFileSize"`
+	OnExceed           LimitAction `yaml:"onExceed"`
+}
+
+// LimitAction is how the commit exceeding the limits is handled.
+type LimitAction string
+
+const (
+	// LimitTruncate passes the context downgraded by the limits with Truncated. It's the default.
+	LimitTruncate LimitAction = "truncate"
+	// LimitSkip skips the commit for the plugins like the commit skipped by the plugin.
+	LimitSkip LimitAction = "skip"
+	// LimitFail fails the scan by LimitExceededError.
+	LimitFail LimitAction = "fail"
+)
+
+func (a LimitAction) valid() bool {
+	switch a {
+	case "", LimitTruncate, LimitSkip, LimitFail:
+		return true
+	}
+	return false
+}
+
+// StepStage is when the plugins of the step run.
+type StepStage string
+
+const (
+	// StageCommit runs the plugins for each commit of the walk. It's the default.
+	StageCommit StepStage = "commit"
+	// StageAggregate runs the plugins once per repository after the walk, and passes them the results of
+	// the upstream plugins for all commits in commit order, so they reduce them to a summary like min, max or trend.
+	// The result is stored for the last commit of the walk, and it isn't cached.
+	StageAggregate StepStage = "aggregate"
+)
+
+func (s StepStage) valid() bool {
+	switch s {
+	case "", StageCommit, StageAggregate:
+		return true
+	}
+	return false
+}
+
+// StepConfig is the plugins run in parallel for each commit.
+// By default, the step runs after all previous steps, and the plugins see the results of them for the same commit.
+// If dependsOn is set, the step runs after only the named steps, and the plugins see the results of them and their dependencies.
 type StepConfig struct {
-	Plugins []*PluginExecConfig
+	Name      string
+	DependsOn []string
+	Stage     StepStage
+	Plugins   []*PluginExecConfig
+	// Before and After are the hooks run for each repository before and after the step.
+	Before HookCommands
+	After  HookCommands
+}
+
+// stepDefinition is the step written as mapping with name, dependencies and hooks.
+type stepDefinition struct {
+	Name      string       `yaml:"name"`
+	DependsOn []string     `yaml:"dependsOn"`
+	Stage     StepStage    `yaml:"stage,omitempty"`
+	Plugins   *StepConfig  `yaml:"plugins"`
+	Before    HookCommands `yaml:"before,omitempty"`
+	After     HookCommands `yaml:"after,omitempty"`
 }
 
 func (c *StepConfig) tryPluginNameOnly(b []byte) bool {
@@ -209,6 +856,34 @@ func (c *StepConfig) tryPluginNamesOnly(b []byte) bool {
 	return false
 }
 
+func (c *StepConfig) tryStepDefinition(b []byte) bool {
+	var v stepDefinition
+	if err := yaml.Unmarshal(b, &v); err != nil || v.Plugins == nil {
+		return false
+	}
+	c.Name = v.Name
+	c.DependsOn = v.DependsOn
+	c.Stage = v.Stage
+	c.Plugins = v.Plugins.Plugins
+	c.Before = v.Before
+	c.After = v.After
+	return true
+}
+
+func (c *StepConfig) MarshalYAML() (interface{}, error) {
+	if c.Name != "" || c.DependsOn != nil || c.Stage != "" || len(c.Before) != 0 || len(c.After) != 0 {
+		return &stepDefinition{
+			Name:      c.Name,
+			DependsOn: c.DependsOn,
+			Stage:     c.Stage,
+			Plugins:   &StepConfig{Plugins: c.Plugins},
+			Before:    c.Before,
+			After:     c.After,
+		}, nil
+	}
+	return c.Plugins, nil
+}
+
 func (c *StepConfig) UnmarshalYAML(b []byte) error {
 	if c.tryPluginNameOnly(b) {
 		return nil
@@ -216,6 +891,9 @@ func (c *StepConfig) UnmarshalYAML(b []byte) error {
 	if c.tryPluginNamesOnly(b) {
 		return nil
 	}
+	if c.tryStepDefinition(b) {
+		return nil
+	}
 	var v PluginExecConfig
 	if err := yaml.Unmarshal(b, &v); err == nil {
 		c.Plugins = append(c.Plugins, &v)
@@ -224,19 +902,179 @@ func (c *StepConfig) UnmarshalYAML(b []byte) error {
 	return yaml.Unmarshal(b, &c.Plugins)
 }
 
+// ReportConfig renders the aggregated scan results by Go template.
+// If output is empty, the report is written to stdout.
+type ReportConfig struct {
+	Name     string `yaml:"name"`
+	Template string `yaml:"template"`
+	// Format is the builtin format used instead of Template. It's json, csv, markdown or html.
+	Format ReportFormat `yaml:"format"`
+	Output string       `yaml:"output"`
+}
+
+type NotificationType string
+
+const (
+	SlackNotification   NotificationType = "slack"
+	EmailNotification   NotificationType = "email"
+	WebhookNotification NotificationType = "webhook"
+)
+
+// NotificationConfig is the destination of notifications.
+// url is the name of the environment variable which has the incoming webhook url of Slack or the url of webhook.
+// on is the list of statuses to notify ( success and failure by default ).
+type NotificationConfig struct {
+	Name   string              `yaml:"name"`
+	Type   NotificationType    `yaml:"type"`
+	URLEnv string              `yaml:"url"`
+	Email  *EmailConfig        `yaml:"email"`
+	Locale string              `yaml:"locale"`
+	On     []NotificationEvent `yaml:"on"`
+}
+
+func (c *NotificationConfig) URL() string {
+	return os.Getenv(c.URLEnv)
+}
+
+// EmailConfig is the SMTP server and the addresses of email notifications.
+type EmailConfig struct {
+	Addr string      `yaml:"addr"`
+	From string      `yaml:"from"`
+	To   []string    `yaml:"to"`
+	Auth *AuthConfig `yaml:"auth"`
+}
+
+// PipelineNotificationConfig overrides the locale of all notifications and the message templates for the pipeline.
+type PipelineNotificationConfig struct {
+	Locale   string                       `yaml:"locale"`
+	Messages map[string]*MessageTemplates `yaml:"messages"`
+}
+
+// MessageTemplates are the Go templates of the notification for each status.
+type MessageTemplates struct {
+	Success *MessageTemplate `yaml:"success"`
+	Failure *MessageTemplate `yaml:"failure"`
+}
+
+// MessageTemplate is the Go template of the notification. subject is used only by email.
+type MessageTemplate struct {
+	Subject string `yaml:"subject"`
+	Body    string `yaml:"body"`
+}
+
+// SchemaPolicy is how to handle cached results whose schema version differs from the current plugin.
+type SchemaPolicy string
+
+const (
+	// SchemaPolicyInvalidate rescans the commits whose cached results have another schema version ( default ).
+	SchemaPolicyInvalidate SchemaPolicy = "invalidate"
+	// SchemaPolicyKeep reuses the cached results. Use it if the plugin can read results of older schema.
+	SchemaPolicyKeep SchemaPolicy = "keep"
+)
+
+// StorageType is where the repository is cloned.
+type StorageType string
+
+const (
+	// StorageFilesystem clones the repository under the mount path ( default ).
+	StorageFilesystem StorageType = "filesystem"
+	// StorageMemory clones the repository in memory without the worktree for every scan.
+	// It's useful for small repositories scanned by CI. The plugin caches are still stored under the mount path.
+	StorageMemory StorageType = "memory"
+)
+
+func (t StorageType) valid() bool {
+	switch t {
+	case "", StorageFilesystem, StorageMemory:
+		return true
+	}
+	return false
+}
+
+// OnError is how to handle the failure of the plugin or the repository.
+type OnError string
+
+const (
+	// OnErrorFail aborts the scan ( default ).
+	OnErrorFail OnError = "fail"
+	// OnErrorContinue records the failure and continues the scan. The scan reports partial results and returns ScanErrors.
+	OnErrorContinue OnError = "continue"
+)
+
+func (e OnError) valid() bool {
+	switch e {
+	case "", OnErrorFail, OnErrorContinue:
+		return true
+	}
+	return false
+}
+
 type PluginExecConfig struct {
-	Name string
-	Args []string
+	Name         string       `yaml:"name"`
+	Args         []string     `yaml:"args"`
+	SchemaPolicy SchemaPolicy `yaml:"schemaPolicy"`
+	OnError      OnError      `yaml:"onError"`
+	Retry        *RetryConfig `yaml:"retry"`
+	// Env is the environment variables passed to the plugin.
+	Env *PluginEnvConfig `yaml:"env"`
+	// Sandbox restricts the process of the plugin.
+	Sandbox *SandboxConfig `yaml:"sandbox"`
+	// Checkout writes the files of each scanned commit to the temporary directory for the plugin.
+	Checkout bool `yaml:"checkout"`
+	// Dirs passes the directories of the snapshot with the total size and the number of the files under them.
+	Dirs bool `yaml:"dirs"`
+	// Quarantine skips the commits which consistently fail the plugin.
+	Quarantine *QuarantineConfig `yaml:"quarantine"`
+}
+
+type loadOptions struct {
+	strict bool
+}
+
+type LoadOption func(*loadOptions)
+
+// StrictConfig rejects the config which has unknown keys like a typo of strategy.
+func StrictConfig() LoadOption {
+	return func(opts *loadOptions) {
+		opts.strict = true
+	}
 }
 
-func LoadConfig(path string) (*Config, error) {
+// LoadConfig loads the config written in YAML or JSON. JSON is decoded as YAML which is its superset,
+// and the file with the extension .json is checked as JSON before decoding, so its syntax errors are reported as JSON.
+func LoadConfig(path string, opts ...LoadOption) (*Config, error) {
+	var loadOpts loadOptions
+	for _, opt := range opts {
+		opt(&loadOpts)
+	}
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return nil, errors.WithCode(err, errors.CodeConfig)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var v interface{}
+		if err := json.Unmarshal(file, &v); err != nil {
+			return nil, errors.WithCode(errors.Wrapf(err, "failed to decode JSON config"), errors.CodeConfig)
+		}
+	}
+	if loadOpts.strict {
+		errs, err := unknownKeys(file)
+		if err != nil {
+			return nil, errors.WithCode(err, errors.CodeConfig)
+		}
+		if len(errs) != 0 {
+			return nil, errs
+		}
 	}
 	var cfg Config
 	if err := yaml.Unmarshal(file, &cfg); err != nil {
-		return nil, err
+		return nil, errors.WithCode(err, errors.CodeConfig)
 	}
+	// ${VAR} and ${VAR:-default} in the values are expanded after decoding, so the values don't change the structure.
+	expandEnvFields(reflect.ValueOf(&cfg))
+	cfg.resolveAuthProfiles()
+	cfg.applyDefaults()
+	cfg.resolveRemotes()
+	cfg.source = file
 	return &cfg, nil
 }
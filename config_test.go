@@ -0,0 +1,322 @@
+package treport_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestLoadConfigExpandEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("TREPORT_TEST_OWNER", "goccy")
+	defer os.Unsetenv("TREPORT_TEST_OWNER")
+	path := filepath.Join(dir, "treport.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+project:
+  path: ${TREPORT_TEST_UNDEFINED_PATH:-/tmp/treport}
+pipelines:
+  - name: size
+    strategy: allMergeCommit
+    repository:
+      - repo: https://github.com/${TREPORT_TEST_OWNER}/go-json
+    steps:
+      - name: size
+        args: [ "--user=${TREPORT_TEST_OWNER}", "$HOME" ]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if cfg.Project.Path != "/tmp/treport" {
+		t.Errorf("unexpected project path %q", cfg.Project.Path)
+	}
+	if repo := cfg.Pipelines[0].Repository[0].Repo; repo != "https://github.com/goccy/go-json" {
+		t.Errorf("unexpected repo %q", repo)
+	}
+	args := cfg.Pipelines[0].Steps[0].Plugins[0].Args
+	if len(args) != 2 || args[0] != "--user=goccy" || args[1] != "$HOME" {
+		t.Errorf("unexpected args %q", args)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "treport.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+defaults:
+  strategy: allCommit
+  auth:
+    user: GITHUB_USER
+    password: GITHUB_TOKEN
+pipelines:
+  - name: size
+    repository:
+      - repo: https://github.com/goccy/go-json
+      - repo: https://github.com/goccy/go-yaml
+        auth:
+          user: GITLAB_USER
+    steps:
+      - size
+  - name: head
+    strategy: headOnly
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if cfg.Pipelines[0].Strategy != treport.AllCommit || cfg.Pipelines[1].Strategy != treport.HeadOnly {
+		t.Errorf("unexpected strategies %q and %q", cfg.Pipelines[0].Strategy, cfg.Pipelines[1].Strategy)
+	}
+	repos := cfg.Pipelines[0].Repository
+	if repos[0].Auth == nil || repos[0].Auth.UserEnv != "GITHUB_USER" {
+		t.Errorf("default auth isn't inherited")
+	}
+	if repos[1].Auth.UserEnv != "GITLAB_USER" || repos[1].Auth.PasswordEnv != "" {
+		t.Errorf("auth of the repository is overridden by the default")
+	}
+}
+
+func TestLoadConfigAuthProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "treport.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+auth:
+  github-bot:
+    user: GITHUB_USER
+    password: GITHUB_TOKEN
+plugin:
+  scanner:
+    - name: loc
+      repo: https://github.com/goccy/treport-loc
+      auth: github-bot
+pipelines:
+  - name: size
+    strategy: allCommit
+    repository:
+      - repo: https://github.com/goccy/go-json
+        auth: github-bot
+      - repo: https://github.com/goccy/go-yaml
+        auth: unknown
+    steps:
+      - loc
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if auth := cfg.Plugin.Scanner[0].Auth; auth.UserEnv != "GITHUB_USER" {
+		t.Errorf("auth profile of plugin isn't resolved: %+v", auth)
+	}
+	if auth := cfg.Pipelines[0].Repository[0].Auth; auth.UserEnv != "GITHUB_USER" || auth.PasswordEnv != "GITHUB_TOKEN" {
+		t.Errorf("auth profile of repository isn't resolved: %+v", auth)
+	}
+	errs, ok := cfg.Validate().(treport.ValidationErrors)
+	if !ok {
+		t.Fatal("expected validation errors")
+	}
+	found := false
+	for _, e := range errs {
+		if e.Path == "$.pipelines[0].repository[1].auth" && e.Message == `unknown auth profile "unknown"` {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("unknown auth profile isn't reported: %s", errs)
+	}
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	if _, err := treport.LoadConfig("scan.yaml", treport.StrictConfig()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "treport.json")
+	if err := ioutil.WriteFile(path, []byte(`{
+  "pipelines": [
+    {
+      "name": "size",
+      "stratgy": "allCommit",
+      "repository": [{ "repo": "https://github.com/goccy/go-json", "clone": { "bare": true, "prun": true } }],
+      "steps": [ "size", { "name": "size", "arg": [ "-v" ] } ]
+    }
+  ]
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if cfg.Pipelines[0].Name != "size" || !cfg.Pipelines[0].Repository[0].Clone.Bare {
+		t.Fatalf("failed to load JSON config")
+	}
+	_, err = treport.LoadConfig(path, treport.StrictConfig())
+	errs, ok := err.(treport.ValidationErrors)
+	if !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []string{
+		`5:7: $.pipelines[0]: unknown key "stratgy"`,
+		`6:93: $.pipelines[0].repository[0].clone: unknown key "prun"`,
+		`7:44: $.pipelines[0].steps[1]: unknown key "arg"`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors but got %d: %s", len(expected), len(errs), errs)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(errs[i].Error(), e) {
+			t.Errorf("expected %q but got %q", e, errs[i].Error())
+		}
+	}
+}
+
+func TestConfigJSONSchema(t *testing.T) {
+	b, err := (&treport.Config{}).JSONSchema()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var schema struct {
+		Properties  map[string]interface{} `json:"properties"`
+		Definitions map[string]struct {
+			AnyOf      []interface{}          `json:"anyOf"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := schema.Properties["pipelines"]; !exists {
+		t.Fatalf("failed to find pipelines in schema")
+	}
+	if len(schema.Definitions["StepConfig"].AnyOf) != 4 {
+		t.Fatalf("failed to find the forms of steps in schema")
+	}
+	if _, exists := schema.Definitions["RetryConfig"].Properties["maxBackoff"]; !exists {
+		t.Fatalf("failed to find fields of RetryConfig in schema")
+	}
+}
+
+func TestConfigCachePaths(t *testing.T) {
+	cfg := &treport.Config{
+		Project: treport.ProjectConfig{Path: "/treport"},
+		Pipelines: []*treport.PipelineConfig{
+			{
+				Name:      "a",
+				CachePath: "/ssd/cache",
+				Repository: []*treport.RepositoryConfig{
+					{Repo: "https://github.com/goccy/go-json"},
+					{Repo: "https://github.com/goccy/go-yaml", MountPath: "/large"},
+				},
+			},
+			{Name: "b", Repository: []*treport.RepositoryConfig{{Repo: "https://github.com/goccy/go-json"}}},
+		},
+	}
+	expected := []string{"/treport/cache", "/ssd/cache", "/large/cache"}
+	paths := cfg.CachePaths()
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v but got %v", expected, paths)
+	}
+}
+
+func TestConfigBuilder(t *testing.T) {
+	cfg, err := treport.NewConfigBuilder().
+		MountPath("/treport").
+		Defaults(&treport.DefaultsConfig{Strategy: treport.AllCommit}).
+		Pipeline(
+			treport.NewPipelineBuilder("size").
+				Repository("https://github.com/goccy/go-json").
+				Step("size").
+				Build(),
+		).
+		Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if cfg.Pipelines[0].Strategy != treport.AllCommit || cfg.Pipelines[0].Steps[0].Plugins[0].Name != "size" {
+		t.Fatalf("unexpected pipeline %+v", cfg.Pipelines[0])
+	}
+	_, err = treport.NewConfigBuilder().
+		Pipeline(
+			treport.NewPipelineBuilder("size").
+				Strategy("all").
+				Repository("https://github.com/goccy/go-json").
+				Step("size").
+				Build(),
+		).
+		Build()
+	errs, ok := err.(treport.ValidationErrors)
+	if !ok || len(errs) != 1 || errs[0].Path != "$.pipelines[0].strategy" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestLoadConfigHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "treport.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+pipelines:
+  - name: size
+    strategy: allMergeCommit
+    before: echo before
+    after: [ echo after, echo done ]
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - name: publish
+        plugins: [ size ]
+        after: echo step
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	pipelineCfg := cfg.Pipelines[0]
+	if len(pipelineCfg.Before) != 1 || pipelineCfg.Before[0] != "echo before" {
+		t.Errorf("unexpected before hooks %q", pipelineCfg.Before)
+	}
+	if len(pipelineCfg.After) != 2 || pipelineCfg.After[1] != "echo done" {
+		t.Errorf("unexpected after hooks %q", pipelineCfg.After)
+	}
+	stepCfg := pipelineCfg.Steps[0]
+	if len(stepCfg.Plugins) != 1 || len(stepCfg.After) != 1 || stepCfg.After[0] != "echo step" {
+		t.Errorf("unexpected step %+v", stepCfg)
+	}
+}
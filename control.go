@@ -0,0 +1,112 @@
+package treport
+
+import (
+	"context"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// controlService is the gRPC control API. It shares the scan jobs with the HTTP API of Server.
+type controlService struct {
+	server *Server
+}
+
+// RegisterControlService registers the gRPC control API to g.
+func (s *Server) RegisterControlService(g *grpc.Server) {
+	treportproto.RegisterControlServer(g, &controlService{server: s})
+}
+
+func (c *controlService) ListPipelines(ctx context.Context, req *treportproto.ListPipelinesRequest) (*treportproto.ListPipelinesResponse, error) {
+	pipelines := c.server.pipelines()
+	res := &treportproto.ListPipelinesResponse{
+		Pipelines: make([]*treportproto.Pipeline, 0, len(pipelines)),
+	}
+	for _, pipeline := range pipelines {
+		res.Pipelines = append(res.Pipelines, &treportproto.Pipeline{
+			Name:         pipeline.Name,
+			Desc:         pipeline.Desc,
+			Strategy:     string(pipeline.Strategy),
+			Repositories: pipeline.Repositories,
+		})
+	}
+	return res, nil
+}
+
+func (c *controlService) Scan(ctx context.Context, req *treportproto.ScanRequest) (*treportproto.ScanJob, error) {
+	job, err := c.server.startScan(req.Pipeline)
+	if err != nil {
+		var notFoundErr *PipelineNotFoundError
+		if errors.As(err, &notFoundErr) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		var runningErr *PipelineRunningError
+		if errors.As(err, &runningErr) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return scanJobToProto(c.server.snapshot(job)), nil
+}
+
+func (c *controlService) GetResults(ctx context.Context, req *treportproto.GetResultsRequest) (*treportproto.GetResultsResponse, error) {
+	if (req.Repo == "") != (req.Plugin == "") {
+		return nil, status.Error(codes.InvalidArgument, "both repo and plugin must be specified")
+	}
+	results, err := c.server.results(req.Repo, req.Plugin)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &treportproto.GetResultsResponse{
+		Results: make([]*treportproto.Result, 0, len(results)),
+	}
+	for _, result := range results {
+		res.Results = append(res.Results, &treportproto.Result{
+			Pipeline:   result.Pipeline,
+			Repo:       result.Repo,
+			Plugin:     result.Plugin,
+			CommitHash: result.CommitHash,
+			CommitTime: timestampProto(result.CommitTime),
+			AuthorTime: timestampProto(result.AuthorTime),
+			ScanTime:   timestampProto(result.ScanTime),
+			Time:       timestampProto(result.Time),
+			Response:   result.Response,
+		})
+	}
+	return res, nil
+}
+
+func (c *controlService) WatchProgress(req *treportproto.WatchProgressRequest, stream treportproto.Control_WatchProgressServer) error {
+	job := c.server.findJob(req.JobId)
+	if job == nil {
+		return status.Errorf(codes.NotFound, "scan %s is not found", req.JobId)
+	}
+	return c.server.watch(stream.Context(), job, func(state ScanJob) error {
+		return stream.Send(scanJobToProto(state))
+	})
+}
+
+func scanJobToProto(job ScanJob) *treportproto.ScanJob {
+	return &treportproto.ScanJob{
+		Id:         job.ID,
+		Pipeline:   job.Pipeline,
+		Status:     string(job.Status),
+		StartedAt:  timestampProto(job.StartedAt),
+		FinishedAt: timestampProto(job.FinishedAt),
+		Error:      job.Error,
+	}
+}
+
+func timestampProto(t time.Time) *timestamp.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	ts, _ := ptypes.TimestampProto(t)
+	return ts
+}
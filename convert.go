@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/utils/merkletrie"
 	"github.com/goccy/treport/proto"
@@ -15,7 +16,7 @@ func toCommit(src *object.Commit) *Commit {
 	for _, hash := range src.ParentHashes {
 		parentHashes = append(parentHashes, hash.String())
 	}
-	return &Commit{
+	commit := &Commit{
 		Hash:         src.Hash.String(),
 		Author:       toSignature(src.Author),
 		Committer:    toSignature(src.Committer),
@@ -24,6 +25,9 @@ func toCommit(src *object.Commit) *Commit {
 		TreeHash:     src.TreeHash.String(),
 		ParentHashes: parentHashes,
 	}
+	commit.PullRequestNumber, commit.References = parseCommitReferences(src.Message)
+	commit.Trailers = parseTrailers(src.Message)
+	return commit
 }
 
 func toSignature(src object.Signature) *Signature {
@@ -34,10 +38,14 @@ func toSignature(src object.Signature) *Signature {
 	}
 }
 
-func toSnapshot(src *object.Tree) (*Snapshot, error) {
+func toSnapshot(ctx context.Context, src *object.Tree) (*Snapshot, error) {
 	entries := []*File{}
 	fileIter := src.Files()
+	defer fileIter.Close()
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		file, err := fileIter.Next()
 		if err != nil {
 			if err != io.EOF {
@@ -74,24 +82,40 @@ func toChange(src *object.Change, fromTree *object.Tree, toTree *object.Tree) (*
 		from, to *File
 	)
 	if src.From.Name != "" {
-		file, err := fromTree.TreeEntryFile(&src.From.TreeEntry)
+		file, err := toChangeFile(src.From, fromTree)
 		if err != nil {
 			return nil, err
 		}
-		from = toFile(file)
+		from = file
 	}
 	if src.To.Name != "" {
-		file, err := toTree.TreeEntryFile(&src.To.TreeEntry)
+		file, err := toChangeFile(src.To, toTree)
 		if err != nil {
 			return nil, err
 		}
-		to = toFile(file)
+		to = file
 	}
-	return &Change{
-		From:   from,
-		To:     to,
-		Action: toAction(action),
-	}, nil
+	return newChange(from, to, toAction(action)), nil
+}
+
+func toChangeFile(entry object.ChangeEntry, tree *object.Tree) (*File, error) {
+	if entry.TreeEntry.Mode == filemode.Submodule {
+		// the entry of the submodule is the commit in the submodule, so it has no blob.
+		return &File{
+			Name: entry.Name,
+			Mode: FileMode(entry.TreeEntry.Mode),
+			Hash: entry.TreeEntry.Hash.String(),
+			Type: SubmoduleFile,
+		}, nil
+	}
+	file, err := tree.TreeEntryFile(&entry.TreeEntry)
+	if err != nil {
+		return nil, err
+	}
+	f := toFile(file)
+	// the file of the tree entry has only the base name.
+	f.Name = entry.Name
+	return f, nil
 }
 
 func toFile(src *object.File) *File {
@@ -100,6 +124,18 @@ func toFile(src *object.File) *File {
 		Mode: FileMode(src.Mode),
 		Size: src.Blob.Size,
 		Hash: src.Blob.Hash.String(),
+		Type: toFileType(src.Mode),
+	}
+}
+
+func toFileType(mode filemode.FileMode) FileType {
+	switch mode {
+	case filemode.Symlink:
+		return SymlinkFile
+	case filemode.Submodule:
+		return SubmoduleFile
+	default:
+		return RegularFile
 	}
 }
 
@@ -118,11 +154,22 @@ func toAction(action merkletrie.Action) ActionType {
 
 func protoToScanContext(ctx context.Context, src *proto.ScanContext) *ScanContext {
 	return &ScanContext{
-		Context:  ctx,
-		Commit:   protoToCommit(src.Commit),
-		Snapshot: protoToSnapshot(src.Snapshot),
-		Changes:  protoToChanges(src.Changes),
-		Data:     src.Data,
+		Context:              ctx,
+		Commit:               protoToCommit(src.Commit),
+		Snapshot:             protoToSnapshot(src.Snapshot),
+		Changes:              protoToChanges(src.Changes),
+		DiffMode:             DiffMode(src.DiffMode),
+		TopoIndex:            src.TopoIndex,
+		Truncated:            src.Truncated,
+		TotalSnapshotEntries: src.TotalSnapshotEntries,
+		TotalChanges:         src.TotalChanges,
+		Data:                 src.Data,
+		pluginToType:         src.PluginToType,
+		previous:             src.Previous,
+		PullRequest:          protoToPullRequest(src.PullRequest),
+		Merge:                protoToMergeInfo(src.Merge),
+		WorktreePath:         src.WorktreePath,
+		series:               src.Series,
 	}
 }
 
@@ -134,7 +181,19 @@ func protoToSnapshot(src *proto.Snapshot) *Snapshot {
 	return &Snapshot{
 		Hash:    src.Hash,
 		Entries: entries,
+		Dirs:    protoToDirs(src.Dirs),
+	}
+}
+
+func protoToDirs(src []*proto.Dir) []*Dir {
+	if len(src) == 0 {
+		return nil
 	}
+	dirs := make([]*Dir, 0, len(src))
+	for _, dir := range src {
+		dirs = append(dirs, &Dir{Name: dir.Name, Size: dir.Size, Files: dir.Files})
+	}
+	return dirs
 }
 
 func protoToChanges(src []*proto.Change) Changes {
@@ -146,11 +205,8 @@ func protoToChanges(src []*proto.Change) Changes {
 }
 
 func protoToChange(src *proto.Change) *Change {
-	return &Change{
-		Action: protoToAction(src.Action),
-		From:   protoToFile(src.From),
-		To:     protoToFile(src.To),
-	}
+	// the mode change is detected from the files, because the caches written by the older versions don't have it.
+	return newChange(protoToFile(src.From), protoToFile(src.To), protoToAction(src.Action))
 }
 
 func protoToAction(action string) ActionType {
@@ -161,32 +217,103 @@ func protoToAction(action string) ActionType {
 		return Deleted
 	case "Updated":
 		return Updated
+	case "SubmoduleUpdated":
+		return SubmoduleUpdated
 	default:
 		return Updated
 	}
 }
 
+func protoToFileType(typ string) FileType {
+	switch typ {
+	case "Symlink":
+		return SymlinkFile
+	case "Submodule":
+		return SubmoduleFile
+	default:
+		return RegularFile
+	}
+}
+
 func protoToFile(src *proto.File) *File {
 	if src == nil {
 		return nil
 	}
 	return &File{
-		Name: src.Name,
-		Mode: FileMode(src.Mode),
-		Size: src.Size,
-		Hash: src.Hash,
+		Name:     src.Name,
+		Mode:     FileMode(src.Mode),
+		Size:     src.Size,
+		Hash:     src.Hash,
+		Type:     protoToFileType(src.Type),
+		IsBinary: src.IsBinary,
 	}
 }
 
 func protoToCommit(src *proto.Commit) *Commit {
 	return &Commit{
-		Hash:         src.Hash,
-		Author:       protoToSignature(src.Author),
-		Committer:    protoToSignature(src.Committer),
-		PGPSignature: src.PgpSignature,
-		Message:      src.Message,
-		TreeHash:     src.TreeHash,
-		ParentHashes: src.ParentHashes,
+		Hash:              src.Hash,
+		Author:            protoToSignature(src.Author),
+		Committer:         protoToSignature(src.Committer),
+		PGPSignature:      src.PgpSignature,
+		Message:           src.Message,
+		TreeHash:          src.TreeHash,
+		ParentHashes:      src.ParentHashes,
+		SignatureValid:    src.SignatureValid,
+		SignerKeyID:       src.SignerKeyID,
+		PullRequestNumber: src.PullRequestNumber,
+		References:        src.References,
+		Trailers:          protoToTrailers(src.Trailers),
+	}
+}
+
+func protoToTrailers(src []*proto.Trailer) []*Trailer {
+	if src == nil {
+		return nil
+	}
+	trailers := make([]*Trailer, 0, len(src))
+	for _, trailer := range src {
+		trailers = append(trailers, &Trailer{Key: trailer.Key, Value: trailer.Value})
+	}
+	return trailers
+}
+
+func protoToPullRequest(src *proto.PullRequest) *PullRequest {
+	if src == nil {
+		return nil
+	}
+	commits := make([]*Commit, 0, len(src.Commits))
+	for _, commit := range src.Commits {
+		commits = append(commits, protoToCommit(commit))
+	}
+	return &PullRequest{
+		Number:  src.Number,
+		Title:   src.Title,
+		Author:  src.Author,
+		Labels:  src.Labels,
+		URL:     src.Url,
+		Commits: commits,
+	}
+}
+
+func protoToMergeInfo(src *proto.MergeInfo) *MergeInfo {
+	if src == nil {
+		return nil
+	}
+	return &MergeInfo{
+		Mainline:  src.Mainline,
+		Head:      src.Head,
+		MergeBase: src.MergeBase,
+	}
+}
+
+func (m *MergeInfo) toProto() *proto.MergeInfo {
+	if m == nil {
+		return nil
+	}
+	return &proto.MergeInfo{
+		Mainline:  m.Mainline,
+		Head:      m.Head,
+		MergeBase: m.MergeBase,
 	}
 }
 
@@ -198,12 +325,30 @@ func protoToSignature(src *proto.Signature) *Signature {
 		When:  t,
 	}
 }
-func (c *ScanContext) toProto() *proto.ScanContext {
+
+// toProto converts the context to the request with the results of the upstream plugins.
+func (c *ScanContext) toProto(upstream []string) *proto.ScanContext {
+	req := c.toProtoWithoutSnapshot(upstream)
+	req.Snapshot = c.Snapshot.toProto()
+	return req
+}
+
+func (c *ScanContext) toProtoWithoutSnapshot(upstream []string) *proto.ScanContext {
+	data, pluginToType := c.upstream(upstream)
 	return &proto.ScanContext{
-		Commit:   c.Commit.toProto(),
-		Snapshot: c.Snapshot.toProto(),
-		Changes:  c.Changes.toProto(),
-		Data:     c.Data,
+		Commit:               c.Commit.toProto(),
+		Changes:              c.Changes.toProto(),
+		DiffMode:             string(c.DiffMode),
+		TopoIndex:            c.TopoIndex,
+		Truncated:            c.Truncated,
+		TotalSnapshotEntries: c.TotalSnapshotEntries,
+		TotalChanges:         c.TotalChanges,
+		Data:                 data,
+		PluginToType:         pluginToType,
+		Previous:             c.previous,
+		PullRequest:          c.PullRequest.toProto(),
+		Merge:                c.Merge.toProto(),
+		Series:               c.series,
 	}
 }
 
@@ -215,9 +360,21 @@ func (s *Snapshot) toProto() *proto.Snapshot {
 	return &proto.Snapshot{
 		Hash:    s.Hash,
 		Entries: entries,
+		Dirs:    dirsToProto(s.Dirs),
 	}
 }
 
+func dirsToProto(dirs []*Dir) []*proto.Dir {
+	if len(dirs) == 0 {
+		return nil
+	}
+	result := make([]*proto.Dir, 0, len(dirs))
+	for _, dir := range dirs {
+		result = append(result, &proto.Dir{Name: dir.Name, Size: dir.Size, Files: dir.Files})
+	}
+	return result
+}
+
 func (c Changes) toProto() []*proto.Change {
 	result := []*proto.Change{}
 	for _, cc := range c {
@@ -228,9 +385,12 @@ func (c Changes) toProto() []*proto.Change {
 
 func (c *Change) toProto() *proto.Change {
 	return &proto.Change{
-		Action: c.Action.String(),
-		From:   c.From.toProto(),
-		To:     c.To.toProto(),
+		Action:      c.Action.String(),
+		From:        c.From.toProto(),
+		To:          c.To.toProto(),
+		ModeChanged: c.ModeChanged,
+		OldMode:     uint32(c.OldMode),
+		NewMode:     uint32(c.NewMode),
 	}
 }
 
@@ -239,22 +399,59 @@ func (f *File) toProto() *proto.File {
 		return nil
 	}
 	return &proto.File{
-		Name: f.Name,
-		Mode: uint32(f.Mode),
-		Size: f.Size,
-		Hash: f.Hash,
+		Name:       f.Name,
+		Mode:       uint32(f.Mode),
+		Size:       f.Size,
+		Hash:       f.Hash,
+		Type:       f.Type.String(),
+		IsBinary:   f.IsBinary,
+		Executable: f.IsExecutable(),
 	}
 }
 
 func (c *Commit) toProto() *proto.Commit {
 	return &proto.Commit{
-		Hash:         c.Hash,
-		Author:       c.Author.toProto(),
-		Committer:    c.Committer.toProto(),
-		PgpSignature: c.PGPSignature,
-		Message:      c.Message,
-		TreeHash:     c.TreeHash,
-		ParentHashes: c.ParentHashes,
+		Hash:              c.Hash,
+		Author:            c.Author.toProto(),
+		Committer:         c.Committer.toProto(),
+		PgpSignature:      c.PGPSignature,
+		Message:           c.Message,
+		TreeHash:          c.TreeHash,
+		ParentHashes:      c.ParentHashes,
+		SignatureValid:    c.SignatureValid,
+		SignerKeyID:       c.SignerKeyID,
+		PullRequestNumber: c.PullRequestNumber,
+		References:        c.References,
+		Trailers:          trailersToProto(c.Trailers),
+	}
+}
+
+func trailersToProto(trailers []*Trailer) []*proto.Trailer {
+	if trailers == nil {
+		return nil
+	}
+	v := make([]*proto.Trailer, 0, len(trailers))
+	for _, trailer := range trailers {
+		v = append(v, &proto.Trailer{Key: trailer.Key, Value: trailer.Value})
+	}
+	return v
+}
+
+func (pr *PullRequest) toProto() *proto.PullRequest {
+	if pr == nil {
+		return nil
+	}
+	commits := make([]*proto.Commit, 0, len(pr.Commits))
+	for _, commit := range pr.Commits {
+		commits = append(commits, commit.toProto())
+	}
+	return &proto.PullRequest{
+		Number:  pr.Number,
+		Title:   pr.Title,
+		Author:  pr.Author,
+		Labels:  pr.Labels,
+		Url:     pr.URL,
+		Commits: commits,
 	}
 }
 
@@ -0,0 +1,123 @@
+package treport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Daemon keeps the repositories synced and rescans the pipelines by their schedule.
+type Daemon struct {
+	cfg    *Config
+	logger Logger
+	// scanMu serializes scans, because all pipelines share the plugin version db.
+	scanMu  sync.Mutex
+	webhook bool
+	// pluginPool keeps the plugins set up by the scans until they're idle for plugin.keepalive.idleTimeout.
+	pluginPool *pluginPool
+}
+
+func NewDaemon(cfg *Config, logger Logger) *Daemon {
+	return &Daemon{
+		cfg:        cfg,
+		logger:     logger,
+		pluginPool: newPluginPool(cfg.Plugin.keepaliveConfig().idleTimeout()),
+	}
+}
+
+// Run scans each pipeline which has the schedule whenever it's due until ctx is canceled.
+// If the scan fails, it's logged and the pipeline is scanned again at the next scheduled time.
+// Already scanned commits are skipped by cache, so each rescan processes only new commits.
+func (d *Daemon) Run(ctx context.Context) error {
+	schedules := map[string]Schedule{}
+	for _, pipelineCfg := range d.cfg.Pipelines {
+		if pipelineCfg.Schedule == "" || !pipelineCfg.IsEnabled() {
+			continue
+		}
+		schedule, err := ParseSchedule(pipelineCfg.Schedule)
+		if err != nil {
+			return fmt.Errorf("failed to parse schedule of pipeline %s: %w", pipelineCfg.Name, err)
+		}
+		schedules[pipelineCfg.Name] = schedule
+	}
+	if len(schedules) == 0 && !d.webhook {
+		return fmt.Errorf("no pipeline has schedule")
+	}
+	var wg sync.WaitGroup
+	for name, schedule := range schedules {
+		cfg, err := d.cfg.withPipeline(name)
+		if err != nil {
+			return err
+		}
+		name := name
+		schedule := schedule
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runPipeline(ctx, name, cfg, schedule)
+		}()
+	}
+	wg.Wait()
+	// keep serving webhooks until ctx is canceled, and wait for the running scan.
+	<-ctx.Done()
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
+	d.pluginPool.close()
+	return nil
+}
+
+func (d *Daemon) runPipeline(ctx context.Context, name string, cfg *Config, schedule Schedule) {
+	scanner := d.newScanner(cfg)
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			d.logger.Warn("schedule never matches", "pipeline", name)
+			return
+		}
+		d.logger.Info("next scan is scheduled", "pipeline", name, "at", next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		d.scan(ctx, name, scanner)
+	}
+}
+
+func (d *Daemon) scan(ctx context.Context, name string, scanner *Scanner) {
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	start := time.Now()
+	d.logger.Info("start scanning", "pipeline", name)
+	if err := scanner.Scan(ctx); err != nil {
+		d.logger.Error("failed to scan", "pipeline", name, "error", err)
+		return
+	}
+	d.logger.Info("finish scanning", "pipeline", name, "elapsed", time.Since(start))
+}
+
+func (d *Daemon) newScanner(cfg *Config) *Scanner {
+	scanner := NewScanner(cfg)
+	scanner.SetLogger(d.logger.Named("scanner"))
+	scanner.pluginPool = d.pluginPool
+	return scanner
+}
+
+// WebhookHandler returns the handler of the push and pull request webhooks ( POST /webhooks/github and /webhooks/gitlab ).
+// The scan of the pushed repositories starts immediately without waiting for the schedule, and it's canceled by ctx.
+func (d *Daemon) WebhookHandler(ctx context.Context) http.Handler {
+	d.webhook = true
+	mux := http.NewServeMux()
+	mux.Handle("/webhooks/", newWebhookHandler(d.cfg, func(name string, cfg *Config) error {
+		go d.scan(ctx, name, d.newScanner(cfg))
+		return nil
+	}))
+	return mux
+}
@@ -0,0 +1,96 @@
+package treport
+
+// DefaultsConfig is inherited by all pipelines and their repositories unless they set the values.
+// The structured values like auth and clone are replaced as a whole, not merged.
+// auth is also inherited by the repositories of plugins.
+type DefaultsConfig struct {
+	Strategy    Strategy           `yaml:"strategy"`
+	DiffMode    DiffMode           `yaml:"diffMode"`
+	Clock       Clock              `yaml:"clock"`
+	IncludeRoot *bool              `yaml:"includeRoot"`
+	Schedule    string             `yaml:"schedule"`
+	Limits      *LimitsConfig      `yaml:"limits"`
+	Ignore      *IgnoreConfig      `yaml:"ignore"`
+	Filter      *FilterConfig      `yaml:"filter"`
+	Auth        *AuthConfig        `yaml:"auth"`
+	Clone       *CloneConfig       `yaml:"clone"`
+	Retry       *RetryConfig       `yaml:"retry"`
+	OnError     OnError            `yaml:"onError"`
+	PullRequest *PullRequestConfig `yaml:"pullRequest"`
+	Submodules  SubmoduleMode      `yaml:"submodules"`
+	Storage     StorageType        `yaml:"storage"`
+	Keyring     string             `yaml:"keyring"`
+}
+
+// applyDefaults sets the values of defaults to the pipelines and the repositories which don't have them.
+func (c *Config) applyDefaults() {
+	d := c.Defaults
+	if d == nil {
+		return
+	}
+	if c.Plugin != nil {
+		for _, repoCfgs := range [][]*RepositoryConfig{c.Plugin.Scanner, c.Plugin.Storer} {
+			for _, repoCfg := range repoCfgs {
+				if repoCfg.Auth == nil {
+					repoCfg.Auth = d.Auth
+				}
+			}
+		}
+	}
+	for _, pipelineCfg := range c.Pipelines {
+		if pipelineCfg.Strategy == "" {
+			pipelineCfg.Strategy = d.Strategy
+		}
+		if pipelineCfg.DiffMode == "" {
+			pipelineCfg.DiffMode = d.DiffMode
+		}
+		if pipelineCfg.Clock == "" {
+			pipelineCfg.Clock = d.Clock
+		}
+		if pipelineCfg.IncludeRoot == nil {
+			pipelineCfg.IncludeRoot = d.IncludeRoot
+		}
+		if pipelineCfg.Schedule == "" {
+			pipelineCfg.Schedule = d.Schedule
+		}
+		if pipelineCfg.Limits == nil {
+			pipelineCfg.Limits = d.Limits
+		}
+		if pipelineCfg.Ignore == nil {
+			pipelineCfg.Ignore = d.Ignore
+		}
+		if pipelineCfg.Filter == nil {
+			pipelineCfg.Filter = d.Filter
+		}
+		for _, repoCfg := range pipelineCfg.Repository {
+			d.applyRepository(repoCfg)
+		}
+	}
+}
+
+func (d *DefaultsConfig) applyRepository(repoCfg *RepositoryConfig) {
+	if repoCfg.Auth == nil {
+		repoCfg.Auth = d.Auth
+	}
+	if repoCfg.Clone == nil {
+		repoCfg.Clone = d.Clone
+	}
+	if repoCfg.Retry == nil {
+		repoCfg.Retry = d.Retry
+	}
+	if repoCfg.OnError == "" {
+		repoCfg.OnError = d.OnError
+	}
+	if repoCfg.PullRequest == nil {
+		repoCfg.PullRequest = d.PullRequest
+	}
+	if repoCfg.Submodules == "" {
+		repoCfg.Submodules = d.Submodules
+	}
+	if repoCfg.Storage == "" {
+		repoCfg.Storage = d.Storage
+	}
+	if repoCfg.Keyring == "" {
+		repoCfg.Keyring = d.Keyring
+	}
+}
@@ -0,0 +1,293 @@
+package treport
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ResultTyper is implemented by GRPCScanner which declares the messages of its results.
+// The descriptors of the messages are published to the host before the first scan,
+// otherwise only the messages the plugin has returned are published.
+type ResultTyper interface {
+	ResultTypes() []proto.Message
+}
+
+// resultTypeSet is the names of the messages the plugin returned.
+type resultTypeSet struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+func (s *resultTypeSet) add(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.names == nil {
+		s.names = map[string]struct{}{}
+	}
+	s.names[name] = struct{}{}
+}
+
+func (s *resultTypeSet) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (m *grpcServer) Descriptors(ctx context.Context, req *treportproto.DescriptorsRequest) (*treportproto.Descriptors, error) {
+	names := m.types.list()
+	if typer, ok := m.Scanner.(ResultTyper); ok {
+		for _, msg := range typer.ResultTypes() {
+			names = append(names, proto.MessageName(msg))
+		}
+	}
+	sort.Strings(names)
+	files, err := fileDescriptorSet(names)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &treportproto.Descriptors{Files: files}, nil
+}
+
+// fileDescriptorSet returns the files of the messages registered to the plugin, whose dependencies come first.
+func fileDescriptorSet(names []string) (*descriptorpb.FileDescriptorSet, error) {
+	files := make([]protoreflect.FileDescriptor, 0, len(names))
+	for _, name := range names {
+		typ, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("message %s isn't registered: %w", name, err)
+		}
+		files = append(files, typ.Descriptor().ParentFile())
+	}
+	return newFileDescriptorSet(files), nil
+}
+
+func newFileDescriptorSet(files []protoreflect.FileDescriptor) *descriptorpb.FileDescriptorSet {
+	set := &descriptorpb.FileDescriptorSet{}
+	added := map[string]struct{}{}
+	var add func(protoreflect.FileDescriptor)
+	add = func(file protoreflect.FileDescriptor) {
+		if _, exists := added[file.Path()]; exists {
+			return
+		}
+		added[file.Path()] = struct{}{}
+		imports := file.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(file))
+	}
+	for _, file := range files {
+		add(file)
+	}
+	return set
+}
+
+// typeRegistry resolves the messages of the results by the descriptors published by the plugins,
+// so the host decodes the results of the plugins whose proto isn't compiled into it.
+type typeRegistry struct {
+	mu    sync.RWMutex
+	files *protoregistry.Files
+}
+
+var resultTypes = &typeRegistry{files: new(protoregistry.Files)}
+
+// register adds the files which aren't known yet. The files compiled into the host are used as they are.
+func (r *typeRegistry) register(set *descriptorpb.FileDescriptorSet) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, fd := range set.GetFile() {
+		path := fd.GetName()
+		if _, err := protoregistry.GlobalFiles.FindFileByPath(path); err == nil {
+			continue
+		}
+		if _, err := r.files.FindFileByPath(path); err == nil {
+			continue
+		}
+		file, err := protodesc.NewFile(fd, r)
+		if err != nil {
+			return errors.Wrapf(err, "failed to build descriptor of %s", path)
+		}
+		if err := r.files.RegisterFile(file); err != nil {
+			return errors.Wrapf(err, "failed to register descriptor of %s", path)
+		}
+	}
+	return nil
+}
+
+// FindFileByPath and FindDescriptorByName resolve the dependencies of the files by the host and the registered ones.
+// The lock is held by register while the files are built.
+func (r *typeRegistry) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if file, err := protoregistry.GlobalFiles.FindFileByPath(path); err == nil {
+		return file, nil
+	}
+	return r.files.FindFileByPath(path)
+}
+
+func (r *typeRegistry) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if desc, err := protoregistry.GlobalFiles.FindDescriptorByName(name); err == nil {
+		return desc, nil
+	}
+	return r.files.FindDescriptorByName(name)
+}
+
+func (r *typeRegistry) messageType(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	if typ, err := protoregistry.GlobalTypes.FindMessageByName(name); err == nil {
+		return typ, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	desc, err := r.files.FindDescriptorByName(name)
+	if err != nil {
+		return nil, err
+	}
+	msg, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't message", name)
+	}
+	return dynamicpb.NewMessageType(msg), nil
+}
+
+// fileSet returns the files of the message published by the plugin. It returns nil if the message is compiled into the host
+// or isn't published, because there is nothing to persist.
+func (r *typeRegistry) fileSet(name string) *descriptorpb.FileDescriptorSet {
+	if _, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name)); err == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	desc, err := r.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil
+	}
+	return newFileDescriptorSet([]protoreflect.FileDescriptor{desc.ParentFile()})
+}
+
+// resolvable reports whether the message of the result can be decoded.
+func (r *typeRegistry) resolvable(name string) bool {
+	_, err := r.messageType(protoreflect.FullName(name))
+	return err == nil
+}
+
+// UnmarshalAny decodes the result of the plugin. The message which isn't compiled into the host is decoded
+// to the dynamic message by the descriptors published by the plugin.
+func UnmarshalAny(v *anypb.Any) (protoreflect.ProtoMessage, error) {
+	typ, err := resultTypes.messageType(v.MessageName())
+	if err != nil {
+		return nil, ErrUnknownResultType(string(v.MessageName()))
+	}
+	msg := typ.New().Interface()
+	if err := protobuf.Unmarshal(v.GetValue(), msg); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", v.MessageName())
+	}
+	return msg, nil
+}
+
+// responseJSON returns the JSON of the result. The JSON of the result which doesn't have it,
+// like the one returned by older plugins, is generated from the data by the registered descriptors.
+func responseJSON(res *treportproto.ScanResponse) (string, error) {
+	if res.Json != "" || res.Data == nil {
+		return res.Json, nil
+	}
+	msg, err := UnmarshalAny(res.Data)
+	if err != nil {
+		return "", err
+	}
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encode %s to JSON", res.Data.MessageName())
+	}
+	return string(b), nil
+}
+
+// saveDescriptors writes the files of the message to dir, so the results stored with it are decoded
+// after the plugin is removed. It does nothing if the message is compiled into the host.
+func saveDescriptors(dir, name string) error {
+	set := resultTypes.fileSet(name)
+	if set == nil {
+		return nil
+	}
+	path := filepath.Join(dir, name+".pb")
+	if existsPath(path) {
+		return nil
+	}
+	b, err := protobuf.Marshal(set)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode descriptors of %s", name)
+	}
+	if err := mkdirIfNotExists(dir); err != nil {
+		return errors.Wrapf(err, "failed to create descriptors directory %s", dir)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write descriptors %s", path)
+	}
+	return nil
+}
+
+// loadSavedDescriptors registers the files written by saveDescriptors.
+func loadSavedDescriptors(dir string) error {
+	if !existsPath(dir) {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read descriptors directory %s", dir)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pb" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read descriptors %s", path)
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := protobuf.Unmarshal(b, &set); err != nil {
+			return errors.Wrapf(err, "failed to decode descriptors %s", path)
+		}
+		if err := resultTypes.register(&set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDescriptors registers the descriptors published by the plugin.
+// Plugins built before Descriptors RPC is introduced publish nothing.
+func (c *Client) loadDescriptors(ctx context.Context) error {
+	grpcClient, err := c.conn()
+	if err != nil {
+		return err
+	}
+	res, err := grpcClient.Descriptors(ctx, &treportproto.DescriptorsRequest{}, c.callOptions...)
+	if err != nil {
+		c.discard(grpcClient, err)
+		if status.Code(err) == codes.Unimplemented {
+			return nil
+		}
+		return errors.Wrapf(pluginError("descriptors", err), "failed to get descriptors of %s", c.pluginName)
+	}
+	return resultTypes.register(res.GetFiles())
+}
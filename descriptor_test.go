@@ -0,0 +1,73 @@
+package treport_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/goccy/treport"
+	treportproto "github.com/goccy/treport/proto"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestUnmarshalAnyByPublishedDescriptors(t *testing.T) {
+	// count = 42
+	data := &anypb.Any{TypeUrl: "type.googleapis.com/example.Stats", Value: []byte{0x08, 42}}
+	var unknownErr *treport.UnknownResultTypeError
+	if _, err := treport.UnmarshalAny(data); !errors.As(err, &unknownErr) {
+		t.Fatalf("expected unknown result type error: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    protobuf.String("example/stats.proto"),
+				Package: protobuf.String("example"),
+				Syntax:  protobuf.String("proto3"),
+				MessageType: []*descriptorpb.DescriptorProto{
+					{
+						Name: protobuf.String("Stats"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							{
+								Name:     protobuf.String("count"),
+								JsonName: protobuf.String("count"),
+								Number:   protobuf.Int32(1),
+								Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:     descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := treport.RegisterDescriptors(set); err != nil {
+		t.Fatal(err)
+	}
+	// the plugins publish the same files for each client.
+	if err := treport.RegisterDescriptors(set); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := treport.UnmarshalAny(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := msg.ProtoReflect()
+	if count := m.Get(m.Descriptor().Fields().ByName("count")).Int(); count != 42 {
+		t.Fatalf("unexpected count: %d", count)
+	}
+	// the JSON of the result cached without it is generated by the descriptors.
+	res := &treport.Result{Response: &treportproto.ScanResponse{Name: "example.Stats", Data: data}}
+	v, err := res.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats map[string]interface{}
+	if err := json.Unmarshal([]byte(v), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["count"] != "42" {
+		t.Fatalf("unexpected JSON: %s", v)
+	}
+}
@@ -0,0 +1,251 @@
+package treport
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// ConfigDiffStatus is how the pipeline is changed from the previous config.
+type ConfigDiffStatus string
+
+const (
+	ConfigDiffAdded     ConfigDiffStatus = "added"
+	ConfigDiffRemoved   ConfigDiffStatus = "removed"
+	ConfigDiffChanged   ConfigDiffStatus = "changed"
+	ConfigDiffUnchanged ConfigDiffStatus = "unchanged"
+)
+
+// ConfigDiff is what the scan with the current config drops and rescans, because the config is changed from the previous one.
+type ConfigDiff struct {
+	Pipelines []*PipelineDiff
+}
+
+type PipelineDiff struct {
+	Name   string
+	Status ConfigDiffStatus
+	// Changes are the changes of the pipeline which move the caches like the strategy, the plugins of the steps and their args.
+	Changes []string
+	// Warnings are the changes which keep the caches though they may change the results like the hooks.
+	Warnings []string
+	// Dropped are the caches of the previous config which the current one doesn't use.
+	Dropped []*CacheDiff
+	// Rescanned are the plugins which don't have the caches of the previous config, so they scan Pending commits again.
+	Rescanned []*CacheDiff
+}
+
+// CacheDiff is the cache of the results of the plugin for the repository.
+type CacheDiff struct {
+	Repo string
+	// Branch is the branch scanned as a separate stream. It's empty for the base branch.
+	Branch string
+	Step   int
+	Plugin string
+	Path   string
+	// Pending is the number of the commits scanned by the plugin. It's zero if the repository isn't cloned yet.
+	Pending int
+}
+
+// Diff compares the config of the scanner with the previous one like the config of the last run,
+// and reports which caches are dropped and which plugins scan the commits again.
+// Like Plan, it doesn't clone or sync repositories, run plugins and modify caches.
+func (s *Scanner) Diff(ctx context.Context, previous *Config) (*ConfigDiff, error) {
+	plan, err := s.Plan(ctx)
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	pending := map[string]int{}
+	for _, pipelinePlan := range plan.Pipelines {
+		for _, repoPlan := range pipelinePlan.Repos {
+			for _, pluginPlan := range repoPlan.Plugins {
+				pending[pluginPlan.CachePath] = pluginPlan.Pending
+			}
+		}
+	}
+	previousPipelines := map[string]*PipelineConfig{}
+	for _, pipelineCfg := range previous.Pipelines {
+		if pipelineCfg.IsEnabled() {
+			previousPipelines[pipelineCfg.Name] = pipelineCfg
+		}
+	}
+	diff := &ConfigDiff{}
+	for _, pipelineCfg := range s.cfg.Pipelines {
+		if !pipelineCfg.IsEnabled() {
+			continue
+		}
+		pipelineDiff, err := s.diffPipeline(previous, previousPipelines[pipelineCfg.Name], pipelineCfg, pending)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to diff pipeline %s", pipelineCfg.Name)
+		}
+		delete(previousPipelines, pipelineCfg.Name)
+		diff.Pipelines = append(diff.Pipelines, pipelineDiff)
+	}
+	for _, pipelineCfg := range previous.Pipelines {
+		if _, exists := previousPipelines[pipelineCfg.Name]; !exists {
+			continue
+		}
+		caches, err := previous.resultCaches(pipelineCfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to diff pipeline %s", pipelineCfg.Name)
+		}
+		diff.Pipelines = append(diff.Pipelines, &PipelineDiff{Name: pipelineCfg.Name, Status: ConfigDiffRemoved, Dropped: caches})
+	}
+	return diff, nil
+}
+
+func (s *Scanner) diffPipeline(previous *Config, previousCfg, pipelineCfg *PipelineConfig, pending map[string]int) (*PipelineDiff, error) {
+	diff := &PipelineDiff{Name: pipelineCfg.Name, Status: ConfigDiffAdded}
+	caches, err := s.cfg.resultCaches(pipelineCfg)
+	if err != nil {
+		return nil, err
+	}
+	previousPaths := map[string]struct{}{}
+	if previousCfg != nil {
+		diff.Status = ConfigDiffUnchanged
+		diff.Changes, diff.Warnings = diffPipelineConfig(previousCfg, pipelineCfg)
+		previousCaches, err := previous.resultCaches(previousCfg)
+		if err != nil {
+			return nil, err
+		}
+		paths := map[string]struct{}{}
+		for _, cache := range caches {
+			paths[cache.Path] = struct{}{}
+		}
+		for _, cache := range previousCaches {
+			previousPaths[cache.Path] = struct{}{}
+			if _, exists := paths[cache.Path]; !exists {
+				diff.Dropped = append(diff.Dropped, cache)
+			}
+		}
+	}
+	for _, cache := range caches {
+		if _, exists := previousPaths[cache.Path]; exists {
+			continue
+		}
+		cache.Pending = pending[cache.Path]
+		diff.Rescanned = append(diff.Rescanned, cache)
+	}
+	if diff.Status == ConfigDiffUnchanged && (len(diff.Changes) > 0 || len(diff.Warnings) > 0 || len(diff.Dropped) > 0 || len(diff.Rescanned) > 0) {
+		diff.Status = ConfigDiffChanged
+	}
+	return diff, nil
+}
+
+// resultCaches returns the caches of the plugins used by the pipeline. The branches are listed from the local clones.
+func (c *Config) resultCaches(pipelineCfg *PipelineConfig) ([]*CacheDiff, error) {
+	var caches []*CacheDiff
+	for _, repoCfg := range pipelineCfg.Repository {
+		repoPath, err := repositoryPath(c.repoPathOf(pipelineCfg, repoCfg), repoCfg)
+		if err != nil {
+			return nil, err
+		}
+		branches, err := localBranches(repoPath, repoCfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find branches of %s", repoCfg.identity())
+		}
+		if len(branches) == 0 {
+			branches = []string{""}
+		}
+		for _, branch := range branches {
+			for idx, stepCfg := range pipelineCfg.Steps {
+				for _, pluginExecCfg := range stepCfg.Plugins {
+					path, err := resultPath(DefaultIDScheme, c, pipelineCfg, repoCfg, branch, idx, pluginExecCfg.Name)
+					if err != nil {
+						return nil, errors.Stack(err)
+					}
+					caches = append(caches, &CacheDiff{
+						Repo:   repoCfg.identity(),
+						Branch: branch,
+						Step:   idx,
+						Plugin: pluginExecCfg.Name,
+						Path:   path,
+					})
+				}
+			}
+		}
+	}
+	return caches, nil
+}
+
+// diffPipelineConfig describes the changes of the pipeline. The changes which keep the caches are returned as the warnings.
+func diffPipelineConfig(previous, current *PipelineConfig) ([]string, []string) {
+	var changes, warnings []string
+	if previous.Strategy != current.Strategy {
+		changes = append(changes, fmt.Sprintf("strategy is changed from %s to %s", previous.Strategy, current.Strategy))
+	}
+	previousRepos := map[string]struct{}{}
+	for _, repoCfg := range previous.Repository {
+		previousRepos[repoCfg.identity()] = struct{}{}
+	}
+	for _, repoCfg := range current.Repository {
+		if _, exists := previousRepos[repoCfg.identity()]; !exists {
+			changes = append(changes, fmt.Sprintf("repository %s is added", repoCfg.identity()))
+		}
+		delete(previousRepos, repoCfg.identity())
+	}
+	for _, repoCfg := range previous.Repository {
+		if _, exists := previousRepos[repoCfg.identity()]; exists {
+			changes = append(changes, fmt.Sprintf("repository %s is removed", repoCfg.identity()))
+		}
+	}
+	for idx := 0; idx < len(previous.Steps) || idx < len(current.Steps); idx++ {
+		switch {
+		case idx >= len(previous.Steps):
+			changes = append(changes, fmt.Sprintf("steps[%d] is added", idx))
+			continue
+		case idx >= len(current.Steps):
+			changes = append(changes, fmt.Sprintf("steps[%d] is removed", idx))
+			continue
+		}
+		previousStep, currentStep := previous.Steps[idx], current.Steps[idx]
+		previousNames, currentNames := stepPluginNames(previousStep), stepPluginNames(currentStep)
+		if !reflect.DeepEqual(previousNames, currentNames) {
+			changes = append(changes, fmt.Sprintf("plugins of steps[%d] are changed from [%s] to [%s]",
+				idx, strings.Join(previousNames, " "), strings.Join(currentNames, " ")))
+			continue
+		}
+		if !reflect.DeepEqual(previousStep.DependsOn, currentStep.DependsOn) {
+			changes = append(changes, fmt.Sprintf("dependsOn of steps[%d] is changed", idx))
+		}
+		previousArgs := map[string][]string{}
+		for _, pluginExecCfg := range previousStep.Plugins {
+			previousArgs[pluginExecCfg.Name] = pluginExecCfg.Args
+		}
+		for _, pluginExecCfg := range currentStep.Plugins {
+			if strings.Join(previousArgs[pluginExecCfg.Name], " ") != strings.Join(pluginExecCfg.Args, " ") {
+				changes = append(changes, fmt.Sprintf("args of %s in steps[%d] are changed", pluginExecCfg.Name, idx))
+			}
+		}
+	}
+	if len(changes) == 0 && len(warnings) == 0 && !samePipelineConfig(previous, current) {
+		warnings = append(warnings, "settings are changed, but the cached results are kept")
+	}
+	return changes, warnings
+}
+
+// stepPluginNames returns the sorted names, because the order of the plugins in the step doesn't move the caches.
+func stepPluginNames(stepCfg *StepConfig) []string {
+	names := make([]string, 0, len(stepCfg.Plugins))
+	for _, pluginExecCfg := range stepCfg.Plugins {
+		names = append(names, pluginExecCfg.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func samePipelineConfig(a, b *PipelineConfig) bool {
+	ab, err := yaml.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bb, err := yaml.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(ab) == string(bb)
+}
@@ -0,0 +1,102 @@
+package treport
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// diffCache persists the changes between trees, so the commits already diffed by the previous runs aren't diffed again.
+// The cache of the same path is shared by the pipelines and the branches in the process, because badger locks the directory.
+type diffCache struct {
+	path string
+	db   *badger.DB
+	refs int
+}
+
+var (
+	diffCachesMu sync.Mutex
+	diffCaches   = map[string]*diffCache{}
+)
+
+func openDiffCache(path string, cfg *CacheConfig) (*diffCache, error) {
+	diffCachesMu.Lock()
+	defer diffCachesMu.Unlock()
+	if cache, exists := diffCaches[path]; exists {
+		cache.refs++
+		return cache, nil
+	}
+	if !cfg.inMemory() {
+		if err := mkdirIfNotExists(path); err != nil {
+			return nil, errors.Wrapf(err, "failed to create directory for diff cache")
+		}
+	}
+	db, err := badger.Open(cfg.options(path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open diff cache DB")
+	}
+	cache := &diffCache{path: path, db: db, refs: 1}
+	diffCaches[path] = cache
+	return cache, nil
+}
+
+// Close closes the DB when all users of the cache close it.
+func (c *diffCache) Close() error {
+	diffCachesMu.Lock()
+	defer diffCachesMu.Unlock()
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	delete(diffCaches, c.path)
+	return c.db.Close()
+}
+
+// diffCacheKey is the key of the changes between the trees. The changes depend on how the submodules are resolved.
+func diffCacheKey(mode SubmoduleMode, from, to *object.Tree) []byte {
+	fromHash := plumbing.ZeroHash
+	if from != nil {
+		fromHash = from.Hash
+	}
+	return []byte(fmt.Sprintf("%s:%s:%s", mode, fromHash, to.Hash))
+}
+
+// get returns the cached changes. It returns false if they aren't cached.
+func (c *diffCache) get(key []byte) (Changes, bool, error) {
+	var changes Changes
+	if err := c.db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(v, &changes)
+	}); err != nil {
+		if err == badger.ErrKeyNotFound {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrapf(err, "failed to read diff cache")
+	}
+	return changes, true, nil
+}
+
+func (c *diffCache) put(key []byte, changes Changes) error {
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode changes")
+	}
+	if err := c.db.Update(func(tx *badger.Txn) error {
+		return tx.Set(key, b)
+	}); err != nil {
+		return errors.Wrapf(err, "failed to write diff cache")
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package treport
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// clone is the repository cloned under the mount path.
+type clone struct {
+	root     string
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+var (
+	clonesMu sync.Mutex
+	// clonesInUse is the number of the pipelines using the clone in the process. They aren't evicted.
+	clonesInUse = map[string]int{}
+)
+
+func acquireClone(path string) {
+	clonesMu.Lock()
+	defer clonesMu.Unlock()
+	clonesInUse[path]++
+}
+
+func releaseClone(path string) {
+	clonesMu.Lock()
+	defer clonesMu.Unlock()
+	clonesInUse[path]--
+	if clonesInUse[path] <= 0 {
+		delete(clonesInUse, path)
+	}
+}
+
+// touchClone records the use of the clone by the modification time of the directory, which orders the eviction.
+func touchClone(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// repoRoots returns the directories which the repositories are cloned under.
+func (c *Config) repoRoots() []string {
+	roots := []string{c.RepoPath()}
+	exists := map[string]struct{}{c.RepoPath(): {}}
+	for _, pipelineCfg := range c.Pipelines {
+		for _, repoCfg := range pipelineCfg.Repository {
+			root := c.repoPathOf(pipelineCfg, repoCfg)
+			if _, found := exists[root]; found {
+				continue
+			}
+			exists[root] = struct{}{}
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// referencedClones returns the paths of the clones of the repositories used by the pipelines or the plugins.
+func (c *Config) referencedClones() (map[string]struct{}, error) {
+	paths := map[string]struct{}{}
+	add := func(root string, repoCfg *RepositoryConfig) error {
+		if repoCfg.IsLocal() || IsBuiltinPlugin(repoCfg.Name) {
+			return nil
+		}
+		path, err := repositoryPath(root, repoCfg)
+		if err != nil {
+			return errors.Stack(err)
+		}
+		paths[path] = struct{}{}
+		return nil
+	}
+	for _, repoCfg := range c.Plugin.repositories() {
+		if err := add(c.RepoPath(), repoCfg); err != nil {
+			return nil, err
+		}
+	}
+	for _, pipelineCfg := range c.Pipelines {
+		for _, repoCfg := range pipelineCfg.Repository {
+			if err := add(c.repoPathOf(pipelineCfg, repoCfg), repoCfg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return paths, nil
+}
+
+// findClones returns the clones under the root. The directories under the clones aren't walked.
+func findClones(root string) ([]*clone, error) {
+	if !existsPath(root) {
+		return nil, nil
+	}
+	clones := []*clone{}
+	if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || path == root {
+			return nil
+		}
+		if !isClone(path) {
+			return nil
+		}
+		clones = append(clones, &clone{
+			root:     root,
+			path:     path,
+			size:     gitDirSize(path),
+			lastUsed: info.ModTime(),
+		})
+		return filepath.SkipDir
+	}); err != nil {
+		return nil, errors.Wrapf(err, "failed to find clones under %s", root)
+	}
+	return clones, nil
+}
+
+// isClone reports whether the directory is the repository with the worktree or the bare repository.
+func isClone(path string) bool {
+	if existsPath(filepath.Join(path, ".git")) {
+		return true
+	}
+	return existsPath(filepath.Join(path, "HEAD")) && existsPath(filepath.Join(path, "objects"))
+}
+
+// removeClone removes the clone and the parent directories emptied by it under the root.
+func removeClone(c *clone) error {
+	if err := os.RemoveAll(c.path); err != nil {
+		return errors.Wrapf(err, "failed to remove clone %s", c.path)
+	}
+	for dir := filepath.Dir(c.path); dir != c.root && len(dir) > len(c.root); dir = filepath.Dir(dir) {
+		// os.Remove fails if the directory isn't empty.
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *Config) clones() ([]*clone, error) {
+	clones := []*clone{}
+	for _, root := range c.repoRoots() {
+		found, err := findClones(root)
+		if err != nil {
+			return nil, err
+		}
+		clones = append(clones, found...)
+	}
+	return clones, nil
+}
+
+// enforceDiskQuota removes the least recently used clones until the total size of the clones fits in project.diskQuota.
+// The clones used by the pipelines and the plugins in the process aren't removed, and the clones are removed
+// under the lock of the mount paths, so the clones of the scans of the other processes aren't removed either.
+// The removed clones are cloned again when they are used.
+func enforceDiskQuota(ctx context.Context, cfg *Config) error {
+	quota := cfg.Project.DiskQuota
+	if quota <= 0 {
+		return nil
+	}
+	unlock, err := cfg.lockMounts(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	clones, err := cfg.clones()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, c := range clones {
+		total += c.size
+	}
+	if total <= quota {
+		return nil
+	}
+	sort.SliceStable(clones, func(i, j int) bool {
+		return clones[i].lastUsed.Before(clones[j].lastUsed)
+	})
+	clonesMu.Lock()
+	defer clonesMu.Unlock()
+	for _, c := range clones {
+		if total <= quota {
+			break
+		}
+		if _, inUse := clonesInUse[c.path]; inUse {
+			continue
+		}
+		if err := removeClone(c); err != nil {
+			return err
+		}
+		total -= c.size
+		loggerFrom(ctx).Info("evicted repository clone", "path", c.path, "size", c.size, "lastUsed", c.lastUsed)
+	}
+	if total > quota {
+		loggerFrom(ctx).Warn("clones in use exceed disk quota", "size", total, "quota", quota)
+	}
+	return nil
+}
+
+// Cleanup removes the clones of the repositories which aren't referenced by any pipeline or plugin of the config,
+// and returns the paths of the removed clones. The clones are removed under the lock of the mount paths,
+// so the clones used by the scans of the other processes aren't removed.
+func Cleanup(ctx context.Context, cfg *Config) ([]string, error) {
+	unlock, err := cfg.lockMounts(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	referenced, err := cfg.referencedClones()
+	if err != nil {
+		return nil, err
+	}
+	clones, err := cfg.clones()
+	if err != nil {
+		return nil, err
+	}
+	clonesMu.Lock()
+	defer clonesMu.Unlock()
+	removed := []string{}
+	for _, c := range clones {
+		if _, exists := referenced[c.path]; exists {
+			continue
+		}
+		if _, inUse := clonesInUse[c.path]; inUse {
+			continue
+		}
+		if err := removeClone(c); err != nil {
+			return removed, err
+		}
+		loggerFrom(ctx).Info("removed unreferenced repository clone", "path", c.path, "size", c.size)
+		removed = append(removed, c.path)
+	}
+	return removed, nil
+}
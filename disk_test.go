@@ -0,0 +1,304 @@
+package treport_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/goccy/treport"
+	treportproto "github.com/goccy/treport/proto"
+)
+
+func TestCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, path := range []string{"github.com/goccy/go-json", "github.com/goccy/go-yaml", "github.com/other/repo"} {
+		if _, err := git.PlainInit(filepath.Join(dir, "repo", path), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &treport.Config{
+		Project: treport.ProjectConfig{Path: dir},
+		Pipelines: []*treport.PipelineConfig{
+			{Name: "a", Repository: []*treport.RepositoryConfig{{Repo: "https://github.com/goccy/go-json"}}},
+		},
+	}
+	removed, err := treport.Cleanup(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("expected 2 removed clones but got %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "repo", "github.com", "goccy", "go-json")); err != nil {
+		t.Fatalf("referenced clone is removed: %v", err)
+	}
+	// the directory emptied by the removal is also removed.
+	if _, err := os.Stat(filepath.Join(dir, "repo", "github.com", "other")); !os.IsNotExist(err) {
+		t.Fatalf("expected empty directory to be removed: %v", err)
+	}
+}
+
+func TestCleanupLockedByAnotherProcess(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := git.PlainInit(filepath.Join(dir, "repo", "github.com", "other", "repo"), true); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := treport.LockMountFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	cfg := &treport.Config{Project: treport.ProjectConfig{Path: dir}}
+	_, err = treport.Cleanup(context.Background(), cfg)
+	var lockedErr *treport.MountLockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("expected MountLockedError, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "repo", "github.com", "other", "repo")); err != nil {
+		t.Fatalf("clone is removed while another process has the lock: %v", err)
+	}
+}
+
+func TestDiskQuotaLockedByAnotherProcess(t *testing.T) {
+	dir := t.TempDir()
+	clone := filepath.Join(dir, "repo", "github.com", "other", "repo")
+	if _, err := git.PlainInit(clone, true); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := treport.LockMountFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	cfg := &treport.Config{Project: treport.ProjectConfig{Path: dir, DiskQuota: 1}}
+	err = treport.EnforceDiskQuota(context.Background(), cfg)
+	var lockedErr *treport.MountLockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("expected MountLockedError, got %v", err)
+	}
+	if _, err := os.Stat(clone); err != nil {
+		t.Fatalf("clone is evicted while another process has the lock: %v", err)
+	}
+}
+
+func TestOrphanCaches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scan.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+project:
+  path: `+dir+`
+pipelines:
+  - name: size
+    strategy: allCommit
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	plan, err := treport.NewScanner(cfg).Plan(context.Background())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	used := plan.Pipelines[0].Repos[0].Plugins[0].CachePath
+	orphans := []string{
+		filepath.Join(cfg.CachePath(), "renamed-pipeline"),
+		filepath.Join(cfg.CachePath(), "diff", "removed-repo"),
+	}
+	for _, path := range append(orphans, used) {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	removed, err := treport.RemoveOrphanCaches(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(removed) != len(orphans) {
+		t.Fatalf("expected %d orphan caches but got %d", len(orphans), len(removed))
+	}
+	for _, path := range orphans {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected orphan cache %s to be removed: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(used); err != nil {
+		t.Fatalf("used cache is removed: %v", err)
+	}
+}
+
+func TestBlobCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reads := map[string]int{}
+	get := func(cache *treport.BlobCache, hash string) {
+		t.Helper()
+		data, err := cache.Get(hash, func() (io.ReadCloser, error) {
+			reads[hash]++
+			return ioutil.NopCloser(strings.NewReader(hash[:3])), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != hash[:3] {
+			t.Fatalf("unexpected contents %q of %s", data, hash)
+		}
+	}
+	a := strings.Repeat("a", 40)
+	b := strings.Repeat("b", 40)
+	c := strings.Repeat("c", 40)
+	cfg := &treport.BlobCacheConfig{MemorySize: 4, DiskSize: 7}
+	cache, err := treport.OpenBlobCache(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a is kept in memory and on the disk.
+	get(cache, a)
+	get(cache, a)
+	// b evicts a from memory, but a is still on the disk.
+	get(cache, b)
+	get(cache, a)
+	// c evicts b from the disk, because a is used more recently.
+	get(cache, c)
+	cache.Close()
+	if reads[a] != 1 || reads[b] != 1 || reads[c] != 1 {
+		t.Fatalf("unexpected reads %v", reads)
+	}
+	// the disk cache is kept across the runs.
+	cache, err = treport.OpenBlobCache(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	get(cache, a)
+	get(cache, c)
+	get(cache, b)
+	if reads[a] != 1 || reads[b] != 2 || reads[c] != 1 {
+		t.Fatalf("unexpected reads %v", reads)
+	}
+}
+
+func TestCacheWriteBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const commit = "3f2a9c1e0d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39"
+	plg := &treport.Plugin{Name: "size", CachePath: filepath.Join(dir, "size")}
+	if err := plg.StoreCache(commit, &treportproto.ScanResponse{Name: "size"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := plg.SetHighWaterMark(commit); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// the result is batched, so the mark isn't written before it.
+	if mark, err := plg.HighWaterMark(); err != nil || mark != "" {
+		t.Fatalf("expected mark not to be written but got %q: %v", mark, err)
+	}
+	if res, err := plg.GetCache(commit); err != nil || res == nil {
+		t.Fatalf("expected batched result to be read: %v", err)
+	}
+	plg.Cleanup()
+	next := &treport.Plugin{Name: "size", CachePath: filepath.Join(dir, "size")}
+	defer next.Cleanup()
+	if mark, err := next.HighWaterMark(); err != nil || mark != commit {
+		t.Fatalf("expected mark %s but got %q: %v", commit, mark, err)
+	}
+	if res, err := next.GetCache(commit); err != nil || res == nil || res.Name != "size" {
+		t.Fatalf("expected result to be written: %v", err)
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const commit = "3f2a9c1e0d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39"
+	nightly := &treport.Plugin{Name: "size", CachePath: filepath.Join(dir, "nightly", "size")}
+	if err := nightly.StoreCache(commit, &treportproto.ScanResponse{Name: "size"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	nightly.Cleanup()
+
+	ci := &treport.Plugin{Name: "size", CachePath: filepath.Join(dir, "ci", "size")}
+	ci.SetWarmCache(nightly.CachePath)
+	if res, err := ci.GetCache(commit); err != nil || res == nil || res.Name != "size" {
+		t.Fatalf("expected result to be read from warm cache: %v", err)
+	}
+	if res, err := ci.GetCache("0000000000000000000000000000000000000000"); err != nil || res != nil {
+		t.Fatalf("expected missing result: %v", err)
+	}
+	ci.Cleanup()
+	// the result read from the warm cache is kept in the own cache.
+	next := &treport.Plugin{Name: "size", CachePath: filepath.Join(dir, "ci", "size")}
+	defer next.Cleanup()
+	if res, err := next.GetCache(commit); err != nil || res == nil {
+		t.Fatalf("expected result to be kept: %v", err)
+	}
+}
+
+func TestPluginVersionPlatform(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &treport.Config{Project: treport.ProjectConfig{Path: dir}}
+	db, err := cfg.PluginVersionDB()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// the version recorded by older versions is used until the one of the platform is written.
+	if err := db.WriteLegacyVersion(&treport.PluginVersion{Name: "size", Version: 3}); err != nil {
+		t.Fatal(err)
+	}
+	ver, err := db.ReadVersion("size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver == nil || ver.Version != 3 {
+		t.Fatalf("expected legacy version but got %+v", ver)
+	}
+	if err := db.WriteVersion(&treport.PluginVersion{Name: "size", Version: 4}); err != nil {
+		t.Fatal(err)
+	}
+	ver, err = db.ReadVersion("size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver == nil || ver.Version != 4 {
+		t.Fatalf("expected version of platform but got %+v", ver)
+	}
+	db.Close()
+	cfg.Plugin = &treport.PluginConfig{Scanner: []*treport.RepositoryConfig{{Name: "lint", Repo: "https://github.com/goccy/treport-lint"}}}
+	plugins, err := treport.ListPlugins(context.Background(), cfg, "lint")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	platform := runtime.GOOS + "_" + runtime.GOARCH
+	if len(plugins) != 1 || filepath.Base(filepath.Dir(plugins[0].Binary)) != platform {
+		t.Fatalf("expected binary for %s: %+v", platform, plugins)
+	}
+}
@@ -0,0 +1,57 @@
+package treport
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+)
+
+var envMatcher = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} and ${VAR:-default} in s by the environment variables.
+// The default is used if the variable is unset or empty. $VAR without braces is left as it is.
+func expandEnv(s string) string {
+	return envMatcher.ReplaceAllStringFunc(s, func(match string) string {
+		sub := envMatcher.FindStringSubmatch(match)
+		if v := os.Getenv(sub[1]); v != "" {
+			return v
+		}
+		return sub[3]
+	})
+}
+
+// expandEnvFields expands the environment variables in all exported string fields reachable from v.
+func expandEnvFields(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			expandEnvFields(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				// unexported.
+				continue
+			}
+			expandEnvFields(v.Field(i))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			expandEnvFields(v.Index(i))
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			value := iter.Value()
+			if value.Kind() == reflect.String {
+				v.SetMapIndex(iter.Key(), reflect.ValueOf(expandEnv(value.String())).Convert(value.Type()))
+				continue
+			}
+			expandEnvFields(value)
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(expandEnv(v.String()))
+		}
+	}
+}
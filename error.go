@@ -1,6 +1,15 @@
 package treport
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
 
 type InvalidRepositoryPathError struct {
 	Path string
@@ -10,8 +19,653 @@ func (e *InvalidRepositoryPathError) Error() string {
 	return fmt.Sprintf("invalid repository path: %q", e.Path)
 }
 
+func (e *InvalidRepositoryPathError) ErrorCode() errors.ErrorCode {
+	return errors.CodeGit
+}
+
 func ErrInvalidRepositoryPath(path string) error {
 	return &InvalidRepositoryPathError{
 		Path: path,
 	}
 }
+
+type EmptyRepositoryError struct {
+	Repo string
+}
+
+func (e *EmptyRepositoryError) Error() string {
+	return fmt.Sprintf("repository %s is empty", e.Repo)
+}
+
+func (e *EmptyRepositoryError) ErrorCode() errors.ErrorCode {
+	return errors.CodeGit
+}
+
+func ErrEmptyRepository(repo string) error {
+	return &EmptyRepositoryError{
+		Repo: repo,
+	}
+}
+
+type BranchNotFoundError struct {
+	Repo   string
+	Branch string
+}
+
+func (e *BranchNotFoundError) Error() string {
+	if e.Branch == "" {
+		return fmt.Sprintf("failed to find base branch of %s", e.Repo)
+	}
+	return fmt.Sprintf("failed to find branch %s of %s", e.Branch, e.Repo)
+}
+
+func (e *BranchNotFoundError) ErrorCode() errors.ErrorCode {
+	return errors.CodeGit
+}
+
+func ErrBranchNotFound(repo, branch string) error {
+	return &BranchNotFoundError{
+		Repo:   repo,
+		Branch: branch,
+	}
+}
+
+type OrphanBranchError struct {
+	Repo   string
+	Branch string
+}
+
+func (e *OrphanBranchError) Error() string {
+	return fmt.Sprintf("%s of %s is an orphan branch which has no common ancestor with the base branch", e.Branch, e.Repo)
+}
+
+func (e *OrphanBranchError) ErrorCode() errors.ErrorCode {
+	return errors.CodeGit
+}
+
+func ErrOrphanBranch(repo, branch string) error {
+	return &OrphanBranchError{
+		Repo:   repo,
+		Branch: branch,
+	}
+}
+
+type RunNotFoundError struct {
+	ID string
+}
+
+func (e *RunNotFoundError) Error() string {
+	return fmt.Sprintf("failed to find run %s", e.ID)
+}
+
+func ErrRunNotFound(id string) error {
+	return &RunNotFoundError{
+		ID: id,
+	}
+}
+
+// RunMismatchError reports that the current state differs from the recorded run.
+type RunMismatchError struct {
+	ID       string
+	Target   string
+	Recorded string
+	Current  string
+}
+
+func (e *RunMismatchError) Error() string {
+	return fmt.Sprintf("%s of run %s is changed: recorded %q but current %q", e.Target, e.ID, e.Recorded, e.Current)
+}
+
+func ErrRunMismatch(id, target, recorded, current string) error {
+	return &RunMismatchError{
+		ID:       id,
+		Target:   target,
+		Recorded: recorded,
+		Current:  current,
+	}
+}
+
+type PipelineNotFoundError struct {
+	Name string
+}
+
+func (e *PipelineNotFoundError) Error() string {
+	return fmt.Sprintf("failed to find pipeline %s", e.Name)
+}
+
+func (e *PipelineNotFoundError) ErrorCode() errors.ErrorCode {
+	return errors.CodeConfig
+}
+
+func ErrPipelineNotFound(name string) error {
+	return &PipelineNotFoundError{
+		Name: name,
+	}
+}
+
+type RepositoryNotFoundError struct {
+	Pipeline string
+	Repo     string
+}
+
+func (e *RepositoryNotFoundError) Error() string {
+	return fmt.Sprintf("failed to find repository %s in pipeline %s", e.Repo, e.Pipeline)
+}
+
+func (e *RepositoryNotFoundError) ErrorCode() errors.ErrorCode {
+	return errors.CodeConfig
+}
+
+func ErrRepositoryNotFound(pipeline, repo string) error {
+	return &RepositoryNotFoundError{
+		Pipeline: pipeline,
+		Repo:     repo,
+	}
+}
+
+type PipelineRunningError struct {
+	Name  string
+	JobID string
+}
+
+func (e *PipelineRunningError) Error() string {
+	return fmt.Sprintf("pipeline %s is already being scanned by %s", e.Name, e.JobID)
+}
+
+func ErrPipelineRunning(name, jobID string) error {
+	return &PipelineRunningError{
+		Name:  name,
+		JobID: jobID,
+	}
+}
+
+// CacheNotFoundError reports that the result of the commit scanned before isn't in the cache.
+type CacheNotFoundError struct {
+	Plugin string
+	Commit string
+}
+
+func (e *CacheNotFoundError) Error() string {
+	return fmt.Sprintf("failed to find cache of %s for commit %s", e.Plugin, e.Commit)
+}
+
+func (e *CacheNotFoundError) ErrorCode() errors.ErrorCode {
+	return errors.CodeCache
+}
+
+func ErrCacheNotFound(plugin, commit string) error {
+	return &CacheNotFoundError{
+		Plugin: plugin,
+		Commit: commit,
+	}
+}
+
+// ScanError is the failure of the pipeline, the repository or the plugin.
+// Repo is empty if the pipeline failed, and Plugin is empty if the repository failed.
+type ScanError struct {
+	Pipeline string
+	Repo     string
+	Plugin   string
+	Err      error
+}
+
+func (e *ScanError) scope() string {
+	scope := "pipeline " + e.Pipeline
+	if e.Repo != "" {
+		scope += ": repository " + e.Repo
+	}
+	if e.Plugin != "" {
+		scope += ": plugin " + e.Plugin
+	}
+	return scope
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("%s: %s", e.scope(), e.Err)
+}
+
+// Format prints the stack of the error by %+v.
+func (e *ScanError) Format(state fmt.State, verb rune) {
+	if verb == 'v' && state.Flag('+') {
+		fmt.Fprintf(state, "%s: %+v", e.scope(), e.Err)
+		return
+	}
+	io.WriteString(state, e.Error())
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// ScanErrors is all failures of the scan. The failures continued by onError are included, and the scan has partial results
+// if all failures are continued. %+v prints the stack of each failure.
+type ScanErrors []*ScanError
+
+func (e ScanErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d failures:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+func (e ScanErrors) Format(state fmt.State, verb rune) {
+	if verb != 'v' || !state.Flag('+') {
+		io.WriteString(state, e.Error())
+		return
+	}
+	fmt.Fprintf(state, "%d failures:", len(e))
+	for _, err := range e {
+		fmt.Fprintf(state, "\n%+v", err)
+	}
+}
+
+func (e ScanErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// scanErrorCollector collects the failures of the pipelines, the repositories and the plugins scanned in parallel.
+type scanErrorCollector struct {
+	mu   sync.Mutex
+	errs ScanErrors
+}
+
+// add adds err as the failure of scope. If err has the failures of the inner scopes, they are added as they are.
+func (c *scanErrorCollector) add(err error, scope ScanError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs ScanErrors
+	if errors.As(err, &errs) {
+		c.errs = append(c.errs, errs...)
+		return
+	}
+	scope.Err = err
+	c.errs = append(c.errs, &scope)
+}
+
+// err returns the failures ordered by the scope, or nil if nothing failed.
+func (c *scanErrorCollector) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errs) == 0 {
+		return nil
+	}
+	errs := append(ScanErrors{}, c.errs...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].scope() < errs[j].scope()
+	})
+	return errs
+}
+
+// TransientError is the error which may succeed by retry like network failures.
+type TransientError struct {
+	Op  string
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("failed to %s: %s", e.Op, e.Err)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+func ErrTransient(op string, err error) error {
+	return &TransientError{
+		Op:  op,
+		Err: err,
+	}
+}
+
+// UnknownStrategyError reports that the strategy of the pipeline isn't one of the known strategies.
+type UnknownStrategyError struct {
+	Pipeline string
+	Strategy Strategy
+}
+
+func (e *UnknownStrategyError) Error() string {
+	return fmt.Sprintf("unknown strategy %q of pipeline %s", e.Strategy, e.Pipeline)
+}
+
+func (e *UnknownStrategyError) ErrorCode() errors.ErrorCode {
+	return errors.CodeConfig
+}
+
+func ErrUnknownStrategy(pipeline string, strategy Strategy) error {
+	return &UnknownStrategyError{
+		Pipeline: pipeline,
+		Strategy: strategy,
+	}
+}
+
+// AuthError is the failure of the authentication or the authorization to the remote.
+type AuthError struct {
+	Op  string
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("failed to %s: %s", e.Op, e.Err)
+}
+
+func (e *AuthError) ErrorCode() errors.ErrorCode {
+	return errors.CodeAuth
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func ErrAuth(op string, err error) error {
+	return &AuthError{
+		Op:  op,
+		Err: err,
+	}
+}
+
+// NotFoundError reports that the remote repository or the API resource doesn't exist.
+// The private repository may be reported as it if the credentials aren't given.
+type NotFoundError struct {
+	Op  string
+	Err error
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("failed to %s: %s", e.Op, e.Err)
+}
+
+func (e *NotFoundError) ErrorCode() errors.ErrorCode {
+	return errors.CodeGit
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+func ErrNotFound(op string, err error) error {
+	return &NotFoundError{
+		Op:  op,
+		Err: err,
+	}
+}
+
+// NetworkError is the failure of the connection to the remote. It's retried by the retry policy.
+type NetworkError struct {
+	Op  string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("failed to %s: %s", e.Op, e.Err)
+}
+
+func (e *NetworkError) ErrorCode() errors.ErrorCode {
+	return errors.CodeGit
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+func ErrNetwork(op string, err error) error {
+	return &NetworkError{
+		Op:  op,
+		Err: err,
+	}
+}
+
+// RateLimitError reports that the remote rejected the request by the rate limit. It's retried by the retry policy.
+// RetryAfter is the wait time requested by the remote. It's zero if the remote doesn't tell it.
+type RateLimitError struct {
+	Op         string
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("failed to %s: %s", e.Op, e.Err)
+}
+
+func (e *RateLimitError) ErrorCode() errors.ErrorCode {
+	return errors.CodeGit
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+func ErrRateLimit(op string, err error, retryAfter time.Duration) error {
+	return &RateLimitError{
+		Op:         op,
+		Err:        err,
+		RetryAfter: retryAfter,
+	}
+}
+
+// DependencyNotFoundError reports that the plugin of the dependent step has no result of the commit.
+type DependencyNotFoundError struct {
+	Plugin     string
+	Dependency string
+	Commit     string
+}
+
+func (e *DependencyNotFoundError) Error() string {
+	return fmt.Sprintf("failed to find result of %s for commit %s required by %s", e.Dependency, e.Commit, e.Plugin)
+}
+
+func (e *DependencyNotFoundError) ErrorCode() errors.ErrorCode {
+	return errors.CodePlugin
+}
+
+func ErrDependencyNotFound(plugin, dependency, commit string) error {
+	return &DependencyNotFoundError{
+		Plugin:     plugin,
+		Dependency: dependency,
+		Commit:     commit,
+	}
+}
+
+// StepSkippedError reports that the step didn't run because the step it depends on directly or indirectly failed.
+type StepSkippedError struct {
+	Step       string
+	FailedStep string
+}
+
+func (e *StepSkippedError) Error() string {
+	return fmt.Sprintf("step %s is skipped because step %s failed", e.Step, e.FailedStep)
+}
+
+func ErrStepSkipped(step, failedStep string) error {
+	return &StepSkippedError{
+		Step:       step,
+		FailedStep: failedStep,
+	}
+}
+
+type PluginNotInstalledError struct {
+	Plugin string
+}
+
+func (e *PluginNotInstalledError) Error() string {
+	return fmt.Sprintf("plugin %s isn't installed", e.Plugin)
+}
+
+func (e *PluginNotInstalledError) ErrorCode() errors.ErrorCode {
+	return errors.CodePlugin
+}
+
+func ErrPluginNotInstalled(plugin string) error {
+	return &PluginNotInstalledError{
+		Plugin: plugin,
+	}
+}
+
+// PluginChecksumMismatchError reports that the plugin binary isn't the one built by treport.
+// Expected is empty if the binary isn't built by treport plugins install or update.
+type PluginChecksumMismatchError struct {
+	Plugin   string
+	Expected string
+	Actual   string
+}
+
+func (e *PluginChecksumMismatchError) Error() string {
+	if e.Expected == "" {
+		return fmt.Sprintf("checksum of plugin %s isn't recorded", e.Plugin)
+	}
+	return fmt.Sprintf("checksum of plugin %s is %s, expected %s", e.Plugin, e.Actual, e.Expected)
+}
+
+func (e *PluginChecksumMismatchError) ErrorCode() errors.ErrorCode {
+	return errors.CodePlugin
+}
+
+func ErrPluginChecksumMismatch(plugin, expected, actual string) error {
+	return &PluginChecksumMismatchError{
+		Plugin:   plugin,
+		Expected: expected,
+		Actual:   actual,
+	}
+}
+
+// UnknownResultTypeError reports that the message of the result isn't compiled into the host
+// and the plugin hasn't published its descriptor.
+type UnknownResultTypeError struct {
+	Type string
+}
+
+func (e *UnknownResultTypeError) Error() string {
+	return fmt.Sprintf("descriptor of %s isn't known", e.Type)
+}
+
+func (e *UnknownResultTypeError) ErrorCode() errors.ErrorCode {
+	return errors.CodePlugin
+}
+
+func ErrUnknownResultType(typ string) error {
+	return &UnknownResultTypeError{
+		Type: typ,
+	}
+}
+
+// MountLockedError reports that the mount path is used by another process.
+// Owner is nil if the process holding the lock isn't known.
+type MountLockedError struct {
+	Path  string
+	Owner *MountLockOwner
+}
+
+func (e *MountLockedError) Error() string {
+	if e.Owner == nil {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by process %d on %s since %s", e.Path, e.Owner.PID, e.Owner.Host, e.Owner.StartedAt.Format(time.RFC3339))
+}
+
+func ErrMountLocked(path string, owner *MountLockOwner) error {
+	return &MountLockedError{
+		Path:  path,
+		Owner: owner,
+	}
+}
+
+// HookFailedError reports that the command of the before or the after hook failed.
+type HookFailedError struct {
+	Hook    string
+	Command string
+	Output  string
+	Err     error
+}
+
+func (e *HookFailedError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("%s hook %q failed: %s", e.Hook, e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s hook %q failed: %s: %s", e.Hook, e.Command, e.Err, e.Output)
+}
+
+func (e *HookFailedError) Unwrap() error {
+	return e.Err
+}
+
+func ErrHookFailed(hook, command string, err error, output []byte) error {
+	return &HookFailedError{
+		Hook:    hook,
+		Command: command,
+		Output:  strings.TrimSpace(string(output)),
+		Err:     err,
+	}
+}
+
+// PluginErrorClass is how the host handles the error returned by the plugin.
+type PluginErrorClass string
+
+const (
+	// PluginErrorRetryable is retried by retry of the plugin like the transient errors.
+	PluginErrorRetryable PluginErrorClass = "retryable"
+	// PluginErrorSkipCommit skips the commit. The commit is recorded as skipped without result, and the scan continues.
+	PluginErrorSkipCommit PluginErrorClass = "skipCommit"
+	// PluginErrorFatal fails the pipeline even if onError is continue.
+	PluginErrorFatal PluginErrorClass = "fatal"
+)
+
+// PluginError is the error classified by the plugin. The plugin returns it by ErrRetryable, ErrSkipCommit or ErrFatal,
+// and the host gets it from the detail of the status. The other errors are handled by onError.
+type PluginError struct {
+	Class PluginErrorClass
+	Err   error
+}
+
+func (e *PluginError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PluginError) ErrorCode() errors.ErrorCode {
+	return errors.CodePlugin
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+func ErrRetryable(err error) error {
+	return &PluginError{
+		Class: PluginErrorRetryable,
+		Err:   err,
+	}
+}
+
+func ErrSkipCommit(err error) error {
+	return &PluginError{
+		Class: PluginErrorSkipCommit,
+		Err:   err,
+	}
+}
+
+func ErrFatal(err error) error {
+	return &PluginError{
+		Class: PluginErrorFatal,
+		Err:   err,
+	}
+}
+
+// LimitExceededError reports that the commit exceeds the limits of the pipeline whose onExceed is skip or fail.
+type LimitExceededError struct {
+	Commit string
+	Reason string
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("commit %s exceeds limits: %s", e.Commit, e.Reason)
+}
+
+func ErrLimitExceeded(commit, reason string) error {
+	return &LimitExceededError{
+		Commit: commit,
+		Reason: reason,
+	}
+}
@@ -0,0 +1,59 @@
+package treport_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+)
+
+func TestScanErrors(t *testing.T) {
+	var err error = treport.ScanErrors{
+		{Pipeline: "size", Repo: "https://github.com/goccy/go-json", Plugin: "size", Err: errors.Wrapf(fmt.Errorf("broken"), "failed to scan")},
+		{Pipeline: "size", Repo: "https://github.com/goccy/go-yaml", Err: &treport.PluginError{Class: treport.PluginErrorFatal, Err: fmt.Errorf("crashed")}},
+	}
+	err = errors.Stack(err)
+	var pluginErr *treport.PluginError
+	if !errors.As(err, &pluginErr) || pluginErr.Class != treport.PluginErrorFatal {
+		t.Fatalf("expected plugin error in failures: %v", err)
+	}
+	if !errors.Is(err, pluginErr) {
+		t.Fatalf("expected failures to have plugin error")
+	}
+	detail := fmt.Sprintf("%+v", err)
+	for _, expected := range []string{
+		"2 failures:",
+		"pipeline size: repository https://github.com/goccy/go-json: plugin size: failed to scan",
+		"pipeline size: repository https://github.com/goccy/go-yaml: crashed",
+	} {
+		if !strings.Contains(detail, expected) {
+			t.Fatalf("expected %q in %s", expected, detail)
+		}
+	}
+	// the stack of the failure is printed once after the one of the aggregate.
+	if n := strings.Count(detail, "error_test.go"); n != 2 {
+		t.Fatalf("expected stack of each failure but got %d frames: %s", n, detail)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	err := errors.Wrapf(treport.ErrBranchNotFound("https://github.com/goccy/go-json", "main"), "failed to sync")
+	if code := errors.Code(err); code != errors.CodeGit {
+		t.Fatalf("expected git code but got %s", code)
+	}
+	if code := errors.Code(errors.WithCode(err, errors.CodeConfig)); code != errors.CodeConfig {
+		t.Fatalf("expected code to be overridden but got %s", code)
+	}
+	scanErrs := treport.ScanErrors{
+		{Pipeline: "size", Err: fmt.Errorf("broken")},
+		{Pipeline: "size", Repo: "https://github.com/goccy/go-json", Err: treport.ErrAuth("fetch", fmt.Errorf("denied"))},
+	}
+	if code := errors.Code(errors.Stack(scanErrs)); code != errors.CodeAuth {
+		t.Fatalf("expected auth code of failure but got %s", code)
+	}
+	if code := errors.Code(fmt.Errorf("unknown")); code != errors.CodeUnknown {
+		t.Fatalf("expected unknown code but got %s", code)
+	}
+}
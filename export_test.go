@@ -0,0 +1,240 @@
+package treport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	treportproto "github.com/goccy/treport/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ConvertDiff diffs the trees and converts the changes to the messages sent to the plugins.
+func ConvertDiff(ctx context.Context, from, to *object.Tree) ([]*treportproto.Change, error) {
+	changes, err := diffTree(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return changes.toProto(), nil
+}
+
+// ParseCommitReferences returns the pull request number and the references parsed from the commit message.
+func ParseCommitReferences(message string) (int64, []int64) {
+	return parseCommitReferences(message)
+}
+
+// ParseTrailers returns the trailers parsed from the commit message.
+func ParseTrailers(message string) []*Trailer {
+	return parseTrailers(message)
+}
+
+// RegisterDescriptors registers the descriptors as they are published by the plugin.
+func RegisterDescriptors(set *descriptorpb.FileDescriptorSet) error {
+	return resultTypes.register(set)
+}
+
+// ClassifyPluginError sends the error returned by the plugin to the host, and returns the error the host handles.
+func ClassifyPluginError(err error) error {
+	return pluginError("scan", pluginStatus(err))
+}
+
+// RecordFailure records the failure of the commit scanned by the plugin.
+func (p *Plugin) RecordFailure(commitHash string, err error) error {
+	return p.recordFailure(context.Background(), commitHash, err)
+}
+
+// SetWarmCache makes the plugin read the results missing in its cache from the cache of another pipeline.
+func (p *Plugin) SetWarmCache(path string) {
+	p.warmPath = path
+}
+
+// IsQuarantined reports whether the plugin skips the commit.
+func (p *Plugin) IsQuarantined(commitHash string) (bool, error) {
+	return p.isQuarantined(commitHash)
+}
+
+// Limit applies the limits of the pipeline to the context.
+func (c *ScanContext) Limit(limits *LimitsConfig) error {
+	return c.limit(limits)
+}
+
+// Negotiate applies the plugin information to the client as the plugin is set up.
+func (c *Client) Negotiate(ctx context.Context) error {
+	info, err := c.info(ctx)
+	if err != nil {
+		return err
+	}
+	c.applyInfo(info)
+	return nil
+}
+
+// AggregateDirs returns the directories passed to the plugins enabling dirs.
+func (s *Snapshot) AggregateDirs() []*Dir {
+	return s.dirs()
+}
+
+// BlobCache is the cache of the blob contents.
+type BlobCache = blobCache
+
+// OpenBlobCache opens the cache of the blob contents at the path.
+func OpenBlobCache(path string, cfg *BlobCacheConfig) (*BlobCache, error) {
+	return openBlobCache(path, cfg)
+}
+
+// Get returns the contents of the blob. open is called if the blob isn't cached.
+func (c *blobCache) Get(hash string, open func() (io.ReadCloser, error)) ([]byte, error) {
+	return c.get(hash, open)
+}
+
+// Fetch fetches the remote of the repository.
+func (r *Repository) Fetch(ctx context.Context) error {
+	return r.syncRemoteBranches(ctx)
+}
+
+// PluginPool keeps the plugins between the scans of daemon mode.
+type PluginPool = pluginPool
+
+func NewPluginPool(idleTimeout time.Duration) *PluginPool {
+	return newPluginPool(idleTimeout)
+}
+
+// Get returns the idle client of the key.
+func (p *pluginPool) Get(key string) *Client {
+	return p.get(key)
+}
+
+// Put keeps the client until it's got again or it's idle for the timeout.
+func (p *pluginPool) Put(key string, client *Client) {
+	p.put(key, client)
+}
+
+// NewPluginClient returns the client of the plugin binary at path, which has no process.
+func NewPluginClient(path string) (*Client, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{pluginName: path, path: path, mtime: stat.ModTime()}, nil
+}
+
+// IsStopped reports whether the client is stopped.
+func (c *Client) IsStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// ReadVersion reads the version of the plugin for the platform of the host.
+func (db *PluginVersionDB) ReadVersion(name string) (*PluginVersion, error) {
+	return db.readVersion(name)
+}
+
+// WriteVersion writes the version of the plugin for the platform of the host.
+func (db *PluginVersionDB) WriteVersion(ver *PluginVersion) error {
+	return db.writeVersion(ver)
+}
+
+// WriteLegacyVersion writes the version keyed only by the name as older versions did.
+func (db *PluginVersionDB) WriteLegacyVersion(ver *PluginVersion) error {
+	b, err := json.Marshal(ver)
+	if err != nil {
+		return err
+	}
+	return db.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry([]byte(ver.Name), b))
+	})
+}
+
+// WatchPack reports the size of the pack written under repoPath to the clone progress of ctx every interval until stop is called.
+func WatchPack(ctx context.Context, repoPath string, interval time.Duration) (stop func()) {
+	clonePackInterval = interval
+	return newCloneProgressWriter(ctx, repoPath, CloneOperationFetch, 0, 0).watchPack(repoPath)
+}
+
+// NewSetupPlugin returns the plugin which is set up by setup instead of starting the process.
+func NewSetupPlugin(name string, setup func([]string) error) *Plugin {
+	return &Plugin{Name: name, setup: setup}
+}
+
+// NewProcessPlugins returns the plugins of a run which share the process started by start.
+func NewProcessPlugins(name string, n int, start func() error) []*Plugin {
+	processes := newPluginProcesses(nil)
+	plugins := make([]*Plugin, 0, n)
+	for i := 0; i < n; i++ {
+		plg := &Plugin{Name: name, processes: processes}
+		plg.setup = func(args []string) error {
+			return plg.setupProcess(pluginProcessKey(name, args, nil, nil, nil), func() (*Client, error) {
+				if err := start(); err != nil {
+					return nil, err
+				}
+				return &Client{pluginName: name}, nil
+			})
+		}
+		plugins = append(plugins, plg)
+	}
+	return plugins
+}
+
+// MergedPullRequests returns the function listing the merged pull requests of the config
+// as a run of the clone at repoPath does.
+func MergedPullRequests(cfg *RepositoryConfig, repoPath string) func(context.Context) (map[string]*PullRequest, error) {
+	repo := &Repository{cfg: cfg, path: repoPath, pullRequests: newPullRequestCache()}
+	return repo.mergedPullRequests
+}
+
+// RunPipelineHooks runs scan between the before and the after hooks of the pipeline.
+func RunPipelineHooks(ctx context.Context, pipeline *Pipeline, scan func() error) error {
+	return runPipelineHooks(ctx, pipeline, scan)
+}
+
+// ScanSteps runs the steps of the pipeline without plugins for a repository, in the order of their dependencies.
+func ScanSteps(ctx context.Context, cfg *PipelineConfig) error {
+	deps, err := cfg.StepDependencies()
+	if err != nil {
+		return err
+	}
+	repo := &PipelineRepository{Repository: &Repository{cfg: &RepositoryConfig{Repo: "test"}}}
+	for idx := range cfg.Steps {
+		repo.Steps = append(repo.Steps, &Step{Idx: idx})
+	}
+	repo.resolveDependencies(deps)
+	return (&Scanner{}).scanWithPipelineAndRepo(ctx, &Pipeline{Config: cfg}, repo)
+}
+
+// LockMountFile takes the lock of the mount path as another process does, and returns the function which releases it.
+func LockMountFile(path string) (func(), error) {
+	file, err := tryLockFile(filepath.Join(path, mountLockName))
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%s is already locked", path)
+	}
+	return func() { unlockFile(file) }, nil
+}
+
+// EnforceDiskQuota evicts the clones exceeding project.diskQuota as the run does.
+func EnforceDiskQuota(ctx context.Context, cfg *Config) error {
+	return enforceDiskQuota(ctx, cfg)
+}
+
+// SandboxCommand sets up the sandbox of the plugin to cmd, and returns the function which removes its working directory.
+func SandboxCommand(name string, cfg *SandboxConfig, cmd *exec.Cmd) (func(), error) {
+	sandbox, err := newPluginSandbox(name, cfg, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return sandbox.cleanup, nil
+}
+
+// ScrubPluginEnv scrubs the environment of cmd as the plugin is started.
+func ScrubPluginEnv(cmd *exec.Cmd, cfg *PluginEnvConfig) error {
+	return scrubPluginEnv(cmd, cfg, nil)
+}
@@ -0,0 +1,80 @@
+package treport
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// FilterConfig excludes commits from the walk. The excluded commits aren't passed to plugins,
+// and their changes aren't included in the changes of the next commit.
+type FilterConfig struct {
+	// SkipAuthors are glob patterns of the author or committer email like *@example.com.
+	SkipAuthors []string `yaml:"skipAuthors"`
+	// SkipBots excludes the commits authored by bot accounts like dependabot[bot].
+	SkipBots bool `yaml:"skipBots"`
+	// SkipMessages are regular expressions of the commit message like \[skip treport\].
+	SkipMessages []string `yaml:"skipMessages"`
+
+	once     sync.Once
+	messages []*regexp.Regexp
+}
+
+func (c *FilterConfig) messageRegexps() []*regexp.Regexp {
+	c.once.Do(func() {
+		for _, pattern := range c.SkipMessages {
+			// invalid patterns are reported by the validation.
+			if re, err := regexp.Compile(pattern); err == nil {
+				c.messages = append(c.messages, re)
+			}
+		}
+	})
+	return c.messages
+}
+
+// skip reports whether the commit is excluded by the filter.
+func (c *FilterConfig) skip(commit *object.Commit) bool {
+	if c == nil {
+		return false
+	}
+	if matchBranch(c.SkipAuthors, commit.Author.Email) || matchBranch(c.SkipAuthors, commit.Committer.Email) {
+		return true
+	}
+	if c.SkipBots && isBot(commit.Author) {
+		return true
+	}
+	for _, re := range c.messageRegexps() {
+		if re.MatchString(commit.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBot reports whether the signature is a GitHub App like dependabot[bot] or a GitLab bot user like project_1_bot.
+func isBot(sig object.Signature) bool {
+	if strings.HasSuffix(sig.Name, "[bot]") {
+		return true
+	}
+	user := strings.SplitN(sig.Email, "@", 2)[0]
+	if strings.HasSuffix(user, "[bot]") {
+		return true
+	}
+	return (strings.HasPrefix(user, "project_") || strings.HasPrefix(user, "group_")) && strings.Contains(user, "_bot")
+}
+
+// count returns the number of commits which aren't excluded by the filter.
+func (c *FilterConfig) count(commits []*object.Commit) int {
+	if c == nil {
+		return len(commits)
+	}
+	n := 0
+	for _, commit := range commits {
+		if !c.skip(commit) {
+			n++
+		}
+	}
+	return n
+}
@@ -6,12 +6,16 @@ require (
 	github.com/dgraph-io/badger/v2 v2.2007.2
 	github.com/go-git/go-git/v5 v5.3.0
 	github.com/goccy/go-yaml v1.8.9
+	github.com/goccy/treport/proto v0.1.0
 	github.com/golang/protobuf v1.5.2
 	github.com/hashicorp/go-hclog v0.14.1
 	github.com/hashicorp/go-plugin v1.4.1
 	github.com/jhump/protoreflect v1.6.0
-	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	go.opentelemetry.io/otel v1.4.1
+	go.opentelemetry.io/otel/trace v1.4.1
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1
 	google.golang.org/grpc v1.37.0
 	google.golang.org/protobuf v1.26.0
 )
+
+require github.com/go-logr/logr v1.2.3 // indirect
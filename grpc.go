@@ -0,0 +1,67 @@
+package treport
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// maxMessageSizeEnv passes maxMessageSize of the host to the plugin process, so Serve accepts the same size.
+const maxMessageSizeEnv = "TREPORT_GRPC_MAX_MESSAGE_SIZE"
+
+type GRPCCompression string
+
+const (
+	GRPCCompressionNone GRPCCompression = "none"
+	GRPCCompressionGzip GRPCCompression = "gzip"
+)
+
+// GRPCConfig configures the messages between treport and the plugins.
+type GRPCConfig struct {
+	// MaxMessageSize is the max bytes of the messages sent and received by both sides. Zero means the default of gRPC ( 4MB ).
+	MaxMessageSize int `yaml:"maxMessageSize"`
+	// Compression compresses the messages. It's none by default.
+	Compression GRPCCompression `yaml:"compression"`
+}
+
+func (c *PluginConfig) grpcConfig() *GRPCConfig {
+	if c == nil {
+		return nil
+	}
+	return c.GRPC
+}
+
+func (c *GRPCConfig) callOptions() []grpc.CallOption {
+	if c == nil {
+		return nil
+	}
+	var opts []grpc.CallOption
+	if c.MaxMessageSize > 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(c.MaxMessageSize), grpc.MaxCallSendMsgSize(c.MaxMessageSize))
+	}
+	if c.Compression == GRPCCompressionGzip {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+	}
+	return opts
+}
+
+// env returns the environment variables of the plugin process.
+func (c *GRPCConfig) env() []string {
+	if c == nil || c.MaxMessageSize <= 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s=%d", maxMessageSizeEnv, c.MaxMessageSize)}
+}
+
+// serverOptions returns the options of the plugin server by maxMessageSize passed from the host.
+// The compressed request is decoded by the registered gzip compressor, and the response is compressed by the same one.
+func serverOptions() []grpc.ServerOption {
+	size, err := strconv.Atoi(os.Getenv(maxMessageSizeEnv))
+	if err != nil || size <= 0 {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.MaxRecvMsgSize(size), grpc.MaxSendMsgSize(size)}
+}
@@ -0,0 +1,82 @@
+package treport
+
+import (
+	"context"
+
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+	"github.com/golang/protobuf/proto"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ScanResult is the result of the scanner for the commit returned by RunScanner.
+type ScanResult struct {
+	Commit   *Commit
+	response *treportproto.ScanResponse
+}
+
+// Decode decodes the result to msg. It returns ErrNoData if the scanner returned no result for the commit.
+func (r *ScanResult) Decode(msg proto.Message) error {
+	if r.response == nil || r.response.Data == nil {
+		return ErrNoData
+	}
+	v := proto.MessageReflect(msg).Interface()
+	return anypb.UnmarshalTo(r.response.Data, v, protobuf.UnmarshalOptions{})
+}
+
+// JSON returns the result encoded to JSON, or the empty string if the scanner returned no result.
+func (r *ScanResult) JSON() string {
+	if r.response == nil {
+		return ""
+	}
+	return r.response.Json
+}
+
+// Warnings returns the warnings the scanner added to the result.
+func (r *ScanResult) Warnings() []string {
+	if r.response == nil {
+		return nil
+	}
+	return r.response.Warnings
+}
+
+// RunScanner scans the commits of the repository with the scanner in this process, so the plugin is tested without
+// building the binary. The scan context is converted as it's sent to the plugin process, and Previous returns
+// the result of the last commit as the scan of the pipeline does. The results are ordered as the walk.
+func RunScanner(ctx context.Context, repo *Repository, scanner GRPCScanner, opt *WalkOptions) ([]*ScanResult, error) {
+	iter, err := repo.Commits(ctx, opt)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to walk commits")
+	}
+	newestFirst := opt != nil && opt.NewestFirst
+	results := []*ScanResult{}
+	var previous *treportproto.ScanResponse
+	for iter.Next() {
+		scanctx := iter.ScanContext()
+		if !newestFirst {
+			scanctx.previous = previous
+		}
+		res, err := scanner.Scan(protoToScanContext(ctx, scanctx.toProto(nil)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to scan %s", scanctx.Commit.Hash)
+		}
+		var response *treportproto.ScanResponse
+		if res != nil {
+			response = &treportproto.ScanResponse{
+				Name:          res.name,
+				Data:          res.data,
+				Json:          res.json,
+				SchemaVersion: schemaVersionOf(scanner),
+				PluginVersion: versionOf(scanner),
+				Warnings:      res.warnings,
+			}
+		}
+		previous = response
+		results = append(results, &ScanResult{Commit: scanctx.Commit, response: response})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to walk commits")
+	}
+	return results, nil
+}
@@ -0,0 +1,208 @@
+package treport
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// ScanRecord is the history of a pipeline scanned by a run.
+type ScanRecord struct {
+	RunID      string                  `json:"runId"`
+	Pipeline   string                  `json:"pipeline"`
+	StartedAt  time.Time               `json:"startedAt"`
+	FinishedAt time.Time               `json:"finishedAt"`
+	ConfigHash string                  `json:"configHash"`
+	Plugins    []*RunPlugin            `json:"plugins"`
+	Repos      []*ScanRecordRepository `json:"repos"`
+	Error      string                  `json:"error,omitempty"`
+}
+
+type ScanRecordRepository struct {
+	Repo   string `json:"repo"`
+	Branch string `json:"branch,omitempty"`
+	// Commits is the largest number of the commits visited by the plugins.
+	Commits      int   `json:"commits"`
+	CacheHits    int   `json:"cacheHits"`
+	Failures     int   `json:"failures"`
+	BytesFetched int64 `json:"bytesFetched"`
+}
+
+// historyMu serializes the access to the history DB, because badger locks the directory.
+var historyMu sync.Mutex
+
+func newScanRecords(run *Run, summary *ScanSummary, pipelines []*Pipeline, pipelineErrs []error, scanErr error) []*ScanRecord {
+	records := make([]*ScanRecord, 0, len(pipelines))
+	for i, pipeline := range pipelines {
+		pipelineSummary := summary.Pipelines[i]
+		record := &ScanRecord{
+			RunID:      run.ID,
+			Pipeline:   pipeline.Config.Name,
+			StartedAt:  summary.StartedAt,
+			FinishedAt: summary.FinishedAt,
+			ConfigHash: run.ConfigHash,
+			Plugins:    pipelinePlugins(run.Plugins, pipeline),
+		}
+		if pipelineSummary.Elapsed > 0 {
+			record.FinishedAt = summary.StartedAt.Add(pipelineSummary.Elapsed)
+		}
+		switch {
+		case pipelineErrs[i] != nil:
+			record.Error = pipelineErrs[i].Error()
+		case pipelineSummary.Elapsed == 0 && scanErr != nil:
+			// the pipeline isn't finished by the failure of the other pipeline.
+			record.Error = scanErr.Error()
+		}
+		for _, repo := range pipelineSummary.Repos {
+			recordRepo := &ScanRecordRepository{
+				Repo:         repo.Repo,
+				Branch:       repo.Branch,
+				BytesFetched: repo.BytesFetched,
+			}
+			for _, plg := range repo.Plugins {
+				if plg.Commits > recordRepo.Commits {
+					recordRepo.Commits = plg.Commits
+				}
+				recordRepo.CacheHits += plg.CacheHits
+				recordRepo.Failures += plg.Failures
+			}
+			record.Repos = append(record.Repos, recordRepo)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// pipelinePlugins returns the plugins of the run used by the pipeline.
+func pipelinePlugins(plugins []*RunPlugin, pipeline *Pipeline) []*RunPlugin {
+	used := map[string]struct{}{}
+	for _, repo := range pipeline.Repos {
+		for _, step := range repo.Steps {
+			for _, plg := range step.Plugins {
+				used[plg.Name] = struct{}{}
+			}
+		}
+	}
+	filtered := []*RunPlugin{}
+	for _, plg := range plugins {
+		if _, exists := used[plg.Name]; exists {
+			filtered = append(filtered, plg)
+		}
+	}
+	return filtered
+}
+
+// historyKey orders the records by the pipeline and the started time.
+func historyKey(record *ScanRecord) []byte {
+	return []byte(fmt.Sprintf("%s/%020d/%s", record.Pipeline, record.StartedAt.UnixNano(), record.RunID))
+}
+
+func saveScanRecords(historyPath string, records []*ScanRecord) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if err := mkdirIfNotExists(historyPath); err != nil {
+		return errors.Wrapf(err, "failed to create directory for history")
+	}
+	db, err := badger.Open(badger.DefaultOptions(historyPath))
+	if err != nil {
+		return errors.Wrapf(err, "failed to open history DB")
+	}
+	defer db.Close()
+	if err := db.Update(func(tx *badger.Txn) error {
+		for _, record := range records {
+			b, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := tx.Set(historyKey(record), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "failed to write history")
+	}
+	return nil
+}
+
+func loadScanRecords(historyPath string) ([]*ScanRecord, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if !existsPath(historyPath) {
+		return nil, nil
+	}
+	db, err := badger.Open(badger.DefaultOptions(historyPath).WithReadOnly(true))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open history DB")
+	}
+	defer db.Close()
+	records := []*ScanRecord{}
+	if err := db.View(func(tx *badger.Txn) error {
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			v, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var record ScanRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			records = append(records, &record)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "failed to read history")
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].StartedAt.Before(records[j].StartedAt)
+	})
+	return records, nil
+}
+
+// History returns the scan records of the pipeline in started order. The empty pipeline means all pipelines.
+// If repo is specified, only the records which scanned the repository are returned.
+func (db *ResultDB) History(pipeline, repo string) ([]*ScanRecord, error) {
+	records, err := loadScanRecords(db.historyPath)
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	filtered := []*ScanRecord{}
+	for _, record := range records {
+		if pipeline != "" && record.Pipeline != pipeline {
+			continue
+		}
+		if repo != "" && record.repo(repo) == nil {
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+	return filtered, nil
+}
+
+// LastScan returns the last scan record of the repository.
+func (db *ResultDB) LastScan(repo string) (*ScanRecord, error) {
+	records, err := db.History("", repo)
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	if len(records) == 0 {
+		return nil, ErrNoData
+	}
+	return records[len(records)-1], nil
+}
+
+func (r *ScanRecord) repo(repo string) *ScanRecordRepository {
+	for _, recordRepo := range r.Repos {
+		if recordRepo.Repo == repo {
+			return recordRepo
+		}
+	}
+	return nil
+}
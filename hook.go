@@ -0,0 +1,188 @@
+package treport
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// afterHookTimeout is the time the after hooks can take. They don't use the context of the scan,
+// so they run even if the scan is canceled or times out.
+const afterHookTimeout = 10 * time.Minute
+
+// HookCommands are the shell commands run in order. It's written as a string or a list of strings.
+// The commands know the pipeline, the repository and the result by the environment variables of hookEnv.
+type HookCommands []string
+
+func (c *HookCommands) UnmarshalYAML(b []byte) error {
+	var v string
+	if err := yaml.Unmarshal(b, &v); err == nil {
+		*c = HookCommands{v}
+		return nil
+	}
+	var vs []string
+	if err := yaml.Unmarshal(b, &vs); err != nil {
+		return err
+	}
+	*c = HookCommands(vs)
+	return nil
+}
+
+const (
+	hookBefore = "before"
+	hookAfter  = "after"
+)
+
+// hookEnv is the environment variables passed to the hook commands. The empty ones aren't passed.
+type hookEnv struct {
+	hook     string
+	pipeline string
+	// repos are the repositories of the pipeline passed to the hooks of the pipeline.
+	repos  []string
+	repo   string
+	repoID string
+	branch string
+	step   string
+	// commits, failures and err are the result passed to the after hooks.
+	commits  int
+	failures int
+	err      error
+}
+
+func (e *hookEnv) environ() []string {
+	env := []string{"TREPORT_HOOK=" + e.hook, "TREPORT_PIPELINE=" + e.pipeline}
+	for _, kv := range [][2]string{
+		{"TREPORT_REPOS", strings.Join(e.repos, " ")},
+		{"TREPORT_REPO", e.repo},
+		{"TREPORT_REPO_ID", e.repoID},
+		{"TREPORT_BRANCH", e.branch},
+		{"TREPORT_STEP", e.step},
+	} {
+		if kv[1] != "" {
+			env = append(env, kv[0]+"="+kv[1])
+		}
+	}
+	if e.hook != hookAfter {
+		return env
+	}
+	status := string(NotifySuccess)
+	if e.err != nil {
+		status = string(NotifyFailure)
+		env = append(env, "TREPORT_ERROR="+e.err.Error())
+	}
+	return append(env,
+		"TREPORT_STATUS="+status,
+		"TREPORT_COMMITS="+strconv.Itoa(e.commits),
+		"TREPORT_FAILURES="+strconv.Itoa(e.failures),
+	)
+}
+
+// run runs the commands in order, and stops at the first failure. The output of the commands is logged,
+// so it doesn't mix with the results written to stdout.
+func (c HookCommands) run(ctx context.Context, env *hookEnv) error {
+	for _, command := range c {
+		cmd := shellCommand(ctx, command)
+		cmd.Env = append(os.Environ(), env.environ()...)
+		out, err := cmd.CombinedOutput()
+		loggerFrom(ctx).Info("ran hook", "hook", env.hook, "pipeline", env.pipeline, "repo", env.repo, "step", env.step, "command", command, "output", strings.TrimSpace(string(out)))
+		if err != nil {
+			return ErrHookFailed(env.hook, command, err, out)
+		}
+	}
+	return nil
+}
+
+// afterHookContext returns the context of the after hooks, which keeps only the logger of ctx.
+func afterHookContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(withLogger(context.Background(), loggerFrom(ctx)), afterHookTimeout)
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// runPipelineHooks runs scan between the before and the after hooks of the pipeline.
+// The after hooks run even if the scan fails or is canceled, and the error of the scan takes precedence over the one of the hooks.
+func runPipelineHooks(ctx context.Context, pipeline *Pipeline, scan func() error) error {
+	env := &hookEnv{hook: hookBefore, pipeline: pipeline.Config.Name}
+	for _, repo := range pipeline.Repos {
+		env.repos = append(env.repos, repo.cfg.identity())
+	}
+	if err := pipeline.Config.Before.run(ctx, env); err != nil {
+		return err
+	}
+	err := scan()
+	if len(pipeline.Config.After) == 0 {
+		return err
+	}
+	env.hook = hookAfter
+	env.err = err
+	for _, repo := range pipeline.Repos {
+		commits, failures := repo.results.counts()
+		env.commits += commits
+		env.failures += failures
+	}
+	afterCtx, cancel := afterHookContext(ctx)
+	defer cancel()
+	if hookErr := pipeline.Config.After.run(afterCtx, env); hookErr != nil && err == nil {
+		return hookErr
+	}
+	return err
+}
+
+// runStepHooks runs scan between the before and the after hooks of the step for the repository.
+// The after hooks are passed the results of the plugins of the step.
+func runStepHooks(ctx context.Context, pipeline *Pipeline, repo *PipelineRepository, step *Step, scan func() error) error {
+	stepCfg := pipeline.Config.Steps[step.Idx]
+	env := &hookEnv{
+		hook:     hookBefore,
+		pipeline: pipeline.Config.Name,
+		repo:     repo.cfg.identity(),
+		repoID:   repo.ID,
+		branch:   repo.scanBranch,
+		step:     stepCfg.Name,
+	}
+	if env.step == "" {
+		env.step = fmt.Sprint(step.Idx)
+	}
+	if err := stepCfg.Before.run(ctx, env); err != nil {
+		return err
+	}
+	err := scan()
+	if len(stepCfg.After) == 0 {
+		return err
+	}
+	env.hook = hookAfter
+	env.err = err
+	env.commits, env.failures = repo.results.pluginCounts(step.Plugins)
+	afterCtx, cancel := afterHookContext(ctx)
+	defer cancel()
+	if hookErr := stepCfg.After.run(afterCtx, env); hookErr != nil && err == nil {
+		return hookErr
+	}
+	return err
+}
+
+// validateHooks checks that the hooks don't have the empty command.
+func (v *configValidator) validateHooks(path string, before, after HookCommands) {
+	for _, hook := range []struct {
+		name     string
+		commands HookCommands
+	}{{hookBefore, before}, {hookAfter, after}} {
+		for i, command := range hook.commands {
+			if strings.TrimSpace(command) == "" {
+				v.addError(fmt.Sprintf("%s.%s[%d]", path, hook.name, i), "command is required")
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package treport_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestAfterHookRunsOnCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is written for sh")
+	}
+	out := filepath.Join(t.TempDir(), "after")
+	pipeline := &treport.Pipeline{
+		Config: &treport.PipelineConfig{
+			Name:  "test",
+			After: treport.HookCommands{`echo "$TREPORT_STATUS $TREPORT_ERROR" > ` + out},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := treport.RunPipelineHooks(ctx, pipeline, func() error {
+		cancel()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the error of the scan, got %v", err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("after hook didn't run: %v", err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "failure context canceled" {
+		t.Fatalf("unexpected env of after hook %q", got)
+	}
+}
+
+func TestStepSkippedByFailedDependency(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is written for sh")
+	}
+	out := filepath.Join(t.TempDir(), "independent")
+	cfg := &treport.PipelineConfig{
+		Name: "test",
+		Steps: []*treport.StepConfig{
+			{Name: "failed", Before: treport.HookCommands{"exit 1"}},
+			{Name: "dependent", DependsOn: []string{"failed"}},
+			{Name: "indirect", DependsOn: []string{"dependent"}},
+			{Name: "independent", DependsOn: []string{}, Before: treport.HookCommands{"touch " + out}},
+		},
+	}
+	err := treport.ScanSteps(context.Background(), cfg)
+	var errs treport.ScanErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ScanErrors, got %v", err)
+	}
+	skipped := map[string]string{}
+	var hookFailed bool
+	for _, e := range errs {
+		var skippedErr *treport.StepSkippedError
+		var hookErr *treport.HookFailedError
+		switch {
+		case errors.As(e.Err, &skippedErr):
+			skipped[skippedErr.Step] = skippedErr.FailedStep
+		case errors.As(e.Err, &hookErr):
+			hookFailed = true
+		default:
+			t.Fatalf("unexpected error %v", e)
+		}
+	}
+	if !hookFailed {
+		t.Fatalf("failure of the step isn't reported: %v", err)
+	}
+	if len(skipped) != 2 || skipped["dependent"] != "failed" || skipped["indirect"] != "failed" {
+		t.Fatalf("unexpected skipped steps %v", skipped)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Fatalf("independent step didn't run: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package treport_test
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestIDScheme(t *testing.T) {
+	if treport.DefaultIDScheme.ID("a:b") == treport.DefaultIDScheme.ID("a", "b") {
+		t.Fatal("IDs of different parts must not collide")
+	}
+	if !strings.HasPrefix(treport.DefaultIDScheme.ID("a"), fmt.Sprintf("v%d-", treport.DefaultIDScheme.Version())) {
+		t.Fatal("ID must have version prefix")
+	}
+	legacy := fmt.Sprintf("%x", sha1.Sum([]byte("a:b")))
+	if treport.LegacyIDScheme.ID("a", "b") != legacy {
+		t.Fatal("legacy ID must be compatible with the existing cache")
+	}
+}
@@ -0,0 +1,144 @@
+package treport
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	treportIgnoreFile = ".treportignore"
+	gitAttributesFile = ".gitattributes"
+)
+
+// linguistAttributes mark the generated, vendored and documentation paths for GitHub Linguist.
+var linguistAttributes = []string{"linguist-generated", "linguist-vendored", "linguist-documentation"}
+
+// IgnoreConfig controls the paths which the scanned repository declares to exclude from the snapshots and the changes.
+// The files at the root of the tree of each commit are used, so the paths follow the history of the files.
+type IgnoreConfig struct {
+	// Disable doesn't read .treportignore, which is written in the syntax of .gitignore.
+	Disable bool `yaml:"disable"`
+	// Linguist excludes the paths marked by linguist-generated, linguist-vendored or linguist-documentation in .gitattributes.
+	Linguist bool `yaml:"linguist"`
+}
+
+// pathIgnorer reports whether the path is excluded by the ignore files of the commit.
+type pathIgnorer struct {
+	ignore     gitignore.Matcher
+	attributes gitattributes.Matcher
+}
+
+// newPathIgnorer reads the ignore files of the commit. It returns nil if the commit has none of them.
+func newPathIgnorer(repo *Repository, commitHash string, cfg *IgnoreConfig) (*pathIgnorer, error) {
+	if cfg != nil && cfg.Disable && !cfg.Linguist {
+		return nil, nil
+	}
+	commit, err := repo.CommitObject(plumbing.NewHash(commitHash))
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	ignorer := &pathIgnorer{}
+	if cfg == nil || !cfg.Disable {
+		content, err := rootFileContent(tree, treportIgnoreFile)
+		if err != nil {
+			return nil, err
+		}
+		if content != "" {
+			var patterns []gitignore.Pattern
+			for _, line := range strings.Split(content, "\n") {
+				line = strings.TrimRight(line, "\r")
+				if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				patterns = append(patterns, gitignore.ParsePattern(line, nil))
+			}
+			ignorer.ignore = gitignore.NewMatcher(patterns)
+		}
+	}
+	if cfg != nil && cfg.Linguist {
+		content, err := rootFileContent(tree, gitAttributesFile)
+		if err != nil {
+			return nil, err
+		}
+		if content != "" {
+			// the malformed lines are skipped as git does.
+			var attrs []gitattributes.MatchAttribute
+			for _, line := range strings.Split(content, "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				if attr, err := gitattributes.ParseAttributesLine(line, nil, true); err == nil {
+					attrs = append(attrs, attr)
+				}
+			}
+			ignorer.attributes = gitattributes.NewMatcher(attrs)
+		}
+	}
+	if ignorer.ignore == nil && ignorer.attributes == nil {
+		return nil, nil
+	}
+	return ignorer, nil
+}
+
+// rootFileContent returns the content of the file at the root of the tree, or the empty string if it doesn't exist.
+func rootFileContent(tree *object.Tree, name string) (string, error) {
+	file, err := tree.File(name)
+	if err != nil {
+		if err == object.ErrFileNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return file.Contents()
+}
+
+func (i *pathIgnorer) ignored(file *File) bool {
+	if file == nil {
+		return true
+	}
+	path := strings.Split(file.Name, "/")
+	if i.ignore != nil && i.ignore.Match(path, false) {
+		return true
+	}
+	if i.attributes == nil {
+		return false
+	}
+	results, _ := i.attributes.Match(path, linguistAttributes)
+	for _, attr := range results {
+		if attr.IsSet() || (attr.IsValueSet() && attr.Value() == "true") {
+			return true
+		}
+	}
+	return false
+}
+
+// apply removes the ignored entries of the snapshot and the ignored changes. The renamed change is kept
+// unless both paths are ignored.
+func (i *pathIgnorer) apply(c *ScanContext) {
+	if c.Snapshot != nil {
+		entries := make([]*File, 0, len(c.Snapshot.Entries))
+		for _, entry := range c.Snapshot.Entries {
+			if !i.ignored(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		c.Snapshot = &Snapshot{Hash: c.Snapshot.Hash, Entries: entries}
+	}
+	changes := make(Changes, 0, len(c.Changes))
+	for _, change := range c.Changes {
+		if i.ignored(change.From) && i.ignored(change.To) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	c.Changes = changes
+}
@@ -0,0 +1,64 @@
+package errors
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// ErrorCode is the stable classification of the error. The API responses and the exit codes are mapped from it
+// without parsing the messages.
+type ErrorCode string
+
+const (
+	CodeUnknown ErrorCode = "unknown"
+	// CodeConfig is the error of the config like the malformed file and the unknown pipeline.
+	CodeConfig ErrorCode = "config"
+	// CodeAuth is the rejected credentials of the remote.
+	CodeAuth ErrorCode = "auth"
+	// CodeGit is the error of the repository like the missing branch and the failed fetch.
+	CodeGit ErrorCode = "git"
+	// CodePlugin is the error of the plugin like the failed scan and the missing binary.
+	CodePlugin ErrorCode = "plugin"
+	// CodeCache is the error of the cache of the plugin results.
+	CodeCache ErrorCode = "cache"
+)
+
+// coder is the error which has the code.
+type coder interface {
+	ErrorCode() ErrorCode
+}
+
+// WithCode wraps err with the code for stack trace. The code overrides the ones of the errors in err's chain.
+func WithCode(err error, code ErrorCode) error {
+	if err == nil {
+		return nil
+	}
+	return &wrapError{
+		baseError: &baseError{},
+		err:       xerrors.Errorf(""),
+		parentErr: err,
+		frame:     xerrors.Caller(1),
+		code:      code,
+	}
+}
+
+// Code returns the code of the first error in err's chain which has the code. It survives the wrapping by Wrapf and Stack.
+// For the errors joined by Unwrap() []error, the code of the first one which has the code is returned.
+func Code(err error) ErrorCode {
+	for err != nil {
+		if c, ok := err.(coder); ok {
+			if code := c.ErrorCode(); code != "" {
+				return code
+			}
+		}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				if code := Code(e); code != CodeUnknown {
+					return code
+				}
+			}
+			return CodeUnknown
+		}
+		err = xerrors.Unwrap(err)
+	}
+	return CodeUnknown
+}
@@ -58,6 +58,7 @@ type wrapError struct {
 	err       error
 	parentErr error
 	frame     xerrors.Frame
+	code      ErrorCode
 }
 
 func (e *wrapError) rootError() error {
@@ -80,6 +81,10 @@ func (e *wrapError) Unwrap() error {
 	return e.parentErr
 }
 
+func (e *wrapError) ErrorCode() ErrorCode {
+	return e.code
+}
+
 func (e *wrapError) FormatError(p xerrors.Printer) error {
 	if e.verb == 'v' && e.state.Flag('+') {
 		// print stack trace for debugging
@@ -133,3 +138,42 @@ func (s *wrapState) Flag(c int) bool {
 	}
 	return true
 }
+
+// As finds the first error in err's chain that matches target.
+// The errors joined by Unwrap() []error are also searched.
+func As(err error, target interface{}) bool {
+	if xerrors.As(err, target) {
+		return true
+	}
+	for _, e := range joinedErrors(err) {
+		if As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is reports whether any error in err's chain matches target.
+// The errors joined by Unwrap() []error are also searched.
+func Is(err, target error) bool {
+	if xerrors.Is(err, target) {
+		return true
+	}
+	for _, e := range joinedErrors(err) {
+		if Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinedErrors returns the errors of the first error in err's chain which has Unwrap() []error.
+func joinedErrors(err error) []error {
+	for err != nil {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			return joined.Unwrap()
+		}
+		err = xerrors.Unwrap(err)
+	}
+	return nil
+}
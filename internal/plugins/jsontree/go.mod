@@ -0,0 +1,13 @@
+module jsontree
+
+go 1.15
+
+require (
+	github.com/goccy/treport v0.0.0-00010101000000-000000000000
+	github.com/hashicorp/go-hclog v0.14.1
+)
+
+replace (
+	github.com/goccy/treport => ../../../
+	github.com/goccy/treport/proto => ../../../proto
+)
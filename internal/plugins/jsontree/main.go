@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/goccy/treport"
+	jsontreeproto "github.com/goccy/treport/plugin/jsontree"
+	"github.com/hashicorp/go-hclog"
+)
+
+// jsonTreeStorer writes the results of the plugins which ran before it to <output>/<repo>/<plugin>/<commit>.json.
+// It's the reference implementation of the storer, which reads the results by ListData.
+type jsonTreeStorer struct {
+	output string
+	logger hclog.Logger
+}
+
+func (s *jsonTreeStorer) Scan(ctx *treport.ScanContext) (*treport.Response, error) {
+	stored := &jsontreeproto.StoredFiles{}
+	for _, data := range ctx.ListData() {
+		repoPath, err := repositoryPath(data.Repository)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(s.output, repoPath, data.Plugin, ctx.Commit.Hash+".json")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data.JSON+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		s.logger.Debug("stored result", "plugin", data.Plugin, "path", path)
+		stored.Paths = append(stored.Paths, path)
+	}
+	return treport.ToResponse(stored)
+}
+
+func (s *jsonTreeStorer) SchemaVersion() string {
+	return "1"
+}
+
+// repositoryPath returns the directory of the repository like github.com/goccy/go-json,
+// or file/path/to/repo for the local repository.
+func repositoryPath(repo string) (string, error) {
+	if repo == "" {
+		return "unknown", nil
+	}
+	u, err := treport.ParseRepositoryURL(repo)
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(u.DiskPath()), nil
+}
+
+//go:generate protoc -Iproto proto/jsontree.proto --go_out=plugins=grpc:../../../plugin/jsontree
+func main() {
+	output := flag.String("output", "treport-results", "directory of the results")
+	flag.Parse()
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Trace,
+		Output:     os.Stderr,
+		JSONFormat: true,
+		Color:      hclog.AutoColor,
+	})
+	treport.Serve(&jsonTreeStorer{output: *output, logger: logger}, logger)
+}
@@ -7,4 +7,7 @@ require (
 	github.com/hashicorp/go-hclog v0.14.1
 )
 
-replace github.com/goccy/treport => ../../../
+replace (
+	github.com/goccy/treport => ../../../
+	github.com/goccy/treport/proto => ../../../proto
+)
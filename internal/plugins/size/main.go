@@ -14,24 +14,25 @@ type sizeScanner struct {
 
 func (s *sizeScanner) Scan(ctx *treport.ScanContext) (*treport.Response, error) {
 	var v sizeproto.SizeData
-	if err := ctx.GetData(&v); err != nil {
+	if err := ctx.Previous(&v); err != nil {
 		if err != treport.ErrNoData {
 			return nil, err
 		}
 	}
-	curSize := v.Size
-	s.logger.Debug("current size = ", curSize)
-	for _, change := range ctx.Changes {
-		switch change.Action {
-		case treport.Added:
-			curSize += change.To.Size
-		case treport.Deleted:
-			curSize -= change.From.Size
-		case treport.Updated:
-			curSize += (change.To.Size - change.From.Size)
-		}
+	s.logger.Debug("current size = ", v.Size)
+	curSize := v.Size + ctx.Changes.TotalSizeDelta()
+	res, err := treport.ToResponse(&sizeproto.SizeData{Size: curSize})
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Truncated {
+		res.Warnf("changes are truncated by the limits, so the size is approximate")
 	}
-	return treport.ToResponse(&sizeproto.SizeData{Size: curSize})
+	return res, nil
+}
+
+func (s *sizeScanner) SchemaVersion() string {
+	return "1"
 }
 
 //go:generate protoc -Iproto proto/size.proto --go_out=plugins=grpc:../../../plugin/size
@@ -0,0 +1,433 @@
+package treport
+
+import (
+	"context"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	treportproto "github.com/goccy/treport/proto"
+)
+
+// ScanIterator iterates the ScanContext of each commit from the oldest one ( or the newest one by opt.NewestFirst ),
+// so the caller drives the walk instead of passing the callback. The ScanContext is created for each commit.
+type ScanIterator struct {
+	ctx         context.Context
+	repo        *Repository
+	opt         *WalkOptions
+	commits     []*object.Commit
+	topoIndexes map[plumbing.Hash]int64
+	firstParent bool
+	idx         int
+	prevTree    *object.Tree
+	// uncached is the number of the commits planned by the newest-first walk.
+	uncached int
+	diffs    *diffQueue
+	cur      *ScanContext
+	err      error
+}
+
+// Commits returns the iterator walking the commits as AllCommits does.
+// The commits until opt.Since are passed to opt.Scanned before it returns.
+func (r *Repository) Commits(ctx context.Context, opt *WalkOptions) (*ScanIterator, error) {
+	if opt == nil {
+		opt = &WalkOptions{}
+	}
+	allCommits, err := r.logCommits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.newScanIterator(ctx, opt, allCommits, false)
+}
+
+// newScanIterator creates the iterator of commits ordered from newest to oldest.
+// If firstParent is true, the merge commits are diffed by opt.DiffMode.
+func (r *Repository) newScanIterator(ctx context.Context, opt *WalkOptions, allCommits []*object.Commit, firstParent bool) (*ScanIterator, error) {
+	it := &ScanIterator{
+		ctx:         ctx,
+		repo:        r,
+		opt:         opt,
+		commits:     allCommits,
+		topoIndexes: topoIndexes(allCommits),
+		firstParent: firstParent,
+		idx:         len(allCommits) - 1,
+	}
+	diff := it.diff
+	if opt.MetadataOnly {
+		diff = it.describe
+	}
+	if opt.NewestFirst {
+		it.idx = 0
+		it.diffs = newDiffQueue(r, opt.DiffWorkers, it.planNewest, diff)
+		return it, nil
+	}
+	it.diffs = newDiffQueue(r, opt.DiffWorkers, it.plan, diff)
+	oldest := it.idx
+	if oldest >= 0 && allCommits[oldest].NumParents() == 0 && !opt.IncludeRoot {
+		oldest--
+	}
+	if opt.Started != nil {
+		opt.Started(opt.Filter.count(allCommits[:oldest+1]))
+	}
+	since, err := opt.resumeIndex(ctx, allCommits, oldest, it.topoIndexes)
+	if err != nil {
+		return nil, err
+	}
+	if since >= 0 {
+		if !opt.MetadataOnly {
+			tree, err := allCommits[since].Tree()
+			if err != nil {
+				return nil, err
+			}
+			it.prevTree = tree
+		}
+		it.idx = since - 1
+	}
+	return it, nil
+}
+
+// Next advances the iterator to the next commit. It returns false at the end of the walk or on error.
+func (it *ScanIterator) Next() bool {
+	it.cur = nil
+	if it.err != nil {
+		return false
+	}
+	task := it.diffs.next()
+	if task == nil {
+		return false
+	}
+	if task.err != nil {
+		it.err = task.err
+		return false
+	}
+	scanctx := &ScanContext{
+		Commit:       task.commit,
+		Snapshot:     task.snapshot,
+		Changes:      task.changes,
+		Merge:        task.merge,
+		TopoIndex:    it.topoIndexes[task.src.Hash],
+		Data:         map[string]*treportproto.ScanResponse{},
+		pluginToType: map[string]string{},
+	}
+	if it.firstParent {
+		scanctx.DiffMode = it.opt.DiffMode
+	}
+	it.cur = scanctx
+	return true
+}
+
+// ScanContext returns the ScanContext of the current commit.
+func (it *ScanIterator) ScanContext() *ScanContext {
+	return it.cur
+}
+
+// Err returns the error which stopped the iteration.
+func (it *ScanIterator) Err() error {
+	return it.err
+}
+
+// plan returns the task to diff the next commit against the previous one.
+// It returns nil at the end of the walk.
+func (it *ScanIterator) plan() *diffTask {
+	for it.idx >= 0 {
+		commit := it.commits[it.idx]
+		it.idx--
+		task, err := it.planCommit(commit)
+		if err != nil {
+			return failedDiffTask(err)
+		}
+		if task != nil {
+			return task
+		}
+	}
+	return nil
+}
+
+// planNewest returns the task to diff the next older commit against the commit before it in the history.
+// It returns nil at the first cached commit, after opt.MaxUncached commits or at the end of the walk.
+func (it *ScanIterator) planNewest() *diffTask {
+	for it.idx < len(it.commits) {
+		if err := it.ctx.Err(); err != nil {
+			return failedDiffTask(err)
+		}
+		i := it.idx
+		it.idx++
+		commit := it.commits[i]
+		if i == len(it.commits)-1 && commit.NumParents() == 0 && !it.opt.IncludeRoot {
+			// the root commit is used only as the base tree of the next commit.
+			return nil
+		}
+		if it.opt.Filter.skip(commit) {
+			continue
+		}
+		if it.opt.Cached != nil {
+			cached, err := it.opt.Cached(commit.Hash.String())
+			if err != nil {
+				return failedDiffTask(err)
+			}
+			if cached {
+				return nil
+			}
+		}
+		if it.opt.MaxUncached > 0 && it.uncached >= it.opt.MaxUncached {
+			return nil
+		}
+		it.uncached++
+		if it.opt.MetadataOnly {
+			return newDiffTask(commit, nil, nil)
+		}
+		var prevTree *object.Tree
+		if i+1 < len(it.commits) {
+			tree, err := it.commits[i+1].Tree()
+			if err != nil {
+				return failedDiffTask(err)
+			}
+			prevTree = tree
+		} else if commit.NumParents() > 0 {
+			tree, err := it.repo.firstTree(commit)
+			if err != nil && err != plumbing.ErrObjectNotFound {
+				return failedDiffTask(err)
+			}
+			prevTree = tree
+		}
+		curTree, err := commit.Tree()
+		if err != nil {
+			return failedDiffTask(err)
+		}
+		return newDiffTask(commit, prevTree, curTree)
+	}
+	return nil
+}
+
+// planCommit returns nil if the commit isn't passed to the caller.
+func (it *ScanIterator) planCommit(commit *object.Commit) (*diffTask, error) {
+	if err := it.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if it.opt.MetadataOnly {
+		return it.planMetadata(commit), nil
+	}
+	if it.prevTree == nil && commit.NumParents() == 0 && !it.opt.IncludeRoot {
+		// the root commit is used only as the base tree of the next commit.
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+		it.prevTree = tree
+		return nil, nil
+	}
+	if it.prevTree == nil && commit.NumParents() > 0 {
+		tree, err := it.repo.firstTree(commit)
+		if err != nil && err != plumbing.ErrObjectNotFound {
+			return nil, err
+		}
+		// the parent of the bottom commit of shallow history doesn't exist, so it's treated as the root commit.
+		it.prevTree = tree
+	}
+	curTree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	prevTree := it.prevTree
+	it.prevTree = curTree
+	if it.opt.Filter.skip(commit) {
+		return nil, nil
+	}
+	return newDiffTask(commit, prevTree, curTree), nil
+}
+
+// planMetadata returns the task passing only the metadata of the commit, so no trees are read to plan it.
+func (it *ScanIterator) planMetadata(commit *object.Commit) *diffTask {
+	// plan has moved idx to the next commit already.
+	oldest := it.idx+1 == len(it.commits)-1
+	if oldest && commit.NumParents() == 0 && !it.opt.IncludeRoot {
+		return nil
+	}
+	if it.opt.Filter.skip(commit) {
+		return nil
+	}
+	return newDiffTask(commit, nil, nil)
+}
+
+// describe reads only the commit of the task for the walk by WalkOptions.MetadataOnly.
+// The snapshot has only the hash of the tree, and the changes are empty.
+func (it *ScanIterator) describe(r *Repository, task *diffTask) error {
+	commit, err := r.CommitObject(task.src.Hash)
+	if err != nil {
+		return err
+	}
+	task.commit = r.toCommit(commit)
+	task.snapshot = &Snapshot{Hash: commit.TreeHash.String()}
+	task.merge = r.mergeInfo(commit)
+	return nil
+}
+
+// diff computes the changes and the snapshot of the task by the repository of the worker.
+// If prevTree is nil, this is the bottom commit and all files are reported as Added.
+func (it *ScanIterator) diff(r *Repository, task *diffTask) error {
+	commit, prevTree, curTree, err := task.objects(r)
+	if err != nil {
+		return err
+	}
+	if it.firstParent && prevTree != nil && commit.NumParents() > 1 {
+		task.changes, err = r.mergeCommitChanges(it.ctx, commit, prevTree, curTree, it.opt.DiffMode)
+	} else {
+		task.changes, err = r.diffTree(it.ctx, prevTree, curTree)
+	}
+	if err != nil {
+		return err
+	}
+	task.snapshot, err = r.snapshot(it.ctx, curTree)
+	if err != nil {
+		return err
+	}
+	task.commit = r.toCommit(commit)
+	task.merge = r.mergeInfo(commit)
+	return nil
+}
+
+// diffTask is the diff of a commit computed by diffQueue.
+type diffTask struct {
+	src      *object.Commit
+	prevTree *object.Tree
+	curTree  *object.Tree
+	commit   *Commit
+	changes  Changes
+	snapshot *Snapshot
+	// pullRequest is set by PullRequests.
+	pullRequest *PullRequest
+	merge       *MergeInfo
+	err         error
+	done        chan struct{}
+}
+
+func newDiffTask(commit *object.Commit, prevTree, curTree *object.Tree) *diffTask {
+	return &diffTask{
+		src:      commit,
+		prevTree: prevTree,
+		curTree:  curTree,
+		done:     make(chan struct{}),
+	}
+}
+
+func failedDiffTask(err error) *diffTask {
+	task := &diffTask{err: err, done: make(chan struct{})}
+	close(task.done)
+	return task
+}
+
+// objects returns the commit and the trees of the task read from the repository.
+func (task *diffTask) objects(r *Repository) (*object.Commit, *object.Tree, *object.Tree, error) {
+	commit, err := r.CommitObject(task.src.Hash)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var prevTree *object.Tree
+	if task.prevTree != nil {
+		prevTree, err = r.TreeObject(task.prevTree.Hash)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	curTree, err := r.TreeObject(task.curTree.Hash)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return commit, prevTree, curTree, nil
+}
+
+// diffQueue diffs the upcoming commits by the workers in the background, and returns them in the planned order.
+// Planning is sequential because each commit is diffed against the tree of the previous one,
+// but diffing and creating the snapshot are independent of each other.
+type diffQueue struct {
+	repo    *Repository
+	workers int
+	plan    func() *diffTask
+	diff    func(*Repository, *diffTask) error
+	pending []*diffTask
+	end     bool
+	// idle is the repositories of the workers which aren't diffing.
+	idle chan *Repository
+}
+
+func newDiffQueue(repo *Repository, workers int, plan func() *diffTask, diff func(*Repository, *diffTask) error) *diffQueue {
+	return &diffQueue{
+		repo:    repo,
+		workers: workers,
+		plan:    plan,
+		diff:    diff,
+		idle:    make(chan *Repository, workers),
+	}
+}
+
+// next returns the next task after it's diffed. It returns nil at the end.
+// The failed task ends the queue, so it's returned after the tasks planned before it.
+func (q *diffQueue) next() *diffTask {
+	q.fill()
+	var task *diffTask
+	if len(q.pending) > 0 {
+		task = q.pending[0]
+		q.pending = q.pending[1:]
+	} else {
+		// no workers. the task is diffed when it's reached.
+		task = q.planNext()
+		if task == nil {
+			return nil
+		}
+		if task.err == nil {
+			task.err = q.diff(q.repo, task)
+			close(task.done)
+		}
+	}
+	<-task.done
+	// start diffing the next commits while the caller processes this one.
+	q.fill()
+	return task
+}
+
+func (q *diffQueue) fill() {
+	for len(q.pending) < q.workers {
+		task := q.planNext()
+		if task == nil {
+			return
+		}
+		q.pending = append(q.pending, task)
+		go q.run(task)
+	}
+}
+
+func (q *diffQueue) planNext() *diffTask {
+	if q.end {
+		return nil
+	}
+	task := q.plan()
+	if task == nil || task.err != nil {
+		q.end = true
+	}
+	return task
+}
+
+func (q *diffQueue) run(task *diffTask) {
+	if task.err != nil {
+		// failed in planning.
+		return
+	}
+	repo, err := q.worker()
+	if err != nil {
+		task.err = err
+		close(task.done)
+		return
+	}
+	task.err = q.diff(repo, task)
+	q.idle <- repo
+	close(task.done)
+}
+
+// worker returns the idle repository of the worker, or opens the new one.
+func (q *diffQueue) worker() (*Repository, error) {
+	select {
+	case repo := <-q.idle:
+		return repo, nil
+	default:
+	}
+	return q.repo.diffWorker()
+}
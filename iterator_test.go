@@ -0,0 +1,545 @@
+package treport_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/goccy/treport"
+)
+
+func TestCommits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitRepo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{"first", "second", "third"}
+	for i, msg := range messages {
+		if err := ioutil.WriteFile(filepath.Join(dir, msg), []byte(msg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(msg); err != nil {
+			t.Fatal(err)
+		}
+		sig := &object.Signature{Name: "treport", Email: "treport@example.com", When: time.Unix(int64(i), 0)}
+		if _, err := wt.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := treport.NewRepository(context.Background(), dir, &treport.RepositoryConfig{Path: dir})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// the commits are passed in order even if they are diffed ahead by the workers.
+	for _, workers := range []int{0, 2} {
+		it, err := repo.Commits(context.Background(), &treport.WalkOptions{IncludeRoot: true, DiffWorkers: workers})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var scanned []string
+		for it.Next() {
+			scanctx := it.ScanContext()
+			if len(scanctx.Changes) != 1 || scanctx.Changes[0].Action != treport.Added {
+				t.Fatalf("unexpected changes of %s", scanctx.Commit.Message)
+			}
+			scanned = append(scanned, scanctx.Commit.Message)
+			if len(scanned) == 2 {
+				break
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(scanned) != 2 || scanned[0] != messages[0] || scanned[1] != messages[1] {
+			t.Fatalf("unexpected commits %v with %d workers", scanned, workers)
+		}
+	}
+	// the newest-first walk stops at the first cached commit.
+	it, err := repo.Commits(context.Background(), &treport.WalkOptions{
+		IncludeRoot: true,
+		NewestFirst: true,
+		Cached: func(hash string) (bool, error) {
+			commit, err := gitRepo.CommitObject(plumbing.NewHash(hash))
+			if err != nil {
+				return false, err
+			}
+			return commit.Message == messages[0], nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var scanned []string
+	for it.Next() {
+		scanned = append(scanned, it.ScanContext().Commit.Message)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(scanned) != 2 || scanned[0] != messages[2] || scanned[1] != messages[1] {
+		t.Fatalf("unexpected commits %v by newest-first walk", scanned)
+	}
+	// the single commit is diffed against its first parent.
+	head, err := gitRepo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Commit(context.Background(), head.Hash(), func(scanctx *treport.ScanContext) error {
+		if scanctx.Commit.Message != messages[2] || len(scanctx.Changes) != 1 || scanctx.Snapshot == nil {
+			t.Fatalf("unexpected context of %s with %d changes", scanctx.Commit.Message, len(scanctx.Changes))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestPeriodicCommits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitRepo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits := []struct {
+		msg  string
+		when time.Time
+	}{
+		{"jan1", time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"jan2", time.Date(2021, 1, 30, 0, 0, 0, 0, time.UTC)},
+		{"feb1", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"feb2", time.Date(2021, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"mar1", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range commits {
+		if err := ioutil.WriteFile(filepath.Join(dir, c.msg), []byte(c.msg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(c.msg); err != nil {
+			t.Fatal(err)
+		}
+		sig := &object.Signature{Name: "treport", Email: "treport@example.com", When: c.when}
+		if _, err := wt.Commit(c.msg, &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := treport.NewRepository(context.Background(), dir, &treport.RepositoryConfig{Path: dir})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var scanned []string
+	var changes []int
+	if err := repo.PeriodicCommits(context.Background(), &treport.WalkOptions{IncludeRoot: true, Period: treport.PeriodMonth}, func(scanctx *treport.ScanContext) error {
+		scanned = append(scanned, scanctx.Commit.Message)
+		changes = append(changes, len(scanctx.Changes))
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// the sampled commits after the oldest one are diffed against the previous sample, so the changes are the ones of the month.
+	if len(scanned) != 3 || scanned[0] != "jan2" || scanned[1] != "feb2" || scanned[2] != "mar1" {
+		t.Fatalf("unexpected commits %v", scanned)
+	}
+	if changes[0] != 1 || changes[1] != 2 || changes[2] != 1 {
+		t.Fatalf("unexpected number of changes %v", changes)
+	}
+}
+
+func TestAttachRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitRepo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("a"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "treport", Email: "treport@example.com", When: time.Unix(0, 0)}
+	if _, err := wt.Commit("first", &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := treport.OpenRepository(dir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	attached, err := treport.AttachRepository(gitRepo, &treport.RepositoryConfig{Path: dir})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if opened.ID != attached.ID {
+		t.Fatalf("unexpected IDs %s and %s", opened.ID, attached.ID)
+	}
+	if _, err := treport.AttachRepository(gitRepo, nil); err == nil {
+		t.Fatal("expected error for repository without path and repo")
+	}
+	remote, err := treport.AttachRepository(gitRepo, &treport.RepositoryConfig{Repo: "https://github.com/goccy/treport.git"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var scanned []string
+	if err := remote.HeadOnly(context.Background(), func(scanctx *treport.ScanContext) error {
+		scanned = append(scanned, scanctx.Commit.Message)
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(scanned) != 1 || scanned[0] != "first" {
+		t.Fatalf("unexpected commits %v", scanned)
+	}
+}
+
+func TestSyncRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origin := filepath.Join(dir, "origin")
+	gitRepo, err := git.PlainInit(origin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(name string) plumbing.Hash {
+		if err := ioutil.WriteFile(filepath.Join(origin, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+		sig := &object.Signature{Name: "treport", Email: "treport@example.com", When: time.Now()}
+		hash, err := wt.Commit(name, &git.CommitOptions{Author: sig, Committer: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	first := commit("first")
+	repo, err := treport.NewRepository(context.Background(), filepath.Join(dir, "repo"), &treport.RepositoryConfig{Repo: "file://" + origin})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	second := commit("second")
+	if err := gitRepo.Storer.SetReference(plumbing.NewHashReference("refs/pull/1/head", second)); err != nil {
+		t.Fatal(err)
+	}
+	// the refs fetched by the old versions which mapped all refs of the remote to refs/heads/*.
+	for _, name := range []plumbing.ReferenceName{"refs/heads/HEAD", "refs/heads/heads/master", "refs/heads/pull/1/head"} {
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(name, first)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	branch, err := repo.BaseBranch()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := repo.SyncRefs(context.Background(), branch.Merge); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, name := range []plumbing.ReferenceName{"refs/remotes/origin/master", "refs/remotes/origin/pull/1/head"} {
+		if ref, err := repo.Reference(name, true); err != nil || ref.Hash() != second {
+			t.Fatalf("expected %s to be fetched: %v", name, err)
+		}
+	}
+	for _, name := range []plumbing.ReferenceName{"refs/heads/HEAD", "refs/heads/heads/master", "refs/heads/pull/1/head"} {
+		if _, err := repo.Reference(name, true); err != plumbing.ErrReferenceNotFound {
+			t.Fatalf("expected legacy ref %s to be removed: %v", name, err)
+		}
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != second {
+		t.Fatalf("expected HEAD to be %s but got %s", second, head.Hash())
+	}
+	repoWt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoWt.Filesystem.Stat("second"); !os.IsNotExist(err) {
+		t.Fatalf("expected worktree not to be checked out: %v", err)
+	}
+	// the worktree is checked out by Sync for the plugins which need it.
+	if err := repo.Sync(context.Background(), branch.Merge); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := repoWt.Filesystem.Stat("second"); err != nil {
+		t.Fatalf("expected worktree to be checked out: %v", err)
+	}
+}
+
+func TestBaseBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origin := filepath.Join(dir, "origin")
+	gitRepo, err := git.PlainInit(origin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(origin, "file"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("file"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "treport", Email: "treport@example.com", When: time.Now()}
+	hash, err := wt.Commit("file", &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := treport.NewRepository(context.Background(), filepath.Join(dir, "repo"), &treport.RepositoryConfig{Repo: "file://" + origin})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// HEAD of the remote is moved to develop, and the clone has no branch section.
+	if err := gitRepo.Storer.SetReference(plumbing.NewHashReference("refs/heads/develop", hash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitRepo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/develop")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Branches = map[string]*config.Branch{}
+	if err := repo.Storer.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Fetch(context.Background()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	branch, err := repo.BaseBranch()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if branch.Merge != "refs/heads/develop" {
+		t.Fatalf("expected base branch to be develop but got %s", branch.Merge)
+	}
+	if err := repo.SyncRefs(context.Background(), branch.Merge); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestMergeInfo(t *testing.T) {
+	dir := t.TempDir()
+	gitRepo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(name string, parents ...plumbing.Hash) plumbing.Hash {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+		sig := &object.Signature{Name: "treport", Email: "treport@example.com", When: time.Now()}
+		hash, err := wt.Commit(name, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	base := commit("base")
+	feature := commit("feature", base)
+	mainline := commit("mainline", base)
+	merge := commit("merge", mainline, feature)
+	repo, err := treport.NewRepository(context.Background(), dir, &treport.RepositoryConfig{Path: dir})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := repo.Commit(context.Background(), merge, func(scanctx *treport.ScanContext) error {
+		expected := treport.MergeInfo{Mainline: mainline.String(), Head: feature.String(), MergeBase: base.String()}
+		if scanctx.Merge == nil || *scanctx.Merge != expected {
+			t.Fatalf("expected %+v but got %+v", expected, scanctx.Merge)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := repo.Commit(context.Background(), feature, func(scanctx *treport.ScanContext) error {
+		if scanctx.Merge != nil {
+			t.Fatalf("unexpected merge info of %s", scanctx.Commit.Message)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestMetadataOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitRepo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	trees := map[string]string{}
+	for i, msg := range []string{"first", "second", "third"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, msg), []byte(msg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(msg); err != nil {
+			t.Fatal(err)
+		}
+		sig := &object.Signature{Name: "treport", Email: "treport@example.com", When: time.Unix(int64(i), 0)}
+		hash, err := wt.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit, err := gitRepo.CommitObject(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trees[msg] = commit.TreeHash.String()
+	}
+	repo, err := treport.NewRepository(context.Background(), dir, &treport.RepositoryConfig{Path: dir})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, newestFirst := range []bool{false, true} {
+		var scanned []string
+		if err := repo.AllCommits(context.Background(), &treport.WalkOptions{MetadataOnly: true, NewestFirst: newestFirst}, func(scanctx *treport.ScanContext) error {
+			if len(scanctx.Changes) != 0 || len(scanctx.Snapshot.Entries) != 0 {
+				t.Fatalf("expected no files of %s", scanctx.Commit.Message)
+			}
+			if scanctx.Snapshot.Hash != trees[scanctx.Commit.Message] {
+				t.Fatalf("expected tree %s of %s but got %s", trees[scanctx.Commit.Message], scanctx.Commit.Message, scanctx.Snapshot.Hash)
+			}
+			scanned = append(scanned, scanctx.Commit.Message)
+			return nil
+		}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		// the root commit isn't passed without IncludeRoot.
+		expected := "second,third"
+		if newestFirst {
+			expected = "third,second"
+		}
+		if got := strings.Join(scanned, ","); got != expected {
+			t.Fatalf("expected %s but got %s", expected, got)
+		}
+	}
+}
+
+func TestSinglePullRequest(t *testing.T) {
+	dir := t.TempDir()
+	gitRepo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(name string, parents ...plumbing.Hash) plumbing.Hash {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+		sig := &object.Signature{Name: "treport", Email: "treport@example.com", When: time.Now()}
+		hash, err := wt.Commit(name, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	base := commit("base")
+	feature := commit("feature", base)
+	mainline := commit("mainline", base)
+	merge := commit("merge", mainline, feature)
+	// the head of the pull request fetched from the remote.
+	if err := gitRepo.Storer.SetReference(plumbing.NewHashReference("refs/remotes/origin/pull/1/head", feature)); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := treport.NewRepository(context.Background(), dir, &treport.RepositoryConfig{Path: dir})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	walk := func(fn func(context.Context, *treport.WalkOptions, func(*treport.ScanContext) error) error, diffMode treport.DiffMode) (int, []string) {
+		started := -1
+		var scanned []string
+		opt := &treport.WalkOptions{DiffMode: diffMode, Started: func(total int) { started = total }}
+		if err := fn(context.Background(), opt, func(scanctx *treport.ScanContext) error {
+			scanned = append(scanned, scanctx.Commit.Hash)
+			return nil
+		}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		return started, scanned
+	}
+	// the pull request is diffed against its merge base, so it doesn't need the previous one.
+	for _, diffMode := range []treport.DiffMode{treport.DiffPrevious, treport.DiffMergeBase} {
+		started, scanned := walk(repo.PullRequests, diffMode)
+		if started != 1 || len(scanned) != 1 || scanned[0] != merge.String() {
+			t.Fatalf("expected the pull request to be scanned once but got %d %v", started, scanned)
+		}
+	}
+	if started, scanned := walk(repo.AllMergeCommits, treport.DiffMergeBase); started != 1 || len(scanned) != 1 {
+		t.Fatalf("expected the merge commit to be scanned once but got %d %v", started, scanned)
+	}
+	// the only merge commit is the base tree of the previous diff mode.
+	if started, scanned := walk(repo.AllMergeCommits, treport.DiffPrevious); started != -1 || len(scanned) != 0 {
+		t.Fatalf("expected no merge commit to be scanned but got %d %v", started, scanned)
+	}
+}
@@ -0,0 +1,45 @@
+package treport_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestScanContextLimits(t *testing.T) {
+	newScanContext := func() *treport.ScanContext {
+		return &treport.ScanContext{
+			Commit: &treport.Commit{Hash: "3f2a9c1e0d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39"},
+			Snapshot: &treport.Snapshot{Entries: []*treport.File{
+				{Name: "a", Size: 10},
+				{Name: "vendor/large", Size: 1000},
+				{Name: "b", Size: 20},
+			}},
+			Changes: treport.Changes{
+				{To: &treport.File{Name: "vendor/large", Size: 1000}, Action: treport.Added},
+				{To: &treport.File{Name: "b", Size: 20}, Action: treport.Added},
+			},
+		}
+	}
+	scanctx := newScanContext()
+	if err := scanctx.Limit(&treport.LimitsConfig{MaxFileSize: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if !scanctx.Truncated || len(scanctx.Snapshot.Entries) != 2 || len(scanctx.Changes) != 1 || scanctx.Changes[0].Path() != "b" {
+		t.Fatalf("large file isn't excluded: %+v", scanctx)
+	}
+	if scanctx.TotalSnapshotEntries != 3 || scanctx.TotalChanges != 2 {
+		t.Fatalf("unexpected totals %d and %d", scanctx.TotalSnapshotEntries, scanctx.TotalChanges)
+	}
+	err := newScanContext().Limit(&treport.LimitsConfig{MaxChanges: 1, OnExceed: treport.LimitSkip})
+	var pluginErr *treport.PluginError
+	if !errors.As(err, &pluginErr) || pluginErr.Class != treport.PluginErrorSkipCommit {
+		t.Fatalf("expected skip commit error: %v", err)
+	}
+	err = newScanContext().Limit(&treport.LimitsConfig{MaxFileSize: 100, OnExceed: treport.LimitFail})
+	var limitErr *treport.LimitExceededError
+	if !errors.As(err, &limitErr) || errors.As(err, &pluginErr) {
+		t.Fatalf("expected limit exceeded error: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package treport
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+type loggerKey struct{}
+
+// SetLogger sets the logger of the scan. It logs the clone and the sync of the repositories,
+// the scan of each commit, the cache hits and misses and the cleanup of the plugins. Nothing is logged by default.
+func (s *Scanner) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+func (s *Scanner) log() Logger {
+	if s.logger == nil {
+		return hclog.NewNullLogger()
+	}
+	return s.logger
+}
+
+// withLogger returns the context which has the logger for the repositories and the plugins which don't know the scanner.
+func withLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func loggerFrom(ctx context.Context) Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+	return hclog.NewNullLogger()
+}
@@ -0,0 +1,199 @@
+package treport
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+const (
+	mountLockName         = ".treport.lock"
+	mountLockPollInterval = 100 * time.Millisecond
+)
+
+// LockConfig is the lock of the mount paths, so the processes sharing them, like the jobs of CI, don't corrupt
+// the clones and the caches. The scans of the same process share the lock.
+type LockConfig struct {
+	// Wait waits for the lock held by another process instead of failing.
+	Wait bool `yaml:"wait"`
+	// Timeout is the max duration of the wait like 10m. It waits until the scan is canceled by default.
+	Timeout string `yaml:"timeout"`
+	// Disable doesn't lock the mount paths, e.g. the processes are already serialized by the CI.
+	Disable bool `yaml:"disable"`
+}
+
+func (c *LockConfig) timeout() (time.Duration, error) {
+	if c == nil || c.Timeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(c.Timeout)
+}
+
+// MountLockOwner is the process holding the lock. It's written to the lock file, so the other processes report it.
+type MountLockOwner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// mountLock is the lock of the directory held by the process.
+type mountLock struct {
+	file *os.File
+	refs int
+}
+
+var (
+	// mountLocksMu is held while the lock is waited, so the scans of the process wait for the same lock together.
+	mountLocksMu sync.Mutex
+	mountLocks   = map[string]*mountLock{}
+)
+
+// lockPaths returns the directories which have the clones and the caches of the config in the order they are locked.
+func (c *Config) lockPaths() ([]string, error) {
+	dirs := append([]string{c.MountPath()}, c.repoRoots()...)
+	dirs = append(dirs, c.CachePaths()...)
+	exists := map[string]struct{}{}
+	paths := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		path, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get path of %s", dir)
+		}
+		if _, found := exists[path]; found {
+			continue
+		}
+		exists[path] = struct{}{}
+		paths = append(paths, path)
+	}
+	// the processes lock the shared directories in the same order, so they don't deadlock.
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// lockMounts locks all mount paths of the config. onAcquire is called with the path locked first in the process,
+// so the files left by the crashed processes can be removed. It returns the function which releases the locks.
+func (c *Config) lockMounts(ctx context.Context, onAcquire func(path string) error) (func(), error) {
+	if c.Project.Lock != nil && c.Project.Lock.Disable {
+		return func() {}, nil
+	}
+	paths, err := c.lockPaths()
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := c.Project.Lock.timeout()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse lock timeout")
+	}
+	wait := c.Project.Lock != nil && c.Project.Lock.Wait
+	if wait && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	locked := make([]string, 0, len(paths))
+	unlock := func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			releaseMount(locked[i])
+		}
+	}
+	for _, path := range paths {
+		if err := acquireMount(ctx, path, wait, onAcquire); err != nil {
+			unlock()
+			return nil, err
+		}
+		locked = append(locked, path)
+	}
+	return unlock, nil
+}
+
+func acquireMount(ctx context.Context, path string, wait bool, onAcquire func(string) error) error {
+	mountLocksMu.Lock()
+	defer mountLocksMu.Unlock()
+	if lock, exists := mountLocks[path]; exists {
+		lock.refs++
+		return nil
+	}
+	if err := mkdirIfNotExists(path); err != nil {
+		return errors.Wrapf(err, "failed to create directory for lock")
+	}
+	lockPath := filepath.Join(path, mountLockName)
+	for {
+		file, err := tryLockFile(lockPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to lock %s", path)
+		}
+		if file != nil {
+			if err := writeMountLockOwner(file); err != nil {
+				unlockFile(file)
+				return errors.Wrapf(err, "failed to write owner of lock %s", path)
+			}
+			if onAcquire != nil {
+				if err := onAcquire(path); err != nil {
+					unlockFile(file)
+					return err
+				}
+			}
+			mountLocks[path] = &mountLock{file: file, refs: 1}
+			return nil
+		}
+		if !wait {
+			return ErrMountLocked(path, readMountLockOwner(lockPath))
+		}
+		select {
+		case <-time.After(mountLockPollInterval):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrMountLocked(path, readMountLockOwner(lockPath))
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func releaseMount(path string) {
+	mountLocksMu.Lock()
+	defer mountLocksMu.Unlock()
+	lock, exists := mountLocks[path]
+	if !exists {
+		return
+	}
+	lock.refs--
+	if lock.refs > 0 {
+		return
+	}
+	delete(mountLocks, path)
+	unlockFile(lock.file)
+}
+
+func writeMountLockOwner(file *os.File) error {
+	host, _ := os.Hostname()
+	b, err := json.Marshal(&MountLockOwner{PID: os.Getpid(), Host: host, StartedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(b, 0)
+	return err
+}
+
+// readMountLockOwner returns the owner of the lock, or nil if it isn't known.
+func readMountLockOwner(lockPath string) *MountLockOwner {
+	b, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return nil
+	}
+	var owner MountLockOwner
+	if err := json.Unmarshal(b, &owner); err != nil {
+		return nil
+	}
+	return &owner
+}
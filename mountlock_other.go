@@ -0,0 +1,26 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package treport
+
+import (
+	"os"
+)
+
+// tryLockFile creates the lock file exclusively. It returns nil if another process has it.
+// The lock file of the crashed process remains, so it must be removed by hand.
+func tryLockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+func unlockFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
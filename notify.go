@@ -0,0 +1,274 @@
+package treport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+type NotificationEvent string
+
+const (
+	NotifySuccess NotificationEvent = "success"
+	NotifyFailure NotificationEvent = "failure"
+)
+
+const defaultLocale = "en"
+
+// builtinMessages are the default notification templates by locale.
+var builtinMessages = map[string]*MessageTemplates{
+	"en": {
+		Success: &MessageTemplate{
+			Subject: `[treport] pipeline {{ .Pipeline }} succeeded`,
+			Body: `Pipeline {{ .Pipeline }} succeeded in {{ .Elapsed }}.
+{{- range .Report.Repositories }}
+- {{ .Repo }}: {{ len .Commits }} commits
+{{- end }}`,
+		},
+		Failure: &MessageTemplate{
+			Subject: `[treport] pipeline {{ .Pipeline }} failed`,
+			Body: `Pipeline {{ .Pipeline }} failed in {{ .Elapsed }}.
+{{ .Error }}`,
+		},
+	},
+	"ja": {
+		Success: &MessageTemplate{
+			Subject: `[treport] パイプライン {{ .Pipeline }} が成功しました`,
+			Body: `パイプライン {{ .Pipeline }} が成功しました ( {{ .Elapsed }} )。
+{{- range .Report.Repositories }}
+- {{ .Repo }}: {{ len .Commits }} コミット
+{{- end }}`,
+		},
+		Failure: &MessageTemplate{
+			Subject: `[treport] パイプライン {{ .Pipeline }} が失敗しました`,
+			Body: `パイプライン {{ .Pipeline }} が失敗しました ( {{ .Elapsed }} )。
+{{ .Error }}`,
+		},
+	},
+}
+
+// NotificationData is the data passed to the message templates.
+type NotificationData struct {
+	Pipeline   string
+	Desc       string
+	Status     NotificationEvent
+	Error      string
+	RunID      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Elapsed    time.Duration
+	Report     *PipelineReport
+}
+
+// Notification is the rendered message.
+type Notification struct {
+	Pipeline string
+	Status   NotificationEvent
+	Subject  string
+	Body     string
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+func NewNotifier(cfg *NotificationConfig) (Notifier, error) {
+	switch cfg.Type {
+	case SlackNotification:
+		return &slackNotifier{url: cfg.URL()}, nil
+	case WebhookNotification:
+		return &webhookNotifier{url: cfg.URL()}, nil
+	case EmailNotification:
+		if cfg.Email == nil {
+			return nil, fmt.Errorf("email is required for notification %s", cfg.Name)
+		}
+		return &emailNotifier{cfg: cfg.Email}, nil
+	}
+	return nil, fmt.Errorf("unknown notification type %q", cfg.Type)
+}
+
+type slackNotifier struct {
+	url string
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, msg *Notification) error {
+	return postJSON(ctx, n.url, map[string]string{"text": msg.Body})
+}
+
+type webhookNotifier struct {
+	url string
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, msg *Notification) error {
+	return postJSON(ctx, n.url, map[string]string{
+		"pipeline": msg.Pipeline,
+		"status":   string(msg.Status),
+		"subject":  msg.Subject,
+		"body":     msg.Body,
+	})
+}
+
+func postJSON(ctx context.Context, url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode notification")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to send notification")
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("failed to send notification: unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+type emailNotifier struct {
+	cfg *EmailConfig
+}
+
+func (n *emailNotifier) Notify(ctx context.Context, msg *Notification) error {
+	var auth smtp.Auth
+	if user := n.cfg.Auth.User(); user != "" {
+		host, _, err := net.SplitHostPort(n.cfg.Addr)
+		if err != nil {
+			return errors.Wrapf(err, "invalid smtp address %s", n.cfg.Addr)
+		}
+		auth = smtp.PlainAuth("", user, n.cfg.Auth.Password(), host)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	if err := smtp.SendMail(n.cfg.Addr, auth, n.cfg.From, n.cfg.To, b.Bytes()); err != nil {
+		return errors.Wrapf(err, "failed to send email")
+	}
+	return nil
+}
+
+// MessageTemplate returns the template of the status in the locale.
+// The template is looked up from the pipeline overrides, the config and the builtin templates in that order,
+// and falls back to the default locale if the locale has no template.
+func (c *Config) MessageTemplate(pipelineCfg *PipelineConfig, locale string, status NotificationEvent) *MessageTemplate {
+	sources := []map[string]*MessageTemplates{}
+	if pipelineCfg.Notification != nil {
+		sources = append(sources, pipelineCfg.Notification.Messages)
+	}
+	sources = append(sources, c.Messages, builtinMessages)
+	tmpl := &MessageTemplate{}
+	for _, l := range []string{locale, defaultLocale} {
+		for _, src := range sources {
+			msgs, exists := src[l]
+			if !exists || msgs == nil {
+				continue
+			}
+			t := msgs.Success
+			if status == NotifyFailure {
+				t = msgs.Failure
+			}
+			if t == nil {
+				continue
+			}
+			if tmpl.Subject == "" {
+				tmpl.Subject = t.Subject
+			}
+			if tmpl.Body == "" {
+				tmpl.Body = t.Body
+			}
+		}
+	}
+	return tmpl
+}
+
+// Render renders the template by data.
+func (t *MessageTemplate) Render(data *NotificationData) (string, string, error) {
+	subject, err := renderMessage("subject", t.Subject, data)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := renderMessage("body", t.Body, data)
+	if err != nil {
+		return "", "", err
+	}
+	return subject, body, nil
+}
+
+func renderMessage(name, text string, data *NotificationData) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse %s template", name)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", errors.Wrapf(err, "failed to execute %s template", name)
+	}
+	return b.String(), nil
+}
+
+func (c *NotificationConfig) notifies(status NotificationEvent) bool {
+	if len(c.On) == 0 {
+		return true
+	}
+	for _, on := range c.On {
+		if on == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *NotificationConfig) locale(pipelineCfg *PipelineConfig) string {
+	if pipelineCfg.Notification != nil && pipelineCfg.Notification.Locale != "" {
+		return pipelineCfg.Notification.Locale
+	}
+	if c.Locale != "" {
+		return c.Locale
+	}
+	return defaultLocale
+}
+
+// notify sends the result of the pipeline to all notifications.
+func (c *Config) notify(ctx context.Context, data *NotificationData, pipelineCfg *PipelineConfig) error {
+	for _, notificationCfg := range c.Notifications {
+		if !notificationCfg.notifies(data.Status) {
+			continue
+		}
+		notifier, err := NewNotifier(notificationCfg)
+		if err != nil {
+			return errors.Stack(err)
+		}
+		locale := notificationCfg.locale(pipelineCfg)
+		subject, body, err := c.MessageTemplate(pipelineCfg, locale, data.Status).Render(data)
+		if err != nil {
+			return errors.Wrapf(err, "failed to render message of notification %s", notificationCfg.Name)
+		}
+		if err := notifier.Notify(ctx, &Notification{
+			Pipeline: data.Pipeline,
+			Status:   data.Status,
+			Subject:  subject,
+			Body:     body,
+		}); err != nil {
+			return errors.Wrapf(err, "failed to notify %s", notificationCfg.Name)
+		}
+	}
+	return nil
+}
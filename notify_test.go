@@ -0,0 +1,77 @@
+package treport_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goccy/treport"
+)
+
+func TestMessageTemplate(t *testing.T) {
+	cfg := &treport.Config{
+		Messages: map[string]*treport.MessageTemplates{
+			"fr": {
+				Failure: &treport.MessageTemplate{Body: "Échec de {{ .Pipeline }}"},
+			},
+		},
+	}
+	pipelineCfg := &treport.PipelineConfig{
+		Name: "size",
+		Notification: &treport.PipelineNotificationConfig{
+			Messages: map[string]*treport.MessageTemplates{
+				"en": {
+					Success: &treport.MessageTemplate{Body: "{{ .Pipeline }} is done"},
+				},
+			},
+		},
+	}
+	data := &treport.NotificationData{
+		Pipeline: "size",
+		Elapsed:  time.Second,
+		Report:   &treport.PipelineReport{},
+	}
+	tests := []struct {
+		name    string
+		locale  string
+		status  treport.NotificationEvent
+		subject string
+		body    string
+	}{
+		{
+			name:    "pipeline override",
+			locale:  "en",
+			status:  treport.NotifySuccess,
+			subject: "[treport] pipeline size succeeded",
+			body:    "size is done",
+		},
+		{
+			name:    "builtin locale",
+			locale:  "ja",
+			status:  treport.NotifyFailure,
+			subject: "[treport] パイプライン size が失敗しました",
+			body:    "パイプライン size が失敗しました ( 1s )。\n",
+		},
+		{
+			name:    "config locale falls back to default subject",
+			locale:  "fr",
+			status:  treport.NotifyFailure,
+			subject: "[treport] pipeline size failed",
+			body:    "Échec de size",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data.Status = test.status
+			subject, body, err := cfg.MessageTemplate(pipelineCfg, test.locale, test.status).Render(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != test.subject {
+				t.Fatalf("unexpected subject %q", subject)
+			}
+			if body != test.body {
+				t.Fatalf("unexpected body %q", body)
+			}
+		})
+	}
+}
@@ -3,28 +3,50 @@ package treport
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
-	"strings"
 
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/goccy/treport/internal/errors"
 )
 
-func CreatePipelines(ctx context.Context, cfg *Config) ([]*Pipeline, error) {
-	pluginMap := map[string]*Plugin{}
-	for _, plg := range BuiltinPlugins {
-		pluginMap[plg.Name] = plg
-	}
-	for _, repoCfg := range cfg.Plugin.Scanner {
-		if _, exists := pluginMap[repoCfg.Name]; exists {
-			continue
+func CreatePipelines(ctx context.Context, cfg *Config) (_ []*Pipeline, e error) {
+	var (
+		setupPlugins   []*Plugin
+		openedDiffs    []*diffCache
+		openedBlobs    []*blobCache
+		acquiredClones []string
+	)
+	defer func() {
+		if e != nil {
+			// stop the plugins already set up, so the failure doesn't leave plugin processes and cache locks behind.
+			for _, plg := range setupPlugins {
+				plg.Cleanup()
+			}
+			for _, diffs := range openedDiffs {
+				diffs.Close()
+			}
+			for _, blobs := range openedBlobs {
+				blobs.Close()
+			}
+			for _, path := range acquiredClones {
+				releaseClone(path)
+			}
 		}
-		repo, err := NewRepository(ctx, cfg.RepoPath(), repoCfg)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to create repository with repoCfg: %+v", repoCfg)
+	}()
+	pluginMap := map[string]func() *Plugin{}
+	grpcCfg := cfg.Plugin.grpcConfig()
+	keepaliveCfg := cfg.Plugin.keepaliveConfig()
+	startupCfg := cfg.Plugin.startupConfig()
+	// the plugins of the run share the processes, which are got from the pool of daemon mode if it's set.
+	processes := newPluginProcesses(pluginPoolFrom(ctx))
+	for _, pluginName := range BuiltinPluginNames {
+		pluginName := pluginName
+		pluginMap[pluginName] = func() *Plugin {
+			return newBuiltinPlugin(ctx, cfg, pluginName, grpcCfg, keepaliveCfg, startupCfg)
 		}
-		pluginMap[repoCfg.Name] = &Plugin{Repo: repo}
 	}
-	for _, repoCfg := range cfg.Plugin.Storer {
+	for _, repoCfg := range cfg.Plugin.repositories() {
 		if _, exists := pluginMap[repoCfg.Name]; exists {
 			continue
 		}
@@ -32,43 +54,128 @@ func CreatePipelines(ctx context.Context, cfg *Config) ([]*Pipeline, error) {
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to create repository with repoCfg: %+v", repoCfg)
 		}
-		pluginMap[repoCfg.Name] = &Plugin{Repo: repo}
+		pluginMap[repoCfg.Name] = func() *Plugin {
+			return newRepositoryPlugin(ctx, cfg, repo, grpcCfg, keepaliveCfg, startupCfg)
+		}
 	}
 
 	pluginVerDB, err := cfg.PluginVersionDB()
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get connection to plugin version db")
 	}
+	defer pluginVerDB.Close()
 
+	pool := newRepositoryPool()
 	pipelines := make([]*Pipeline, 0, len(cfg.Pipelines))
 	for _, pipelineCfg := range cfg.Pipelines {
+		if !pipelineCfg.IsEnabled() {
+			continue
+		}
+		if !pipelineCfg.Strategy.Valid() {
+			return nil, ErrUnknownStrategy(pipelineCfg.Name, pipelineCfg.Strategy)
+		}
 		pipeline := &Pipeline{Config: pipelineCfg}
+		stepDeps, err := pipelineCfg.StepDependencies()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve step dependencies of pipeline %s", pipelineCfg.Name)
+		}
 		for _, repoCfg := range pipelineCfg.Repository {
-			repo, err := NewRepository(ctx, cfg.RepoPath(), repoCfg)
+			repoPath := cfg.repoPathOf(pipelineCfg, repoCfg)
+			repo, err := pool.get(ctx, repoPath, repoCfg)
 			if err != nil {
+				var emptyErr *EmptyRepositoryError
+				if errors.As(err, &emptyErr) {
+					continue
+				}
 				return nil, err
 			}
-			pipelineRepo := &PipelineRepository{Repository: repo}
-			for idx, stepCfg := range pipelineCfg.Steps {
-				step := &Step{Idx: idx}
-				for _, pluginExecCfg := range stepCfg.Plugins {
-					plg, exists := pluginMap[pluginExecCfg.Name]
-					if !exists {
-						return nil, fmt.Errorf("failed to find plugin %s", pluginExecCfg.Name)
-					}
-					if err := plg.Setup(pluginExecCfg.Args); err != nil {
-						return nil, errors.Wrapf(err, "failed to setup plugin")
+			repos := []*Repository{repo}
+			if len(repoCfg.Branches) > 0 {
+				branchRepos, err := repo.branchRepositories(ctx, repoPath)
+				if err != nil {
+					return nil, err
+				}
+				repos = branchRepos
+			}
+			for _, repo := range repos {
+				pipelineRepo := &PipelineRepository{Repository: repo}
+				if repo.path != "" && !repoCfg.IsLocal() {
+					acquireClone(repo.path)
+					acquiredClones = append(acquiredClones, repo.path)
+					pipelineRepo.clonePath = repo.path
+				}
+				for idx, stepCfg := range pipelineCfg.Steps {
+					step := &Step{Idx: idx}
+					for _, pluginExecCfg := range stepCfg.Plugins {
+						newPlugin, exists := pluginMap[pluginExecCfg.Name]
+						if !exists {
+							return nil, fmt.Errorf("failed to find plugin %s", pluginExecCfg.Name)
+						}
+						plg := newPlugin()
+						plg.SchemaPolicy = pluginExecCfg.SchemaPolicy
+						plg.OnError = pluginExecCfg.OnError
+						plg.Retry = pluginExecCfg.Retry
+						plg.Limits = pipelineCfg.Limits
+						plg.Ignore = pipelineCfg.Ignore
+						plg.SkipEmptyChanges = pipelineCfg.SkipEmptyChanges
+						plg.Env = pluginExecCfg.Env
+						plg.Sandbox = pluginExecCfg.Sandbox
+						plg.Checkout = pluginExecCfg.Checkout
+						plg.Dirs = pluginExecCfg.Dirs
+						plg.Quarantine = pluginExecCfg.Quarantine
+						plg.Stage = stepCfg.Stage
+						plg.cacheCfg = cfg.Cache
+						plg.processes = processes
+						if pipelineCfg.CacheFrom != "" {
+							path, err := warmCachePath(cfg, pipelineCfg, repoCfg, repo.scanBranch, pluginExecCfg)
+							if err != nil {
+								return nil, errors.Wrapf(err, "failed to find cache of pipeline %s", pipelineCfg.CacheFrom)
+							}
+							plg.warmPath = path
+						}
+						if err := ctx.Err(); err != nil {
+							return nil, err
+						}
+						if err := plg.Setup(pluginExecCfg.Args); err != nil {
+							return nil, errors.Wrapf(err, "failed to setup plugin")
+						}
+						setupPlugins = append(setupPlugins, plg)
+						step.Plugins = append(step.Plugins, plg)
 					}
-					step.Plugins = append(step.Plugins, plg)
+					pipelineRepo.Steps = append(pipelineRepo.Steps, step)
 				}
-				pipelineRepo.Steps = append(pipelineRepo.Steps, step)
+				pipelineRepo.resolveDependencies(stepDeps)
+				pipeline.Repos = append(pipeline.Repos, pipelineRepo)
 			}
-			pipeline.Repos = append(pipeline.Repos, pipelineRepo)
 		}
-		pipeline.ID = createPipelineID(pipelineCfg.Strategy, pipeline.Repos[0].Steps)
-		pipeline.CachePath = filepath.Join(cfg.CachePath(), string(pipeline.ID))
+		if len(pipeline.Repos) == 0 {
+			pipelines = append(pipelines, pipeline)
+			continue
+		}
+		pipeline.ID = createPipelineID(pipelineCfg, pipeline.Repos[0].Steps)
+		pipeline.CachePath = filepath.Join(cfg.cachePathOf(pipelineCfg, nil), string(pipeline.ID))
 		for _, repo := range pipeline.Repos {
-			repo.CachePath = filepath.Join(pipeline.CachePath, repo.ID)
+			repo.CachePath = filepath.Join(cfg.cachePathOf(pipelineCfg, repo.cfg), string(pipeline.ID), repo.ID)
+			if pipelineCfg.CacheDiffs {
+				path, err := cfg.diffCachePathOf(pipelineCfg, repo.cfg)
+				if err != nil {
+					return nil, err
+				}
+				diffs, err := openDiffCache(path, cfg.Cache)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to open diff cache of %s", repo.cfg.Repo)
+				}
+				repo.diffs = diffs
+				openedDiffs = append(openedDiffs, diffs)
+			}
+			if blobCfg := cfg.Cache.blobCache(); blobCfg != nil {
+				blobs, err := openBlobCache(cfg.BlobCachePath(), blobCfg)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to open blob cache")
+				}
+				repo.blobs = blobs
+				openedBlobs = append(openedBlobs, blobs)
+			}
 			for _, step := range repo.Steps {
 				step.CachePath = filepath.Join(repo.CachePath, fmt.Sprintf("%03d", step.Idx))
 				for _, plg := range step.Plugins {
@@ -76,6 +183,9 @@ func CreatePipelines(ctx context.Context, cfg *Config) ([]*Pipeline, error) {
 				}
 			}
 		}
+		if err := migrateLegacyCache(cfg, pipeline); err != nil {
+			return nil, errors.Wrapf(err, "failed to migrate cache created by legacy id scheme")
+		}
 		needToDeleteStepCache := false
 		for _, repo := range pipeline.Repos {
 			for _, step := range repo.Steps {
@@ -105,13 +215,181 @@ func CreatePipelines(ctx context.Context, cfg *Config) ([]*Pipeline, error) {
 		}
 		pipelines = append(pipelines, pipeline)
 	}
+	linkWarmCaches(pipelines)
+	if err := enforceDiskQuota(ctx, cfg); err != nil {
+		return nil, errors.Wrapf(err, "failed to enforce disk quota")
+	}
 	return pipelines, nil
 }
 
-func createPipelineID(strategy Strategy, steps []*Step) PipelineID {
-	pluginIDs := []string{string(strategy)}
+// linkWarmCaches makes the plugins read the cache of cacheFrom by the plugins of the same run which open it.
+func linkWarmCaches(pipelines []*Pipeline) {
+	plugins := map[string]*Plugin{}
+	for _, pipeline := range pipelines {
+		for _, repo := range pipeline.Repos {
+			for _, step := range repo.Steps {
+				for _, plg := range step.Plugins {
+					plugins[plg.CachePath] = plg
+				}
+			}
+		}
+	}
+	for _, plg := range plugins {
+		if plg.warmPath == "" {
+			continue
+		}
+		if warm, exists := plugins[plg.warmPath]; exists {
+			plg.warm = warm
+		}
+	}
+}
+
+func createPipelineID(pipelineCfg *PipelineConfig, steps []*Step) PipelineID {
+	stepPluginIDs := make([][]string, 0, len(steps))
 	for _, step := range steps {
-		pluginIDs = append(pluginIDs, step.PluginIDs()...)
+		stepPluginIDs = append(stepPluginIDs, step.PluginIDs())
+	}
+	return createPipelineIDByPluginIDs(DefaultIDScheme, pipelineCfg, stepPluginIDs)
+}
+
+// createPipelineIDByPluginIDs returns the ID of the pipeline. The name and the args of the plugins are included,
+// so the pipelines of the same plugins don't share the cache, and the results of the changed args aren't reused.
+func createPipelineIDByPluginIDs(scheme IDScheme, pipelineCfg *PipelineConfig, stepPluginIDs [][]string) PipelineID {
+	parts := unnamedPipelineIDParts(pipelineCfg, stepPluginIDs)
+	parts = append(parts, "name:"+pipelineCfg.Name)
+	parts = append(parts, pipelineCfg.argsIDs()...)
+	return PipelineID(scheme.ID(parts...))
+}
+
+// createUnnamedPipelineID returns the ID of the pipeline created by older versions, which has only the strategy and the plugins.
+func createUnnamedPipelineID(scheme IDScheme, pipelineCfg *PipelineConfig, stepPluginIDs [][]string) PipelineID {
+	return PipelineID(scheme.ID(unnamedPipelineIDParts(pipelineCfg, stepPluginIDs)...))
+}
+
+func unnamedPipelineIDParts(pipelineCfg *PipelineConfig, stepPluginIDs [][]string) []string {
+	parts := []string{string(pipelineCfg.Strategy)}
+	for _, ids := range stepPluginIDs {
+		parts = append(parts, ids...)
+	}
+	return append(parts, pipelineCfg.dependencyIDs()...)
+}
+
+// migrateLegacyCache renames cache directories named by the older IDs to the current ones.
+// If the pipelines of the same plugins shared the unnamed cache, the first scanned one takes it over, and the others scan again.
+func migrateLegacyCache(cfg *Config, pipeline *Pipeline) error {
+	steps := pipeline.Repos[0].Steps
+	stepPluginIDs := make([][]string, 0, len(steps))
+	for _, step := range steps {
+		stepPluginIDs = append(stepPluginIDs, step.PluginIDs())
+	}
+	unnamedPipelineID := createUnnamedPipelineID(DefaultIDScheme, pipeline.Config, stepPluginIDs)
+	for _, repo := range pipeline.Repos {
+		unnamedPath := filepath.Join(cfg.cachePathOf(pipeline.Config, repo.cfg), string(unnamedPipelineID))
+		if err := migrateLegacyPath(filepath.Join(unnamedPath, repo.ID), repo.CachePath); err != nil {
+			return err
+		}
+		// the directory is removed only if all repositories are migrated.
+		_ = os.Remove(unnamedPath)
+	}
+	legacyStepPluginIDs := make([][]string, 0, len(steps))
+	for _, step := range steps {
+		legacyStepPluginIDs = append(legacyStepPluginIDs, step.legacyPluginIDs())
+	}
+	legacyPipelineID := createUnnamedPipelineID(LegacyIDScheme, pipeline.Config, legacyStepPluginIDs)
+	if err := migrateLegacyPath(filepath.Join(cfg.cachePathOf(pipeline.Config, nil), string(legacyPipelineID)), pipeline.CachePath); err != nil {
+		return err
+	}
+	for _, repo := range pipeline.Repos {
+		if err := migrateLegacyPath(filepath.Join(filepath.Dir(repo.CachePath), repo.legacyID), repo.CachePath); err != nil {
+			return err
+		}
+		for _, step := range repo.Steps {
+			for _, plg := range step.Plugins {
+				if err := migrateLegacyPath(filepath.Join(step.CachePath, plg.Repo.legacyID), plg.CachePath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// pluginIDByName returns the ID of the plugin without setting up it.
+func pluginIDByName(scheme IDScheme, cfg *Config, name string) (string, error) {
+	if IsBuiltinPlugin(name) {
+		return scheme.ID(name), nil
+	}
+	if cfg.Plugin != nil {
+		for _, repoCfgs := range [][]*RepositoryConfig{cfg.Plugin.Scanner, cfg.Plugin.Storer} {
+			for _, repoCfg := range repoCfgs {
+				if repoCfg.Name == name {
+					return repositoryID(scheme, cfg.RepoPath(), repoCfg, "")
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("failed to find plugin %s", name)
+}
+
+// ScanCommit scans only the commit of the repository by the plugins of the pipeline without walking the history.
+// The commit is diffed against its first parent. If it isn't in the clone, the remote is fetched once.
+// repo is the url of the repository, or the path of the local one. The plugins of the aggregate stage aren't run.
+func (p *Pipeline) ScanCommit(ctx context.Context, repo, hash string) (*CommitReport, error) {
+	target := p.findRepository(repo)
+	if target == nil {
+		return nil, ErrRepositoryNotFound(p.Config.Name, repo)
+	}
+	commitHash := plumbing.NewHash(hash)
+	if _, err := target.CommitObject(commitHash); err != nil {
+		if err != plumbing.ErrObjectNotFound || target.cfg.IsLocal() {
+			return nil, errors.Wrapf(err, "failed to get commit %s", hash)
+		}
+		if err := target.syncRemoteBranches(ctx); err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch commit %s", hash)
+		}
+	}
+	var report *CommitReport
+	if err := target.Commit(ctx, commitHash, func(scanctx *ScanContext) error {
+		scanctx.Branch = target.scanBranch
+		scanctx.Repository = target.Repository
+		report = &CommitReport{
+			Commit:  scanctx.Commit,
+			Results: map[string]*PluginResult{},
+		}
+		// the plugins read the results of the previous steps from the cache, so the steps are run in order.
+		for _, step := range target.Steps {
+			for _, plg := range step.Plugins {
+				if plg.Stage == StageAggregate {
+					continue
+				}
+				if err := plg.Scan(ctx, scanctx); err != nil {
+					return errors.Wrapf(err, "failed to scan by %s", plg.Name)
+				}
+				if res, exists := scanctx.pluginResponse(plg.Name); exists {
+					report.Results[plg.Name] = newPluginResult(plg.Name, res)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Stack(err)
+	}
+	return report, nil
+}
+
+// findRepository returns the repository of the pipeline. The base branch is preferred to the other branches.
+func (p *Pipeline) findRepository(repo string) *PipelineRepository {
+	var found *PipelineRepository
+	for _, pipelineRepo := range p.Repos {
+		if normalizeRepoURL(pipelineRepo.cfg.identity()) != normalizeRepoURL(repo) {
+			continue
+		}
+		if pipelineRepo.scanBranch == "" {
+			return pipelineRepo
+		}
+		if found == nil {
+			found = pipelineRepo
+		}
 	}
-	return PipelineID(makeHashID(strings.Join(pluginIDs, ":")))
+	return found
 }
@@ -0,0 +1,240 @@
+package treport
+
+import (
+	"context"
+	"os"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+type RepositoryAction string
+
+const (
+	// RepositoryClone means the repository isn't cloned yet.
+	RepositoryClone RepositoryAction = "clone"
+	// RepositorySync means the cloned repository is synced with the remote.
+	RepositorySync RepositoryAction = "sync"
+)
+
+type CacheState string
+
+const (
+	// CacheEmpty means the plugin has no cache.
+	CacheEmpty CacheState = "empty"
+	// CacheStale means the plugin is updated, so the cache is deleted before scanning.
+	CacheStale CacheState = "stale"
+	// CacheAvailable means the results of Cached commits are restored from the cache.
+	CacheAvailable CacheState = "available"
+)
+
+// Plan is what Scan would do with the current config.
+type Plan struct {
+	Pipelines []*PipelinePlan
+}
+
+type PipelinePlan struct {
+	Name     string
+	Strategy Strategy
+	Repos    []*RepositoryPlan
+}
+
+type RepositoryPlan struct {
+	Repo string
+	// Branch is the branch scanned as a separate stream by branches of the config. It's empty for the base branch.
+	Branch string
+	Path   string
+	Action RepositoryAction
+	// Commits are the commits visited by the strategy ordered from oldest to newest.
+	// They are listed from the local clone without fetching, so the commits pushed after the last sync aren't included,
+	// and it's empty if the repository isn't cloned yet.
+	Commits []string
+	Plugins []*PluginPlan
+}
+
+type PluginPlan struct {
+	Name          string
+	Step          int
+	Cache         CacheState
+	CachePath     string
+	HighWaterMark string
+	// Cached is the number of Commits restored from the cache, and Pending is the number of Commits scanned by the plugin.
+	Cached  int
+	Pending int
+}
+
+// Plan resolves the config and reports which repositories, commits and plugins Scan would process.
+// It doesn't clone or sync repositories, run plugins and modify caches.
+func (s *Scanner) Plan(ctx context.Context) (*Plan, error) {
+	verDB, err := s.cfg.readOnlyPluginVersionDB()
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	if verDB != nil {
+		defer verDB.Close()
+	}
+	plan := &Plan{}
+	for _, pipelineCfg := range s.cfg.Pipelines {
+		if !pipelineCfg.IsEnabled() {
+			continue
+		}
+		pipelinePlan, err := s.planPipeline(ctx, pipelineCfg, verDB)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to plan pipeline %s", pipelineCfg.Name)
+		}
+		plan.Pipelines = append(plan.Pipelines, pipelinePlan)
+	}
+	return plan, nil
+}
+
+func (s *Scanner) planPipeline(ctx context.Context, pipelineCfg *PipelineConfig, verDB *PluginVersionDB) (*PipelinePlan, error) {
+	if !pipelineCfg.Strategy.Valid() {
+		return nil, ErrUnknownStrategy(pipelineCfg.Name, pipelineCfg.Strategy)
+	}
+	pipelinePlan := &PipelinePlan{Name: pipelineCfg.Name, Strategy: pipelineCfg.Strategy}
+	for _, repoCfg := range pipelineCfg.Repository {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		repoPath, err := repositoryPath(s.cfg.repoPathOf(pipelineCfg, repoCfg), repoCfg)
+		if err != nil {
+			return nil, err
+		}
+		// the branches aren't fetched, so the branches created after the last sync aren't planned.
+		branches, err := localBranches(repoPath, repoCfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find branches of %s", repoCfg.Repo)
+		}
+		if len(branches) == 0 {
+			branches = []string{""}
+		}
+		for _, branch := range branches {
+			repoPlan, err := s.planRepository(ctx, pipelineCfg, repoCfg, repoPath, branch, verDB)
+			if err != nil {
+				return nil, err
+			}
+			pipelinePlan.Repos = append(pipelinePlan.Repos, repoPlan)
+		}
+	}
+	return pipelinePlan, nil
+}
+
+func (s *Scanner) planRepository(ctx context.Context, pipelineCfg *PipelineConfig, repoCfg *RepositoryConfig, repoPath, branch string, verDB *PluginVersionDB) (*RepositoryPlan, error) {
+	repoPlan := &RepositoryPlan{Repo: repoCfg.Repo, Branch: branch, Path: repoPath, Action: RepositoryClone}
+	if existsPath(repoPath) {
+		repoPlan.Action = RepositorySync
+		commits, err := planCommits(ctx, repoPath, repoCfg, branch, pipelineCfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list commits of %s", repoCfg.Repo)
+		}
+		repoPlan.Commits = commits
+	}
+	needToDeleteStepCache := false
+	for idx, stepCfg := range pipelineCfg.Steps {
+		updatedStep := false
+		for _, pluginExecCfg := range stepCfg.Plugins {
+			cachePath, err := resultPath(DefaultIDScheme, s.cfg, pipelineCfg, repoCfg, branch, idx, pluginExecCfg.Name)
+			if err != nil {
+				return nil, errors.Stack(err)
+			}
+			plg := &Plugin{Name: pluginExecCfg.Name, CachePath: cachePath, Stage: stepCfg.Stage}
+			updated := needToDeleteStepCache
+			if !updated {
+				isUpdated, err := s.cfg.isPluginUpdated(verDB, pluginExecCfg.Name)
+				if err != nil {
+					return nil, errors.Stack(err)
+				}
+				updated = isUpdated
+			}
+			pluginPlan, err := planPlugin(plg, idx, updated, repoPlan.Commits)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to plan plugin %s", plg.Name)
+			}
+			updatedStep = updatedStep || updated
+			repoPlan.Plugins = append(repoPlan.Plugins, pluginPlan)
+		}
+		needToDeleteStepCache = needToDeleteStepCache || updatedStep
+	}
+	return repoPlan, nil
+}
+
+func planCommits(ctx context.Context, repoPath string, repoCfg *RepositoryConfig, branch string, pipelineCfg *PipelineConfig) ([]string, error) {
+	gitRepo, err := openRepo(repoPath)
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	repo := &Repository{Repository: gitRepo, cfg: repoCfg, scanBranch: branch}
+	if _, err := repo.Head(); err != nil {
+		// empty repository is skipped by Scan.
+		return nil, nil
+	}
+	commits, err := repo.visitedCommits(ctx, pipelineCfg.Strategy, pipelineCfg.WalkOptions())
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	hashes := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		hashes = append(hashes, commit.Hash.String())
+	}
+	return hashes, nil
+}
+
+func planPlugin(plg *Plugin, step int, updated bool, commits []string) (*PluginPlan, error) {
+	mark, err := plg.HighWaterMark()
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	plan := &PluginPlan{
+		Name:          plg.Name,
+		Step:          step,
+		Cache:         CacheEmpty,
+		CachePath:     plg.CachePath,
+		HighWaterMark: mark,
+		Pending:       len(commits),
+	}
+	if plg.Stage == StageAggregate {
+		// the plugin runs once for the last commit, and the result isn't cached.
+		if plan.Pending > 1 {
+			plan.Pending = 1
+		}
+		return plan, nil
+	}
+	if !existsPath(plg.CachePath) {
+		return plan, nil
+	}
+	if updated {
+		plan.Cache = CacheStale
+		return plan, nil
+	}
+	cached, err := plg.cachedCommits(commits)
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	plan.Cache = CacheAvailable
+	plan.Cached = cached
+	plan.Pending = len(commits) - cached
+	return plan, nil
+}
+
+// isPluginUpdated reports whether the plugin binary is updated since the last scan.
+// The plugin is built by the scan if it isn't installed, and it's updated if it has been scanned by the binary built before.
+func (c *Config) isPluginUpdated(verDB *PluginVersionDB, name string) (bool, error) {
+	binary := c.pluginBinaryPath(name)
+	if !existsPath(binary) {
+		if verDB == nil {
+			return false, nil
+		}
+		ver, err := verDB.readVersion(name)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to read version of plugin %s", name)
+		}
+		return ver != nil, nil
+	}
+	if verDB == nil {
+		return true, nil
+	}
+	stat, err := os.Stat(binary)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get stat for plugin %s", name)
+	}
+	return verDB.isUpdated(name, stat.ModTime())
+}
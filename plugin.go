@@ -3,77 +3,207 @@ package treport
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/goccy/treport/internal/errors"
 	treportproto "github.com/goccy/treport/proto"
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-plugin"
 	"github.com/jhump/protoreflect/dynamic"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/known/anypb"
 )
 
 var (
 	Handshake = plugin.HandshakeConfig{
-		ProtocolVersion:  1,
+		ProtocolVersion:  treportproto.ProtocolVersion,
 		MagicCookieKey:   "TREPORT_PLUGIN",
 		MagicCookieValue: "treport",
 	}
 	BuiltinPluginNames = []string{
 		"size",
+		"jsontree",
 	}
-	BuiltinPlugins []*Plugin
 )
 
-func init() {
-	for _, pluginName := range BuiltinPluginNames {
-		pluginName := pluginName
-		var plugin *Plugin
-		plugin = &Plugin{
-			Name: pluginName,
-			Repo: &Repository{
-				ID: makeHashID(pluginName),
-			},
-			setup: func(args []string) error {
-				client, err := setupBuiltinPlugin(pluginName, args)
-				if err != nil {
-					return errors.Wrapf(err, "failed to setup builtin plugin %s", pluginName)
-				}
-				plugin.Client = client
-				return nil
-			},
+// newBuiltinPlugin creates a builtin plugin instance. It runs the binary installed by InstallPlugins,
+// and the binary not installed yet is built from the source bundled with treport when the plugin is set up.
+// Each pipeline step owns its instance and cache, and the instances of the run share the process by processes.
+func newBuiltinPlugin(ctx context.Context, cfg *Config, pluginName string, grpcCfg *GRPCConfig, keepaliveCfg *KeepaliveConfig, startupCfg *StartupConfig) *Plugin {
+	plugin := &Plugin{
+		Name: pluginName,
+		Repo: &Repository{
+			ID:       makeHashID(pluginName),
+			legacyID: LegacyIDScheme.ID(pluginName),
+		},
+	}
+	plugin.setup = func(args []string) error {
+		key := pluginProcessKey(pluginName, args, grpcCfg, plugin.Env, plugin.Sandbox)
+		if err := plugin.setupProcess(key, func() (*Client, error) {
+			cmd, err := cfg.installedPluginBinary(ctx, pluginName)
+			if err != nil {
+				return nil, err
+			}
+			return setupPlugin(pluginName, cmd, args, grpcCfg, keepaliveCfg, startupCfg, plugin.Env, plugin.Sandbox)
+		}); err != nil {
+			return errors.Wrapf(err, "failed to setup builtin plugin %s", pluginName)
+		}
+		return nil
+	}
+	return plugin
+}
+
+// newRepositoryPlugin creates the instance of the plugin configured by the repository. It runs the binary installed
+// by InstallPlugins, and the binary not installed yet is built from the repository when the plugin is set up.
+func newRepositoryPlugin(ctx context.Context, cfg *Config, repo *Repository, grpcCfg *GRPCConfig, keepaliveCfg *KeepaliveConfig, startupCfg *StartupConfig) *Plugin {
+	pluginName := repo.cfg.Name
+	plugin := &Plugin{Name: pluginName, Repo: repo}
+	plugin.setup = func(args []string) error {
+		key := pluginProcessKey(pluginName, args, grpcCfg, plugin.Env, plugin.Sandbox)
+		if err := plugin.setupProcess(key, func() (*Client, error) {
+			cmd, err := cfg.installedPluginBinary(ctx, pluginName)
+			if err != nil {
+				return nil, err
+			}
+			return setupPlugin(pluginName, cmd, args, grpcCfg, keepaliveCfg, startupCfg, plugin.Env, plugin.Sandbox)
+		}); err != nil {
+			return errors.Wrapf(err, "failed to setup plugin %s", pluginName)
+		}
+		if repo.path != "" && !repo.cfg.IsLocal() {
+			acquireClone(repo.path)
+			plugin.clonePath = repo.path
+		}
+		return nil
+	}
+	return plugin
+}
+
+// IsBuiltinPlugin reports whether the plugin is bundled with treport.
+func IsBuiltinPlugin(pluginName string) bool {
+	for _, name := range BuiltinPluginNames {
+		if name == pluginName {
+			return true
 		}
-		BuiltinPlugins = append(BuiltinPlugins, plugin)
 	}
+	return false
 }
 
 type GRPCScanner interface {
 	Scan(*ScanContext) (*Response, error)
 }
 
+// SchemaVersioner is implemented by GRPCScanner which declares the version of its result schema.
+// If the version is changed, the host handles cached results by schemaPolicy of the plugin.
+type SchemaVersioner interface {
+	SchemaVersion() string
+}
+
+func schemaVersionOf(scanner GRPCScanner) string {
+	if versioner, ok := scanner.(SchemaVersioner); ok {
+		return versioner.SchemaVersion()
+	}
+	return ""
+}
+
+// Versioner is implemented by GRPCScanner which declares the version of the plugin itself.
+// It's stored with the results, so the results can be traced back to the plugin which made them.
+type Versioner interface {
+	Version() string
+}
+
+func versionOf(scanner GRPCScanner) string {
+	if versioner, ok := scanner.(Versioner); ok {
+		return versioner.Version()
+	}
+	return ""
+}
+
+// SnapshotStreamer is implemented by GRPCScanner which reads the snapshot only by ScanContext.Files.
+// The host sends the entries by pages while the plugin iterates them, so the plugin doesn't hold all entries
+// of the large repositories. Snapshot of the context has only the hash.
+type SnapshotStreamer interface {
+	StreamsSnapshot() bool
+}
+
+func streamsSnapshot(scanner GRPCScanner) bool {
+	if streamer, ok := scanner.(SnapshotStreamer); ok {
+		return streamer.StreamsSnapshot()
+	}
+	return false
+}
+
 type ScannerPlugin struct {
 	plugin.Plugin
 	Scanner GRPCScanner
 }
 
 type grpcServer struct {
-	Scanner GRPCScanner
+	Scanner   GRPCScanner
+	snapshots snapshotDecoder
+	types     resultTypeSet
 }
 
 func (m *grpcServer) Scan(ctx context.Context, req *treportproto.ScanContext) (*treportproto.ScanResponse, error) {
+	if err := m.snapshots.decode(req); err != nil {
+		return nil, err
+	}
+	return m.scan(protoToScanContext(ctx, req))
+}
+
+// ScanStream scans the commit whose entries of the snapshot are read from the stream by ScanContext.Files.
+func (m *grpcServer) ScanStream(stream treportproto.Scanner_ScanStreamServer) error {
+	chunk, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if chunk.Context == nil {
+		return status.Error(codes.InvalidArgument, "first chunk doesn't have the scan context")
+	}
+	scanctx := protoToScanContext(stream.Context(), chunk.Context)
+	scanctx.files = &snapshotStream{stream: stream}
+	response, err := m.scan(scanctx)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(response)
+}
+
+func (m *grpcServer) scan(scanctx *ScanContext) (*treportproto.ScanResponse, error) {
 	response := &treportproto.ScanResponse{}
-	res, err := m.Scanner.Scan(protoToScanContext(ctx, req))
+	res, err := m.Scanner.Scan(scanctx)
 	if res != nil {
+		m.types.add(res.name)
 		response.Name = res.name
 		response.Data = res.data
 		response.Json = res.json
+		response.SchemaVersion = schemaVersionOf(m.Scanner)
+		response.PluginVersion = versionOf(m.Scanner)
+		response.Warnings = res.warnings
 	}
-	return response, err
+	return response, pluginStatus(err)
+}
+
+func (m *grpcServer) Info(ctx context.Context, req *treportproto.InfoRequest) (*treportproto.PluginInfo, error) {
+	// the streamed snapshot isn't held by the plugin, so it can't be the base of the delta.
+	streams := streamsSnapshot(m.Scanner)
+	return &treportproto.PluginInfo{
+		SchemaVersion:  schemaVersionOf(m.Scanner),
+		SnapshotDelta:  !streams,
+		Version:        versionOf(m.Scanner),
+		SnapshotStream: streams,
+	}, nil
 }
 
 func (p *ScannerPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
@@ -93,8 +223,10 @@ func Serve(scanner GRPCScanner, logger Logger) {
 		Plugins: map[string]plugin.Plugin{
 			"treport": &ScannerPlugin{Scanner: scanner},
 		},
-		GRPCServer: plugin.DefaultGRPCServer,
-		Logger:     logger,
+		GRPCServer: func(opts []grpc.ServerOption) *grpc.Server {
+			return grpc.NewServer(append(opts, serverOptions()...)...)
+		},
+		Logger: logger,
 	})
 }
 
@@ -102,20 +234,144 @@ var (
 	ErrNoData = fmt.Errorf("data doesn't exist")
 )
 
-func (c *ScanContext) GetData(msg proto.Message) error {
-	name := proto.MessageName(msg)
-	data, exists := c.Data[name]
+// GetDataOption changes how the result is decoded to the message.
+type GetDataOption func(*protobuf.UnmarshalOptions)
+
+// DiscardUnknown drops the fields unknown to the message, like the ones added by the newer version of the plugin.
+func DiscardUnknown() GetDataOption {
+	return func(opts *protobuf.UnmarshalOptions) {
+		opts.DiscardUnknown = true
+	}
+}
+
+// AllowPartial doesn't fail the decode of the result which doesn't have the required fields of proto2.
+func AllowPartial() GetDataOption {
+	return func(opts *protobuf.UnmarshalOptions) {
+		opts.AllowPartial = true
+	}
+}
+
+func unmarshalData(data *anypb.Any, msg protoreflect.ProtoMessage, opts []GetDataOption) error {
+	var unmarshalOpts protobuf.UnmarshalOptions
+	for _, opt := range opts {
+		opt(&unmarshalOpts)
+	}
+	return anypb.UnmarshalTo(data, msg, unmarshalOpts)
+}
+
+func messageName(msg protoreflect.ProtoMessage) string {
+	return string(msg.ProtoReflect().Descriptor().FullName())
+}
+
+// GetData gets the result of the type of msg from the plugins which ran before in the pipeline.
+func (c *ScanContext) GetData(msg protoreflect.ProtoMessage, opts ...GetDataOption) error {
+	c.dataMu.Lock()
+	data, exists := c.Data[messageName(msg)]
+	c.dataMu.Unlock()
+	if !exists {
+		return ErrNoData
+	}
+	return unmarshalData(data.Data, msg, opts)
+}
+
+// GetLegacyData gets the result to the message generated by the older protoc-gen-go, which doesn't implement protoreflect.ProtoMessage.
+//
+// Deprecated: regenerate the message by the current protoc-gen-go, and use GetData.
+func (c *ScanContext) GetLegacyData(msg proto.Message) error {
+	return c.GetData(proto.MessageReflect(msg).Interface())
+}
+
+// GetDataByPlugin gets the result of the plugin which ran before in the pipeline.
+func (c *ScanContext) GetDataByPlugin(pluginName string, msg protoreflect.ProtoMessage, opts ...GetDataOption) error {
+	data, exists := c.pluginResponse(pluginName)
 	if !exists {
 		return ErrNoData
 	}
-	v := proto.MessageReflect(msg).Interface()
-	return anypb.UnmarshalTo(data.Data, v, protobuf.UnmarshalOptions{})
+	if name := messageName(msg); data.Name != name {
+		return fmt.Errorf("%s returns %s instead of %s", pluginName, data.Name, name)
+	}
+	return unmarshalData(data.Data, msg, opts)
+}
+
+// Previous gets the result of the plugin itself for the previous commit of the walk.
+// It's ErrNoData for the first commit, and for the commits of headOnly, worktree and the newest-first walk.
+func (c *ScanContext) Previous(msg protoreflect.ProtoMessage, opts ...GetDataOption) error {
+	if c.previous == nil {
+		return ErrNoData
+	}
+	return unmarshalData(c.previous.Data, msg, opts)
+}
+
+// SeriesData is the result of the upstream plugin for a commit passed to the plugin of the aggregate stage.
+type SeriesData struct {
+	CommitHash string
+	CommitTime time.Time
+	TopoIndex  int64
+	JSON       string
+	response   *treportproto.ScanResponse
+}
+
+// GetData gets the result for the commit.
+func (d *SeriesData) GetData(msg protoreflect.ProtoMessage, opts ...GetDataOption) error {
+	if name := messageName(msg); d.response.Name != name {
+		return fmt.Errorf("result is %s instead of %s", d.response.Name, name)
+	}
+	return unmarshalData(d.response.Data, msg, opts)
+}
+
+// Series returns the results of the upstream plugin for all commits of the walk in commit order.
+// It's passed only to the plugins of the aggregate stage, and nil for the others.
+func (c *ScanContext) Series(pluginName string) []*SeriesData {
+	series, exists := c.series[pluginName]
+	if !exists {
+		return nil
+	}
+	list := make([]*SeriesData, 0, len(series.Results))
+	for _, res := range series.Results {
+		commitTime, _ := ptypes.Timestamp(res.CommitTime)
+		list = append(list, &SeriesData{
+			CommitHash: res.CommitHash,
+			CommitTime: commitTime,
+			TopoIndex:  res.TopoIndex,
+			JSON:       res.Json,
+			response:   res,
+		})
+	}
+	return list
+}
+
+// DataInfo is the result of the plugin in the scan context.
+type DataInfo struct {
+	Plugin string
+	// Type is the full name of the proto message of the result.
+	Type string
+	JSON string
+	// Repository is the url of the scanned repository, or the path of the local one.
+	Repository string
+}
+
+// ListData returns the results of the plugins which ran before in the pipeline, sorted by the plugin name.
+func (c *ScanContext) ListData() []*DataInfo {
+	data, pluginToType := c.upstream(nil)
+	list := make([]*DataInfo, 0, len(pluginToType))
+	for plugin, typ := range pluginToType {
+		res := data[typ]
+		list = append(list, &DataInfo{Plugin: plugin, Type: res.Name, JSON: res.Json, Repository: res.Repository})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Plugin < list[j].Plugin })
+	return list
 }
 
 type Response struct {
-	name string
-	data *anypb.Any
-	json string
+	name     string
+	data     *anypb.Any
+	json     string
+	warnings []string
+}
+
+// Warnf adds the warning about the result, which is stored with it and logged by the host.
+func (r *Response) Warnf(format string, args ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
 }
 
 func ToResponse(data proto.Message) (*Response, error) {
@@ -149,58 +405,372 @@ func (c Clients) Stop() {
 }
 
 type Client struct {
-	pluginName   string
-	pluginClient *plugin.Client
-	grpcClient   treportproto.ScannerClient
-	mtime        time.Time
+	pluginName    string
+	pluginClient  *plugin.Client
+	grpcClient    treportproto.ScannerClient
+	path          string
+	mtime         time.Time
+	schemaVersion string
+	version       string
+	// snapshotDelta reports that the plugin accepts the snapshot as the delta from the previous one.
+	snapshotDelta bool
+	snapshots     snapshotEncoder
+	// snapshotStream reports that the plugin receives the entries of the snapshot by ScanStream RPC.
+	snapshotStream bool
+	// descriptors loads the descriptors of the results once the plugin returns the message unknown to the host.
+	descriptors sync.Once
+	callOptions []grpc.CallOption
+	sandbox     *pluginSandbox
+	// mu guards the plugin process. The process killed by the health check or the transport error is started again
+	// by start at the next call, and the gRPC client is dispensed again.
+	mu    sync.Mutex
+	start func() error
+	// stderr has the last output of the process to report why the process failed to start.
+	stderr        *stderrTail
+	protocol      plugin.ClientProtocol
+	stopped       bool
+	stopKeepalive chan struct{}
 }
 
+// conn returns the gRPC client of the running plugin process. It starts the process if it has exited.
+func (c *Client) conn() (treportproto.ScannerClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.start == nil || c.pluginClient != nil && !c.pluginClient.Exited() {
+		return c.grpcClient, nil
+	}
+	if c.stopped {
+		return nil, fmt.Errorf("plugin %s is stopped", c.pluginName)
+	}
+	c.sandbox.cleanup()
+	if err := c.start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start plugin %s", c.pluginName)
+	}
+	return c.grpcClient, nil
+}
+
+// discard kills the plugin process if the call by grpcClient failed by the transport, so the next call starts it again.
+// The process is kept if it's already started again by another call.
+func (c *Client) discard(grpcClient treportproto.ScannerClient, err error) {
+	if !isTransportError(err) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pluginClient != nil && c.grpcClient == grpcClient {
+		c.pluginClient.Kill()
+	}
+}
+
+// isTransportError reports whether the RPC failed by the connection to the plugin. The plugin returns the status
+// which has the detail if it classifies the error.
+func isTransportError(err error) bool {
+	st := status.Convert(err)
+	return st.Code() == codes.Unavailable && len(st.Details()) == 0
+}
+
+// keepalive checks the health of the plugin by interval until it's stopped. The plugin which doesn't respond within
+// interval is killed even while it scans, so the stalled scan fails and it's retried by the plugin started again.
+func (c *Client) keepalive(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	c.stopKeepalive = done
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			c.ping(interval)
+		}
+	}()
+}
+
+func (c *Client) ping(timeout time.Duration) {
+	c.mu.Lock()
+	pluginClient, protocol := c.pluginClient, c.protocol
+	c.mu.Unlock()
+	if pluginClient == nil || protocol == nil || pluginClient.Exited() {
+		return
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- protocol.Ping()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		if err == nil {
+			return
+		}
+	case <-timer.C:
+	}
+	pluginClient.Kill()
+}
+
+// isUpdated reports whether the plugin binary is replaced after the client is set up.
+func (c *Client) isUpdated() bool {
+	stat, err := os.Stat(c.path)
+	return err != nil || !stat.ModTime().Equal(c.mtime)
+}
+
+// info gets the plugin information. Plugins built before Info RPC is introduced are treated as no schema version.
+func (c *Client) info(ctx context.Context) (*treportproto.PluginInfo, error) {
+	grpcClient, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	info, err := grpcClient.Info(ctx, &treportproto.InfoRequest{}, c.callOptions...)
+	if err != nil {
+		c.discard(grpcClient, err)
+		if status.Code(err) == codes.Unimplemented {
+			return &treportproto.PluginInfo{}, nil
+		}
+		return nil, errors.Wrapf(err, "failed to get plugin info of %s", c.pluginName)
+	}
+	return info, nil
+}
+
+func (c *Client) applyInfo(info *treportproto.PluginInfo) {
+	c.schemaVersion = info.SchemaVersion
+	c.version = info.Version
+	c.snapshotDelta = info.SnapshotDelta
+	c.snapshotStream = info.SnapshotStream
+}
+
+// Scan scans the commit. The plugin is passed all results in scanctx.
 func (c *Client) Scan(ctx context.Context, scanctx *ScanContext) (*treportproto.ScanResponse, error) {
-	result, err := c.grpcClient.Scan(ctx, scanctx.toProto())
+	return c.scan(ctx, scanctx, &scanOptions{}, nil)
+}
+
+// scanOptions are the options of the plugin applied to the request.
+type scanOptions struct {
+	// upstream are the plugins whose results are passed. If it's nil, all results are passed.
+	upstream     []string
+	worktreePath string
+	// dirs passes the directories of the snapshot.
+	dirs bool
+}
+
+func (c *Client) scan(ctx context.Context, scanctx *ScanContext, opts *scanOptions, timing *ScanTiming) (*treportproto.ScanResponse, error) {
+	start := time.Now()
+	req := c.request(scanctx, opts)
+	timing.addSerialize(start)
+	start = time.Now()
+	result, err := c.send(ctx, req, scanctx.Snapshot)
+	elapsed := time.Since(start)
+	timing.addScan(start)
+	if status.Code(err) == codes.FailedPrecondition && req.SnapshotDelta != nil {
+		// the plugin doesn't have the base snapshot of the delta. send the snapshot in full.
+		c.snapshots.reset()
+		start = time.Now()
+		req = c.request(scanctx, opts)
+		timing.addSerialize(start)
+		start = time.Now()
+		result, err = c.send(ctx, req, scanctx.Snapshot)
+		elapsed = time.Since(start)
+		timing.addScan(start)
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to scan %s", c.pluginName)
+		// the plugin may not have received the snapshot.
+		c.snapshots.reset()
+		return nil, errors.Wrapf(pluginError("scan", err), "failed to scan %s", c.pluginName)
+	}
+	if result.SchemaVersion == "" {
+		result.SchemaVersion = c.schemaVersion
 	}
+	if result.Name != "" && !resultTypes.resolvable(result.Name) {
+		c.descriptors.Do(func() {
+			if err := c.loadDescriptors(ctx); err != nil {
+				loggerFrom(ctx).Warn("failed to load descriptors", "plugin", c.pluginName, "err", err)
+			}
+		})
+	}
+	if result.PluginVersion == "" {
+		result.PluginVersion = c.version
+	}
+	result.Duration = ptypes.DurationProto(elapsed)
+	result.DiffMode = string(scanctx.DiffMode)
+	result.CommitHash = scanctx.Commit.Hash
+	result.ParentHashes = scanctx.Commit.ParentHashes
+	result.TopoIndex = scanctx.TopoIndex
+	result.CommitTime, _ = ptypes.TimestampProto(scanctx.Commit.Committer.When)
+	result.AuthorTime, _ = ptypes.TimestampProto(scanctx.Commit.Author.When)
+	result.ScanTime = ptypes.TimestampNow()
 	c.storeResult(result, scanctx)
 	return result, nil
 }
 
-func (c *Client) storeResult(result *treportproto.ScanResponse, scanctx *ScanContext) {
-	scanctx.Data[result.Name] = result
-	if _, exists := scanctx.pluginToType[c.pluginName]; !exists {
-		scanctx.pluginToType[c.pluginName] = result.Name
+// request converts the scan context to the request. The snapshot is encoded as the delta if the plugin accepts it,
+// and it has only the hash if the plugin receives the entries by ScanStream.
+func (c *Client) request(scanctx *ScanContext, opts *scanOptions) *treportproto.ScanContext {
+	var req *treportproto.ScanContext
+	switch {
+	case c.snapshotStream:
+		req = scanctx.toProtoWithoutSnapshot(opts.upstream)
+		req.Snapshot = &treportproto.Snapshot{Hash: scanctx.Snapshot.Hash}
+	case c.snapshotDelta:
+		req = scanctx.toProtoWithoutSnapshot(opts.upstream)
+		c.snapshots.encode(req, scanctx.Snapshot)
+	default:
+		req = scanctx.toProto(opts.upstream)
 	}
+	req.WorktreePath = opts.worktreePath
+	if opts.dirs {
+		req.Snapshot.Dirs = dirsToProto(scanctx.Snapshot.dirs())
+	}
+	return req
+}
+
+// send sends the request by Scan RPC, or by ScanStream RPC followed by the pages of the entries of the snapshot.
+func (c *Client) send(ctx context.Context, req *treportproto.ScanContext, snapshot *Snapshot) (*treportproto.ScanResponse, error) {
+	grpcClient, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.sendBy(ctx, grpcClient, req, snapshot)
+	if err != nil {
+		c.discard(grpcClient, err)
+	}
+	return res, err
+}
+
+func (c *Client) sendBy(ctx context.Context, grpcClient treportproto.ScannerClient, req *treportproto.ScanContext, snapshot *Snapshot) (*treportproto.ScanResponse, error) {
+	if !c.snapshotStream {
+		return grpcClient.Scan(ctx, req, c.callOptions...)
+	}
+	stream, err := grpcClient.ScanStream(ctx, c.callOptions...)
+	if err != nil {
+		return nil, err
+	}
+	err = stream.Send(&treportproto.ScanChunk{Context: req})
+	for start := 0; err == nil && start < len(snapshot.Entries); start += snapshotPageSize {
+		end := start + snapshotPageSize
+		if end > len(snapshot.Entries) {
+			end = len(snapshot.Entries)
+		}
+		entries := make([]*treportproto.File, 0, end-start)
+		for _, entry := range snapshot.Entries[start:end] {
+			entries = append(entries, entry.toProto())
+		}
+		err = stream.Send(&treportproto.ScanChunk{Entries: entries})
+	}
+	// io.EOF means the plugin has returned without reading all entries. The result is received by CloseAndRecv.
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return stream.CloseAndRecv()
+}
+
+func (c *Client) storeResult(result *treportproto.ScanResponse, scanctx *ScanContext) {
+	scanctx.storeResult(c.pluginName, result)
 }
 
 func (c *Client) Stop() {
-	c.pluginClient.Kill()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	if c.stopKeepalive != nil {
+		close(c.stopKeepalive)
+		c.stopKeepalive = nil
+	}
+	if c.pluginClient != nil {
+		c.pluginClient.Kill()
+	}
+	c.sandbox.cleanup()
+}
+
+// builtinPluginSourcePath returns the directory of the source of the builtin plugin in the root of treport.
+func builtinPluginSourcePath(pluginName string) string {
+	return filepath.Join("internal", "plugins", pluginName)
 }
 
-func setupBuiltinPlugin(pluginName string, args []string) (*Client, error) {
-	cmd := fmt.Sprintf("./internal/plugins/%s/%s", pluginName, pluginName)
+// setupPlugin starts the plugin binary cmd, and negotiates the plugin information.
+func setupPlugin(pluginName, cmd string, args []string, grpcCfg *GRPCConfig, keepaliveCfg *KeepaliveConfig, startupCfg *StartupConfig, envCfg *PluginEnvConfig, sandboxCfg *SandboxConfig) (*Client, error) {
 	stat, err := os.Stat(cmd)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get stat for %s", cmd)
 	}
+	c := &Client{
+		pluginName:  pluginName,
+		callOptions: grpcCfg.callOptions(),
+		path:        cmd,
+		mtime:       stat.ModTime(),
+	}
+	c.start = func() error {
+		return c.startProcess(cmd, args, grpcCfg, startupCfg, envCfg, sandboxCfg)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startupCfg.protocolTimeout())
+	defer cancel()
+	info, err := c.info(ctx)
+	if err != nil {
+		c.Stop()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.Wrapf(err, "plugin %s didn't respond within %s after the handshake%s", pluginName, startupCfg.protocolTimeout(), c.stderr.suffix())
+		}
+		return nil, err
+	}
+	c.applyInfo(info)
+	c.keepalive(keepaliveCfg.interval())
+	return c, nil
+}
+
+// startProcess starts the plugin process and dispenses the gRPC client. c.mu must be held.
+func (c *Client) startProcess(cmd string, args []string, grpcCfg *GRPCConfig, startupCfg *StartupConfig, envCfg *PluginEnvConfig, sandboxCfg *SandboxConfig) error {
+	pluginName := c.pluginName
+	// the binary is executed without the shell, which Windows doesn't have.
+	execCmd := exec.Command(cmd, args...)
+	execCmd.Env = grpcCfg.env()
+	sandbox, err := newPluginSandbox(pluginName, sandboxCfg, execCmd)
+	if err != nil {
+		return err
+	}
+	if err := scrubPluginEnv(execCmd, envCfg, sandbox.env()); err != nil {
+		sandbox.cleanup()
+		return errors.Wrapf(err, "failed to set environment of plugin %s", pluginName)
+	}
+	stderr := &stderrTail{}
 	client := plugin.NewClient(&plugin.ClientConfig{
 		HandshakeConfig:  Handshake,
 		Plugins:          map[string]plugin.Plugin{"treport": &ScannerPlugin{}},
-		Cmd:              exec.Command("sh", append([]string{"-c", cmd}, args...)...),
+		Cmd:              execCmd,
 		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		StartTimeout:     startupCfg.handshakeTimeout(),
+		Stderr:           stderr,
+		// managed clients are killed by plugin.CleanupClients even if Cleanup isn't called.
+		Managed: true,
 	})
+	kill := func() {
+		client.Kill()
+		sandbox.cleanup()
+	}
+	c.stderr = stderr
 	rpcClient, err := client.Client()
 	if err != nil {
-		return nil, err
+		kill()
+		return errors.Wrapf(err, "plugin %s didn't handshake%s", pluginName, stderr.suffix())
 	}
 	scannerClient, err := rpcClient.Dispense("treport")
 	if err != nil {
-		return nil, err
+		kill()
+		return err
 	}
-	c, ok := scannerClient.(*Client)
+	dispensed, ok := scannerClient.(*Client)
 	if !ok {
-		return nil, fmt.Errorf("failed to get Client from %T", scannerClient)
+		kill()
+		return fmt.Errorf("failed to get Client from %T", scannerClient)
 	}
-	c.pluginName = pluginName
 	c.pluginClient = client
-	c.mtime = stat.ModTime()
-	return c, nil
+	c.protocol = rpcClient
+	c.grpcClient = dispensed.grpcClient
+	c.sandbox = sandbox
+	// the new process doesn't have the snapshot sent to the previous one.
+	c.snapshots.reset()
+	return nil
 }
@@ -0,0 +1,147 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.23.0
+// 	protoc        v3.14.0
+// source: jsontree.proto
+
+package proto
+
+import (
+	proto "github.com/golang/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+// StoredFiles is the files written by the jsontree storer for the commit.
+type StoredFiles struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Paths []string `protobuf:"bytes,1,rep,name=paths,proto3" json:"paths,omitempty"`
+}
+
+func (x *StoredFiles) Reset() {
+	*x = StoredFiles{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_jsontree_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StoredFiles) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoredFiles) ProtoMessage() {}
+
+func (x *StoredFiles) ProtoReflect() protoreflect.Message {
+	mi := &file_jsontree_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoredFiles.ProtoReflect.Descriptor instead.
+func (*StoredFiles) Descriptor() ([]byte, []int) {
+	return file_jsontree_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StoredFiles) GetPaths() []string {
+	if x != nil {
+		return x.Paths
+	}
+	return nil
+}
+
+var File_jsontree_proto protoreflect.FileDescriptor
+
+var file_jsontree_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x6a, 0x73, 0x6f, 0x6e, 0x74, 0x72, 0x65, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x05, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x23, 0x0a, 0x0b, 0x53, 0x74, 0x6f, 0x72, 0x65,
+	0x64, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x61, 0x74, 0x68, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x70, 0x61, 0x74, 0x68, 0x73, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_jsontree_proto_rawDescOnce sync.Once
+	file_jsontree_proto_rawDescData = file_jsontree_proto_rawDesc
+)
+
+func file_jsontree_proto_rawDescGZIP() []byte {
+	file_jsontree_proto_rawDescOnce.Do(func() {
+		file_jsontree_proto_rawDescData = protoimpl.X.CompressGZIP(file_jsontree_proto_rawDescData)
+	})
+	return file_jsontree_proto_rawDescData
+}
+
+var file_jsontree_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_jsontree_proto_goTypes = []interface{}{
+	(*StoredFiles)(nil), // 0: proto.StoredFiles
+}
+var file_jsontree_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_jsontree_proto_init() }
+func file_jsontree_proto_init() {
+	if File_jsontree_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_jsontree_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StoredFiles); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_jsontree_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_jsontree_proto_goTypes,
+		DependencyIndexes: file_jsontree_proto_depIdxs,
+		MessageInfos:      file_jsontree_proto_msgTypes,
+	}.Build()
+	File_jsontree_proto = out.File
+	file_jsontree_proto_rawDesc = nil
+	file_jsontree_proto_goTypes = nil
+	file_jsontree_proto_depIdxs = nil
+}
@@ -0,0 +1,440 @@
+package treport_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/goccy/treport"
+	treportproto "github.com/goccy/treport/proto"
+	"github.com/goccy/treport/treporttest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestGetDataOptions(t *testing.T) {
+	data, err := anypb.New(&durationpb.Duration{Seconds: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the field added by the newer version of the plugin.
+	data.Value = protowire.AppendVarint(protowire.AppendTag(data.Value, 99, protowire.VarintType), 1)
+	name := string((&durationpb.Duration{}).ProtoReflect().Descriptor().FullName())
+	scanctx := &treport.ScanContext{
+		Data: map[string]*treportproto.ScanResponse{name: {Name: name, Data: data}},
+	}
+	var kept durationpb.Duration
+	if err := scanctx.GetData(&kept); err != nil {
+		t.Fatal(err)
+	}
+	if kept.Seconds != 3 || len(kept.ProtoReflect().GetUnknown()) == 0 {
+		t.Fatalf("expected unknown field to be kept: %v", &kept)
+	}
+	var discarded durationpb.Duration
+	if err := scanctx.GetData(&discarded, treport.DiscardUnknown()); err != nil {
+		t.Fatal(err)
+	}
+	if discarded.Seconds != 3 || len(discarded.ProtoReflect().GetUnknown()) != 0 {
+		t.Fatalf("expected unknown field to be discarded: %v", &discarded)
+	}
+	var legacy durationpb.Duration
+	if err := scanctx.GetLegacyData(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(&legacy, &kept) {
+		t.Fatalf("unexpected legacy data %v", &legacy)
+	}
+}
+
+var errStopFiles = errors.New("stop")
+
+// streamScanner counts the entries of the streamed snapshot. If limit is set, it stops reading at the limit.
+type streamScanner struct {
+	limit int
+}
+
+func (s *streamScanner) Scan(ctx *treport.ScanContext) (*treport.Response, error) {
+	if len(ctx.Snapshot.Entries) != 0 {
+		return nil, fmt.Errorf("snapshot has %d entries", len(ctx.Snapshot.Entries))
+	}
+	var count int64
+	err := ctx.Files(func(*treport.File) error {
+		count++
+		if s.limit > 0 && count == int64(s.limit) {
+			return errStopFiles
+		}
+		return nil
+	})
+	if err != nil && err != errStopFiles {
+		return nil, err
+	}
+	if err := ctx.Files(func(*treport.File) error { return nil }); err == nil {
+		return nil, fmt.Errorf("streamed snapshot is read twice")
+	}
+	return treport.ToResponse(wrapperspb.Int64(count))
+}
+
+func (s *streamScanner) StreamsSnapshot() bool {
+	return true
+}
+
+func TestSnapshotStream(t *testing.T) {
+	now := time.Now()
+	snapshot := &treport.Snapshot{Hash: "6d1e0f3b2a9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e"}
+	for i := 0; i < 12000; i++ {
+		snapshot.Entries = append(snapshot.Entries, &treport.File{Name: fmt.Sprintf("file%05d", i), Size: 1})
+	}
+	for _, test := range []struct {
+		limit    int
+		expected int64
+	}{
+		{0, 12000},
+		{10, 10},
+	} {
+		listener := bufconn.Listen(1 << 20)
+		server := grpc.NewServer()
+		if err := (&treport.ScannerPlugin{Scanner: &streamScanner{limit: test.limit}}).GRPCServer(nil, server); err != nil {
+			t.Fatal(err)
+		}
+		go server.Serve(listener)
+		conn, err := grpc.Dial("bufconn",
+			grpc.WithInsecure(),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := (&treport.ScannerPlugin{}).GRPCClient(context.Background(), nil, conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := c.(*treport.Client)
+		if err := client.Negotiate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		scanctx := &treport.ScanContext{
+			Commit: &treport.Commit{
+				Hash:      "3f2a9c1e0d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39",
+				Author:    &treport.Signature{When: now},
+				Committer: &treport.Signature{When: now},
+			},
+			Snapshot: snapshot,
+		}
+		res, err := client.Scan(context.Background(), scanctx)
+		conn.Close()
+		server.Stop()
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var count wrapperspb.Int64Value
+		if err := anypb.UnmarshalTo(res.Data, &count, proto.UnmarshalOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if count.Value != test.expected {
+			t.Fatalf("expected %d entries but got %d", test.expected, count.Value)
+		}
+	}
+}
+
+func TestSnapshotDirs(t *testing.T) {
+	snapshot := &treport.Snapshot{Entries: []*treport.File{
+		{Name: "README.md", Size: 1},
+		{Name: "internal/a/a.go", Size: 2},
+		{Name: "internal/b.go", Size: 4},
+		{Name: "pkg/c.go", Size: 8},
+	}}
+	dirs := snapshot.AggregateDirs()
+	expected := []treport.Dir{
+		{Name: "internal", Size: 6, Files: 2},
+		{Name: "internal/a", Size: 2, Files: 1},
+		{Name: "pkg", Size: 8, Files: 1},
+	}
+	if len(dirs) != len(expected) {
+		t.Fatalf("expected %d dirs but got %d", len(expected), len(dirs))
+	}
+	for i, dir := range dirs {
+		if *dir != expected[i] {
+			t.Fatalf("expected %+v but got %+v", expected[i], *dir)
+		}
+	}
+}
+
+func TestPluginPool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "size")
+	if err := ioutil.WriteFile(path, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	newClient := func() *treport.Client {
+		client, err := treport.NewPluginClient(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	pool := treport.NewPluginPool(100 * time.Millisecond)
+	client := newClient()
+	pool.Put("size", client)
+	if got := pool.Get("size"); got != client || client.IsStopped() {
+		t.Fatalf("expected idle client to be reused")
+	}
+	if got := pool.Get("size"); got != nil {
+		t.Fatalf("expected client to be got once")
+	}
+	pool.Put("size", client)
+	time.Sleep(300 * time.Millisecond)
+	if got := pool.Get("size"); got != nil || !client.IsStopped() {
+		t.Fatalf("expected idle client to be stopped")
+	}
+	client = newClient()
+	pool.Put("size", client)
+	// the plugin binary is replaced.
+	updated := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, updated, updated); err != nil {
+		t.Fatal(err)
+	}
+	if got := pool.Get("size"); got != nil || !client.IsStopped() {
+		t.Fatalf("expected client of old binary to be stopped")
+	}
+}
+
+func TestPluginLifecycle(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		setups int
+	)
+	plg := treport.NewSetupPlugin("size", func([]string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		setups++
+		return nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := plg.Setup([]string{"-v"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if setups != 1 {
+		t.Fatalf("expected plugin to be set up once but got %d", setups)
+	}
+	if err := plg.Setup([]string{"-q"}); err == nil {
+		t.Fatal("expected error for different args")
+	}
+	plg.Cleanup()
+	plg.Cleanup()
+	if err := plg.Setup([]string{"-v"}); err == nil {
+		t.Fatal("expected error for plugin cleaned up")
+	}
+	if setups != 1 {
+		t.Fatalf("expected plugin not to be set up again but got %d", setups)
+	}
+}
+
+func TestPluginSharedProcess(t *testing.T) {
+	starts := 0
+	plugins := treport.NewProcessPlugins("size", 3, func() error {
+		starts++
+		return nil
+	})
+	for _, plg := range plugins[:2] {
+		if err := plg.Setup([]string{"-v"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := plugins[2].Setup([]string{"-q"}); err != nil {
+		t.Fatal(err)
+	}
+	if starts != 2 {
+		t.Fatalf("expected the plugins with the same args to share the process, but it's started %d times", starts)
+	}
+	shared := plugins[0].Client
+	if plugins[1].Client != shared || plugins[2].Client == shared {
+		t.Fatal("unexpected clients of the plugins")
+	}
+	plugins[0].Cleanup()
+	if shared.IsStopped() {
+		t.Fatal("expected the process to run while another plugin uses it")
+	}
+	plugins[1].Cleanup()
+	if !shared.IsStopped() {
+		t.Fatal("expected the process to stop by the cleanup of the last plugin using it")
+	}
+	plugins[2].Cleanup()
+}
+
+func TestPluginAfterCleanup(t *testing.T) {
+	plg := treport.NewProcessPlugins("size", 1, func() error { return nil })[0]
+	if err := plg.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	plg.Cleanup()
+	if err := plg.Scan(context.Background(), &treport.ScanContext{}); err == nil {
+		t.Fatal("expected error for scan after cleanup")
+	}
+	if _, err := plg.GetCache("0000000000000000000000000000000000000000"); err == nil {
+		t.Fatal("expected error for cache read after cleanup")
+	}
+}
+
+func TestRepositoryPlugin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the plugin is built by go build")
+	}
+	dir := t.TempDir()
+	repoPath := filepath.Join(dir, "repo")
+	if _, err := treporttest.GenerateRepository(repoPath, &treporttest.RepositoryOptions{Commits: 3, Files: 10, ChangesPerCommit: 2}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	source := newPluginRepository(t, filepath.Join(dir, "plugin"))
+	cfg := &treport.Config{
+		Project: treport.ProjectConfig{Path: filepath.Join(dir, "mount")},
+		Plugin: &treport.PluginConfig{
+			Scanner: []*treport.RepositoryConfig{{Name: "local-size", Path: source}},
+		},
+		Pipelines: []*treport.PipelineConfig{
+			{
+				Name:       "local-size",
+				Strategy:   treport.AllCommit,
+				Repository: []*treport.RepositoryConfig{{Path: repoPath}},
+				Steps: []*treport.StepConfig{
+					{Plugins: []*treport.PluginExecConfig{{Name: "local-size"}}},
+				},
+			},
+		},
+	}
+	plugins, err := treport.InstallPlugins(context.Background(), cfg, "local-size")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(plugins) != 1 || !plugins[0].Installed {
+		t.Fatalf("expected plugin to be installed: %+v", plugins)
+	}
+	scanner := treport.NewScanner(cfg)
+	if err := scanner.Scan(context.Background()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	summary := scanner.Summary().Pipelines[0].Repos[0].Plugins[0]
+	if summary.Name != "local-size" || summary.Commits == 0 || summary.Failures != 0 {
+		t.Fatalf("unexpected summary of plugin %+v", summary)
+	}
+}
+
+func TestBuiltinPluginInstalledByScan(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the plugin is built by go build")
+	}
+	dir := t.TempDir()
+	repoPath := filepath.Join(dir, "repo")
+	if _, err := treporttest.GenerateRepository(repoPath, &treporttest.RepositoryOptions{Commits: 3, Files: 10, ChangesPerCommit: 2}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	cfg := &treport.Config{
+		Project: treport.ProjectConfig{Path: filepath.Join(dir, "mount")},
+		Pipelines: []*treport.PipelineConfig{
+			{
+				Name:       "size",
+				Strategy:   treport.AllCommit,
+				Repository: []*treport.RepositoryConfig{{Path: repoPath}},
+				Steps: []*treport.StepConfig{
+					{Plugins: []*treport.PluginExecConfig{{Name: "size"}}},
+				},
+			},
+		},
+	}
+	scanner := treport.NewScanner(cfg)
+	if err := scanner.Scan(context.Background()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	plugins, err := treport.ListPlugins(context.Background(), cfg, "size")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	platform := runtime.GOOS + "_" + runtime.GOARCH
+	if len(plugins) != 1 || !plugins[0].Installed || filepath.Dir(plugins[0].Binary) != filepath.Join(cfg.PluginPath(), "bin", platform) {
+		t.Fatalf("expected builtin plugin to be installed for %s: %+v", platform, plugins)
+	}
+	if err := plugins[0].Verify(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// newPluginRepository creates the repository of the plugin which has the source of the builtin size plugin.
+func newPluginRepository(t *testing.T, dir string) string {
+	t.Helper()
+	root, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the plugin depends on the modules treport depends on.
+	for src, dst := range map[string]string{
+		filepath.Join(root, "internal", "plugins", "size", "main.go"): "main.go",
+		filepath.Join(root, "go.sum"):                                 "go.sum",
+	} {
+		b, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, dst), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	goMod := fmt.Sprintf(`module size
+
+go 1.15
+
+require (
+	github.com/goccy/treport v0.0.0-00010101000000-000000000000
+	github.com/hashicorp/go-hclog v0.14.1
+)
+
+replace (
+	github.com/goccy/treport => %s
+	github.com/goccy/treport/proto => %s
+)
+`, root, filepath.Join(root, "proto"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wt.AddGlob("*"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Commit("size plugin", &git.CommitOptions{
+		Author: &object.Signature{Name: "treport", Email: "treport@example.com", When: time.Now()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
@@ -0,0 +1,91 @@
+package treport
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// PluginEnvConfig is the environment variables of the plugin process. The environment of the host is scrubbed
+// except the basic variables like PATH and HOME and the ones of allow, so the credentials like GITHUB_TOKEN aren't
+// passed to the third-party plugins. set adds the variables, or overwrites the passed ones.
+// The plugins can't run on Windows, which doesn't have env to scrub the variables go-plugin passes.
+type PluginEnvConfig struct {
+	Allow []string          `yaml:"allow"`
+	Set   map[string]string `yaml:"set"`
+}
+
+// defaultPluginEnvs are always passed to the plugin.
+var defaultPluginEnvs = []string{
+	"PATH", "HOME", "USER", "TMPDIR", "LANG", "LC_ALL", "TZ",
+	Handshake.MagicCookieKey, maxMessageSizeEnv,
+}
+
+// scrubPluginEnv sets the environment of the command to the allowed variables of the host and cmd.Env,
+// and set of the config and overwrites after them. go-plugin adds all variables of the host to the command,
+// so the command is started by env which removes the others again. It fails on Windows, which doesn't have env.
+func scrubPluginEnv(cmd *exec.Cmd, cfg *PluginEnvConfig, overwrites map[string]string) error {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("environment of plugin can't be scrubbed on windows")
+	}
+	set := map[string]string{}
+	if cfg != nil {
+		for key, value := range cfg.Set {
+			set[key] = value
+		}
+	}
+	for key, value := range overwrites {
+		set[key] = value
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	allowed := map[string]struct{}{}
+	for _, key := range defaultPluginEnvs {
+		allowed[key] = struct{}{}
+	}
+	if cfg != nil {
+		for _, key := range cfg.Allow {
+			allowed[key] = struct{}{}
+		}
+	}
+	env, err := exec.LookPath("env")
+	if err != nil {
+		return errors.Wrapf(err, "failed to find env to start plugin")
+	}
+	path, err := filepath.Abs(cmd.Path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get path of plugin")
+	}
+	// removed marks the variables once, so each of them is removed once.
+	removed := map[string]struct{}{}
+	scrubbed := []string{}
+	args := []string{env}
+	for _, kv := range append(os.Environ(), cmd.Env...) {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if _, exists := allowed[key]; exists {
+			scrubbed = append(scrubbed, kv)
+			continue
+		}
+		if _, exists := removed[key]; !exists && key != "" {
+			args = append(args, "-u", key)
+			removed[key] = struct{}{}
+		}
+	}
+	for _, key := range keys {
+		scrubbed = append(scrubbed, key+"="+set[key])
+		args = append(args, key+"="+set[key])
+	}
+	cmd.Env = scrubbed
+	cmd.Path = env
+	cmd.Args = append(append(args, path), cmd.Args[1:]...)
+	return nil
+}
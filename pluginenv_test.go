@@ -0,0 +1,46 @@
+package treport_test
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestScrubPluginEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the environment can't be scrubbed on windows")
+	}
+	defer os.Unsetenv("TREPORT_TEST_SECRET")
+	defer os.Unsetenv("TREPORT_TEST_ALLOWED")
+	os.Setenv("TREPORT_TEST_SECRET", "secret")
+	os.Setenv("TREPORT_TEST_ALLOWED", "allowed")
+	cmd := exec.Command("sh", "-c", "env")
+	if err := treport.ScrubPluginEnv(cmd, &treport.PluginEnvConfig{
+		Allow: []string{"TREPORT_TEST_ALLOWED"},
+		Set:   map[string]string{"TREPORT_TEST_SET": "set"},
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, kv := range cmd.Env {
+		if strings.HasPrefix(kv, "TREPORT_TEST_SECRET=") {
+			t.Fatalf("environment of the command has %s", kv)
+		}
+	}
+	// go-plugin adds the environment of the host to the command.
+	cmd.Env = append(cmd.Env, os.Environ()...)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := string(out)
+	if strings.Contains(env, "TREPORT_TEST_SECRET") {
+		t.Fatalf("secret is passed to the plugin: %s", env)
+	}
+	if !strings.Contains(env, "TREPORT_TEST_ALLOWED=allowed") || !strings.Contains(env, "TREPORT_TEST_SET=set") {
+		t.Fatalf("allowed variables aren't passed to the plugin: %s", env)
+	}
+}
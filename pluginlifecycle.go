@@ -0,0 +1,109 @@
+package treport
+
+import (
+	"fmt"
+)
+
+// pluginLifecycle is the state of the plugin. The plugin is created by CreatePipelines for each repository and step
+// referencing it, set up once by Setup which acquires the process shared by the plugins of the run, scans the commits,
+// and is shut down once by Cleanup which releases the process. The plugin isn't set up again after Cleanup,
+// and it doesn't scan or read the cache after it, so the process and the cache it released are never used.
+type pluginLifecycle int
+
+const (
+	pluginCreated pluginLifecycle = iota
+	pluginReady
+	pluginShutdown
+)
+
+// Setup starts the plugin with args. It's safe for concurrent use, and the plugin set up already isn't set up again.
+// The plugins of the run set up with the same args share one process, which is started by the first of them.
+func (p *Plugin) Setup(args []string) error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	switch p.lifecycle {
+	case pluginReady:
+		// the plugin already runs the process, so setting it up again doesn't start another one.
+		if !equalArgs(p.Args, args) {
+			return fmt.Errorf("plugin %s is already set up with args %q", p.Name, p.Args)
+		}
+		return nil
+	case pluginShutdown:
+		return fmt.Errorf("plugin %s is already cleaned up", p.Name)
+	}
+	if p.setup == nil {
+		return fmt.Errorf("failed to find setup function for plugin %s", p.Name)
+	}
+	if err := p.setup(args); err != nil {
+		return err
+	}
+	p.Args = args
+	p.lifecycle = pluginReady
+	return nil
+}
+
+// Cleanup shuts down the plugin. It releases the process, which is put back to the pool or stopped
+// if no other plugin of the run uses it, and closes the cache. Calling it again does nothing.
+func (p *Plugin) Cleanup() {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	p.lifecycle = pluginShutdown
+	if p.Client != nil {
+		p.processes.release(p.processKey, p.Client)
+		p.Client = nil
+	}
+	if p.clonePath != "" {
+		releaseClone(p.clonePath)
+		p.clonePath = ""
+	}
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	p.cacheClosed = true
+	if p.cache != nil {
+		// the results are usually written at the end of the walk. if it fails here, they are scanned again by the next run.
+		_ = p.flushCacheLocked()
+		p.cache.Close()
+		p.cache = nil
+	}
+	if p.warmDB != nil {
+		p.warmDB.Close()
+		p.warmDB = nil
+	}
+}
+
+// client returns the client to scan the commits. It fails after Cleanup instead of using the process put back to the pool.
+func (p *Plugin) client() (*Client, error) {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	if p.lifecycle == pluginShutdown {
+		return nil, fmt.Errorf("plugin %s is already cleaned up", p.Name)
+	}
+	if p.Client == nil {
+		return nil, fmt.Errorf("plugin %s isn't set up", p.Name)
+	}
+	return p.Client, nil
+}
+
+// setupProcess sets the client of the process identified by key. The process is shared by the plugins of the run,
+// and start starts it if no plugin of the run uses it and the pool doesn't have it.
+func (p *Plugin) setupProcess(key string, start func() (*Client, error)) error {
+	client, err := p.processes.acquire(key, start)
+	if err != nil {
+		return err
+	}
+	p.processKey = key
+	p.Client = client
+	return nil
+}
+
+func equalArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,391 @@
+package treport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/goccy/treport/internal/errors"
+)
+
+type PluginKind string
+
+const (
+	PluginKindBuiltin PluginKind = "builtin"
+	PluginKindScanner PluginKind = "scanner"
+	PluginKindStorer  PluginKind = "storer"
+)
+
+// PluginStatus is the installation of the plugin bundled with treport or configured by plugin.scanner and plugin.storer.
+type PluginStatus struct {
+	Name string
+	Kind PluginKind
+	Repo string
+	// Rev is the revision the plugin is pinned to by the config.
+	Rev string
+	// Commit is the commit of the plugin repository. It's empty for the builtin plugins and the plugins not cloned yet.
+	Commit string
+	// Binary is the path of the plugin binary, and Installed reports whether it exists.
+	Binary    string
+	Installed bool
+	// Version is the number of the updates of the binary detected by the scans. Zero means it isn't used by the scan yet.
+	Version int
+	// Checksum is sha256 of the binary, and Recorded is the one recorded when the binary was built by treport.
+	Checksum string
+	Recorded string
+
+	repoCfg *RepositoryConfig
+}
+
+// Verify reports whether the binary is the one built by InstallPlugins or UpdatePlugins.
+func (s *PluginStatus) Verify() error {
+	if !s.Installed {
+		return ErrPluginNotInstalled(s.Name)
+	}
+	if s.Recorded == "" || s.Checksum != s.Recorded {
+		return ErrPluginChecksumMismatch(s.Name, s.Recorded, s.Checksum)
+	}
+	return nil
+}
+
+// pluginChecksumPath is the file of the checksums of the binaries built by treport. They are keyed by pluginChecksumKey.
+func (c *Config) pluginChecksumPath() string {
+	return filepath.Join(c.PluginPath(), "checksums.json")
+}
+
+// pluginBinaryPath returns the path of the binary of the plugin for the platform of the host.
+// The builtin plugins are installed to the same directory as the plugins configured by the repositories.
+func (c *Config) pluginBinaryPath(name string) string {
+	return filepath.Join(c.PluginPath(), "bin", platform(), executableName(name))
+}
+
+func pluginChecksumKey(name string) string {
+	return platform() + "/" + name
+}
+
+// ListPlugins returns the builtin plugins and the configured plugins without cloning or building them.
+// If names is empty, all plugins are returned.
+func ListPlugins(ctx context.Context, cfg *Config, names ...string) ([]*PluginStatus, error) {
+	return cfg.pluginStatuses(names)
+}
+
+// InstallPlugins clones the repositories of the plugins and builds the binaries, so the scan doesn't need to.
+// The scan runs the installed binaries, and installs the plugins not installed yet by itself.
+// The installed plugins are built again. If names is empty, all plugins are installed.
+// The plugins are built under the lock of the mount paths, so the scans of the other processes don't run the binaries being built.
+func InstallPlugins(ctx context.Context, cfg *Config, names ...string) ([]*PluginStatus, error) {
+	return cfg.buildPlugins(ctx, names, false)
+}
+
+// UpdatePlugins syncs the repositories of the plugins to the latest commit of the branch, or to the rev if it's pinned,
+// and builds the binaries again. If names is empty, all plugins are updated.
+func UpdatePlugins(ctx context.Context, cfg *Config, names ...string) ([]*PluginStatus, error) {
+	return cfg.buildPlugins(ctx, names, true)
+}
+
+// installedPluginBinary returns the binary of the plugin for the platform of the host.
+// The plugin not installed yet is installed as InstallPlugins does, but the version DB opened by the scan isn't read.
+func (c *Config) installedPluginBinary(ctx context.Context, name string) (string, error) {
+	binary := c.pluginBinaryPath(name)
+	if existsPath(binary) {
+		return binary, nil
+	}
+	if err := c.installPlugins(ctx, []string{name}, false); err != nil {
+		return "", errors.Wrapf(err, "failed to install plugin %s", name)
+	}
+	return binary, nil
+}
+
+func (c *Config) pluginStatuses(names []string) ([]*PluginStatus, error) {
+	selected, err := c.configuredPlugins(names)
+	if err != nil {
+		return nil, err
+	}
+	checksums, err := c.readPluginChecksums()
+	if err != nil {
+		return nil, err
+	}
+	versionDB, err := c.readOnlyPluginVersionDB()
+	if err != nil {
+		return nil, err
+	}
+	if versionDB != nil {
+		defer versionDB.Close()
+	}
+	for _, status := range selected {
+		if err := c.loadPluginStatus(status, checksums, versionDB); err != nil {
+			return nil, err
+		}
+	}
+	return selected, nil
+}
+
+// configuredPlugins returns the statuses of the plugins selected by names without loading their installations.
+func (c *Config) configuredPlugins(names []string) ([]*PluginStatus, error) {
+	statuses := []*PluginStatus{}
+	for _, name := range BuiltinPluginNames {
+		statuses = append(statuses, &PluginStatus{
+			Name:   name,
+			Kind:   PluginKindBuiltin,
+			Binary: c.pluginBinaryPath(name),
+		})
+	}
+	if c.Plugin != nil {
+		for _, kind := range []PluginKind{PluginKindScanner, PluginKindStorer} {
+			repoCfgs := c.Plugin.Scanner
+			if kind == PluginKindStorer {
+				repoCfgs = c.Plugin.Storer
+			}
+			for _, repoCfg := range repoCfgs {
+				if IsBuiltinPlugin(repoCfg.Name) {
+					continue
+				}
+				statuses = append(statuses, &PluginStatus{
+					Name:    repoCfg.Name,
+					Kind:    kind,
+					Repo:    repoCfg.Repo,
+					Rev:     repoCfg.Rev,
+					Binary:  c.pluginBinaryPath(repoCfg.Name),
+					repoCfg: repoCfg,
+				})
+			}
+		}
+	}
+	return selectPlugins(statuses, names)
+}
+
+func selectPlugins(statuses []*PluginStatus, names []string) ([]*PluginStatus, error) {
+	if len(names) == 0 {
+		return statuses, nil
+	}
+	selected := make([]*PluginStatus, 0, len(names))
+	for _, name := range names {
+		var found *PluginStatus
+		for _, status := range statuses {
+			if status.Name == name {
+				found = status
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("failed to find plugin %s", name)
+		}
+		selected = append(selected, found)
+	}
+	return selected, nil
+}
+
+func (c *Config) loadPluginStatus(status *PluginStatus, checksums map[string]string, versionDB *PluginVersionDB) error {
+	status.Recorded = checksums[pluginChecksumKey(status.Name)]
+	if existsPath(status.Binary) {
+		checksum, err := fileChecksum(status.Binary)
+		if err != nil {
+			return err
+		}
+		status.Installed = true
+		status.Checksum = checksum
+	}
+	if versionDB != nil {
+		ver, err := versionDB.readVersion(status.Name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read plugin version")
+		}
+		if ver != nil {
+			status.Version = ver.Version
+		}
+	}
+	if status.repoCfg == nil {
+		return nil
+	}
+	path, err := c.pluginSourcePath(status.repoCfg)
+	if err != nil {
+		return err
+	}
+	if !existsPath(path) {
+		return nil
+	}
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open repository of plugin %s", status.Name)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		if err == plumbing.ErrReferenceNotFound {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to get HEAD of plugin %s", status.Name)
+	}
+	status.Commit = head.Hash().String()
+	return nil
+}
+
+// pluginSourcePath returns the directory of the source of the plugin, which is the clone or the local repository.
+func (c *Config) pluginSourcePath(repoCfg *RepositoryConfig) (string, error) {
+	if repoCfg.IsLocal() {
+		return repoCfg.Path, nil
+	}
+	return repositoryPath(c.RepoPath(), repoCfg)
+}
+
+func (c *Config) buildPlugins(ctx context.Context, names []string, update bool) ([]*PluginStatus, error) {
+	if err := c.installPlugins(ctx, names, update); err != nil {
+		return nil, err
+	}
+	return c.pluginStatuses(names)
+}
+
+// installPlugins builds the binaries of the plugins, and records their checksums.
+// The builtin plugins are built from the source bundled with treport, and the others from their repositories.
+func (c *Config) installPlugins(ctx context.Context, names []string, update bool) error {
+	unlock, err := c.lockMounts(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	statuses, err := c.configuredPlugins(names)
+	if err != nil {
+		return err
+	}
+	checksums, err := c.readPluginChecksums()
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dir := builtinPluginSourcePath(status.Name)
+		if status.repoCfg != nil {
+			dir, err = c.checkoutPlugin(ctx, status, update)
+			if err != nil {
+				return err
+			}
+		}
+		if err := buildPlugin(ctx, status.Name, dir, status.Binary); err != nil {
+			return err
+		}
+	}
+	return c.recordPluginChecksums(statuses, checksums)
+}
+
+// checkoutPlugin clones the repository of the plugin, and returns the directory to build.
+// If update is true, the clone is synced to the latest commit of the branch. The pinned plugin is checked out at the rev.
+func (c *Config) checkoutPlugin(ctx context.Context, status *PluginStatus, update bool) (string, error) {
+	repoCfg := status.repoCfg
+	if repoCfg.IsLocal() {
+		return repoCfg.Path, nil
+	}
+	if repoCfg.isBare() || repoCfg.Storage == StorageMemory {
+		return "", fmt.Errorf("plugin %s requires the repository with the worktree to build", status.Name)
+	}
+	repo, err := NewRepository(ctx, c.RepoPath(), repoCfg)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to clone repository of plugin %s", status.Name)
+	}
+	if update {
+		if err := repo.syncRemoteBranches(ctx); err != nil {
+			return "", errors.Wrapf(err, "failed to sync repository of plugin %s", status.Name)
+		}
+		branch, err := repo.BaseBranch()
+		if err != nil {
+			return "", errors.Stack(err)
+		}
+		if err := repo.Sync(ctx, branch.Merge); err != nil {
+			return "", errors.Wrapf(err, "failed to sync repository of plugin %s", status.Name)
+		}
+	}
+	if repoCfg.Rev != "" {
+		if err := repo.checkoutRev(repoCfg.Rev); err != nil {
+			return "", errors.Wrapf(err, "failed to checkout %s of plugin %s", repoCfg.Rev, status.Name)
+		}
+	}
+	return repo.path, nil
+}
+
+// checkoutRev checks out the worktree at the revision, such as the tag or the commit hash.
+func (r *Repository) checkoutRev(rev string) error {
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Checkout(&git.CheckoutOptions{Hash: *hash})
+}
+
+// buildPlugin builds the main package in dir to the binary.
+func buildPlugin(ctx context.Context, name, dir, binary string) error {
+	output, err := filepath.Abs(binary)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get path of plugin %s", name)
+	}
+	if err := mkdirIfNotExists(filepath.Dir(output)); err != nil {
+		return errors.Wrapf(err, "failed to create directory for plugin %s", name)
+	}
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", output, ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to build plugin %s: %s", name, out)
+	}
+	return nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", errors.Wrapf(err, "failed to read %s", path)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func (c *Config) readPluginChecksums() (map[string]string, error) {
+	checksums := map[string]string{}
+	b, err := ioutil.ReadFile(c.pluginChecksumPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return checksums, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read plugin checksums")
+	}
+	if err := json.Unmarshal(b, &checksums); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode plugin checksums")
+	}
+	return checksums, nil
+}
+
+// recordPluginChecksums records the checksums of the built binaries with the ones of the other plugins.
+func (c *Config) recordPluginChecksums(built []*PluginStatus, checksums map[string]string) error {
+	for _, status := range built {
+		checksum, err := fileChecksum(status.Binary)
+		if err != nil {
+			return err
+		}
+		checksums[pluginChecksumKey(status.Name)] = checksum
+	}
+	b, err := json.MarshalIndent(checksums, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode plugin checksums")
+	}
+	if err := mkdirIfNotExists(c.PluginPath()); err != nil {
+		return errors.Wrapf(err, "failed to create directory for plugin")
+	}
+	if err := ioutil.WriteFile(c.pluginChecksumPath(), b, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write plugin checksums")
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package treport
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const defaultPluginIdleTimeout = 10 * time.Minute
+
+// KeepaliveConfig checks the plugin processes and keeps them between the scans of daemon mode.
+type KeepaliveConfig struct {
+	// Interval is the period of the health checks like 30s. The plugin which doesn't respond within it is killed
+	// and started again by the next call. The health checks are disabled by default.
+	Interval string `yaml:"interval"`
+	// IdleTimeout stops the plugin kept by daemon mode after it's unused for the duration like 10m ( default ).
+	IdleTimeout string `yaml:"idleTimeout"`
+}
+
+func (c *PluginConfig) keepaliveConfig() *KeepaliveConfig {
+	if c == nil {
+		return nil
+	}
+	return c.Keepalive
+}
+
+func (c *KeepaliveConfig) interval() time.Duration {
+	if c == nil || c.Interval == "" {
+		return 0
+	}
+	interval, _ := time.ParseDuration(c.Interval)
+	return interval
+}
+
+func (c *KeepaliveConfig) idleTimeout() time.Duration {
+	if c == nil || c.IdleTimeout == "" {
+		return defaultPluginIdleTimeout
+	}
+	timeout, err := time.ParseDuration(c.IdleTimeout)
+	if err != nil {
+		return defaultPluginIdleTimeout
+	}
+	return timeout
+}
+
+// pluginPool keeps the plugins set up by the scans of daemon mode, so the next scan reuses the running processes
+// instead of starting them again. The plugins unused for idleTimeout are stopped.
+type pluginPool struct {
+	mu          sync.Mutex
+	idleTimeout time.Duration
+	idle        map[string][]*idlePlugin
+	closed      bool
+}
+
+type idlePlugin struct {
+	client *Client
+	timer  *time.Timer
+}
+
+func newPluginPool(idleTimeout time.Duration) *pluginPool {
+	return &pluginPool{idleTimeout: idleTimeout, idle: map[string][]*idlePlugin{}}
+}
+
+// pluginProcessKey identifies the plugin processes which are interchangeable.
+func pluginProcessKey(pluginName string, args []string, grpcCfg *GRPCConfig, envCfg *PluginEnvConfig, sandboxCfg *SandboxConfig) string {
+	b, _ := json.Marshal(struct {
+		Name    string
+		Args    []string
+		GRPC    *GRPCConfig
+		Env     *PluginEnvConfig
+		Sandbox *SandboxConfig
+	}{pluginName, args, grpcCfg, envCfg, sandboxCfg})
+	return string(b)
+}
+
+// get returns the idle client of the key, or nil if there is none. The client whose binary is replaced is stopped.
+func (p *pluginPool) get(key string) *Client {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for list := p.idle[key]; len(list) > 0; list = p.idle[key] {
+		plg := list[len(list)-1]
+		p.idle[key] = list[:len(list)-1]
+		plg.timer.Stop()
+		if plg.client.isUpdated() {
+			plg.client.Stop()
+			continue
+		}
+		return plg.client
+	}
+	return nil
+}
+
+// put keeps the client until it's got again or idleTimeout elapses. The client is stopped if the pool is nil or closed.
+func (p *pluginPool) put(key string, client *Client) {
+	if p == nil {
+		client.Stop()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		client.Stop()
+		return
+	}
+	plg := &idlePlugin{client: client}
+	plg.timer = time.AfterFunc(p.idleTimeout, func() {
+		p.expire(key, plg)
+	})
+	p.idle[key] = append(p.idle[key], plg)
+}
+
+func (p *pluginPool) expire(key string, plg *idlePlugin) {
+	p.mu.Lock()
+	list := p.idle[key]
+	expired := false
+	for i, idle := range list {
+		if idle == plg {
+			p.idle[key] = append(list[:i:i], list[i+1:]...)
+			expired = true
+			break
+		}
+	}
+	p.mu.Unlock()
+	if expired {
+		plg.client.Stop()
+	}
+}
+
+// close stops all idle clients, and the clients put after it.
+func (p *pluginPool) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = map[string][]*idlePlugin{}
+	p.mu.Unlock()
+	for _, list := range idle {
+		for _, plg := range list {
+			plg.timer.Stop()
+			plg.client.Stop()
+		}
+	}
+}
+
+// pluginProcesses shares the plugin processes between the plugins of a run. CreatePipelines creates the plugin
+// for each repository and step referencing it, and the ones set up with the same key use one process.
+// The process is put back to the pool, or stopped, by the Cleanup of the last plugin using it.
+type pluginProcesses struct {
+	mu      sync.Mutex
+	pool    *pluginPool
+	running map[string]*sharedProcess
+}
+
+type sharedProcess struct {
+	client *Client
+	refs   int
+}
+
+func newPluginProcesses(pool *pluginPool) *pluginProcesses {
+	return &pluginProcesses{pool: pool, running: map[string]*sharedProcess{}}
+}
+
+// acquire returns the process of the key running in the run. It's got from the pool or started by start
+// if no plugin of the run uses it.
+func (p *pluginProcesses) acquire(key string, start func() (*Client, error)) (*Client, error) {
+	if p == nil {
+		return start()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if proc, exists := p.running[key]; exists {
+		proc.refs++
+		return proc.client, nil
+	}
+	client := p.pool.get(key)
+	if client == nil {
+		c, err := start()
+		if err != nil {
+			return nil, err
+		}
+		client = c
+	}
+	p.running[key] = &sharedProcess{client: client, refs: 1}
+	return client, nil
+}
+
+// release releases the process acquired by the plugin, and puts it back to the pool if no other plugin uses it.
+func (p *pluginProcesses) release(key string, client *Client) {
+	if p == nil {
+		client.Stop()
+		return
+	}
+	p.mu.Lock()
+	proc, exists := p.running[key]
+	if exists && proc.client == client {
+		proc.refs--
+		if proc.refs > 0 {
+			p.mu.Unlock()
+			return
+		}
+		delete(p.running, key)
+	}
+	p.mu.Unlock()
+	p.pool.put(key, client)
+}
+
+type pluginPoolKey struct{}
+
+// withPluginPool returns the context whose plugins set up by CreatePipelines are got from the pool and put back by Cleanup.
+func withPluginPool(ctx context.Context, pool *pluginPool) context.Context {
+	if pool == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, pluginPoolKey{}, pool)
+}
+
+func pluginPoolFrom(ctx context.Context) *pluginPool {
+	pool, _ := ctx.Value(pluginPoolKey{}).(*pluginPool)
+	return pool
+}
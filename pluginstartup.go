@@ -0,0 +1,88 @@
+package treport
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPluginHandshakeTimeout = time.Minute
+	defaultPluginProtocolTimeout  = time.Minute
+	// pluginStderrTailSize is the bytes of stderr of the plugin kept to report why the plugin failed to start.
+	pluginStderrTailSize = 4096
+)
+
+// StartupConfig limits the time to start the plugins, so the plugin hanging on startup doesn't block the pipelines forever.
+type StartupConfig struct {
+	// HandshakeTimeout is the time to wait for the plugin process to write the handshake like 30s. It's 1m by default.
+	HandshakeTimeout string `yaml:"handshakeTimeout"`
+	// ProtocolTimeout is the time to wait for the plugin to respond to the first request after the handshake. It's 1m by default.
+	ProtocolTimeout string `yaml:"protocolTimeout"`
+}
+
+func (c *PluginConfig) startupConfig() *StartupConfig {
+	if c == nil {
+		return nil
+	}
+	return c.Startup
+}
+
+func (c *StartupConfig) handshakeTimeout() time.Duration {
+	if c == nil {
+		return defaultPluginHandshakeTimeout
+	}
+	return parseTimeout(c.HandshakeTimeout, defaultPluginHandshakeTimeout)
+}
+
+func (c *StartupConfig) protocolTimeout() time.Duration {
+	if c == nil {
+		return defaultPluginProtocolTimeout
+	}
+	return parseTimeout(c.ProtocolTimeout, defaultPluginProtocolTimeout)
+}
+
+func parseTimeout(timeout string, defaultTimeout time.Duration) time.Duration {
+	if timeout == "" {
+		return defaultTimeout
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return defaultTimeout
+	}
+	return d
+}
+
+// stderrTail keeps the last bytes written to stderr by the plugin process.
+type stderrTail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > pluginStderrTailSize {
+		t.buf = append([]byte{}, t.buf[len(t.buf)-pluginStderrTailSize:]...)
+	}
+	return len(p), nil
+}
+
+func (t *stderrTail) String() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.TrimSpace(string(t.buf))
+}
+
+// suffix returns stderr of the plugin appended to the error message. The process must be killed before it,
+// so all output of the process is written.
+func (t *stderrTail) suffix() string {
+	if out := t.String(); out != "" {
+		return "\nstderr of plugin:\n" + out
+	}
+	return ""
+}
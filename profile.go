@@ -0,0 +1,162 @@
+package treport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// slowestCommitsLimit is the number of the slowest commits kept in ScanSummary.
+const slowestCommitsLimit = 10
+
+// ScanTiming is the breakdown of the time taken by the plugin for a commit.
+type ScanTiming struct {
+	// Scan is the time of Scan RPC of the plugin including the retries.
+	Scan time.Duration
+	// Serialize is the time to convert the scan context to the request and to encode and decode the cached results.
+	Serialize time.Duration
+	// Cache is the time to read and write the cache DB including the results of the dependency plugins.
+	Cache time.Duration
+}
+
+func (t *ScanTiming) addScan(start time.Time) {
+	if t != nil {
+		t.Scan += time.Since(start)
+	}
+}
+
+func (t *ScanTiming) addSerialize(start time.Time) {
+	if t != nil {
+		t.Serialize += time.Since(start)
+	}
+}
+
+func (t *ScanTiming) addCache(start time.Time) {
+	if t != nil {
+		t.Cache += time.Since(start)
+	}
+}
+
+func (t *ScanTiming) total() time.Duration {
+	return t.Scan + t.Serialize + t.Cache
+}
+
+// CommitProfile is the time taken by the plugin for the commit.
+type CommitProfile struct {
+	Pipeline string
+	Repo     string
+	Branch   string
+	Plugin   string
+	Commit   string
+	Timing   ScanTiming
+}
+
+// PluginProfile is the cumulative time taken by the plugin for the repository.
+type PluginProfile struct {
+	Pipeline string
+	Repo     string
+	Branch   string
+	Plugin   string
+	Commits  int
+	Timing   ScanTiming
+}
+
+// recordTiming adds the timing of the commit to the plugin, and keeps the slowest commits.
+func (s *ScanSummary) recordTiming(ev *ProgressEvent, plg *PluginSummary) {
+	if ev.Timing == nil {
+		return
+	}
+	plg.ScanTime += ev.Timing.Scan
+	plg.SerializeTime += ev.Timing.Serialize
+	plg.CacheTime += ev.Timing.Cache
+	if ev.CacheHit {
+		return
+	}
+	total := ev.Timing.total()
+	if len(s.SlowestCommits) == slowestCommitsLimit && total <= s.SlowestCommits[len(s.SlowestCommits)-1].Timing.total() {
+		return
+	}
+	commit := &CommitProfile{
+		Pipeline: ev.Pipeline,
+		Repo:     ev.Repo,
+		Branch:   ev.Branch,
+		Plugin:   ev.Plugin,
+		Commit:   ev.Commit,
+		Timing:   *ev.Timing,
+	}
+	idx := sort.Search(len(s.SlowestCommits), func(i int) bool {
+		return s.SlowestCommits[i].Timing.total() < total
+	})
+	s.SlowestCommits = append(s.SlowestCommits, nil)
+	copy(s.SlowestCommits[idx+1:], s.SlowestCommits[idx:])
+	s.SlowestCommits[idx] = commit
+	if len(s.SlowestCommits) > slowestCommitsLimit {
+		s.SlowestCommits = s.SlowestCommits[:slowestCommitsLimit]
+	}
+}
+
+// SlowestPlugins returns the plugins of all repositories in descending order of the total time.
+func (s *ScanSummary) SlowestPlugins() []*PluginProfile {
+	plugins := []*PluginProfile{}
+	for _, pipeline := range s.Pipelines {
+		for _, repo := range pipeline.Repos {
+			for _, plg := range repo.Plugins {
+				plugins = append(plugins, &PluginProfile{
+					Pipeline: pipeline.Name,
+					Repo:     repo.Repo,
+					Branch:   repo.Branch,
+					Plugin:   plg.Name,
+					Commits:  plg.Commits,
+					Timing: ScanTiming{
+						Scan:      plg.ScanTime,
+						Serialize: plg.SerializeTime,
+						Cache:     plg.CacheTime,
+					},
+				})
+			}
+		}
+	}
+	sort.SliceStable(plugins, func(i, j int) bool {
+		return plugins[i].Timing.total() > plugins[j].Timing.total()
+	})
+	return plugins
+}
+
+// Profile returns the breakdown of the slowest plugins and the slowest commits.
+func (s *ScanSummary) Profile() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "slowest plugins:")
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PIPELINE\tREPOSITORY\tPLUGIN\tCOMMITS\tTOTAL\tSCAN\tSERIALIZE\tCACHE\tAVERAGE")
+	for _, plg := range s.SlowestPlugins() {
+		var avg time.Duration
+		if plg.Commits > 0 {
+			avg = plg.Timing.total() / time.Duration(plg.Commits)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			plg.Pipeline, repoName(plg.Repo, plg.Branch), plg.Plugin, plg.Commits,
+			plg.Timing.total(), plg.Timing.Scan, plg.Timing.Serialize, plg.Timing.Cache, avg,
+		)
+	}
+	w.Flush()
+	fmt.Fprintln(&b, "slowest commits:")
+	w = tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PIPELINE\tREPOSITORY\tPLUGIN\tCOMMIT\tTOTAL\tSCAN\tSERIALIZE\tCACHE")
+	for _, commit := range s.SlowestCommits {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			commit.Pipeline, repoName(commit.Repo, commit.Branch), commit.Plugin, commit.Commit,
+			commit.Timing.total(), commit.Timing.Scan, commit.Timing.Serialize, commit.Timing.Cache,
+		)
+	}
+	w.Flush()
+	return b.String()
+}
+
+func repoName(repo, branch string) string {
+	if branch == "" {
+		return repo
+	}
+	return repo + "@" + branch
+}
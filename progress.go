@@ -0,0 +1,324 @@
+package treport
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+type ProgressEventType string
+
+const (
+	// ProgressClone reports the progress of the clone. Clone has the details.
+	ProgressClone ProgressEventType = "clone"
+	// ProgressSynced is sent by Scanner.Prepare for each repository whose base branch is synced. Elapsed is the time to sync it.
+	ProgressSynced ProgressEventType = "synced"
+	// ProgressPipelineStarted and ProgressPipelineFinished are sent for each pipeline. Err is set if the pipeline failed.
+	ProgressPipelineStarted  ProgressEventType = "pipelineStarted"
+	ProgressPipelineFinished ProgressEventType = "pipelineFinished"
+	// ProgressPluginStarted and ProgressPluginFinished are sent for each plugin of the repository.
+	// Elapsed of ProgressPluginFinished is the time to scan all commits by the plugin.
+	ProgressPluginStarted  ProgressEventType = "pluginStarted"
+	ProgressPluginFinished ProgressEventType = "pluginFinished"
+	// ProgressPluginFailed is sent before ProgressPluginFinished if the plugin failed. Err has the failure.
+	ProgressPluginFailed ProgressEventType = "pluginFailed"
+	// ProgressCommitScanned is sent for each commit scanned by the plugin.
+	// Scanned and Total are the number of commits the plugin scanned so far and will scan in the repository.
+	// Elapsed is the time taken by the plugin, and it's zero if the result is restored from cache.
+	// Timing has the breakdown of the time, and Result has the result of the plugin for the commit.
+	ProgressCommitScanned ProgressEventType = "commitScanned"
+)
+
+// ProgressEvent is the progress of the scan reported to the callback of Scanner.OnProgress and the handlers of Scanner.Subscribe.
+type ProgressEvent struct {
+	Type     ProgressEventType
+	Time     time.Time
+	Pipeline string
+	Repo     string
+	Branch   string
+	Plugin   string
+	Commit   string
+	Scanned  int
+	Total    int
+	CacheHit bool
+	Elapsed  time.Duration
+	Timing   *ScanTiming
+	Result   *Result
+	Clone    *CloneProgress
+	Err      error
+}
+
+// OnProgress registers the callback to receive the progress of the scan.
+// Events are delivered one by one, so fn must return quickly not to block the scan.
+func (s *Scanner) OnProgress(fn func(*ProgressEvent)) {
+	s.progress = fn
+}
+
+// Subscribe registers the handler of the events of the scan, and returns the function to unsubscribe it.
+// Any number of handlers can be registered, and each event is delivered to them in the order of the registration.
+// Like OnProgress, the handler must return quickly not to block the scan.
+func (s *Scanner) Subscribe(handler func(*ProgressEvent)) func() {
+	return s.subscribeTo(s.subscribers, handler)
+}
+
+// SubscribeOrdered registers the handler of the events of the repositories, and returns the function to unsubscribe it.
+// Unlike Subscribe, the events of each repository are buffered until the repository is scanned, and the repositories are
+// delivered in the order of the config even if they're scanned concurrently, so the output of the handler is diffable.
+// The events of each repository are delivered in the order of ProgressPluginStarted, ProgressCommitScanned of each commit
+// for the plugins in the order of the steps, ProgressPluginFailed and ProgressPluginFinished.
+// The events which aren't of a repository of a pipeline, like ProgressPipelineStarted, aren't delivered.
+func (s *Scanner) SubscribeOrdered(handler func(*ProgressEvent)) func() {
+	return s.subscribeTo(s.orderedSubscribers, handler)
+}
+
+func (s *Scanner) subscribeTo(subs *subscribers, handler func(*ProgressEvent)) func() {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	subs.lastID++
+	id := subs.lastID
+	subs.handlers = append(subs.handlers, &subscriber{id: id, handler: handler})
+	return func() {
+		subs.mu.Lock()
+		defer subs.mu.Unlock()
+		for i, sub := range subs.handlers {
+			if sub.id == id {
+				subs.handlers = append(subs.handlers[:i:i], subs.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+type subscriber struct {
+	id      int
+	handler func(*ProgressEvent)
+}
+
+type subscribers struct {
+	mu       sync.Mutex
+	lastID   int
+	handlers []*subscriber
+}
+
+// list returns the copy of the handlers, so the handler can unsubscribe while the event is delivered.
+func (s *subscribers) list() []*subscriber {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*subscriber{}, s.handlers...)
+}
+
+func (s *Scanner) emit(ev *ProgressEvent) {
+	ev.Time = time.Now()
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	if s.summary != nil {
+		s.summary.record(ev)
+	}
+	for _, sub := range s.subscribers.list() {
+		sub.handler(ev)
+	}
+	if s.ordered != nil && s.ordered.buffer(ev) {
+		s.ordered.flush(s.orderedSubscribers)
+	}
+	if s.progress == nil {
+		return
+	}
+	s.progress(ev)
+}
+
+// repoScanned delivers the buffered events of the repository to the ordered subscribers if the repositories before it are delivered.
+func (s *Scanner) repoScanned(repo *PipelineRepository) {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	if s.ordered == nil {
+		return
+	}
+	if stream, exists := s.ordered.streams[repo]; exists {
+		stream.done = true
+	}
+	s.ordered.flush(s.orderedSubscribers)
+}
+
+// finishOrdered delivers the events of all repositories including the ones which aren't scanned by the failure of the pipeline.
+func (s *Scanner) finishOrdered() {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	if s.ordered == nil {
+		return
+	}
+	for _, stream := range s.ordered.order {
+		stream.done = true
+	}
+	s.ordered.flush(s.orderedSubscribers)
+	s.ordered = nil
+}
+
+// orderedDelivery buffers the events of each repository for the ordered subscribers.
+type orderedDelivery struct {
+	order   []*orderedStream
+	streams map[*PipelineRepository]*orderedStream
+	keys    map[orderedStreamKey]*orderedStream
+	next    int
+}
+
+type orderedStreamKey struct {
+	pipeline string
+	repo     string
+	branch   string
+}
+
+type orderedStream struct {
+	plugins map[string]int
+	events  []*orderedEvent
+	seqs    map[string]int
+	done    bool
+}
+
+type orderedEvent struct {
+	ev     *ProgressEvent
+	phase  int
+	seq    int
+	plugin int
+}
+
+func newOrderedDelivery(pipelines []*Pipeline) *orderedDelivery {
+	d := &orderedDelivery{
+		streams: map[*PipelineRepository]*orderedStream{},
+		keys:    map[orderedStreamKey]*orderedStream{},
+	}
+	for _, pipeline := range pipelines {
+		for _, repo := range pipeline.Repos {
+			stream := &orderedStream{plugins: map[string]int{}, seqs: map[string]int{}}
+			for _, step := range repo.Steps {
+				for _, plg := range step.Plugins {
+					if _, exists := stream.plugins[plg.Name]; !exists {
+						stream.plugins[plg.Name] = len(stream.plugins)
+					}
+				}
+			}
+			d.order = append(d.order, stream)
+			d.streams[repo] = stream
+			d.keys[orderedStreamKey{pipeline: pipeline.Config.Name, repo: repo.cfg.Repo, branch: repo.scanBranch}] = stream
+		}
+	}
+	return d
+}
+
+// buffer keeps the event of the repository, and reports whether it's buffered.
+func (d *orderedDelivery) buffer(ev *ProgressEvent) bool {
+	stream, exists := d.keys[orderedStreamKey{pipeline: ev.Pipeline, repo: ev.Repo, branch: ev.Branch}]
+	if !exists {
+		return false
+	}
+	e := &orderedEvent{ev: ev, plugin: stream.plugins[ev.Plugin]}
+	switch ev.Type {
+	case ProgressPluginStarted:
+		e.phase = 0
+	case ProgressCommitScanned:
+		// the commits are ordered by the position in the walk of each plugin,
+		// because Scanned starts over if the plugin walks the commits again.
+		e.phase = 1
+		stream.seqs[ev.Plugin]++
+		e.seq = stream.seqs[ev.Plugin]
+	case ProgressPluginFailed:
+		e.phase = 2
+	default:
+		e.phase = 3
+	}
+	stream.events = append(stream.events, e)
+	return true
+}
+
+// flush delivers the events of the scanned repositories until the repository which is still scanned.
+func (d *orderedDelivery) flush(subs *subscribers) {
+	for ; d.next < len(d.order) && d.order[d.next].done; d.next++ {
+		stream := d.order[d.next]
+		events := stream.events
+		stream.events = nil
+		sort.SliceStable(events, func(i, j int) bool {
+			if events[i].phase != events[j].phase {
+				return events[i].phase < events[j].phase
+			}
+			if events[i].seq != events[j].seq {
+				return events[i].seq < events[j].seq
+			}
+			return events[i].plugin < events[j].plugin
+		})
+		handlers := subs.list()
+		for _, e := range events {
+			for _, sub := range handlers {
+				sub.handler(e.ev)
+			}
+		}
+	}
+}
+
+// pluginProgress counts the commits scanned by the plugin in the repository.
+type pluginProgress struct {
+	scanner  *Scanner
+	pipeline string
+	repo     string
+	branch   string
+	plugin   string
+	clock    Clock
+	scanned  int
+	total    int
+}
+
+func (p *pluginProgress) started(total int) {
+	p.scanned = 0
+	p.total = total
+}
+
+func (p *pluginProgress) commitScanned(scanctx *ScanContext, cacheHit bool, elapsed time.Duration, timing *ScanTiming) {
+	p.scanned++
+	var result *Result
+	if res, exists := scanctx.pluginResponse(p.plugin); exists {
+		src := &resultSource{pipeline: p.pipeline, repoID: res.RepositoryId, repo: p.repo, branch: p.branch, plugin: p.plugin, clock: p.clock}
+		result = newResult(src, scanctx.Commit.Hash, res)
+	}
+	p.scanner.emit(&ProgressEvent{
+		Type:     ProgressCommitScanned,
+		Pipeline: p.pipeline,
+		Repo:     p.repo,
+		Branch:   p.branch,
+		Plugin:   p.plugin,
+		Commit:   scanctx.Commit.Hash,
+		Scanned:  p.scanned,
+		Total:    p.total,
+		CacheHit: cacheHit,
+		Elapsed:  elapsed,
+		Timing:   timing,
+		Result:   result,
+	})
+}
+
+// ResultWriter writes the result of each commit and plugin as a line of JSON while the scan runs.
+// The line is the same as the element of the results of treport report.
+type ResultWriter struct {
+	enc *json.Encoder
+	err error
+}
+
+func NewResultWriter(w io.Writer) *ResultWriter {
+	return &ResultWriter{enc: json.NewEncoder(w)}
+}
+
+// Handle is the handler of Scanner.Subscribe. The events are delivered one by one, so it isn't called concurrently.
+func (w *ResultWriter) Handle(ev *ProgressEvent) {
+	if ev.Type != ProgressCommitScanned || ev.Result == nil || w.err != nil {
+		return
+	}
+	if err := w.enc.Encode(ev.Result); err != nil {
+		w.err = errors.Wrapf(err, "failed to write result of %s", ev.Commit)
+	}
+}
+
+// Err returns the first error to write the results. The results after the error aren't written.
+func (w *ResultWriter) Err() error {
+	return w.err
+}
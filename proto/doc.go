@@ -0,0 +1,15 @@
+// Package proto is the wire contract between treport, its plugins and the other hosts.
+//
+// scanner.proto is the protocol of the plugins. The ScanResponse returned by the plugins is stored to the caches as it is,
+// so the tools reading the caches decode the values by ScanResponse, and the data by the descriptors of the plugins.
+// control.proto is the protocol of the daemon.
+//
+// It's the separate module versioned by the tags of proto/vX.Y.Z, so the external tools don't depend on treport itself.
+// The versioning is by the module tags only: the protobuf package stays `proto` without the version suffix,
+// because it's a part of the gRPC service names the running plugins are built with.
+// The changes within ProtocolVersion are compatible: the fields and the RPCs are only added, and the removed ones are reserved.
+// The incompatible changes increment ProtocolVersion, and the hosts don't run the plugins of the other versions.
+package proto
+
+// ProtocolVersion is the version of the protocol negotiated by the hosts and the plugins on the handshake.
+const ProtocolVersion = 1
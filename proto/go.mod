@@ -0,0 +1,11 @@
+module github.com/goccy/treport/proto
+
+go 1.15
+
+require (
+	github.com/golang/protobuf v1.5.2
+	golang.org/x/net v0.0.0-20210326060303-6b1517762897 // indirect
+	golang.org/x/sys v0.0.0-20210324051608-47abb6519492 // indirect
+	google.golang.org/grpc v1.37.0
+	google.golang.org/protobuf v1.26.0
+)
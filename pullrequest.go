@@ -0,0 +1,421 @@
+package treport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/goccy/treport/internal/errors"
+)
+
+type PullRequestProvider string
+
+const (
+	// PullRequestRefs detects the merge commits of the pull request heads fetched from the refs of the remote.
+	PullRequestRefs PullRequestProvider = "refs"
+	// PullRequestGitHub detects the commits merged pull requests by GitHub API.
+	PullRequestGitHub PullRequestProvider = "github"
+	// PullRequestGitLab detects the commits merged merge requests by GitLab API.
+	PullRequestGitLab PullRequestProvider = "gitlab"
+)
+
+// PullRequestRefStyle is the convention of the refs of the pull request heads in the remote.
+type PullRequestRefStyle string
+
+const (
+	// GitHubRefs is refs/pull/<number>/head.
+	GitHubRefs PullRequestRefStyle = "github"
+	// GitLabRefs is refs/merge-requests/<number>/head.
+	GitLabRefs PullRequestRefStyle = "gitlab"
+	// BitbucketRefs is refs/pull-requests/<number>/from of Bitbucket Server.
+	BitbucketRefs PullRequestRefStyle = "bitbucket"
+	// GiteaRefs is refs/pull/<number>/head as GitHub.
+	GiteaRefs PullRequestRefStyle = "gitea"
+)
+
+var pullRequestHeadRefs = map[PullRequestRefStyle][]string{
+	GitHubRefs:    {"refs/pull/*/head"},
+	GitLabRefs:    {"refs/merge-requests/*/head"},
+	BitbucketRefs: {"refs/pull-requests/*/from"},
+	GiteaRefs:     {"refs/pull/*/head"},
+}
+
+func (s PullRequestRefStyle) valid() bool {
+	if s == "" {
+		return true
+	}
+	_, exists := pullRequestHeadRefs[s]
+	return exists
+}
+
+const pullRequestsPerPage = 100
+
+// PullRequestConfig is how AllMergeCommit finds the commits merged pull requests.
+// refs is the convention of the pull request heads for the refs provider. If it's set, only the pull request heads of it
+// are fetched with the branches. The heads of all conventions are fetched by default.
+// The API providers also find squashed and rebased pull requests which don't have merge commits.
+// api is the base url of the API ( api.github.com, <host>/api/v3 or <host>/api/v4 by default ),
+// and token is the name of the environment variable which has the API token.
+type PullRequestConfig struct {
+	Provider PullRequestProvider `yaml:"provider"`
+	API      string              `yaml:"api"`
+	TokenEnv string              `yaml:"token"`
+	Refs     PullRequestRefStyle `yaml:"refs"`
+	// LinkCommits finds the pull requests which merged the walked commits by the API, in addition to the messages.
+	LinkCommits bool `yaml:"linkCommits"`
+}
+
+func (c *PullRequestConfig) provider() PullRequestProvider {
+	if c == nil || c.Provider == "" {
+		return PullRequestRefs
+	}
+	return c.Provider
+}
+
+// headRefs returns the patterns of the pull request heads in the remote like refs/pull/*/head.
+func (c *PullRequestConfig) headRefs() []string {
+	if c != nil && c.Refs != "" {
+		return pullRequestHeadRefs[c.Refs]
+	}
+	return []string{
+		pullRequestHeadRefs[GitHubRefs][0],
+		pullRequestHeadRefs[GitLabRefs][0],
+		pullRequestHeadRefs[BitbucketRefs][0],
+	}
+}
+
+// isHeadRef reports whether the ref of the remote is the head of the pull request.
+func (c *PullRequestConfig) isHeadRef(name plumbing.ReferenceName) bool {
+	for _, ref := range c.headRefs() {
+		if config.RefSpec(ref + ":" + ref).Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *PullRequestConfig) linkCommits() bool {
+	return c != nil && c.LinkCommits && c.provider() != PullRequestRefs
+}
+
+func (c *PullRequestConfig) Token() string {
+	if c.TokenEnv == "" {
+		return ""
+	}
+	return os.Getenv(c.TokenEnv)
+}
+
+func (p PullRequestProvider) valid() bool {
+	switch p {
+	case "", PullRequestRefs, PullRequestGitHub, PullRequestGitLab:
+		return true
+	}
+	return false
+}
+
+// PullRequest is the pull request scanned as the unit by the pullRequest strategy.
+type PullRequest struct {
+	// Number, Title, Author, Labels and URL are the ones of the API. The refs provider sets Number by the ref of the head
+	// and Title and Author by the commits, and doesn't set Labels and URL.
+	Number int64
+	Title  string
+	Author string
+	Labels []string
+	URL    string
+	// Commits are the commits of the pull request ordered from oldest to newest.
+	// It's only the merged commit for the squashed or rebased pull request.
+	Commits []*Commit
+}
+
+// listMergedPullRequests returns the pull requests updated since since keyed by the hashes of the commits which merged them
+// by the API of the provider, and the latest time they were updated. All the pull requests are listed if since is zero.
+func listMergedPullRequests(ctx context.Context, cfg *RepositoryConfig, since time.Time) (map[string]*PullRequest, time.Time, error) {
+	host, project, err := splitRepoURL(cfg.Repo)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	prCfg := cfg.PullRequest
+	pullRequests := map[string]*PullRequest{}
+	var updatedAt time.Time
+	for page := 1; ; page++ {
+		var (
+			req *http.Request
+			err error
+		)
+		switch prCfg.provider() {
+		case PullRequestGitHub:
+			api := prCfg.API
+			if api == "" {
+				api = "https://api.github.com"
+				if host != "github.com" {
+					api = fmt.Sprintf("https://%s/api/v3", host)
+				}
+			}
+			req, err = http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(
+				"%s/repos/%s/pulls?state=closed&sort=updated&direction=desc&per_page=%d&page=%d",
+				strings.TrimSuffix(api, "/"), project, pullRequestsPerPage, page,
+			), nil)
+			if err == nil {
+				req.Header.Set("Accept", "application/vnd.github.v3+json")
+				if token := prCfg.Token(); token != "" {
+					req.Header.Set("Authorization", "token "+token)
+				}
+			}
+		case PullRequestGitLab:
+			api := prCfg.API
+			if api == "" {
+				api = fmt.Sprintf("https://%s/api/v4", host)
+			}
+			query := fmt.Sprintf("state=merged&order_by=updated_at&sort=desc&per_page=%d&page=%d", pullRequestsPerPage, page)
+			if !since.IsZero() {
+				query += "&updated_after=" + url.QueryEscape(since.UTC().Format(time.RFC3339))
+			}
+			req, err = http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(
+				"%s/projects/%s/merge_requests?%s",
+				strings.TrimSuffix(api, "/"), url.PathEscape(project), query,
+			), nil)
+			if err == nil {
+				if token := prCfg.Token(); token != "" {
+					req.Header.Set("PRIVATE-TOKEN", token)
+				}
+			}
+		default:
+			return nil, time.Time{}, fmt.Errorf("unknown pull request provider %q", prCfg.Provider)
+		}
+		if err != nil {
+			return nil, time.Time{}, errors.Wrapf(err, "failed to create request")
+		}
+		var prs []*apiPullRequest
+		if err := cfg.remote(ctx, func() error {
+			return getJSON(req, &prs)
+		}); err != nil {
+			return nil, time.Time{}, errors.Wrapf(err, "failed to list pull requests of %s", cfg.Repo)
+		}
+		for _, pr := range prs {
+			if pr.UpdatedAt.Before(since) {
+				// the pull requests are sorted by the updated time, so the rest were listed by the last run.
+				return pullRequests, updatedAt, nil
+			}
+			if pr.UpdatedAt.After(updatedAt) {
+				updatedAt = pr.UpdatedAt
+			}
+			if prCfg.provider() == PullRequestGitHub && pr.MergedAt == nil {
+				// closed without merging.
+				continue
+			}
+			switch {
+			case pr.MergeCommitSHA != "":
+				pullRequests[pr.MergeCommitSHA] = pr.toPullRequest()
+			case pr.SquashCommitSHA != "":
+				pullRequests[pr.SquashCommitSHA] = pr.toPullRequest()
+			}
+		}
+		if len(prs) < pullRequestsPerPage {
+			return pullRequests, updatedAt, nil
+		}
+	}
+}
+
+// pullRequestCache keeps the merged pull requests listed by the API for the run. It's shared by the repositories
+// of the same clone, so the walks of the plugins and the branches don't list all the pull requests again.
+type pullRequestCache struct {
+	mu    sync.Mutex
+	lists map[string]map[string]*PullRequest
+}
+
+func newPullRequestCache() *pullRequestCache {
+	return &pullRequestCache{lists: map[string]map[string]*PullRequest{}}
+}
+
+// pullRequestList is the merged pull requests stored next to the clone.
+// The next run lists only the pull requests updated since UpdatedAt by the same provider.
+type pullRequestList struct {
+	Provider     PullRequestProvider     `json:"provider"`
+	API          string                  `json:"api"`
+	UpdatedAt    time.Time               `json:"updatedAt"`
+	PullRequests map[string]*PullRequest `json:"pullRequests"`
+}
+
+func pullRequestListPath(repoPath string) string {
+	return repoPath + ".pulls.json"
+}
+
+func readPullRequestList(repoPath string) (*pullRequestList, error) {
+	b, err := ioutil.ReadFile(pullRequestListPath(repoPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var list pullRequestList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (l *pullRequestList) save(repoPath string) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pullRequestListPath(repoPath), b, 0644)
+}
+
+// mergedPullRequests returns the pull requests keyed by the hashes of the commits which merged them by the API of the provider.
+// They are listed once in the run, and only the ones updated since the last run are listed for the clone.
+func (r *Repository) mergedPullRequests(ctx context.Context) (map[string]*PullRequest, error) {
+	if r.pullRequests == nil {
+		merged, _, err := listMergedPullRequests(ctx, r.cfg, time.Time{})
+		return merged, err
+	}
+	prCfg := r.cfg.PullRequest
+	key := fmt.Sprintf("%s:%s", prCfg.provider(), prCfg.API)
+	r.pullRequests.mu.Lock()
+	defer r.pullRequests.mu.Unlock()
+	if merged, exists := r.pullRequests.lists[key]; exists {
+		return merged, nil
+	}
+	stored := r.path != "" && !r.cfg.IsLocal()
+	var list *pullRequestList
+	if stored {
+		l, err := readPullRequestList(r.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read pull requests of %s", r.cfg.Repo)
+		}
+		if l != nil && l.Provider == prCfg.provider() && l.API == prCfg.API {
+			list = l
+		}
+	}
+	if list == nil || list.PullRequests == nil {
+		list = &pullRequestList{Provider: prCfg.provider(), API: prCfg.API, PullRequests: map[string]*PullRequest{}}
+	}
+	merged, updatedAt, err := listMergedPullRequests(ctx, r.cfg, list.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for hash, pr := range merged {
+		list.PullRequests[hash] = pr
+	}
+	if updatedAt.After(list.UpdatedAt) {
+		list.UpdatedAt = updatedAt
+	}
+	if stored {
+		if err := list.save(r.path); err != nil {
+			return nil, errors.Wrapf(err, "failed to save pull requests of %s", r.cfg.Repo)
+		}
+	}
+	r.pullRequests.lists[key] = list.PullRequests
+	return list.PullRequests, nil
+}
+
+// apiPullRequest is the pull request of GitHub API or the merge request of GitLab API.
+type apiPullRequest struct {
+	// GitHub
+	Number         int64   `json:"number"`
+	MergedAt       *string `json:"merged_at"`
+	MergeCommitSHA string  `json:"merge_commit_sha"`
+	HTMLURL        string  `json:"html_url"`
+	User           *struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	// GitLab
+	IID             int64  `json:"iid"`
+	SquashCommitSHA string `json:"squash_commit_sha"`
+	WebURL          string `json:"web_url"`
+	Author          *struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	// labels are the objects on GitHub and the names on GitLab.
+	Title     string            `json:"title"`
+	Labels    []json.RawMessage `json:"labels"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+func (pr *apiPullRequest) toPullRequest() *PullRequest {
+	v := &PullRequest{
+		Number: pr.Number,
+		Title:  pr.Title,
+		URL:    pr.HTMLURL,
+	}
+	if pr.IID != 0 {
+		v.Number = pr.IID
+	}
+	if pr.WebURL != "" {
+		v.URL = pr.WebURL
+	}
+	if pr.User != nil {
+		v.Author = pr.User.Login
+	}
+	if pr.Author != nil {
+		v.Author = pr.Author.Username
+	}
+	for _, label := range pr.Labels {
+		var name string
+		if err := json.Unmarshal(label, &name); err == nil {
+			v.Labels = append(v.Labels, name)
+			continue
+		}
+		var obj struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(label, &obj); err == nil && obj.Name != "" {
+			v.Labels = append(v.Labels, obj.Name)
+		}
+	}
+	return v
+}
+
+func getJSON(req *http.Request, v interface{}) error {
+	res, err := transports.client(req.URL).Do(req)
+	if err != nil {
+		if isNetworkError(err) {
+			return ErrNetwork("request", err)
+		}
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return httpError("request", fmt.Errorf("unexpected status %s from %s", res.Status, req.URL), res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "failed to decode response from %s", req.URL)
+	}
+	return nil
+}
+
+// splitRepoURL returns the host and the path of the repository url.
+func splitRepoURL(repo string) (string, string, error) {
+	parts := strings.SplitN(normalizeRepoURL(repo), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", ErrInvalidRepositoryPath(repo)
+	}
+	return parts[0], parts[1], nil
+}
+
+// pullRequestMergeCommitsByAPI returns the commits merged pull requests in commits by the API of the provider,
+// and the pull requests keyed by the hashes of them.
+func (r *Repository) pullRequestMergeCommitsByAPI(ctx context.Context, commits []*object.Commit) ([]*object.Commit, map[string]*PullRequest, error) {
+	merged, err := r.mergedPullRequests(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	prCommits := []*object.Commit{}
+	for _, commit := range commits {
+		if _, exists := merged[commit.Hash.String()]; exists {
+			prCommits = append(prCommits, commit)
+		}
+	}
+	return prCommits, merged, nil
+}
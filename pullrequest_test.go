@@ -0,0 +1,81 @@
+package treport_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goccy/treport"
+)
+
+func TestMergedPullRequests(t *testing.T) {
+	type mergeRequest struct {
+		IID            int64     `json:"iid"`
+		Title          string    `json:"title"`
+		MergeCommitSHA string    `json:"merge_commit_sha"`
+		UpdatedAt      time.Time `json:"updated_at"`
+	}
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	mergeRequests := []*mergeRequest{
+		{IID: 2, Title: "second", MergeCommitSHA: "bbbb", UpdatedAt: base.Add(time.Hour)},
+		{IID: 1, Title: "first", MergeCommitSHA: "aaaa", UpdatedAt: base},
+	}
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("updated_after"))
+		res := []*mergeRequest{}
+		for _, mr := range mergeRequests {
+			if since := r.URL.Query().Get("updated_after"); since != "" {
+				after, err := time.Parse(time.RFC3339, since)
+				if err != nil || mr.UpdatedAt.Before(after) {
+					continue
+				}
+			}
+			res = append(res, mr)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	defer server.Close()
+
+	cfg := &treport.RepositoryConfig{
+		Repo: "gitlab.example.com/group/project",
+		PullRequest: &treport.PullRequestConfig{
+			Provider: treport.PullRequestGitLab,
+			API:      server.URL,
+		},
+	}
+	repoPath := filepath.Join(t.TempDir(), "project")
+	ctx := context.Background()
+
+	list := treport.MergedPullRequests(cfg, repoPath)
+	for i := 0; i < 2; i++ {
+		merged, err := list(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(merged) != 2 || merged["aaaa"].Number != 1 || merged["bbbb"].Number != 2 {
+			t.Fatalf("unexpected pull requests %+v", merged)
+		}
+	}
+	if len(queries) != 1 || queries[0] != "" {
+		t.Fatalf("expected all pull requests to be listed once in the run, got %q", queries)
+	}
+
+	mergeRequests = append([]*mergeRequest{
+		{IID: 3, Title: "third", MergeCommitSHA: "cccc", UpdatedAt: base.Add(2 * time.Hour)},
+	}, mergeRequests...)
+	merged, err := treport.MergedPullRequests(cfg, repoPath)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 3 || merged["aaaa"].Number != 1 || merged["cccc"].Number != 3 {
+		t.Fatalf("unexpected pull requests %+v", merged)
+	}
+	if len(queries) != 2 || queries[1] != base.Add(time.Hour).Format(time.RFC3339) {
+		t.Fatalf("expected the next run to list the pull requests updated since the last one, got %q", queries)
+	}
+}
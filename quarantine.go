@@ -0,0 +1,166 @@
+package treport
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// QuarantineConfig skips the commits which consistently fail the plugin, like the corrupt or the gigantic ones
+// of the old history, with a warning instead of failing the pipeline every time.
+// The quarantined commits don't have the results, and they are scanned again when they are released.
+type QuarantineConfig struct {
+	// Commits are the hashes or their prefixes of the commits skipped by the plugin.
+	Commits []string `yaml:"commits"`
+	// After quarantines the commit which has failed the plugin in the number of runs in a row. Zero disables it.
+	After int `yaml:"after"`
+}
+
+func (c *QuarantineConfig) listed(commitHash string) bool {
+	if c == nil {
+		return false
+	}
+	for _, commit := range c.Commits {
+		if strings.HasPrefix(commitHash, strings.ToLower(commit)) {
+			return true
+		}
+	}
+	return false
+}
+
+// quarantineRecord is the failures of the commit recorded by the plugin.
+type quarantineRecord struct {
+	Failures    int       `json:"failures"`
+	Error       string    `json:"error"`
+	Quarantined bool      `json:"quarantined"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// quarantine is the records of the failed commits of the plugin keyed by the commit hash.
+// It's stored next to the high-water mark, so the records are removed with the cache.
+type quarantine struct {
+	records map[string]*quarantineRecord
+}
+
+func (p *Plugin) quarantinePath() string {
+	return p.CachePath + ".quarantine.json"
+}
+
+// loadQuarantine returns the records of the previous runs. It's loaded once, and the in-memory cache doesn't have it.
+// p.quarantineMu must be held.
+func (p *Plugin) loadQuarantine() (*quarantine, error) {
+	if p.quarantine != nil {
+		return p.quarantine, nil
+	}
+	q := &quarantine{records: map[string]*quarantineRecord{}}
+	if !p.cacheCfg.inMemory() {
+		b, err := ioutil.ReadFile(p.quarantinePath())
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to read quarantine")
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &q.records); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode quarantine")
+			}
+		}
+	}
+	p.quarantine = q
+	return q, nil
+}
+
+func (p *Plugin) saveQuarantine(q *quarantine) error {
+	if p.cacheCfg.inMemory() {
+		return nil
+	}
+	if err := mkdirIfNotExists(filepath.Dir(p.quarantinePath())); err != nil {
+		return errors.Wrapf(err, "failed to create directory for quarantine")
+	}
+	b, err := json.MarshalIndent(q.records, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode quarantine")
+	}
+	if err := ioutil.WriteFile(p.quarantinePath(), b, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write quarantine")
+	}
+	return nil
+}
+
+// isQuarantined reports whether the commit is listed in commits of the config or quarantined by its failures.
+func (p *Plugin) isQuarantined(commitHash string) (bool, error) {
+	if p.Quarantine.listed(commitHash) {
+		return true, nil
+	}
+	if p.Quarantine == nil || p.Quarantine.After <= 0 {
+		return false, nil
+	}
+	p.quarantineMu.Lock()
+	defer p.quarantineMu.Unlock()
+	q, err := p.loadQuarantine()
+	if err != nil {
+		return false, err
+	}
+	record, exists := q.records[commitHash]
+	return exists && record.Quarantined, nil
+}
+
+// skipsQuarantinedCommit reports whether the walked commit isn't passed to the plugin, because it's quarantined.
+func (p *Plugin) skipsQuarantinedCommit(ctx context.Context, commitHash string) (bool, error) {
+	quarantined, err := p.isQuarantined(commitHash)
+	if err != nil {
+		return false, errors.Stack(err)
+	}
+	if quarantined {
+		loggerFrom(ctx).Warn("skipped quarantined commit", "plugin", p.Name, "commit", commitHash)
+	}
+	return quarantined, nil
+}
+
+// recordFailure counts the failure of the commit, and quarantines the commit if it has failed after times in a row.
+func (p *Plugin) recordFailure(ctx context.Context, commitHash string, scanErr error) error {
+	if p.Quarantine == nil || p.Quarantine.After <= 0 {
+		return nil
+	}
+	p.quarantineMu.Lock()
+	defer p.quarantineMu.Unlock()
+	q, err := p.loadQuarantine()
+	if err != nil {
+		return err
+	}
+	record, exists := q.records[commitHash]
+	if !exists {
+		record = &quarantineRecord{}
+		q.records[commitHash] = record
+	}
+	record.Failures++
+	record.Error = scanErr.Error()
+	record.UpdatedAt = time.Now()
+	if record.Failures >= p.Quarantine.After && !record.Quarantined {
+		record.Quarantined = true
+		loggerFrom(ctx).Warn("quarantined commit", "plugin", p.Name, "commit", commitHash, "failures", record.Failures)
+	}
+	return p.saveQuarantine(q)
+}
+
+// clearFailure removes the failures of the commit scanned successfully, so only the failures in a row are counted.
+func (p *Plugin) clearFailure(commitHash string) error {
+	if p.Quarantine == nil || p.Quarantine.After <= 0 {
+		return nil
+	}
+	p.quarantineMu.Lock()
+	defer p.quarantineMu.Unlock()
+	q, err := p.loadQuarantine()
+	if err != nil {
+		return err
+	}
+	if _, exists := q.records[commitHash]; !exists {
+		return nil
+	}
+	delete(q.records, commitHash)
+	return p.saveQuarantine(q)
+}
@@ -0,0 +1,41 @@
+package treport_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const (
+		badCommit    = "3f2a9c1e0d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39"
+		listedCommit = "a1b2c3d4e5f60718293a4b5c6d7e8f9012345678"
+	)
+	cfg := &treport.QuarantineConfig{Commits: []string{"A1B2C3D"}, After: 2}
+	plg := &treport.Plugin{Name: "size", CachePath: filepath.Join(dir, "size"), Quarantine: cfg}
+	if quarantined, err := plg.IsQuarantined(listedCommit); err != nil || !quarantined {
+		t.Fatalf("listed commit isn't quarantined: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if quarantined, err := plg.IsQuarantined(badCommit); err != nil || quarantined {
+			t.Fatalf("commit is quarantined after %d failures: %v", i, err)
+		}
+		if err := plg.RecordFailure(badCommit, fmt.Errorf("broken")); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	// the next run reads the records of the previous runs.
+	next := &treport.Plugin{Name: "size", CachePath: filepath.Join(dir, "size"), Quarantine: cfg}
+	if quarantined, err := next.IsQuarantined(badCommit); err != nil || !quarantined {
+		t.Fatalf("commit isn't quarantined after failures: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package treport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// RateLimitConfig limits the remote operations of all repositories, which are clone, fetch, pull and the requests
+// to the API of the pull request provider, so scanning many repositories doesn't trip the secondary rate limits
+// of the hosting service. Each attempt of the retry is limited.
+type RateLimitConfig struct {
+	// Rate is the number of the operations started per second. It isn't limited if it's 0.
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of the operations started at once without waiting. It's 1 by default.
+	Burst int `yaml:"burst"`
+	// Concurrency is the max number of the operations running at the same time. It isn't limited if it's 0.
+	Concurrency int `yaml:"concurrency"`
+}
+
+// rateLimiter is the token bucket shared by the repositories of the config.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    time.Duration
+	// next is the time when the bucket becomes full again.
+	next time.Time
+	sem  chan struct{}
+}
+
+func newRateLimiter(cfg *RateLimitConfig) *rateLimiter {
+	if cfg == nil || (cfg.Rate <= 0 && cfg.Concurrency <= 0) {
+		return nil
+	}
+	l := &rateLimiter{}
+	if cfg.Rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / cfg.Rate)
+		burst := cfg.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		l.burst = time.Duration(burst-1) * l.interval
+	}
+	if cfg.Concurrency > 0 {
+		l.sem = make(chan struct{}, cfg.Concurrency)
+	}
+	return l
+}
+
+// reserve takes the token, and returns the time to wait for it.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now) - l.burst
+	l.next = l.next.Add(l.interval)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// do calls fn after waiting for the token and the slot of the concurrency.
+func (l *rateLimiter) do(ctx context.Context, fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-l.sem }()
+	}
+	if l.interval > 0 {
+		if wait := l.reserve(); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return fn()
+}
+
+// resolveRemotes shares the rate limiter of project.rateLimit by all repositories including the ones of plugins,
+// and sets project.transport to the repositories which don't have the transport.
+func (c *Config) resolveRemotes() {
+	limiter := newRateLimiter(c.Project.RateLimit)
+	repoCfgs := c.Plugin.repositories()
+	for _, pipelineCfg := range c.Pipelines {
+		repoCfgs = append(repoCfgs, pipelineCfg.Repository...)
+	}
+	for _, repoCfg := range repoCfgs {
+		repoCfg.limiter = limiter
+		if repoCfg.Transport == nil {
+			repoCfg.Transport = c.Project.Transport
+		}
+	}
+}
+
+// remote calls fn of the remote operation with the retry policy, the rate limit and the transport.
+func (c *RepositoryConfig) remote(ctx context.Context, fn func() error) error {
+	if c.Transport != nil && c.Repo != "" {
+		if err := transports.register(c.Repo, c.Transport); err != nil {
+			return errors.Wrapf(err, "failed to set transport of %s", c.Repo)
+		}
+	}
+	return c.Retry.do(ctx, func() error {
+		return c.limiter.do(ctx, fn)
+	})
+}
@@ -0,0 +1,117 @@
+package treport
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// legacyHeadRef is fetched by the refspecs of the old versions which mapped all refs of the remote to refs/heads/*.
+const legacyHeadRef = plumbing.ReferenceName("refs/heads/HEAD")
+
+func remoteRefPrefix(remoteName string) string {
+	return "refs/remotes/" + remoteName + "/"
+}
+
+// fetchRefSpecs returns the refspecs of fetch. The branches are fetched to the remote-tracking refs by default,
+// and the heads of the pull requests are fetched next to them like refs/remotes/origin/pull/1/head if they are found by the refs provider.
+func (c *RepositoryConfig) fetchRefSpecs(remoteName string) []config.RefSpec {
+	if c.Clone != nil && len(c.Clone.RefSpecs) > 0 {
+		specs := make([]config.RefSpec, 0, len(c.Clone.RefSpecs))
+		for _, spec := range c.Clone.RefSpecs {
+			specs = append(specs, config.RefSpec(spec))
+		}
+		return specs
+	}
+	specs := []config.RefSpec{config.RefSpec("+refs/heads/*:" + remoteRefPrefix(remoteName) + "*")}
+	if c.PullRequest.provider() != PullRequestRefs {
+		return specs
+	}
+	for _, ref := range c.PullRequest.headRefs() {
+		specs = append(specs, config.RefSpec("+"+ref+":"+remoteRefPrefix(remoteName)+strings.TrimPrefix(ref, "refs/")))
+	}
+	return specs
+}
+
+func (c *RepositoryConfig) fetchTags() git.TagMode {
+	if c.Clone != nil && c.Clone.Tags {
+		return git.AllTags
+	}
+	return git.TagFollowing
+}
+
+// localRefName returns the local ref which the ref of the remote is fetched to by the refspecs.
+func localRefName(specs []config.RefSpec, name plumbing.ReferenceName) (plumbing.ReferenceName, bool) {
+	for _, spec := range specs {
+		if spec.Match(name) {
+			return spec.Dst(name), true
+		}
+	}
+	return "", false
+}
+
+// remoteRefName returns the ref of the remote which the local ref is fetched from by the refspecs.
+// The most specific refspec is used, because refs/remotes/origin/pull/1/head matches the default refspec of the branches too.
+func remoteRefName(specs []config.RefSpec, name plumbing.ReferenceName) (plumbing.ReferenceName, bool) {
+	var (
+		found  plumbing.ReferenceName
+		prefix = -1
+	)
+	for _, spec := range specs {
+		reversed := config.RefSpec(strings.TrimPrefix(string(spec), "+")).Reverse()
+		if !reversed.Match(name) {
+			continue
+		}
+		src := reversed.Src()
+		if i := strings.Index(src, "*"); i >= 0 {
+			src = src[:i]
+		}
+		if len(src) > prefix {
+			found = reversed.Dst(name)
+			prefix = len(src)
+		}
+	}
+	return found, prefix >= 0
+}
+
+// removeLegacyRefs deletes the refs fetched to refs/heads/* by the old versions, like refs/heads/heads/main and refs/heads/tags/v1.0.0,
+// which hide the local branches. The branches in the config of the repository and the branch HEAD points to are kept.
+func (r *Repository) removeLegacyRefs() error {
+	if _, err := r.Storer.Reference(legacyHeadRef); err != nil {
+		if err == plumbing.ErrReferenceNotFound {
+			return nil
+		}
+		return err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	refIter, err := r.Branches()
+	if err != nil {
+		return err
+	}
+	defer refIter.Close()
+	var legacy []plumbing.ReferenceName
+	if err := refIter.ForEach(func(ref *plumbing.Reference) error {
+		if _, exists := cfg.Branches[ref.Name().Short()]; exists || ref.Name() == head.Target() {
+			return nil
+		}
+		legacy = append(legacy, ref.Name())
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, name := range legacy {
+		if err := r.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
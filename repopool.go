@@ -0,0 +1,87 @@
+package treport
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var (
+	cloneLocksMu sync.Mutex
+	// cloneLocks serializes the writes to the same clone in the process, such as clone, fetch and pull.
+	cloneLocks = map[string]*sync.Mutex{}
+)
+
+func cloneLock(path string) *sync.Mutex {
+	cloneLocksMu.Lock()
+	defer cloneLocksMu.Unlock()
+	lock, exists := cloneLocks[path]
+	if !exists {
+		lock = &sync.Mutex{}
+		cloneLocks[path] = lock
+	}
+	return lock
+}
+
+// repoSync is shared by the repositories of the same clone in a run, so the clone is fetched once
+// and the branch is synced once even if the pipelines and the plugins sync it concurrently.
+type repoSync struct {
+	lock    *sync.Mutex
+	fetched bool
+	synced  map[plumbing.ReferenceName]struct{}
+	// refsSynced are the branches whose refs are updated by SyncRefs without checking out the worktree.
+	refsSynced map[plumbing.ReferenceName]struct{}
+}
+
+func newRepoSync(path string) *repoSync {
+	return &repoSync{
+		lock:       cloneLock(path),
+		synced:     map[plumbing.ReferenceName]struct{}{},
+		refsSynced: map[plumbing.ReferenceName]struct{}{},
+	}
+}
+
+// repositoryPool deduplicates the repositories of the same clone referenced by the pipelines of a run.
+type repositoryPool struct {
+	repos map[string]*Repository
+}
+
+func newRepositoryPool() *repositoryPool {
+	return &repositoryPool{repos: map[string]*Repository{}}
+}
+
+// get returns the repository of the config. The repositories of the same clone share the opened clone and its sync,
+// and have the config of their own.
+func (p *repositoryPool) get(ctx context.Context, mountPath string, cfg *RepositoryConfig) (*Repository, error) {
+	repoPath, err := repositoryPath(mountPath, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if repo, exists := p.repos[repoPath]; exists && repo.cfg.Storage == cfg.Storage {
+		return repo.withConfig(cfg)
+	}
+	repo, err := NewRepository(ctx, mountPath, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.repos[repoPath] = repo
+	return repo.withConfig(cfg)
+}
+
+// withConfig returns the repository of the same clone with the config.
+// The state depending on the config or the pipeline, such as the submodules, the keyring and the diff cache, isn't shared.
+func (r *Repository) withConfig(cfg *RepositoryConfig) (*Repository, error) {
+	keyring, err := loadKeyring(cfg)
+	if err != nil {
+		return nil, err
+	}
+	repo := *r
+	repo.cfg = cfg
+	repo.keyring = keyring
+	repo.submodules = newSubmoduleRepos()
+	repo.diffs = nil
+	repo.blobs = nil
+	repo.linkedPullRequests = nil
+	return &repo, nil
+}
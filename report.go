@@ -0,0 +1,320 @@
+package treport
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+)
+
+// Report is the aggregated scan results of all pipelines.
+type Report struct {
+	Pipelines []*PipelineReport
+}
+
+type PipelineReport struct {
+	Name     string
+	Desc     string
+	Strategy Strategy
+	// Clock is the clock of Time of the plugin results.
+	Clock        Clock
+	Repositories []*RepositoryReport
+}
+
+// RepositoryReport has the results of the repository.
+// Errors are the failures continued by onError, so Commits may have only partial results if it isn't empty.
+type RepositoryReport struct {
+	// ID is the ID of the repository, which is unique in the pipeline even if the repositories are the same.
+	ID string
+	// Repo is the url of the repository, or the path of the local one.
+	Repo string
+	// Branch is the branch scanned as a separate stream. It's empty for the base branch.
+	Branch  string
+	Commits []*CommitReport
+	Errors  []*ScanError
+}
+
+// Plugin returns the results of the plugin in commit order.
+func (r *RepositoryReport) Plugin(name string) []*PluginResult {
+	results := []*PluginResult{}
+	for _, commit := range r.Commits {
+		if result, exists := commit.Results[name]; exists {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Latest returns the result of the plugin for the last scanned commit.
+func (r *RepositoryReport) Latest(name string) *PluginResult {
+	results := r.Plugin(name)
+	if len(results) == 0 {
+		return nil
+	}
+	return results[len(results)-1]
+}
+
+type CommitReport struct {
+	Commit    *Commit
+	TopoIndex int64
+	Results   map[string]*PluginResult
+	// Paths are the paths of the files changed by the commit from its first parent. They're loaded by ChangedPaths
+	// from the repository when the report is rolled up by directory or extension.
+	Paths []string `json:",omitempty"`
+	// loadPaths reads Paths from the repository of the scan.
+	loadPaths func() ([]string, error)
+}
+
+// ChangedPaths returns Paths, and loads them from the repository at first if the report is created by the scan.
+func (c *CommitReport) ChangedPaths() ([]string, error) {
+	if c.Paths != nil || c.loadPaths == nil {
+		return c.Paths, nil
+	}
+	paths, err := c.loadPaths()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load changed paths of %s", c.Commit.Hash)
+	}
+	c.Paths = paths
+	return paths, nil
+}
+
+type PluginResult struct {
+	Plugin        string
+	Type          string
+	SchemaVersion string
+	PluginVersion string
+	Warnings      []string
+	// ScanTime is the time the plugin scanned the commit, and Time is the time of the clock of the pipeline.
+	ScanTime time.Time
+	Time     time.Time
+	JSON     string
+	Data     map[string]interface{}
+	// response is passed to the plugins of the aggregate stage.
+	response *treportproto.ScanResponse
+}
+
+func newPluginResult(plugin string, res *treportproto.ScanResponse) *PluginResult {
+	result := &PluginResult{
+		Plugin:        plugin,
+		Type:          res.Name,
+		SchemaVersion: res.SchemaVersion,
+		PluginVersion: res.PluginVersion,
+		Warnings:      res.Warnings,
+		Data:          map[string]interface{}{},
+		response:      res,
+	}
+	// the JSON is regenerated for the results cached without it.
+	result.JSON, _ = responseJSON(res)
+	if result.JSON != "" {
+		_ = json.Unmarshal([]byte(result.JSON), &result.Data)
+	}
+	commitTime, _, scanTime := responseTimes(res)
+	result.ScanTime = scanTime
+	result.Time = commitTime
+	return result
+}
+
+type resultCollector struct {
+	mu      sync.Mutex
+	commits map[string]*CommitReport
+	errors  []*ScanError
+}
+
+func (c *resultCollector) record(plugin string, scanctx *ScanContext) {
+	res, exists := scanctx.pluginResponse(plugin)
+	if !exists {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.commits == nil {
+		c.commits = map[string]*CommitReport{}
+	}
+	commit, exists := c.commits[scanctx.Commit.Hash]
+	if !exists {
+		commit = &CommitReport{
+			Commit:    scanctx.Commit,
+			TopoIndex: scanctx.TopoIndex,
+			Results:   map[string]*PluginResult{},
+		}
+		c.commits[scanctx.Commit.Hash] = commit
+	}
+	commit.Results[plugin] = newPluginResult(plugin, res)
+}
+
+func (c *resultCollector) fail(err *ScanError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors = append(c.errors, err)
+}
+
+func (c *resultCollector) failures() []*ScanError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*ScanError{}, c.errors...)
+}
+
+// counts returns the number of the commits which have the results and the number of the failures.
+func (c *resultCollector) counts() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.commits), len(c.errors)
+}
+
+// pluginCounts returns the number of the commits which have the results of the plugins and the number of their failures.
+func (c *resultCollector) pluginCounts(plugins []*Plugin) (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var commits, failures int
+	for _, commit := range c.commits {
+		for _, plg := range plugins {
+			if _, exists := commit.Results[plg.Name]; exists {
+				commits++
+				break
+			}
+		}
+	}
+	for _, err := range c.errors {
+		for _, plg := range plugins {
+			if err.Plugin == plg.Name {
+				failures++
+				break
+			}
+		}
+	}
+	return commits, failures
+}
+
+func (c *resultCollector) sortedCommits() []*CommitReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	commits := make([]*CommitReport, 0, len(c.commits))
+	for _, commit := range c.commits {
+		commits = append(commits, commit)
+	}
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].TopoIndex < commits[j].TopoIndex
+	})
+	return commits
+}
+
+func NewReport(pipelines []*Pipeline) *Report {
+	report := &Report{}
+	for _, pipeline := range pipelines {
+		pipelineReport := &PipelineReport{
+			Name:     pipeline.Config.Name,
+			Desc:     pipeline.Config.Desc,
+			Strategy: pipeline.Config.Strategy,
+			Clock:    pipeline.Config.ResultClock(),
+		}
+		for _, repo := range pipeline.Repos {
+			commits := repo.results.sortedCommits()
+			for _, commit := range commits {
+				for _, result := range commit.Results {
+					result.Time = pipelineReport.Clock.timeOf(commit.Commit.Committer.When, commit.Commit.Author.When, result.ScanTime)
+				}
+				if commit.loadPaths == nil {
+					gitRepo, hash := repo.Repository, commit.Commit.Hash
+					commit.loadPaths = func() ([]string, error) {
+						return gitRepo.changedPaths(hash)
+					}
+				}
+			}
+			pipelineReport.Repositories = append(pipelineReport.Repositories, &RepositoryReport{
+				ID:      repo.ID,
+				Repo:    repo.cfg.identity(),
+				Branch:  repo.scanBranch,
+				Commits: commits,
+				Errors:  repo.results.failures(),
+			})
+		}
+		report.Pipelines = append(report.Pipelines, pipelineReport)
+	}
+	return report
+}
+
+type Reporter interface {
+	Report(w io.Writer, report *Report) error
+}
+
+// TemplateReporter renders the report by user-supplied Go template.
+type TemplateReporter struct {
+	tmpl *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+}
+
+func NewTemplateReporter(name, text string) (*TemplateReporter, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse template %s", name)
+	}
+	return &TemplateReporter{tmpl: tmpl}, nil
+}
+
+func LoadTemplateReporter(path string) (*TemplateReporter, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read template %s", path)
+	}
+	return NewTemplateReporter(filepath.Base(path), string(file))
+}
+
+func (r *TemplateReporter) Report(w io.Writer, report *Report) error {
+	if err := r.tmpl.Execute(w, report); err != nil {
+		return errors.Wrapf(err, "failed to execute template %s", r.tmpl.Name())
+	}
+	return nil
+}
+
+// WriteReports writes the report by each config. It's written after each scan, and also used to regenerate the reports from cache.
+func WriteReports(cfgs []*ReportConfig, report *Report) error {
+	for _, cfg := range cfgs {
+		reporter, err := cfg.reporter()
+		if err != nil {
+			return errors.Wrapf(err, "failed to load reporter %s", cfg.Name)
+		}
+		if err := writeReport(cfg.Output, reporter, report); err != nil {
+			return errors.Wrapf(err, "failed to write report %s", cfg.Name)
+		}
+	}
+	return nil
+}
+
+func (c *ReportConfig) reporter() (Reporter, error) {
+	if c.Format != "" {
+		return NewFormatReporter(c.Format)
+	}
+	return LoadTemplateReporter(c.Template)
+}
+
+func writeReport(output string, reporter Reporter, report *Report) error {
+	if output == "" {
+		return reporter.Report(os.Stdout, report)
+	}
+	if err := mkdirIfNotExists(filepath.Dir(output)); err != nil {
+		return errors.Wrapf(err, "failed to create directory for report")
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create report file %s", output)
+	}
+	defer f.Close()
+	return reporter.Report(f, report)
+}
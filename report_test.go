@@ -0,0 +1,163 @@
+package treport_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/treport"
+)
+
+func TestTemplateReporter(t *testing.T) {
+	reporter, err := treport.NewTemplateReporter("size", `{{ range .Pipelines }}{{ range .Repositories }}{{ .Repo }}:{{ range .Plugin "size" }} {{ .Data.size }}{{ end }}{{ end }}{{ end }}`)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	report := &treport.Report{
+		Pipelines: []*treport.PipelineReport{
+			{
+				Name: "repo-size",
+				Repositories: []*treport.RepositoryReport{
+					{
+						Repo: "https://github.com/goccy/go-json",
+						Commits: []*treport.CommitReport{
+							{
+								Commit: &treport.Commit{Hash: "a"},
+								Results: map[string]*treport.PluginResult{
+									"size": {Plugin: "size", Data: map[string]interface{}{"size": "10"}},
+								},
+							},
+							{
+								Commit: &treport.Commit{Hash: "b"},
+								Results: map[string]*treport.PluginResult{
+									"size": {Plugin: "size", Data: map[string]interface{}{"size": "20"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := reporter.Report(&buf, report); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := "https://github.com/goccy/go-json: 10 20"
+	if buf.String() != expected {
+		t.Fatalf("failed to render report: expected %q but got %q", expected, buf.String())
+	}
+}
+
+func TestRollup(t *testing.T) {
+	repo := &treport.RepositoryReport{
+		Repo: "https://github.com/goccy/go-json",
+		Commits: []*treport.CommitReport{
+			{
+				Commit:  &treport.Commit{Hash: "a", Author: &treport.Signature{Name: "alice", Email: "alice@example.com"}},
+				Results: map[string]*treport.PluginResult{"size": {Data: map[string]interface{}{"stats": map[string]interface{}{"lines": "10"}}}},
+				Paths:   []string{"README.md", "internal/a.go", "internal/b.go"},
+			},
+			{
+				Commit:  &treport.Commit{Hash: "b", Author: &treport.Signature{Name: "bob"}},
+				Results: map[string]*treport.PluginResult{"size": {Data: map[string]interface{}{"stats": map[string]interface{}{"lines": 5.0}}}},
+				Paths:   []string{"cmd/main.go"},
+			},
+			{
+				Commit:  &treport.Commit{Hash: "c", Author: &treport.Signature{Name: "alice", Email: "alice@example.com"}},
+				Results: map[string]*treport.PluginResult{"size": {Data: map[string]interface{}{}}},
+				Paths:   []string{"cmd/main.go"},
+			},
+		},
+	}
+	for _, test := range []struct {
+		dimension treport.RollupDimension
+		expected  string
+	}{
+		{treport.RollupAuthor, "alice@example.com=10/1 bob=5/1"},
+		{treport.RollupDirectory, ".=10/1 internal=10/1 cmd=5/1"},
+		{treport.RollupExtension, ".go=15/2 .md=10/1"},
+	} {
+		groups, err := repo.Rollup(test.dimension, "size", "stats.lines")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var got []string
+		for _, group := range groups {
+			got = append(got, fmt.Sprintf("%s=%v/%d", group.Key, group.Sum, group.Commits))
+		}
+		if strings.Join(got, " ") != test.expected {
+			t.Fatalf("%s: expected %q but got %q", test.dimension, test.expected, strings.Join(got, " "))
+		}
+	}
+	if _, err := repo.Rollup("unknown", "size", "stats.lines"); err == nil {
+		t.Fatal("expected error for unknown dimension")
+	}
+}
+
+func TestFormatReporter(t *testing.T) {
+	report := &treport.Report{
+		Pipelines: []*treport.PipelineReport{
+			{
+				Name: "repo-size",
+				Repositories: []*treport.RepositoryReport{
+					{
+						Repo:   "https://github.com/goccy/go-json",
+						Branch: "release",
+						Commits: []*treport.CommitReport{
+							{
+								Commit: &treport.Commit{Hash: "a"},
+								Results: map[string]*treport.PluginResult{
+									"size":  {Type: "Size", JSON: `{"size": "10"}`, Time: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)},
+									"lines": {Type: "Lines", JSON: `{"lines|all": "3"}`, Time: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, test := range []struct {
+		format   treport.ReportFormat
+		expected string
+	}{
+		{
+			treport.ReportCSV,
+			`pipeline,repo,branch,commit,time,plugin,type,data
+repo-size,https://github.com/goccy/go-json,release,a,2021-01-02T03:04:05Z,lines,Lines,"{""lines|all"":""3""}"
+repo-size,https://github.com/goccy/go-json,release,a,2021-01-02T03:04:05Z,size,Size,"{""size"":""10""}"
+`,
+		},
+		{
+			treport.ReportMarkdown,
+			`## repo-size
+
+### https://github.com/goccy/go-json@release
+
+| commit | time | plugin | data |
+| --- | --- | --- | --- |
+| a | 2021-01-02T03:04:05Z | lines | {"lines\|all":"3"} |
+| a | 2021-01-02T03:04:05Z | size | {"size":"10"} |
+
+`,
+		},
+	} {
+		reporter, err := treport.NewFormatReporter(test.format)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var buf bytes.Buffer
+		if err := reporter.Report(&buf, report); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if buf.String() != test.expected {
+			t.Fatalf("%s: expected %q but got %q", test.format, test.expected, buf.String())
+		}
+	}
+	if _, err := treport.NewFormatReporter("pdf"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
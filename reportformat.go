@@ -0,0 +1,204 @@
+package treport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// ReportFormat is the builtin format of the report, which lists the result of each commit and plugin without the template.
+type ReportFormat string
+
+const (
+	ReportJSON     ReportFormat = "json"
+	ReportCSV      ReportFormat = "csv"
+	ReportMarkdown ReportFormat = "markdown"
+	ReportHTML     ReportFormat = "html"
+)
+
+func (f ReportFormat) valid() bool {
+	switch f {
+	case ReportJSON, ReportCSV, ReportMarkdown, ReportHTML:
+		return true
+	}
+	return false
+}
+
+// NewFormatReporter returns the reporter of the builtin format.
+func NewFormatReporter(format ReportFormat) (Reporter, error) {
+	switch format {
+	case ReportJSON:
+		return reporterFunc(writeJSONReport), nil
+	case ReportCSV:
+		return reporterFunc(writeCSVReport), nil
+	case ReportMarkdown:
+		return reporterFunc(writeMarkdownReport), nil
+	case ReportHTML:
+		return reporterFunc(writeHTMLReport), nil
+	}
+	return nil, fmt.Errorf("unknown report format %q", format)
+}
+
+type reporterFunc func(io.Writer, *Report) error
+
+func (f reporterFunc) Report(w io.Writer, report *Report) error {
+	return f(w, report)
+}
+
+// reportRow is the result of the plugin for the commit written by the builtin formats.
+type reportRow struct {
+	Pipeline string          `json:"pipeline"`
+	Repo     string          `json:"repo"`
+	Branch   string          `json:"branch,omitempty"`
+	Commit   string          `json:"commit"`
+	Time     time.Time       `json:"time"`
+	Plugin   string          `json:"plugin"`
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// rows returns the results in the order of the pipelines, the repositories and the commits.
+func (r *Report) rows() []*reportRow {
+	rows := []*reportRow{}
+	for _, pipeline := range r.Pipelines {
+		for _, repo := range pipeline.Repositories {
+			rows = append(rows, repoRows(pipeline.Name, repo)...)
+		}
+	}
+	return rows
+}
+
+// repoRows returns the results of the repository. The results of the commit are sorted by the name of the plugin.
+func repoRows(pipeline string, repo *RepositoryReport) []*reportRow {
+	rows := []*reportRow{}
+	for _, commit := range repo.Commits {
+		plugins := make([]string, 0, len(commit.Results))
+		for plugin := range commit.Results {
+			plugins = append(plugins, plugin)
+		}
+		sort.Strings(plugins)
+		for _, plugin := range plugins {
+			result := commit.Results[plugin]
+			data := json.RawMessage("null")
+			if result.JSON != "" {
+				data = json.RawMessage(result.JSON)
+			}
+			rows = append(rows, &reportRow{
+				Pipeline: pipeline,
+				Repo:     repo.Repo,
+				Branch:   repo.Branch,
+				Commit:   commit.Commit.Hash,
+				Time:     result.Time,
+				Plugin:   plugin,
+				Type:     result.Type,
+				Data:     data,
+			})
+		}
+	}
+	return rows
+}
+
+// compactData returns the data of the row in a line.
+func (r *reportRow) compactData() string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, r.Data); err != nil {
+		return string(r.Data)
+	}
+	return buf.String()
+}
+
+func writeJSONReport(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report.rows()); err != nil {
+		return errors.Wrapf(err, "failed to write JSON report")
+	}
+	return nil
+}
+
+func writeCSVReport(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{{"pipeline", "repo", "branch", "commit", "time", "plugin", "type", "data"}}
+	for _, row := range report.rows() {
+		records = append(records, []string{
+			row.Pipeline, row.Repo, row.Branch, row.Commit, row.Time.Format(time.RFC3339), row.Plugin, row.Type, row.compactData(),
+		})
+	}
+	if err := cw.WriteAll(records); err != nil {
+		return errors.Wrapf(err, "failed to write CSV report")
+	}
+	return nil
+}
+
+func writeMarkdownReport(w io.Writer, report *Report) error {
+	var b strings.Builder
+	escape := strings.NewReplacer("|", `\|`, "\n", " ")
+	for _, pipeline := range report.Pipelines {
+		fmt.Fprintf(&b, "## %s\n\n", pipeline.Name)
+		for _, repo := range pipeline.Repositories {
+			fmt.Fprintf(&b, "### %s\n\n", repoTitle(repo))
+			b.WriteString("| commit | time | plugin | data |\n| --- | --- | --- | --- |\n")
+			for _, row := range repoRows(pipeline.Name, repo) {
+				fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", row.Commit, row.Time.Format(time.RFC3339), row.Plugin, escape.Replace(row.compactData()))
+			}
+			b.WriteString("\n")
+		}
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return errors.Wrapf(err, "failed to write Markdown report")
+	}
+	return nil
+}
+
+func repoTitle(repo *RepositoryReport) string {
+	if repo.Branch == "" {
+		return repo.Repo
+	}
+	return repo.Repo + "@" + repo.Branch
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"title": repoTitle,
+	"rows": func(pipeline *PipelineReport, repo *RepositoryReport) []*reportRow {
+		return repoRows(pipeline.Name, repo)
+	},
+	"data": func(row *reportRow) string {
+		return row.compactData()
+	},
+	"time": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>treport</title></head>
+<body>
+{{- range $pipeline := .Pipelines }}
+<h2>{{ $pipeline.Name }}</h2>
+{{- range $repo := $pipeline.Repositories }}
+<h3>{{ title $repo }}</h3>
+<table>
+<tr><th>commit</th><th>time</th><th>plugin</th><th>data</th></tr>
+{{- range rows $pipeline $repo }}
+<tr><td>{{ .Commit }}</td><td>{{ time .Time }}</td><td>{{ .Plugin }}</td><td><code>{{ data . }}</code></td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
+
+func writeHTMLReport(w io.Writer, report *Report) error {
+	if err := htmlReportTemplate.Execute(w, report); err != nil {
+		return errors.Wrapf(err, "failed to write HTML report")
+	}
+	return nil
+}
@@ -1,98 +1,466 @@
 package treport
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/goccy/treport/internal/errors"
 	treportproto "github.com/goccy/treport/proto"
 )
 
 type Repository struct {
 	*git.Repository
-	ID      string
-	cfg     *RepositoryConfig
-	gitCfg  *config.Config
-	fetched bool
+	ID       string
+	legacyID string
+	cfg      *RepositoryConfig
+	gitCfg   *config.Config
+	sync     *repoSync
+	// scanBranch is the branch walked instead of HEAD. It's set to the repositories created for branches of the config.
+	scanBranch string
+	submodules *submoduleRepos
+	// keyring is the armored keyring which verifies the commit signatures.
+	keyring  string
+	binaries *binaryCache
+	// path is the directory of the clone. It's empty for the repository in memory.
+	path string
+	// fetchedBytes is shared by the repositories created for branches.
+	fetchedBytes *int64
+	// diffs is the cache of the changes between trees. It's nil unless cacheDiffs of the pipeline is enabled.
+	diffs *diffCache
+	// blobs is the cache of the blob contents read for the plugins. It's nil unless cache.blob is set.
+	blobs *blobCache
+	// linkedPullRequests are the numbers of the pull requests keyed by the hashes of the commits which merged them.
+	// It's loaded by the API when the commits are walked if linkCommits of the pull request config is enabled.
+	linkedPullRequests map[string]int64
+	// pullRequests are the merged pull requests listed by the API, shared by the repositories of the same clone.
+	pullRequests *pullRequestCache
 }
 
-func NewRepository(ctx context.Context, mountPath string, cfg *RepositoryConfig) (*Repository, error) {
+func repositoryPath(mountPath string, cfg *RepositoryConfig) (string, error) {
+	if cfg.IsLocal() {
+		return filepath.Abs(cfg.Path)
+	}
 	repoPath, err := cfg.RepoPath()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get repository path")
+		return "", errors.Wrap(err, "failed to get repository path")
+	}
+	path := filepath.Join(mountPath, repoPath)
+	if legacy := legacyRepoPath(cfg.Repo); legacy != "" && !existsPath(path) {
+		// the clone of older versions is used as it is, so the caches keyed by its path stay valid.
+		if legacyPath := filepath.Join(mountPath, legacy); legacyPath != path && existsPath(legacyPath) {
+			return legacyPath, nil
+		}
+	}
+	return path, nil
+}
+
+// repositoryID returns the ID of the repository. The branch scanned as a separate stream has its own ID.
+func repositoryID(scheme IDScheme, mountPath string, cfg *RepositoryConfig, branch string) (string, error) {
+	repoPath, err := repositoryPath(mountPath, cfg)
+	if err != nil {
+		return "", err
+	}
+	if branch == "" {
+		return scheme.ID(repoPath), nil
+	}
+	return scheme.ID(repoPath, branch), nil
+}
+
+// WalkOptions controls how the commit history is walked.
+type WalkOptions struct {
+	// DiffMode is the diff semantics of merge commits.
+	DiffMode DiffMode
+	// IncludeRoot scans the root commit as the change set which adds all files ( default of pipelines ).
+	// Otherwise, the root commit is used only as the base tree of the next commit.
+	IncludeRoot bool
+	// Since is the last scanned commit. The walk resumes from the next commit of it,
+	// and the commits until it are passed to Scanned without Snapshot and Changes.
+	// If it isn't found in the history ( e.g. force pushed ), all commits are walked.
+	Since   string
+	Scanned func(*ScanContext) error
+	// Started is called with the number of commits passed to the callback and Scanned before walking.
+	Started func(total int)
+	// Filter excludes the commits from the walk.
+	Filter *FilterConfig
+	// DiffWorkers is the number of the commits diffed ahead while the callback processes the current one.
+	// The ScanContexts are still passed in commit order. Zero diffs each commit when it's reached.
+	DiffWorkers int
+	// NewestFirst walks the commits from HEAD backwards, and stops at the first commit reported by Cached.
+	// Each commit is diffed against the same commit as the walk from the oldest one. Since, Scanned and Started aren't used.
+	// It's supported by AllCommits and FirstParentCommits.
+	NewestFirst bool
+	Cached      func(commitHash string) (bool, error)
+	// MaxUncached stops the newest-first walk after the number of the commits. Zero means no limit.
+	MaxUncached int
+	// Period is the period sampled by PeriodicCommits.
+	Period Period
+	// MetadataOnly passes only Commit and the hash of the tree as Snapshot without reading the trees,
+	// so Changes are always empty. It's supported by AllCommits, FirstParentCommits and PeriodicCommits.
+	MetadataOnly bool
+}
+
+// resumeIndex returns the index of opt.Since in commits ordered from newest to oldest,
+// after passing the commits from oldest index to it to opt.Scanned. It returns -1 if the walk doesn't resume.
+func (opt *WalkOptions) resumeIndex(ctx context.Context, commits []*object.Commit, oldest int, topoIndexes map[plumbing.Hash]int64) (int, error) {
+	if opt.Since == "" {
+		return -1, nil
+	}
+	since := -1
+	for i, commit := range commits {
+		if commit.Hash.String() == opt.Since {
+			since = i
+			break
+		}
+	}
+	if since < 0 || opt.Scanned == nil {
+		return since, nil
+	}
+	for i := oldest; i >= since; i-- {
+		if err := ctx.Err(); err != nil {
+			return -1, err
+		}
+		commit := commits[i]
+		if opt.Filter.skip(commit) {
+			continue
+		}
+		if err := opt.Scanned(&ScanContext{
+			Commit:       toCommit(commit),
+			DiffMode:     opt.DiffMode,
+			TopoIndex:    topoIndexes[commit.Hash],
+			Data:         map[string]*treportproto.ScanResponse{},
+			pluginToType: map[string]string{},
+		}); err != nil {
+			return -1, err
+		}
+	}
+	return since, nil
+}
+
+func NewRepository(ctx context.Context, mountPath string, cfg *RepositoryConfig) (*Repository, error) {
+	repoPath, err := repositoryPath(mountPath, cfg)
+	if err != nil {
+		return nil, err
 	}
-	repoPath = filepath.Join(mountPath, repoPath)
+	lock := cloneLock(repoPath)
+	lock.Lock()
+	cloned := !cfg.IsLocal() && (cfg.Storage == StorageMemory || !existsPath(repoPath))
+	start := time.Now()
 	repo, err := newRepo(ctx, repoPath, cfg)
+	lock.Unlock()
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	if cloned {
+		loggerFrom(ctx).Info("cloned repository", "repo", cfg.Repo, "path", repoPath, "elapsed", time.Since(start))
+	}
+	var fetchedBytes int64
+	path := repoPath
+	if cfg.Storage == StorageMemory {
+		path = ""
+	} else if cloned {
+		fetchedBytes = gitDirSize(repoPath)
+	}
+	if path != "" && !cfg.IsLocal() {
+		touchClone(path)
+	}
+	return newRepository(repo, cfg, repoPath, path, fetchedBytes)
+}
+
+// OpenRepository opens the repository already cloned to path, e.g. the fixture of the test, as the local repository.
+func OpenRepository(path string) (*Repository, error) {
+	repoPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get path of %s", path)
+	}
+	repo, err := openRepo(repoPath)
 	if err != nil {
 		return nil, errors.Stack(err)
 	}
+	return newRepository(repo, &RepositoryConfig{Path: repoPath}, repoPath, repoPath, 0)
+}
+
+// AttachRepository returns the repository opened by the caller, e.g. the one in memory of the service.
+// The ID of the repository is made by path or repo of cfg, and the repository isn't cloned, fetched or synced by treport.
+func AttachRepository(repo *git.Repository, cfg *RepositoryConfig) (*Repository, error) {
+	if repo == nil {
+		return nil, fmt.Errorf("repository to attach is nil")
+	}
+	if cfg == nil {
+		cfg = &RepositoryConfig{}
+	}
+	key := cfg.identity()
+	var path string
+	if cfg.IsLocal() {
+		repoPath, err := filepath.Abs(cfg.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get path of %s", cfg.Path)
+		}
+		key = repoPath
+		path = repoPath
+	}
+	if key == "" {
+		return nil, fmt.Errorf("path or repo is required to attach repository")
+	}
+	return newRepository(repo, cfg, key, path, 0)
+}
+
+// newRepository returns the repository identified by key. path is the directory of the clone, or empty for the one in memory.
+func newRepository(repo *git.Repository, cfg *RepositoryConfig, key, path string, fetchedBytes int64) (*Repository, error) {
 	gitCfg, err := repo.Config()
 	if err != nil {
 		return nil, err
 	}
+	keyring, err := loadKeyring(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &Repository{
-		ID:         makeHashID(repoPath),
-		Repository: repo,
-		cfg:        cfg,
-		gitCfg:     gitCfg,
+		ID:           makeHashID(key),
+		legacyID:     LegacyIDScheme.ID(key),
+		Repository:   repo,
+		cfg:          cfg,
+		gitCfg:       gitCfg,
+		sync:         newRepoSync(key),
+		submodules:   newSubmoduleRepos(),
+		keyring:      keyring,
+		binaries:     newBinaryCache(),
+		pullRequests: newPullRequestCache(),
+		path:         path,
+		fetchedBytes: &fetchedBytes,
 	}, nil
 }
 
+// diffWorker returns the repository which reads the objects by its own storage,
+// because the object cache of the storage isn't safe for concurrent use.
+// The repository in memory is shared, because its objects are only read.
+func (r *Repository) diffWorker() (*Repository, error) {
+	if r.path == "" {
+		return r, nil
+	}
+	repo, err := openRepo(r.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open repository for diff worker")
+	}
+	worker := *r
+	worker.Repository = repo
+	return &worker, nil
+}
+
+// bytesFetched returns the bytes fetched by the clone and the syncs.
+func (r *Repository) bytesFetched() int64 {
+	if r.fetchedBytes == nil {
+		return 0
+	}
+	return atomic.LoadInt64(r.fetchedBytes)
+}
+
+// branchRepositories returns the repositories walking each branch matching branches of the config
+// after fetching the remote refs.
+func (r *Repository) branchRepositories(ctx context.Context, mountPath string) ([]*Repository, error) {
+	if err := r.syncRemoteBranches(ctx); err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch branches of %s", r.cfg.Repo)
+	}
+	branches, err := r.matchBranches()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find branches of %s", r.cfg.Repo)
+	}
+	repos := make([]*Repository, 0, len(branches))
+	for _, branch := range branches {
+		id, err := repositoryID(DefaultIDScheme, mountPath, r.cfg, branch)
+		if err != nil {
+			return nil, err
+		}
+		legacyID, err := repositoryID(LegacyIDScheme, mountPath, r.cfg, branch)
+		if err != nil {
+			return nil, err
+		}
+		branchRepo := *r
+		branchRepo.ID = id
+		branchRepo.legacyID = legacyID
+		branchRepo.scanBranch = branch
+		repos = append(repos, &branchRepo)
+	}
+	return repos, nil
+}
+
+// matchBranches returns the sorted branches matching branches of the config in the cloned and fetched refs.
+func (r *Repository) matchBranches() ([]string, error) {
+	refIter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refIter.Close()
+	specs := r.cfg.fetchRefSpecs(git.DefaultRemoteName)
+	found := map[string]struct{}{}
+	if err := refIter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		remote, fetched := remoteRefName(specs, ref.Name())
+		if !fetched || !remote.IsBranch() {
+			return nil
+		}
+		branch := remote.Short()
+		if !r.cfg.IsScannedBranch(branch) {
+			return nil
+		}
+		found[branch] = struct{}{}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	branches := make([]string, 0, len(found))
+	for branch := range found {
+		branches = append(branches, branch)
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// localBranches returns the branches scanned as separate streams in the local clone without fetching.
+// It returns only "" which means the base branch if branches of the config is empty.
+func localBranches(repoPath string, cfg *RepositoryConfig) ([]string, error) {
+	if len(cfg.Branches) == 0 {
+		return []string{""}, nil
+	}
+	if !existsPath(repoPath) {
+		return nil, nil
+	}
+	gitRepo, err := openRepo(repoPath)
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	repo := &Repository{Repository: gitRepo, cfg: cfg}
+	return repo.matchBranches()
+}
+
+// headHash returns the commit where the walk starts, which is the tip of scanBranch or HEAD.
+func (r *Repository) headHash() (plumbing.Hash, error) {
+	if r.scanBranch == "" {
+		head, err := r.Head()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return head.Hash(), nil
+	}
+	// the ref fetched by the refspecs is preferred, and the remote-tracking ref created by clone is the fallback.
+	names := []plumbing.ReferenceName{plumbing.NewRemoteReferenceName(git.DefaultRemoteName, r.scanBranch)}
+	if name, fetched := localRefName(r.cfg.fetchRefSpecs(git.DefaultRemoteName), plumbing.NewBranchReferenceName(r.scanBranch)); fetched {
+		names = append([]plumbing.ReferenceName{name}, names...)
+	}
+	for _, name := range names {
+		ref, err := r.Reference(name, true)
+		if err != nil {
+			if err == plumbing.ErrReferenceNotFound {
+				continue
+			}
+			return plumbing.ZeroHash, err
+		}
+		return ref.Hash(), nil
+	}
+	return plumbing.ZeroHash, ErrBranchNotFound(r.cfg.Repo, r.scanBranch)
+}
+
 func newRepo(ctx context.Context, repoPath string, cfg *RepositoryConfig) (*git.Repository, error) {
+	if cfg.IsLocal() {
+		return openRepo(repoPath)
+	}
+	if cfg.Storage == StorageMemory {
+		return cloneInMemory(ctx, cfg)
+	}
+	if cfg.Clone != nil && cfg.Clone.Incremental {
+		return cloneIncrementally(ctx, repoPath, cfg)
+	}
 	if !existsPath(repoPath) {
 		if err := mkdirForClone(repoPath); err != nil {
 			return nil, errors.Wrap(err, "failed to create directory for cloning repository")
 		}
-		repo, err := git.PlainCloneContext(ctx, repoPath, false, &git.CloneOptions{
+		opt := &git.CloneOptions{
 			URL:  cfg.Repo,
-			Auth: cfg.Auth.BasicAuth(),
+			Auth: cfg.basicAuth(),
+		}
+		w := newCloneProgressWriter(ctx, cfg.Repo, CloneOperationClone, 0, 0)
+		if w != nil {
+			opt.Progress = w
+		}
+		stop := w.watchPack(repoPath)
+		defer stop()
+		var repo *git.Repository
+		err := cfg.remote(ctx, func() error {
+			r, err := git.PlainCloneContext(ctx, repoPath, cfg.Clone.bare(), opt)
+			if err != nil {
+				// remove the partial clone to retry from scratch.
+				_ = os.RemoveAll(repoPath)
+				return gitError("clone", err)
+			}
+			repo = r
+			return nil
 		})
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to clone repository. url:%s auth:%v", cfg.Repo, cfg.Auth.BasicAuth())
+			if err == transport.ErrEmptyRemoteRepository {
+				return nil, ErrEmptyRepository(cfg.Repo)
+			}
+			return nil, errors.Wrapf(err, "failed to clone repository. url:%s auth:%v", cfg.Repo, cfg.basicAuth())
 		}
 		return repo, nil
 	}
-	repo, err := git.PlainOpen(repoPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to open repository")
-	}
-	return repo, nil
+	return openRepo(repoPath)
 }
 
+// pullRequestHeads returns the heads of the pull requests fetched to the remote-tracking refs keyed by their hashes.
+// The refs are named by the refs of the remote like refs/pull/1/head.
 func (r *Repository) pullRequestHeads() (map[string]*plumbing.Reference, error) {
-	branchIter, err := r.Branches()
+	refIter, err := r.References()
 	if err != nil {
 		return nil, err
 	}
-
+	defer refIter.Close()
+	specs := r.cfg.fetchRefSpecs(git.DefaultRemoteName)
 	pullRequestHeads := map[string]*plumbing.Reference{}
-	for {
-		branch, err := branchIter.Next()
-		if err != nil {
-			if err == io.EOF {
-				return pullRequestHeads, nil
-			}
-			return nil, err
+	if err := refIter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		remote, fetched := remoteRefName(specs, ref.Name())
+		if !fetched || !r.cfg.PullRequest.isHeadRef(remote) {
+			return nil
 		}
-		if strings.HasPrefix(string(branch.Name()), "refs/heads/pull/") {
-			pullRequestHeads[branch.Hash().String()] = branch
+		if r.cfg.IsSkippedBranch(remote.Short()) {
+			return nil
 		}
+		pullRequestHeads[ref.Hash().String()] = plumbing.NewHashReference(remote, ref.Hash())
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 	return pullRequestHeads, nil
 }
 
 func (r *Repository) HeadOnly(ctx context.Context, cb func(*ScanContext) error) error {
-	iter, err := r.Log(&git.LogOptions{Order: git.LogOrderCommitterTime})
+	head, err := r.headHash()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get head")
+	}
+	iter, err := r.Log(&git.LogOptions{From: head, Order: git.LogOrderCommitterTime})
 	if err != nil {
 		return errors.Wrapf(err, "failed to get log")
 	}
+	defer iter.Close()
 
 	commit, err := iter.Next()
 	if err != nil {
@@ -110,11 +478,12 @@ func (r *Repository) HeadOnly(ctx context.Context, cb func(*ScanContext) error)
 	if err != nil {
 		return errors.Wrapf(err, "failed to get worktree")
 	}
-	snapshot, err := toSnapshot(curTree)
+	snapshot, err := r.snapshot(ctx, curTree)
 	if err != nil {
 		return errors.Wrapf(err, "failed to convert snapshot")
 	}
-	scanctx.Commit = toCommit(commit)
+	scanctx.Commit = r.toCommit(commit)
+	scanctx.Merge = r.mergeInfo(commit)
 	scanctx.Snapshot = snapshot
 	if err := cb(scanctx); err != nil {
 		return errors.Stack(err)
@@ -122,227 +491,1143 @@ func (r *Repository) HeadOnly(ctx context.Context, cb func(*ScanContext) error)
 	return nil
 }
 
-func (r *Repository) AllCommits(ctx context.Context, cb func(*ScanContext) error) error {
-	iter, err := r.Log(&git.LogOptions{Order: git.LogOrderCommitterTime})
+// Revision calls cb with the ScanContext of the revision.
+// Since the revision is scanned alone, all files are reported as Added changes.
+func (r *Repository) Revision(ctx context.Context, rev string, cb func(*ScanContext) error) error {
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "failed to resolve revision %s", rev)
 	}
-	allCommits := []*object.Commit{}
-	for {
-		commit, err := iter.Next()
-		if err != nil {
-			if err != io.EOF {
-				return err
-			}
-			break
-		}
-		allCommits = append(allCommits, commit)
+	commit, err := r.CommitObject(*hash)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get commit object of %s", rev)
+	}
+	curTree, err := commit.Tree()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get tree of %s", rev)
+	}
+	changes, err := r.diffTree(ctx, nil, curTree)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get changes of %s", rev)
+	}
+	snapshot, err := r.snapshot(ctx, curTree)
+	if err != nil {
+		return errors.Wrapf(err, "failed to convert snapshot")
+	}
+	scanctx := &ScanContext{
+		Commit:       r.toCommit(commit),
+		Merge:        r.mergeInfo(commit),
+		Snapshot:     snapshot,
+		Changes:      changes,
+		Data:         map[string]*treportproto.ScanResponse{},
+		pluginToType: map[string]string{},
+	}
+	if err := cb(scanctx); err != nil {
+		return errors.Stack(err)
 	}
+	return nil
+}
 
+// Commit calls cb with the ScanContext of the commit. The changes are diffed against its first parent,
+// and all files are reported as Added changes if it's the root commit.
+func (r *Repository) Commit(ctx context.Context, hash plumbing.Hash, cb func(*ScanContext) error) error {
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get commit object of %s", hash)
+	}
+	curTree, changes, err := r.firstParentChanges(ctx, commit)
+	if err != nil {
+		return errors.Stack(err)
+	}
+	snapshot, err := r.snapshot(ctx, curTree)
+	if err != nil {
+		return errors.Wrapf(err, "failed to convert snapshot")
+	}
 	scanctx := &ScanContext{
+		Commit:       r.toCommit(commit),
+		Merge:        r.mergeInfo(commit),
+		Snapshot:     snapshot,
+		Changes:      changes,
 		Data:         map[string]*treportproto.ScanResponse{},
 		pluginToType: map[string]string{},
 	}
+	if err := cb(scanctx); err != nil {
+		return errors.Stack(err)
+	}
+	return nil
+}
+
+// firstParentChanges returns the tree of the commit and the changes from its first parent.
+func (r *Repository) firstParentChanges(ctx context.Context, commit *object.Commit) (*object.Tree, Changes, error) {
 	var prevTree *object.Tree
-	for i := len(allCommits) - 1; i > 0; i-- {
-		commit := allCommits[i]
-		if prevTree == nil {
-			// first PR
-			tree, err := r.firstTree(commit)
-			if err != nil {
-				return err
-			}
-			prevTree = tree
-		}
-		curTree, err := commit.Tree()
-		if err != nil {
-			return err
-		}
-		changes, err := prevTree.DiffContext(ctx, curTree)
-		if err != nil {
-			return err
+	if commit.NumParents() > 0 {
+		tree, err := r.firstTree(commit)
+		if err != nil && err != plumbing.ErrObjectNotFound {
+			return nil, nil, errors.Wrapf(err, "failed to get tree of parent of %s", commit.Hash)
 		}
-		convertedChanges, err := toChanges(changes, prevTree, curTree)
-		if err != nil {
-			return err
-		}
-		snapshot, err := toSnapshot(curTree)
-		if err != nil {
-			return err
-		}
-		scanctx.Commit = toCommit(commit)
-		scanctx.Snapshot = snapshot
-		scanctx.Changes = convertedChanges
-		if err := cb(scanctx); err != nil {
-			return err
-		}
-		prevTree = curTree
+		// the parent of the bottom commit of shallow history doesn't exist, so it's treated as the root commit.
+		prevTree = tree
 	}
-	return nil
+	curTree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get tree of %s", commit.Hash)
+	}
+	changes, err := r.diffTree(ctx, prevTree, curTree)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get changes of %s", commit.Hash)
+	}
+	return curTree, changes, nil
 }
 
-func (r *Repository) AllMergeCommits(ctx context.Context, cb func(*ScanContext) error) error {
-	prHeads, err := r.pullRequestHeads()
+// changedPaths returns the paths of the files changed by the commit from its first parent.
+// The commit which isn't in the repository like the working tree doesn't have them.
+func (r *Repository) changedPaths(hash string) ([]string, error) {
+	commit, err := r.CommitObject(plumbing.NewHash(hash))
+	if err == plumbing.ErrObjectNotFound {
+		return []string{}, nil
+	}
 	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "failed to get commit object of %s", hash)
+	}
+	_, changes, err := r.firstParentChanges(context.Background(), commit)
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		paths = append(paths, change.Path())
 	}
+	return paths, nil
+}
 
-	iter, err := r.Log(&git.LogOptions{Order: git.LogOrderCommitterTime})
+// logCommits returns all commits from HEAD or scanBranch ordered from newest to oldest.
+func (r *Repository) logCommits(ctx context.Context) ([]*object.Commit, error) {
+	if err := r.linkPullRequests(ctx); err != nil {
+		return nil, errors.Wrapf(err, "failed to link pull requests")
+	}
+	head, err := r.headHash()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	prCommits := []*object.Commit{}
+	iter, err := r.Log(&git.LogOptions{From: head, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	commits := []*object.Commit{}
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		commit, err := iter.Next()
 		if err != nil {
 			if err != io.EOF {
-				return err
+				return nil, err
 			}
 			break
 		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+// pullRequestMergeCommits returns the commits merged pull requests in commits, and the pull requests keyed by the hashes of them.
+// With the API providers, the squashed and rebased pull requests are also included as non-merge commits.
+func (r *Repository) pullRequestMergeCommits(ctx context.Context, commits []*object.Commit) ([]*object.Commit, map[string]*PullRequest, error) {
+	if r.cfg.PullRequest.provider() != PullRequestRefs {
+		return r.pullRequestMergeCommitsByAPI(ctx, commits)
+	}
+	prHeads, err := r.pullRequestHeads()
+	if err != nil {
+		return nil, nil, err
+	}
+	prCommits := []*object.Commit{}
+	pullRequests := map[string]*PullRequest{}
+	for _, commit := range commits {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
 		if commit.NumParents() <= 1 {
 			continue
 		}
 
 		commitIter := commit.Parents()
 		isDirectParent := true
-		isPRCommit := false
+		var pr *PullRequest
 		for {
 			parent, err := commitIter.Next()
 			if err != nil {
 				if err != io.EOF {
-					return err
+					return nil, nil, err
 				}
 				break
 			}
-			if !isDirectParent {
-				if _, exists := prHeads[parent.Hash.String()]; exists {
-					isPRCommit = true
+			if !isDirectParent && pr == nil {
+				if head, exists := prHeads[parent.Hash.String()]; exists {
+					pr = refPullRequest(head, commit, parent)
 				}
 			}
 			isDirectParent = false
 		}
-		if !isPRCommit {
+		if pr == nil {
 			continue
 		}
 		prCommits = append(prCommits, commit)
+		pullRequests[commit.Hash.String()] = pr
 	}
+	return prCommits, pullRequests, nil
+}
 
-	scanctx := &ScanContext{
-		Data:         map[string]*treportproto.ScanResponse{},
-		pluginToType: map[string]string{},
+// refPullRequest returns the pull request of the head ref like refs/pull/1/head. The title is the body of
+// the merge commit message written by GitHub, or the subject of the head commit.
+func refPullRequest(head *plumbing.Reference, merge, headCommit *object.Commit) *PullRequest {
+	pr := &PullRequest{Author: headCommit.Author.Name}
+	parts := strings.Split(head.Name().Short(), "/")
+	if len(parts) >= 2 {
+		pr.Number, _ = strconv.ParseInt(parts[1], 10, 64)
 	}
-	var prevTree *object.Tree
-	for i := len(prCommits) - 1; i > 0; i-- {
-		commit := prCommits[i]
-		if prevTree == nil {
-			// first PR
-			tree, err := r.firstTree(commit)
-			if err != nil {
-				return err
+	lines := strings.Split(strings.TrimSpace(merge.Message), "\n")
+	if strings.HasPrefix(lines[0], "Merge pull request ") {
+		for _, line := range lines[1:] {
+			if line = strings.TrimSpace(line); line != "" {
+				pr.Title = line
+				break
 			}
-			prevTree = tree
 		}
-		curTree, err := commit.Tree()
-		if err != nil {
-			return err
+	}
+	if pr.Title == "" {
+		pr.Title = strings.SplitN(strings.TrimSpace(headCommit.Message), "\n", 2)[0]
+	}
+	return pr
+}
+
+// visitedCommits returns the commits passed to the callback by the strategy, ordered from oldest to newest.
+// Worktree visits no commit because the working tree isn't committed.
+func (r *Repository) visitedCommits(ctx context.Context, strategy Strategy, opt *WalkOptions) ([]*object.Commit, error) {
+	if strategy == Worktree {
+		return nil, nil
+	}
+	var (
+		allCommits []*object.Commit
+		err        error
+	)
+	switch strategy {
+	case FirstParent:
+		allCommits, err = r.firstParentCommits(ctx)
+	case Periodic:
+		allCommits, err = r.periodicCommits(ctx, opt.Period)
+	default:
+		allCommits, err = r.logCommits(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(allCommits) == 0 {
+		return nil, nil
+	}
+	var commits []*object.Commit
+	switch strategy {
+	case HeadOnly:
+		return allCommits[:1], nil
+	case AllCommit, FirstParent, Periodic, Metadata:
+		commits = allCommits
+		if oldest := len(commits) - 1; commits[oldest].NumParents() == 0 && !opt.IncludeRoot {
+			commits = commits[:oldest]
 		}
-		changes, err := prevTree.DiffContext(ctx, curTree)
+	case AllMergeCommit, EachPullRequest:
+		prCommits, _, err := r.pullRequestMergeCommits(ctx, allCommits)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		convertedChanges, err := toChanges(changes, prevTree, curTree)
-		if err != nil {
-			return err
+		if len(prCommits) == 0 {
+			return nil, nil
 		}
-		snapshot, err := toSnapshot(curTree)
-		if err != nil {
-			return err
+		commits = prCommits
+		if opt.diffsPreviousMergeCommit(strategy == EachPullRequest) {
+			// the oldest merge commit is used only as the base tree.
+			commits = prCommits[:len(prCommits)-1]
 		}
-		scanctx.Commit = toCommit(commit)
-		scanctx.Snapshot = snapshot
-		scanctx.Changes = convertedChanges
-		if err := cb(scanctx); err != nil {
-			return err
+	}
+	visited := make([]*object.Commit, 0, len(commits))
+	for i := len(commits) - 1; i >= 0; i-- {
+		if opt.Filter.skip(commits[i]) {
+			continue
 		}
-		prevTree = curTree
+		visited = append(visited, commits[i])
 	}
-	return nil
+	return visited, nil
 }
 
-func (r *Repository) firstTree(commit *object.Commit) (*object.Tree, error) {
-	commitIter := commit.Parents()
-	firstParent, err := commitIter.Next()
+// firstParentCommits returns the first-parent chain of HEAD or scanBranch ordered from newest to oldest.
+// If the history is shallow, the chain ends at the commit whose parent doesn't exist.
+func (r *Repository) firstParentCommits(ctx context.Context) ([]*object.Commit, error) {
+	if err := r.linkPullRequests(ctx); err != nil {
+		return nil, errors.Wrapf(err, "failed to link pull requests")
+	}
+	head, err := r.headHash()
 	if err != nil {
 		return nil, err
 	}
-	firstTree, err := firstParent.Tree()
+	commit, err := r.CommitObject(head)
 	if err != nil {
 		return nil, err
 	}
-	return firstTree, nil
+	commits := []*object.Commit{commit}
+	for commit.NumParents() > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		parent, err := commit.Parent(0)
+		if err != nil {
+			if err == plumbing.ErrObjectNotFound {
+				break
+			}
+			return nil, err
+		}
+		commits = append(commits, parent)
+		commit = parent
+	}
+	return commits, nil
+}
+
+func (r *Repository) AllCommits(ctx context.Context, opt *WalkOptions, cb func(*ScanContext) error) error {
+	allCommits, err := r.logCommits(ctx)
+	if err != nil {
+		return err
+	}
+	return r.walkCommits(ctx, opt, allCommits, false, cb)
+}
+
+// FirstParentCommits walks only the first-parent chain of HEAD, so each commit is diffed against its first parent
+// and the changes of a merge commit are the changes merged into the branch. The merge commits are diffed by opt.DiffMode.
+func (r *Repository) FirstParentCommits(ctx context.Context, opt *WalkOptions, cb func(*ScanContext) error) error {
+	commits, err := r.firstParentCommits(ctx)
+	if err != nil {
+		return err
+	}
+	return r.walkCommits(ctx, opt, commits, true, cb)
+}
+
+// PeriodicCommits walks the last commit of each period on the first-parent chain of HEAD.
+// Each of them is diffed against the previous one, so the changes are the ones made in the period.
+func (r *Repository) PeriodicCommits(ctx context.Context, opt *WalkOptions, cb func(*ScanContext) error) error {
+	commits, err := r.periodicCommits(ctx, opt.Period)
+	if err != nil {
+		return err
+	}
+	return r.walkCommits(ctx, opt, commits, false, cb)
+}
+
+// periodicCommits returns the last commit of each period by the committer time ordered from newest to oldest.
+func (r *Repository) periodicCommits(ctx context.Context, period Period) ([]*object.Commit, error) {
+	commits, err := r.firstParentCommits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	sampled := make([]*object.Commit, 0, len(commits))
+	for _, commit := range commits {
+		key := period.key(commit.Committer.When)
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		seen[key] = struct{}{}
+		sampled = append(sampled, commit)
+	}
+	return sampled, nil
+}
+
+// walkCommits passes commits ordered from newest to oldest to cb from the oldest one.
+// Each commit is diffed against the previous one. If firstParent is true, the merge commits are diffed by opt.DiffMode.
+func (r *Repository) walkCommits(ctx context.Context, opt *WalkOptions, allCommits []*object.Commit, firstParent bool, cb func(*ScanContext) error) error {
+	// the workers of diffQueue are stopped when the walk returns.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	it, err := r.newScanIterator(ctx, opt, allCommits, firstParent)
+	if err != nil {
+		return err
+	}
+	for it.Next() {
+		if err := cb(it.ScanContext()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func (r *Repository) AllMergeCommits(ctx context.Context, opt *WalkOptions, cb func(*ScanContext) error) error {
+	return r.walkMergeCommits(ctx, opt, cb, false)
+}
+
+// PullRequests walks the same commits as AllMergeCommits, and passes each pull request as the unit.
+// The changes are the ones of the pull request since it forked from the mainline regardless of opt.DiffMode,
+// and ScanContext.PullRequest has the metadata and the commits of the pull request.
+func (r *Repository) PullRequests(ctx context.Context, opt *WalkOptions, cb func(*ScanContext) error) error {
+	return r.walkMergeCommits(ctx, opt, cb, true)
+}
+
+// diffsPreviousMergeCommit reports whether each merge commit of the walk is diffed against the previous one,
+// so the oldest merge commit is used only as the base tree. The pull requests are always diffed against their merge bases.
+func (opt *WalkOptions) diffsPreviousMergeCommit(byPullRequest bool) bool {
+	return !byPullRequest && (opt.DiffMode == "" || opt.DiffMode == DiffPrevious)
+}
+
+func (r *Repository) walkMergeCommits(ctx context.Context, opt *WalkOptions, cb func(*ScanContext) error, byPullRequest bool) error {
+	allCommits, err := r.logCommits(ctx)
+	if err != nil {
+		return err
+	}
+	prCommits, pullRequests, err := r.pullRequestMergeCommits(ctx, allCommits)
+	if err != nil {
+		return err
+	}
+
+	topoIndexes := topoIndexes(allCommits)
+	scanctx := &ScanContext{
+		DiffMode:     opt.DiffMode,
+		Data:         map[string]*treportproto.ScanResponse{},
+		pluginToType: map[string]string{},
+	}
+	needsBase := opt.diffsPreviousMergeCommit(byPullRequest)
+	var prevTree *object.Tree
+	start := len(prCommits) - 1
+	if needsBase && start >= 0 {
+		// the oldest merge commit is used only as the base tree of the next one.
+		tree, err := prCommits[start].Tree()
+		if err != nil {
+			return err
+		}
+		prevTree = tree
+		start--
+	}
+	if opt.Started != nil && start >= 0 {
+		opt.Started(opt.Filter.count(prCommits[:start+1]))
+	}
+	since, err := opt.resumeIndex(ctx, prCommits, start, topoIndexes)
+	if err != nil {
+		return err
+	}
+	if since >= 0 {
+		if needsBase {
+			tree, err := prCommits[since].Tree()
+			if err != nil {
+				return err
+			}
+			prevTree = tree
+		}
+		start = since - 1
+	}
+	// the workers of diffQueue are stopped when the walk returns.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	i := start
+	plan := func() *diffTask {
+		// the merge commits diffed by the other modes than previous are diffed against their own parents,
+		// so prevTree is nil for them.
+		for ; i >= 0; i-- {
+			if err := ctx.Err(); err != nil {
+				return failedDiffTask(err)
+			}
+			commit := prCommits[i]
+			curTree, err := commit.Tree()
+			if err != nil {
+				return failedDiffTask(err)
+			}
+			baseTree := prevTree
+			if needsBase {
+				prevTree = curTree
+			}
+			if opt.Filter.skip(commit) {
+				continue
+			}
+			i--
+			return newDiffTask(commit, baseTree, curTree)
+		}
+		return nil
+	}
+	diff := func(r *Repository, task *diffTask) error {
+		commit, prevTree, curTree, err := task.objects(r)
+		if err != nil {
+			return err
+		}
+		diffMode := opt.DiffMode
+		if byPullRequest {
+			diffMode = DiffMergeBase
+			pr, err := r.pullRequest(commit, pullRequests[commit.Hash.String()])
+			if err != nil {
+				return err
+			}
+			task.pullRequest = pr
+		}
+		changes, err := r.mergeCommitChanges(ctx, commit, prevTree, curTree, diffMode)
+		if err != nil {
+			return err
+		}
+		snapshot, err := r.snapshot(ctx, curTree)
+		if err != nil {
+			return err
+		}
+		task.commit = r.toCommit(commit)
+		task.merge = r.mergeInfo(commit)
+		task.changes = changes
+		task.snapshot = snapshot
+		return nil
+	}
+	diffs := newDiffQueue(r, opt.DiffWorkers, plan, diff)
+	for task := diffs.next(); task != nil; task = diffs.next() {
+		if task.err != nil {
+			return task.err
+		}
+		scanctx.Commit = task.commit
+		scanctx.Snapshot = task.snapshot
+		scanctx.Changes = task.changes
+		scanctx.PullRequest = task.pullRequest
+		scanctx.Merge = task.merge
+		scanctx.TopoIndex = topoIndexes[task.src.Hash]
+		if err := cb(scanctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pullRequest returns the copy of the pull request merged by the commit with its commits.
+// The commits are on the first-parent chain of the merged head until the merge base, so the mainline merged into
+// the pull request isn't included. The squashed or rebased pull request has only the commit.
+func (r *Repository) pullRequest(commit *object.Commit, found *PullRequest) (*PullRequest, error) {
+	pr := &PullRequest{}
+	if found != nil {
+		*pr = *found
+	}
+	if commit.NumParents() < 2 {
+		pr.Commits = []*Commit{r.toCommit(commit)}
+		if pr.Title == "" {
+			pr.Title = strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0]
+		}
+		return pr, nil
+	}
+	mainline, err := commit.Parent(0)
+	if err != nil {
+		return nil, err
+	}
+	head, err := commit.Parent(1)
+	if err != nil {
+		return nil, err
+	}
+	bases, err := mainline.MergeBase(head)
+	if err != nil {
+		return nil, err
+	}
+	isBase := map[plumbing.Hash]struct{}{}
+	for _, base := range bases {
+		isBase[base.Hash] = struct{}{}
+	}
+	commits := []*Commit{}
+	for cur := head; ; {
+		if _, exists := isBase[cur.Hash]; exists {
+			break
+		}
+		commits = append(commits, r.toCommit(cur))
+		if cur.NumParents() == 0 {
+			break
+		}
+		if cur, err = cur.Parent(0); err != nil {
+			return nil, err
+		}
+	}
+	// ordered from oldest to newest.
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	pr.Commits = commits
+	return pr, nil
+}
+
+// mergeInfo returns the ancestry of the merge commit, or nil if the commit isn't merge commit.
+func (r *Repository) mergeInfo(commit *object.Commit) *MergeInfo {
+	if commit.NumParents() < 2 {
+		return nil
+	}
+	info := &MergeInfo{
+		Mainline: commit.ParentHashes[0].String(),
+		Head:     commit.ParentHashes[1].String(),
+	}
+	mainline, err := commit.Parent(0)
+	if err != nil {
+		return info
+	}
+	head, err := commit.Parent(1)
+	if err != nil {
+		return info
+	}
+	bases, err := mainline.MergeBase(head)
+	if err != nil || len(bases) == 0 {
+		return info
+	}
+	info.MergeBase = bases[0].Hash.String()
+	return info
+}
+
+func (r *Repository) mergeCommitChanges(ctx context.Context, commit *object.Commit, prevTree, curTree *object.Tree, diffMode DiffMode) (Changes, error) {
+	switch diffMode {
+	case DiffFirstParent:
+		if commit.NumParents() == 0 {
+			// the squashed pull request of the root commit adds all files.
+			return r.diffTree(ctx, nil, curTree)
+		}
+		tree, err := r.firstTree(commit)
+		if err != nil {
+			return nil, err
+		}
+		return r.diffTree(ctx, tree, curTree)
+	case DiffMergeBase:
+		if commit.NumParents() < 2 {
+			// squashed or rebased pull request.
+			return r.mergeCommitChanges(ctx, commit, prevTree, curTree, DiffFirstParent)
+		}
+		changes, err := r.mergeBaseChanges(ctx, commit)
+		if err != nil {
+			var orphanErr *OrphanBranchError
+			if !errors.As(err, &orphanErr) {
+				return nil, err
+			}
+			// merged unrelated history. fallback to diff against first parent.
+			return r.mergeCommitChanges(ctx, commit, prevTree, curTree, DiffFirstParent)
+		}
+		return changes, nil
+	case DiffCombined:
+		return r.combinedChanges(ctx, commit, curTree)
+	}
+	return r.diffTree(ctx, prevTree, curTree)
+}
+
+// mergeBaseChanges returns the changes introduced by the merged head since it forked from the mainline.
+func (r *Repository) mergeBaseChanges(ctx context.Context, commit *object.Commit) (Changes, error) {
+	mainline, err := commit.Parent(0)
+	if err != nil {
+		return nil, err
+	}
+	head, err := commit.Parent(1)
+	if err != nil {
+		return nil, err
+	}
+	bases, err := mainline.MergeBase(head)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, ErrOrphanBranch(r.cfg.Repo, head.Hash.String())
+	}
+	baseTree, err := bases[0].Tree()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return r.diffTree(ctx, baseTree, headTree)
+}
+
+// combinedChanges returns the changes against the first parent for paths which differ from every parent.
+func (r *Repository) combinedChanges(ctx context.Context, commit *object.Commit, curTree *object.Tree) (Changes, error) {
+	var (
+		result  Changes
+		counter = map[string]int{}
+	)
+	for i := 0; i < commit.NumParents(); i++ {
+		parent, err := commit.Parent(i)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+		changes, err := r.diffTree(ctx, parentTree, curTree)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			result = changes
+		}
+		for _, change := range changes {
+			counter[change.Path()]++
+		}
+	}
+	combined := Changes{}
+	for _, change := range result {
+		if counter[change.Path()] == commit.NumParents() {
+			combined = append(combined, change)
+		}
+	}
+	return combined, nil
 }
 
+// topoIndexes assigns each commit an index in topological order ( parents before children ).
+// Commits which are ready at the same time are ordered by committer time,
+// so that the index doesn't depend on skewed timestamps across branches.
+func topoIndexes(commits []*object.Commit) map[plumbing.Hash]int64 {
+	commitMap := make(map[plumbing.Hash]*object.Commit, len(commits))
+	for _, commit := range commits {
+		commitMap[commit.Hash] = commit
+	}
+	children := map[plumbing.Hash][]*object.Commit{}
+	inDegree := make(map[plumbing.Hash]int, len(commits))
+	for _, commit := range commits {
+		for _, parentHash := range commit.ParentHashes {
+			if _, exists := commitMap[parentHash]; !exists {
+				continue
+			}
+			children[parentHash] = append(children[parentHash], commit)
+			inDegree[commit.Hash]++
+		}
+	}
+	ready := &commitQueue{}
+	for _, commit := range commits {
+		if inDegree[commit.Hash] == 0 {
+			heap.Push(ready, commit)
+		}
+	}
+	indexes := make(map[plumbing.Hash]int64, len(commits))
+	for ready.Len() > 0 {
+		commit := heap.Pop(ready).(*object.Commit)
+		indexes[commit.Hash] = int64(len(indexes))
+		for _, child := range children[commit.Hash] {
+			inDegree[child.Hash]--
+			if inDegree[child.Hash] == 0 {
+				heap.Push(ready, child)
+			}
+		}
+	}
+	return indexes
+}
+
+type commitQueue []*object.Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if q[i].Committer.When.Equal(q[j].Committer.When) {
+		return q[i].Hash.String() < q[j].Hash.String()
+	}
+	return q[i].Committer.When.Before(q[j].Committer.When)
+}
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(*object.Commit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	commit := old[n-1]
+	*q = old[:n-1]
+	return commit
+}
+
+// diffTree returns changes between trees. If from is nil, all files in to are reported as Added.
+func diffTree(ctx context.Context, from, to *object.Tree) (Changes, error) {
+	changes, err := object.DiffTreeContext(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return toChanges(changes, from, to)
+}
+
+func (r *Repository) firstTree(commit *object.Commit) (*object.Tree, error) {
+	commitIter := commit.Parents()
+	firstParent, err := commitIter.Next()
+	if err != nil {
+		return nil, err
+	}
+	firstTree, err := firstParent.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return firstTree, nil
+}
+
+// IsEmpty reports whether the repository has no commits.
+func (r *Repository) IsEmpty() (bool, error) {
+	if _, err := r.Head(); err != nil {
+		if err != plumbing.ErrReferenceNotFound {
+			return false, err
+		}
+	} else {
+		return false, nil
+	}
+	refIter, err := r.References()
+	if err != nil {
+		return false, err
+	}
+	defer refIter.Close()
+	isEmpty := true
+	if err := refIter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			isEmpty = false
+			return storer.ErrStop
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return isEmpty, nil
+}
+
+// IsOrphanBranch reports whether the branch has no common ancestor with the base branch like gh-pages.
+func (r *Repository) IsOrphanBranch(branch string) (bool, error) {
+	baseBranch, err := r.BaseBranch()
+	if err != nil {
+		return false, err
+	}
+	baseCommit, err := r.branchCommit(baseBranch.Merge)
+	if err != nil {
+		return false, err
+	}
+	commit, err := r.branchCommit(plumbing.NewBranchReferenceName(branch))
+	if err != nil {
+		return false, err
+	}
+	bases, err := baseCommit.MergeBase(commit)
+	if err != nil {
+		return false, err
+	}
+	return len(bases) == 0, nil
+}
+
+func (r *Repository) branchCommit(name plumbing.ReferenceName) (*object.Commit, error) {
+	ref, err := r.Reference(name, true)
+	if err != nil {
+		if err == plumbing.ErrReferenceNotFound {
+			return nil, ErrBranchNotFound(r.cfg.Repo, name.Short())
+		}
+		return nil, err
+	}
+	return r.CommitObject(ref.Hash())
+}
+
+// BaseBranch returns the branch scanned by default. branch of the config overrides it.
+// Otherwise, it's the branch HEAD of the remote points to, which is recorded to refs/remotes/origin/HEAD by fetch,
+// and the branch sections in the config of the repository are the fallback.
 func (r *Repository) BaseBranch() (*config.Branch, error) {
+	isEmpty, err := r.IsEmpty()
+	if err != nil {
+		return nil, err
+	}
+	if isEmpty {
+		return nil, ErrEmptyRepository(r.cfg.Repo)
+	}
 	cfg, err := r.Config()
 	if err != nil {
 		return nil, err
 	}
+	if r.cfg.Branch != "" {
+		return r.branchOrRemote(cfg, r.cfg.Branch), nil
+	}
+	remoteHead, err := r.remoteHeadBranch(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	if remoteHead != "" {
+		return r.branchOrRemote(cfg, remoteHead), nil
+	}
 	defaultBranch := cfg.Init.DefaultBranch
 	if defaultBranch != "" {
-		return r.Branch(defaultBranch)
+		return r.branch(defaultBranch)
 	}
 	if len(cfg.Branches) != 1 {
-		return nil, fmt.Errorf("failed to find base branch")
+		return nil, ErrBranchNotFound(r.cfg.Repo, "")
 	}
 	for branch := range cfg.Branches {
-		return r.Branch(branch)
+		return r.branch(branch)
+	}
+	return nil, ErrBranchNotFound(r.cfg.Repo, "")
+}
+
+// branchOrRemote returns the branch section of the config, or the branch tracking the one of the remote if the section doesn't exist.
+func (r *Repository) branchOrRemote(cfg *config.Config, name string) *config.Branch {
+	if branch, exists := cfg.Branches[name]; exists {
+		return branch
+	}
+	return &config.Branch{Name: name, Remote: git.DefaultRemoteName, Merge: plumbing.NewBranchReferenceName(name)}
+}
+
+// remoteHeadBranch returns the branch which HEAD of the remote points to. It's empty if HEAD of the remote isn't recorded.
+func (r *Repository) remoteHeadBranch(remoteName string) (string, error) {
+	ref, err := r.Storer.Reference(plumbing.NewRemoteHEADReferenceName(remoteName))
+	if err != nil {
+		if err == plumbing.ErrReferenceNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	if ref.Type() != plumbing.SymbolicReference {
+		return "", nil
+	}
+	if remote, fetched := remoteRefName(r.cfg.fetchRefSpecs(remoteName), ref.Target()); fetched && remote.IsBranch() {
+		return remote.Short(), nil
 	}
-	return nil, fmt.Errorf("failed to find base branch")
+	if strings.HasPrefix(string(ref.Target()), remoteRefPrefix(remoteName)) {
+		return strings.TrimPrefix(string(ref.Target()), remoteRefPrefix(remoteName)), nil
+	}
+	return "", nil
 }
 
+func (r *Repository) branch(name string) (*config.Branch, error) {
+	branch, err := r.Branch(name)
+	if err != nil {
+		if err == git.ErrBranchNotFound {
+			return nil, ErrBranchNotFound(r.cfg.Repo, name)
+		}
+		return nil, err
+	}
+	return branch, nil
+}
+
+// Sync fetches the remote, and checks out and pulls the branch to the worktree.
 func (r *Repository) Sync(ctx context.Context, branch plumbing.ReferenceName) error {
+	return r.syncWith(ctx, branch, false)
+}
+
+// SyncRefs fetches the remote and points the branch and HEAD to the fetched commit without checking out the worktree,
+// because the strategies reading the trees from the object database don't use it.
+// The worktree is still checked out if the submodules are cloned by the files mode, because they are resolved from it.
+func (r *Repository) SyncRefs(ctx context.Context, branch plumbing.ReferenceName) error {
+	return r.syncWith(ctx, branch, r.cfg.Submodules.mode() != SubmoduleFiles)
+}
+
+func (r *Repository) syncWith(ctx context.Context, branch plumbing.ReferenceName, refsOnly bool) error {
+	if r.path != "" && r.fetchedBytes != nil {
+		before := gitDirSize(r.path)
+		defer func() {
+			if grown := gitDirSize(r.path) - before; grown > 0 {
+				atomic.AddInt64(r.fetchedBytes, grown)
+			}
+		}()
+	}
 	if err := r.syncRemoteBranches(ctx); err != nil {
 		return err
 	}
+	r.sync.lock.Lock()
+	defer r.sync.lock.Unlock()
+	if _, synced := r.sync.synced[branch]; synced {
+		return nil
+	}
+	if _, synced := r.sync.refsSynced[branch]; synced && refsOnly {
+		return nil
+	}
+	if refsOnly || r.cfg.isBare() {
+		if err := r.updateBranchRef(branch); err != nil {
+			return err
+		}
+		if r.cfg.isBare() {
+			r.sync.synced[branch] = struct{}{}
+		}
+		r.sync.refsSynced[branch] = struct{}{}
+		return nil
+	}
+	if err := r.syncBranch(ctx, branch); err != nil {
+		return err
+	}
+	r.sync.synced[branch] = struct{}{}
+	return nil
+}
+
+func (r *Repository) syncBranch(ctx context.Context, branch plumbing.ReferenceName) error {
 	wt, err := r.Worktree()
 	if err != nil {
 		return err
 	}
+	if _, err := r.Storer.Reference(branch); err == plumbing.ErrReferenceNotFound {
+		// the base branch found by HEAD of the remote isn't checked out yet.
+		if err := r.trackFetchedBranch(branch); err != nil {
+			return err
+		}
+	}
 	if err := wt.Checkout(&git.CheckoutOptions{Branch: branch}); err != nil {
 		return err
 	}
-	if err := wt.PullContext(ctx, &git.PullOptions{
-		Auth: r.cfg.Auth.BasicAuth(),
-	}); err != nil {
-		if err != git.NoErrAlreadyUpToDate {
-			return err
+	opt := &git.PullOptions{
+		Auth: r.cfg.basicAuth(),
+	}
+	w := newCloneProgressWriter(ctx, r.cfg.Repo, CloneOperationPull, 0, 0)
+	if w != nil {
+		opt.Progress = w
+	}
+	stop := w.watchPack(r.path)
+	err = r.cfg.remote(ctx, func() error {
+		if err := wt.PullContext(ctx, opt); err != nil {
+			if err != git.NoErrAlreadyUpToDate {
+				return gitError("pull", err)
+			}
 		}
+		return nil
+	})
+	stop()
+	if err != nil {
+		return err
+	}
+	if r.cfg.Submodules.mode() == SubmoduleFiles {
+		return r.updateSubmodules(ctx, wt)
 	}
 	return nil
 }
 
+// updateBranchRef points the branch and HEAD to the fetched branch without the worktree,
+// like the bare repository which has no worktree to pull.
+func (r *Repository) updateBranchRef(branch plumbing.ReferenceName) error {
+	if err := r.trackFetchedBranch(branch); err != nil {
+		return err
+	}
+	return r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch))
+}
+
+// trackFetchedBranch points the local branch to the fetched branch.
+func (r *Repository) trackFetchedBranch(branch plumbing.ReferenceName) error {
+	name, fetches := localRefName(r.cfg.fetchRefSpecs(git.DefaultRemoteName), branch)
+	if !fetches {
+		return fmt.Errorf("branch %s isn't fetched by the refspecs of %s", branch.Short(), r.cfg.Repo)
+	}
+	fetched, err := r.Reference(name, true)
+	if err != nil {
+		if err == plumbing.ErrReferenceNotFound {
+			return ErrBranchNotFound(r.cfg.Repo, branch.Short())
+		}
+		return err
+	}
+	return r.Storer.SetReference(plumbing.NewHashReference(branch, fetched.Hash()))
+}
+
+// syncRemoteBranches fetches the remote. It doesn't depend on the base branch, because the base branch is found by HEAD of the remote it records.
 func (r *Repository) syncRemoteBranches(ctx context.Context) error {
-	branch, err := r.BaseBranch()
+	isEmpty, err := r.IsEmpty()
+	if err != nil {
+		return err
+	}
+	if isEmpty {
+		return ErrEmptyRepository(r.cfg.Repo)
+	}
+	return r.fetch(ctx, git.DefaultRemoteName)
+}
+
+func (r *Repository) fetch(ctx context.Context, remoteName string) error {
+	r.sync.lock.Lock()
+	defer r.sync.lock.Unlock()
+	if r.sync.fetched {
+		return nil
+	}
+	opt := &git.FetchOptions{
+		RemoteName: remoteName,
+		RefSpecs:   r.cfg.fetchRefSpecs(remoteName),
+		Tags:       r.cfg.fetchTags(),
+		Auth:       r.cfg.basicAuth(),
+	}
+	w := newCloneProgressWriter(ctx, r.cfg.Repo, CloneOperationFetch, 0, 0)
+	if w != nil {
+		opt.Progress = w
+	}
+	stop := w.watchPack(r.path)
+	err := r.cfg.remote(ctx, func() error {
+		if err := r.FetchContext(ctx, opt); err != nil {
+			if err != git.NoErrAlreadyUpToDate {
+				return gitError("fetch", err)
+			}
+		}
+		return nil
+	})
+	stop()
+	if err != nil {
+		return err
+	}
+	if err := r.removeLegacyRefs(); err != nil {
+		return errors.Wrapf(err, "failed to remove legacy refs of %s", r.cfg.Repo)
+	}
+	remoteRefs, err := r.listRemoteRefs(ctx, remoteName)
 	if err != nil {
 		return err
 	}
-	return r.fetch(ctx, branch)
+	if err := r.updateRemoteHead(remoteName, remoteRefs); err != nil {
+		return errors.Wrapf(err, "failed to update HEAD of remote %s", remoteName)
+	}
+	if r.cfg.Clone.prune() {
+		if err := r.pruneRefs(remoteName, remoteRefs); err != nil {
+			return errors.Wrapf(err, "failed to prune refs of %s", r.cfg.Repo)
+		}
+	}
+	r.sync.fetched = true
+	return nil
 }
 
-func (r *Repository) fetch(ctx context.Context, branch *config.Branch) error {
-	if r.fetched {
+func (r *Repository) listRemoteRefs(ctx context.Context, remoteName string) ([]*plumbing.Reference, error) {
+	remote, err := r.Remote(remoteName)
+	if err != nil {
+		return nil, err
+	}
+	var remoteRefs []*plumbing.Reference
+	if err := r.cfg.remote(ctx, func() error {
+		refs, err := remote.List(&git.ListOptions{Auth: r.cfg.basicAuth()})
+		if err != nil {
+			return gitError("list remote refs", err)
+		}
+		remoteRefs = refs
 		return nil
+	}); err != nil {
+		return nil, err
 	}
-	if err := r.FetchContext(ctx, &git.FetchOptions{
-		RemoteName: branch.Remote,
-		RefSpecs:   []config.RefSpec{"+refs/*:refs/heads/*", "HEAD:refs/heads/HEAD"},
-		Auth:       r.cfg.Auth.BasicAuth(),
+	return remoteRefs, nil
+}
+
+// updateRemoteHead points refs/remotes/origin/HEAD to the remote-tracking ref of the branch which HEAD of the remote points to, like git clone.
+func (r *Repository) updateRemoteHead(remoteName string, remoteRefs []*plumbing.Reference) error {
+	for _, ref := range remoteRefs {
+		if ref.Name() != plumbing.HEAD || ref.Type() != plumbing.SymbolicReference {
+			continue
+		}
+		target, fetched := localRefName(r.cfg.fetchRefSpecs(remoteName), ref.Target())
+		if !fetched {
+			target = plumbing.NewRemoteReferenceName(remoteName, ref.Target().Short())
+		}
+		return r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.NewRemoteHEADReferenceName(remoteName), target))
+	}
+	return nil
+}
+
+// pruneRefs deletes the refs fetched by the refspecs and the remote-tracking branches created by clone
+// which don't exist in the remote anymore. The branch HEAD points to is kept.
+func (r *Repository) pruneRefs(remoteName string, remoteRefs []*plumbing.Reference) error {
+	specs := append(r.cfg.fetchRefSpecs(remoteName), config.RefSpec("+refs/heads/*:"+remoteRefPrefix(remoteName)+"*"))
+	exists := map[plumbing.ReferenceName]struct{}{}
+	for _, ref := range remoteRefs {
+		for _, spec := range specs {
+			if spec.Match(ref.Name()) {
+				exists[spec.Dst(ref.Name())] = struct{}{}
+			}
+		}
+	}
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	refIter, err := r.References()
+	if err != nil {
+		return err
+	}
+	defer refIter.Close()
+	var stale []plumbing.ReferenceName
+	if err := refIter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name()
+		if _, exists := exists[name]; exists || name == head.Target() || ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if _, fetched := remoteRefName(specs, name); !fetched {
+			return nil
+		}
+		stale = append(stale, name)
+		return nil
 	}); err != nil {
-		if err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	for _, name := range stale {
+		if err := r.Storer.RemoveReference(name); err != nil {
 			return err
 		}
 	}
-	r.fetched = true
 	return nil
 }
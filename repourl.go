@@ -0,0 +1,130 @@
+package treport
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// RepositoryURL is the parsed url of the repository.
+type RepositoryURL struct {
+	// Scheme is http, https, ssh, git or file. The scp-like syntax is ssh.
+	Scheme string
+	User   string
+	// Host is lower-cased, and Port is empty if it isn't specified.
+	Host string
+	Port string
+	// Path is the path of the repository without the leading slash, the trailing slash and ".git".
+	// It's the absolute path for file.
+	Path string
+}
+
+var (
+	schemeMatcher = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*)://`)
+	// scpMatcher matches [user@]host:path. The host is longer than one character to tell it from the Windows drive.
+	scpMatcher = regexp.MustCompile(`^(?:([^@/:]+)@)?([^@/:]{2,}):(.*)$`)
+)
+
+var repositoryURLSchemes = map[string]string{
+	"http":    "http",
+	"https":   "https",
+	"ssh":     "ssh",
+	"git+ssh": "ssh",
+	"ssh+git": "ssh",
+	"git":     "git",
+	"file":    "file",
+}
+
+// ParseRepositoryURL parses the url of http, https, ssh, git and file, the scp-like syntax like git@github.com:org/repo.git
+// and the absolute path of the local repository.
+func ParseRepositoryURL(repo string) (*RepositoryURL, error) {
+	if matches := schemeMatcher.FindStringSubmatch(repo); len(matches) == 2 {
+		return parseSchemeURL(repo, strings.ToLower(matches[1]))
+	}
+	if filepath.IsAbs(repo) {
+		return newRepositoryURL(repo, &RepositoryURL{Scheme: "file", Path: filepath.ToSlash(repo)})
+	}
+	if matches := scpMatcher.FindStringSubmatch(repo); len(matches) == 4 {
+		return newRepositoryURL(repo, &RepositoryURL{
+			Scheme: "ssh",
+			User:   matches[1],
+			Host:   matches[2],
+			Path:   matches[3],
+		})
+	}
+	return nil, ErrInvalidRepositoryPath(repo)
+}
+
+func parseSchemeURL(repo, scheme string) (*RepositoryURL, error) {
+	normalized, exists := repositoryURLSchemes[scheme]
+	if !exists {
+		return nil, ErrInvalidRepositoryPath(repo)
+	}
+	u, err := url.Parse(repo)
+	if err != nil {
+		return nil, ErrInvalidRepositoryPath(repo)
+	}
+	if normalized != "file" && u.Hostname() == "" {
+		return nil, ErrInvalidRepositoryPath(repo)
+	}
+	return newRepositoryURL(repo, &RepositoryURL{
+		Scheme: normalized,
+		User:   u.User.Username(),
+		Host:   u.Hostname(),
+		Port:   u.Port(),
+		Path:   u.Path,
+	})
+}
+
+// newRepositoryURL normalizes the host and the path. The path must not escape the mount path by "..".
+func newRepositoryURL(repo string, u *RepositoryURL) (*RepositoryURL, error) {
+	u.Host = strings.ToLower(u.Host)
+	p := strings.TrimSuffix(strings.TrimRight(u.Path, "/"), ".git")
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." || elem == "." {
+			return nil, ErrInvalidRepositoryPath(repo)
+		}
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil, ErrInvalidRepositoryPath(repo)
+	}
+	if u.Scheme != "file" {
+		p = strings.TrimPrefix(p, "/")
+	}
+	u.Path = p
+	return u, nil
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ssh":   "22",
+	"git":   "9418",
+}
+
+// DiskPath returns the relative path of the clone under the mount path like github.com/org/repo.
+// The same repository has the same path whichever syntax is used, and the non default port is added to the host.
+// The local repository of file is under "file".
+func (u *RepositoryURL) DiskPath() string {
+	if u.Scheme == "file" {
+		// the drive of Windows like C: can't be the name of the directory.
+		return path.Join("file", strings.Replace(u.Path, ":", "", 1))
+	}
+	host := u.Host
+	if u.Port != "" && u.Port != defaultPorts[u.Scheme] {
+		host += "_" + u.Port
+	}
+	return path.Join(host, u.Path)
+}
+
+// legacyRepoPath returns the path of the clone created by older versions, which took everything after the scheme of
+// the https url as it was. It's empty if the url isn't https.
+func legacyRepoPath(repo string) string {
+	if !strings.HasPrefix(repo, "https://") {
+		return ""
+	}
+	return strings.TrimPrefix(repo, "https://")
+}
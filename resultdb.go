@@ -0,0 +1,525 @@
+package treport
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Result is the scan result of the plugin for a commit stored in the cache.
+type Result struct {
+	Pipeline string
+	// RepoID is the ID of the repository, and Repo is its url or the path of the local one.
+	RepoID       string
+	Repo         string
+	Branch       string
+	Plugin       string
+	CommitHash   string
+	ParentHashes []string
+	TopoIndex    int64
+	CommitTime   time.Time
+	AuthorTime   time.Time
+	// ScanTime is the time the plugin scanned the commit. It's zero for the results cached by older versions.
+	ScanTime time.Time
+	// Time is the time of the clock of the pipeline, which the result is recorded against.
+	Time time.Time
+	// SchemaVersion and PluginVersion are the versions of the plugin which made the result.
+	// They are empty for the plugins which don't declare them.
+	SchemaVersion string
+	PluginVersion string
+	// Duration is the time the plugin took to scan the commit. It's 0 for the results cached by older versions.
+	Duration time.Duration
+	Warnings []string
+	Response *treportproto.ScanResponse
+}
+
+type resultJSON struct {
+	Pipeline      string          `json:"pipeline"`
+	RepoID        string          `json:"repoId"`
+	Repo          string          `json:"repo"`
+	Branch        string          `json:"branch,omitempty"`
+	Plugin        string          `json:"plugin"`
+	Commit        string          `json:"commit"`
+	CommitTime    time.Time       `json:"commitTime"`
+	AuthorTime    *time.Time      `json:"authorTime,omitempty"`
+	ScanTime      *time.Time      `json:"scanTime,omitempty"`
+	Time          time.Time       `json:"time"`
+	Type          string          `json:"type"`
+	SchemaVersion string          `json:"schemaVersion,omitempty"`
+	PluginVersion string          `json:"pluginVersion,omitempty"`
+	Duration      string          `json:"duration,omitempty"`
+	Warnings      []string        `json:"warnings,omitempty"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// JSON returns the data of the result as JSON. It's generated by the descriptors stored with the cache
+// if the result doesn't have it, so the plugin isn't needed.
+func (r *Result) JSON() (string, error) {
+	return responseJSON(r.Response)
+}
+
+func (r *Result) MarshalJSON() ([]byte, error) {
+	data := json.RawMessage("null")
+	v, err := r.JSON()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get JSON of %s", r.CommitHash)
+	}
+	if v != "" {
+		data = json.RawMessage(v)
+	}
+	var duration string
+	if r.Duration > 0 {
+		duration = r.Duration.String()
+	}
+	var authorTime, scanTime *time.Time
+	if !r.AuthorTime.IsZero() {
+		authorTime = &r.AuthorTime
+	}
+	if !r.ScanTime.IsZero() {
+		scanTime = &r.ScanTime
+	}
+	return json.Marshal(&resultJSON{
+		Pipeline:      r.Pipeline,
+		RepoID:        r.RepoID,
+		Repo:          r.Repo,
+		Branch:        r.Branch,
+		Plugin:        r.Plugin,
+		Commit:        r.CommitHash,
+		CommitTime:    r.CommitTime,
+		AuthorTime:    authorTime,
+		ScanTime:      scanTime,
+		Time:          r.Time,
+		Type:          r.Response.Name,
+		SchemaVersion: r.SchemaVersion,
+		PluginVersion: r.PluginVersion,
+		Duration:      duration,
+		Warnings:      r.Warnings,
+		Data:          data,
+	})
+}
+
+// Message decodes the data of the result, even if the message isn't compiled into the host.
+func (r *Result) Message() (protoreflect.ProtoMessage, error) {
+	return UnmarshalAny(r.Response.Data)
+}
+
+type resultSource struct {
+	pipeline string
+	repoID   string
+	repo     string
+	branch   string
+	plugin   string
+	clock    Clock
+	path     string
+	// legacyPaths are the paths of the cache named by the older IDs, which isn't migrated yet.
+	legacyPaths []string
+}
+
+// ResultDB queries the previously scanned results from the plugin caches and the scan history.
+type ResultDB struct {
+	pipelines   []*PipelineConfig
+	sources     []*resultSource
+	historyPath string
+	mu          sync.Mutex
+	dbs         map[string]*badger.DB
+}
+
+func OpenResultDB(cfg *Config) (*ResultDB, error) {
+	sources, err := resultSources(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get result sources")
+	}
+	return &ResultDB{
+		pipelines:   cfg.Pipelines,
+		sources:     sources,
+		historyPath: cfg.HistoryPath(),
+		dbs:         map[string]*badger.DB{},
+	}, nil
+}
+
+func resultSources(cfg *Config) ([]*resultSource, error) {
+	sources := []*resultSource{}
+	for _, pipelineCfg := range cfg.Pipelines {
+		for _, repoCfg := range pipelineCfg.Repository {
+			repoPath, err := repositoryPath(cfg.repoPathOf(pipelineCfg, repoCfg), repoCfg)
+			if err != nil {
+				return nil, err
+			}
+			branches, err := localBranches(repoPath, repoCfg)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to find branches of %s", repoCfg.Repo)
+			}
+			for _, branch := range branches {
+				repoID, err := repositoryID(DefaultIDScheme, cfg.repoPathOf(pipelineCfg, repoCfg), repoCfg, branch)
+				if err != nil {
+					return nil, err
+				}
+				for idx, stepCfg := range pipelineCfg.Steps {
+					for _, pluginExecCfg := range stepCfg.Plugins {
+						path, err := resultPath(DefaultIDScheme, cfg, pipelineCfg, repoCfg, branch, idx, pluginExecCfg.Name)
+						if err != nil {
+							return nil, err
+						}
+						legacyPaths, err := legacyResultPaths(cfg, pipelineCfg, repoCfg, branch, idx, pluginExecCfg.Name)
+						if err != nil {
+							return nil, err
+						}
+						sources = append(sources, &resultSource{
+							pipeline:    pipelineCfg.Name,
+							repoID:      repoID,
+							repo:        repoCfg.identity(),
+							branch:      branch,
+							plugin:      pluginExecCfg.Name,
+							clock:       pipelineCfg.ResultClock(),
+							path:        path,
+							legacyPaths: legacyPaths,
+						})
+					}
+				}
+			}
+		}
+	}
+	return sources, nil
+}
+
+// resultPath returns the cache path of the plugin results named by the id scheme.
+func resultPath(scheme IDScheme, cfg *Config, pipelineCfg *PipelineConfig, repoCfg *RepositoryConfig, branch string, stepIdx int, pluginName string) (string, error) {
+	return resultPathOf(scheme, createPipelineIDByPluginIDs, cfg, pipelineCfg, repoCfg, branch, stepIdx, pluginName)
+}
+
+// warmCachePath returns the cache path of the plugin results of the pipeline named by cacheFrom of pipelineCfg.
+// The plugin is found by the name and the args in the pipeline, so the results are looked up by the plugin and the commit.
+// It returns empty string if the pipeline doesn't scan the repository by the plugin.
+func warmCachePath(cfg *Config, pipelineCfg *PipelineConfig, repoCfg *RepositoryConfig, branch string, pluginExecCfg *PluginExecConfig) (string, error) {
+	fromCfg := cfg.pipelineConfig(pipelineCfg.CacheFrom)
+	if fromCfg == nil {
+		return "", nil
+	}
+	var fromRepoCfg *RepositoryConfig
+	for _, r := range fromCfg.Repository {
+		if normalizeRepoURL(r.identity()) == normalizeRepoURL(repoCfg.identity()) {
+			fromRepoCfg = r
+			break
+		}
+	}
+	if fromRepoCfg == nil {
+		return "", nil
+	}
+	for idx, stepCfg := range fromCfg.Steps {
+		for _, fromExecCfg := range stepCfg.Plugins {
+			if fromExecCfg.Name != pluginExecCfg.Name || strings.Join(fromExecCfg.Args, "\x00") != strings.Join(pluginExecCfg.Args, "\x00") {
+				continue
+			}
+			return resultPath(DefaultIDScheme, cfg, fromCfg, fromRepoCfg, branch, idx, pluginExecCfg.Name)
+		}
+	}
+	return "", nil
+}
+
+// legacyResultPaths returns the cache paths of the plugin results named by the older IDs in the order they are migrated.
+func legacyResultPaths(cfg *Config, pipelineCfg *PipelineConfig, repoCfg *RepositoryConfig, branch string, stepIdx int, pluginName string) ([]string, error) {
+	paths := make([]string, 0, 2)
+	for _, scheme := range []IDScheme{DefaultIDScheme, LegacyIDScheme} {
+		path, err := resultPathOf(scheme, createUnnamedPipelineID, cfg, pipelineCfg, repoCfg, branch, stepIdx, pluginName)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func resultPathOf(scheme IDScheme, pipelineID func(IDScheme, *PipelineConfig, [][]string) PipelineID, cfg *Config, pipelineCfg *PipelineConfig, repoCfg *RepositoryConfig, branch string, stepIdx int, pluginName string) (string, error) {
+	stepPluginIDs := make([][]string, 0, len(pipelineCfg.Steps))
+	for _, stepCfg := range pipelineCfg.Steps {
+		ids := make([]string, 0, len(stepCfg.Plugins))
+		for _, pluginExecCfg := range stepCfg.Plugins {
+			id, err := pluginIDByName(scheme, cfg, pluginExecCfg.Name)
+			if err != nil {
+				return "", err
+			}
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		stepPluginIDs = append(stepPluginIDs, ids)
+	}
+	repoID, err := repositoryID(scheme, cfg.repoPathOf(pipelineCfg, repoCfg), repoCfg, branch)
+	if err != nil {
+		return "", err
+	}
+	pluginID, err := pluginIDByName(scheme, cfg, pluginName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(
+		cfg.cachePathOf(pipelineCfg, repoCfg),
+		string(pipelineID(scheme, pipelineCfg, stepPluginIDs)),
+		repoID,
+		fmt.Sprintf("%03d", stepIdx),
+		pluginID,
+	), nil
+}
+
+// Commits returns all results of the plugin for the repository in topological order.
+// repo is the url of the repository, or the path of the local one.
+func (db *ResultDB) Commits(repo, plugin string) ([]*Result, error) {
+	results := []*Result{}
+	for _, src := range db.sources {
+		if src.repo != repo || src.plugin != plugin {
+			continue
+		}
+		srcResults, err := db.read(src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read results of %s", plugin)
+		}
+		results = append(results, srcResults...)
+	}
+	results = uniqueResults(results)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].TopoIndex < results[j].TopoIndex
+	})
+	return results, nil
+}
+
+// Latest returns the result of the plugin for the last commit of the repository.
+func (db *ResultDB) Latest(repo, plugin string) (*Result, error) {
+	results, err := db.Commits(repo, plugin)
+	if err != nil {
+		return nil, errors.Stack(err)
+	}
+	if len(results) == 0 {
+		return nil, ErrNoData
+	}
+	return results[len(results)-1], nil
+}
+
+// Range returns all results whose time of the clock of the pipeline is in [since, until).
+// Zero value of since or until means unbounded.
+func (db *ResultDB) Range(since, until time.Time) ([]*Result, error) {
+	results := []*Result{}
+	for _, src := range db.sources {
+		srcResults, err := db.read(src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read results of %s", src.plugin)
+		}
+		for _, result := range srcResults {
+			if !since.IsZero() && result.Time.Before(since) {
+				continue
+			}
+			if !until.IsZero() && !result.Time.Before(until) {
+				continue
+			}
+			results = append(results, result)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Time.Equal(results[j].Time) {
+			return results[i].TopoIndex < results[j].TopoIndex
+		}
+		return results[i].Time.Before(results[j].Time)
+	})
+	return results, nil
+}
+
+// Report builds the report of the cached results without opening the repositories and starting the plugins,
+// so the reports are regenerated without scanning. The commits have only the hashes, the parents and the times,
+// because the other fields aren't cached.
+func (db *ResultDB) Report() (*Report, error) {
+	report := &Report{}
+	for _, pipelineCfg := range db.pipelines {
+		pipelineReport := &PipelineReport{
+			Name:     pipelineCfg.Name,
+			Desc:     pipelineCfg.Desc,
+			Strategy: pipelineCfg.Strategy,
+			Clock:    pipelineCfg.ResultClock(),
+		}
+		repos := map[string]*RepositoryReport{}
+		commits := map[string]map[string]*CommitReport{}
+		for _, src := range db.sources {
+			if src.pipeline != pipelineCfg.Name {
+				continue
+			}
+			results, err := db.read(src)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read results of %s", src.plugin)
+			}
+			repo, exists := repos[src.repoID]
+			if !exists {
+				repo = &RepositoryReport{ID: src.repoID, Repo: src.repo, Branch: src.branch}
+				repos[src.repoID] = repo
+				commits[src.repoID] = map[string]*CommitReport{}
+				pipelineReport.Repositories = append(pipelineReport.Repositories, repo)
+			}
+			for _, result := range results {
+				commit, exists := commits[src.repoID][result.CommitHash]
+				if !exists {
+					commit = &CommitReport{
+						Commit:    result.commit(),
+						TopoIndex: result.TopoIndex,
+						Results:   map[string]*PluginResult{},
+					}
+					commits[src.repoID][result.CommitHash] = commit
+					repo.Commits = append(repo.Commits, commit)
+				}
+				pluginResult := newPluginResult(src.plugin, result.Response)
+				pluginResult.Time = result.Time
+				commit.Results[src.plugin] = pluginResult
+			}
+		}
+		for _, repo := range pipelineReport.Repositories {
+			repoCommits := repo.Commits
+			sort.SliceStable(repoCommits, func(i, j int) bool {
+				return repoCommits[i].TopoIndex < repoCommits[j].TopoIndex
+			})
+		}
+		report.Pipelines = append(report.Pipelines, pipelineReport)
+	}
+	return report, nil
+}
+
+// commit returns the commit of the result which has only the fields kept in cache.
+func (r *Result) commit() *Commit {
+	return &Commit{
+		Hash:         r.CommitHash,
+		Author:       &Signature{When: r.AuthorTime},
+		Committer:    &Signature{When: r.CommitTime},
+		ParentHashes: r.ParentHashes,
+	}
+}
+
+func (db *ResultDB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for path, cache := range db.dbs {
+		if err := cache.Close(); err != nil {
+			return errors.Wrapf(err, "failed to close cache %s", path)
+		}
+		delete(db.dbs, path)
+	}
+	return nil
+}
+
+func (db *ResultDB) open(path string) (*badger.DB, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if cache, exists := db.dbs[path]; exists {
+		return cache, nil
+	}
+	cache, err := badger.Open(badger.DefaultOptions(path).WithReadOnly(true))
+	if err != nil {
+		return nil, err
+	}
+	db.dbs[path] = cache
+	return cache, nil
+}
+
+func (db *ResultDB) read(src *resultSource) ([]*Result, error) {
+	path := src.path
+	if !existsPath(path) {
+		// the cache isn't migrated to the current id yet.
+		for _, legacyPath := range src.legacyPaths {
+			if existsPath(legacyPath) {
+				path = legacyPath
+				break
+			}
+		}
+	}
+	if !existsPath(path) {
+		return nil, nil
+	}
+	if err := loadSavedDescriptors(descriptorsPath(path)); err != nil {
+		return nil, errors.Wrapf(err, "failed to load descriptors of results")
+	}
+	cache, err := db.open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open cache DB")
+	}
+	results := []*Result{}
+	if err := cache.View(func(tx *badger.Txn) error {
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			item := iter.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var res treportproto.ScanResponse
+			if err := proto.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			results = append(results, newResult(src, string(item.Key()), &res))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func newResult(src *resultSource, commitHash string, res *treportproto.ScanResponse) *Result {
+	commitTime, authorTime, scanTime := responseTimes(res)
+	var duration time.Duration
+	if res.Duration != nil {
+		duration, _ = ptypes.Duration(res.Duration)
+	}
+	return &Result{
+		Pipeline:      src.pipeline,
+		RepoID:        src.repoID,
+		Repo:          src.repo,
+		Branch:        src.branch,
+		Plugin:        src.plugin,
+		CommitHash:    commitHash,
+		ParentHashes:  res.ParentHashes,
+		TopoIndex:     res.TopoIndex,
+		CommitTime:    commitTime,
+		AuthorTime:    authorTime,
+		ScanTime:      scanTime,
+		Time:          src.clock.timeOf(commitTime, authorTime, scanTime),
+		SchemaVersion: res.SchemaVersion,
+		PluginVersion: res.PluginVersion,
+		Duration:      duration,
+		Warnings:      res.Warnings,
+		Response:      res,
+	}
+}
+
+// responseTimes returns the commit time, the author time and the scan time of the result.
+// The times the result doesn't have are zero.
+func responseTimes(res *treportproto.ScanResponse) (time.Time, time.Time, time.Time) {
+	var commitTime, authorTime, scanTime time.Time
+	if res.CommitTime != nil {
+		commitTime, _ = ptypes.Timestamp(res.CommitTime)
+	}
+	if res.AuthorTime != nil {
+		authorTime, _ = ptypes.Timestamp(res.AuthorTime)
+	}
+	if res.ScanTime != nil {
+		scanTime, _ = ptypes.Timestamp(res.ScanTime)
+	}
+	return commitTime, authorTime, scanTime
+}
+
+func uniqueResults(results []*Result) []*Result {
+	seen := map[string]struct{}{}
+	unique := make([]*Result, 0, len(results))
+	for _, result := range results {
+		if _, exists := seen[result.CommitHash]; exists {
+			continue
+		}
+		seen[result.CommitHash] = struct{}{}
+		unique = append(unique, result)
+	}
+	return unique
+}
@@ -0,0 +1,229 @@
+package treport
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = time.Minute
+)
+
+// RetryConfig is the retry policy for transient errors.
+// The wait time starts from backoff and doubles up to maxBackoff for each attempt.
+// jitter is the ratio ( 0 to 1 ) of the wait time randomized to avoid retrying at the same time.
+// If attempts is less than 2, it doesn't retry.
+type RetryConfig struct {
+	Attempts   int     `yaml:"attempts"`
+	Backoff    string  `yaml:"backoff"`
+	MaxBackoff string  `yaml:"maxBackoff"`
+	Jitter     float64 `yaml:"jitter"`
+}
+
+func (c *RetryConfig) backoff() (time.Duration, time.Duration, error) {
+	backoff, maxBackoff := defaultRetryBackoff, defaultRetryMaxBackoff
+	if c.Backoff != "" {
+		d, err := time.ParseDuration(c.Backoff)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "failed to parse backoff %s", c.Backoff)
+		}
+		backoff = d
+	}
+	if c.MaxBackoff != "" {
+		d, err := time.ParseDuration(c.MaxBackoff)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "failed to parse maxBackoff %s", c.MaxBackoff)
+		}
+		maxBackoff = d
+	}
+	return backoff, maxBackoff, nil
+}
+
+// do calls fn until it succeeds, it returns the error which isn't transient, or the attempts are exhausted.
+// TransientError, NetworkError and RateLimitError are transient.
+func (c *RetryConfig) do(ctx context.Context, fn func() error) error {
+	if c == nil || c.Attempts < 2 {
+		return fn()
+	}
+	backoff, maxBackoff, err := c.backoff()
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= c.Attempts || !isTransient(err) {
+			return err
+		}
+		wait := backoff
+		if c.Jitter > 0 {
+			wait -= time.Duration(c.Jitter * rand.Float64() * float64(backoff))
+		}
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > wait {
+			// wait until the rate limit is reset, but not longer than maxBackoff.
+			wait = rateLimitErr.RetryAfter
+			if wait > maxBackoff {
+				wait = maxBackoff
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// gitError classifies the error of clone, fetch or pull by AuthError, NotFoundError, NetworkError, RateLimitError
+// or TransientError for the server failure.
+func gitError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired),
+		errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod):
+		return ErrAuth(op, err)
+	case errors.Is(err, transport.ErrRepositoryNotFound):
+		return ErrNotFound(op, err)
+	case errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return err
+	}
+	var httpErr *githttp.Err
+	if errors.As(err, &httpErr) {
+		return httpError(op, err, httpErr.Response)
+	}
+	if isNetworkError(err) {
+		return ErrNetwork(op, err)
+	}
+	return err
+}
+
+// httpError classifies the error of the response by the status code.
+func httpError(op string, err error, res *http.Response) error {
+	switch code := res.StatusCode; {
+	case code == http.StatusTooManyRequests,
+		code == http.StatusForbidden && res.Header.Get("X-RateLimit-Remaining") == "0":
+		return ErrRateLimit(op, err, retryAfter(res))
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return ErrAuth(op, err)
+	case code == http.StatusNotFound:
+		return ErrNotFound(op, err)
+	case code >= http.StatusInternalServerError, code == http.StatusRequestTimeout:
+		return ErrTransient(op, err)
+	}
+	return err
+}
+
+// retryAfter returns the wait time of the Retry-After header in seconds, or the X-RateLimit-Reset header of GitHub.
+func retryAfter(res *http.Response) time.Duration {
+	if sec, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		if d := time.Until(time.Unix(reset, 0)); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// isTransient reports whether the error may succeed by retry.
+func isTransient(err error) bool {
+	var (
+		transientErr *TransientError
+		networkErr   *NetworkError
+		rateLimitErr *RateLimitError
+	)
+	return errors.As(err, &transientErr) || errors.As(err, &networkErr) || errors.As(err, &rateLimitErr) ||
+		isPluginError(err, PluginErrorRetryable)
+}
+
+func isPluginError(err error, class PluginErrorClass) bool {
+	var pluginErr *PluginError
+	return errors.As(err, &pluginErr) && pluginErr.Class == class
+}
+
+// pluginErrorClasses are the classes of PluginError sent as the detail of the status.
+var pluginErrorClasses = map[PluginErrorClass]treportproto.PluginError_Class{
+	PluginErrorRetryable:  treportproto.PluginError_RETRYABLE,
+	PluginErrorSkipCommit: treportproto.PluginError_SKIP_COMMIT,
+	PluginErrorFatal:      treportproto.PluginError_FATAL,
+}
+
+// pluginStatus returns the status of the error returned by the plugin, which has the class of PluginError as the detail.
+// The retryable error is Unavailable, so the hosts which don't know the detail retry it too.
+func pluginStatus(err error) error {
+	var pluginErr *PluginError
+	if err == nil || !errors.As(err, &pluginErr) {
+		return err
+	}
+	code := codes.Unknown
+	if pluginErr.Class == PluginErrorRetryable {
+		code = codes.Unavailable
+	}
+	st, detailErr := status.New(code, err.Error()).WithDetails(&treportproto.PluginError{Class: pluginErrorClasses[pluginErr.Class]})
+	if detailErr != nil {
+		return err
+	}
+	return st.Err()
+}
+
+// pluginError wraps the error of the plugin RPC by TransientError if the plugin is temporarily unavailable,
+// or by PluginError if the plugin classified it. err must be the error returned by the gRPC client as is.
+func pluginError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, detail := range status.Convert(err).Details() {
+		pluginErr, ok := detail.(*treportproto.PluginError)
+		if !ok {
+			continue
+		}
+		for class, protoClass := range pluginErrorClasses {
+			if pluginErr.GetClass() != protoClass {
+				continue
+			}
+			if class == PluginErrorRetryable {
+				return ErrTransient(op, err)
+			}
+			return &PluginError{Class: class, Err: err}
+		}
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return ErrTransient(op, err)
+	}
+	return err
+}
+
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, syscall.EPIPE)
+}
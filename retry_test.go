@@ -0,0 +1,28 @@
+package treport_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestClassifyPluginError(t *testing.T) {
+	var transientErr *treport.TransientError
+	if err := treport.ClassifyPluginError(treport.ErrRetryable(fmt.Errorf("busy"))); !errors.As(err, &transientErr) {
+		t.Fatalf("expected transient error: %v", err)
+	}
+	for _, class := range []treport.PluginErrorClass{treport.PluginErrorSkipCommit, treport.PluginErrorFatal} {
+		err := treport.ClassifyPluginError(&treport.PluginError{Class: class, Err: fmt.Errorf("broken")})
+		var pluginErr *treport.PluginError
+		if !errors.As(err, &pluginErr) || pluginErr.Class != class {
+			t.Fatalf("expected %s error: %v", class, err)
+		}
+	}
+	err := treport.ClassifyPluginError(fmt.Errorf("unknown"))
+	var pluginErr *treport.PluginError
+	if errors.As(err, &pluginErr) || errors.As(err, &transientErr) {
+		t.Fatalf("unexpected classified error: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package treport
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// RollupDimension is the dimension to group the numeric results of the plugins by.
+type RollupDimension string
+
+const (
+	// RollupAuthor groups the results by the email of the author of the commit, or the name if the email is empty.
+	RollupAuthor RollupDimension = "author"
+	// RollupDirectory groups the results by the top-level directories of the files changed by the commit.
+	// The files at the root are grouped as ".".
+	RollupDirectory RollupDimension = "directory"
+	// RollupExtension groups the results by the extensions of the files changed by the commit like ".go".
+	// The files without the extension are grouped as "".
+	RollupExtension RollupDimension = "extension"
+)
+
+// RollupGroup is the sum of the metric of the commits in the group.
+type RollupGroup struct {
+	Key     string
+	Sum     float64
+	Commits int
+}
+
+// Rollup sums the metric of the results of the plugin by the dimension, and returns the groups ordered by the sum in descending order.
+// metric is the dot separated path of the numeric field in Data like "size" or "stats.lines", and the results without it are skipped.
+// The commit changing the files of multiple directories or extensions adds its metric to each of them,
+// so the total of the groups may be larger than the one of the commits.
+func (r *RepositoryReport) Rollup(dimension RollupDimension, plugin, metric string) ([]*RollupGroup, error) {
+	rollup := newRollup(dimension)
+	if err := rollup.add(r.Commits, plugin, metric); err != nil {
+		return nil, errors.Wrapf(err, "failed to roll up %s of %s", metric, r.Repo)
+	}
+	return rollup.groups(), nil
+}
+
+// Rollup is the same as RepositoryReport.Rollup, but it sums the results of all repositories of the pipeline.
+func (r *PipelineReport) Rollup(dimension RollupDimension, plugin, metric string) ([]*RollupGroup, error) {
+	rollup := newRollup(dimension)
+	for _, repo := range r.Repositories {
+		if err := rollup.add(repo.Commits, plugin, metric); err != nil {
+			return nil, errors.Wrapf(err, "failed to roll up %s of %s", metric, repo.Repo)
+		}
+	}
+	return rollup.groups(), nil
+}
+
+type rollup struct {
+	dimension RollupDimension
+	groupMap  map[string]*RollupGroup
+}
+
+func newRollup(dimension RollupDimension) *rollup {
+	return &rollup{dimension: dimension, groupMap: map[string]*RollupGroup{}}
+}
+
+func (r *rollup) add(commits []*CommitReport, plugin, metric string) error {
+	switch r.dimension {
+	case RollupAuthor, RollupDirectory, RollupExtension:
+	default:
+		return fmt.Errorf("unknown rollup dimension %q", r.dimension)
+	}
+	for _, commit := range commits {
+		result, exists := commit.Results[plugin]
+		if !exists {
+			continue
+		}
+		value, ok := metricValue(result.Data, metric)
+		if !ok {
+			continue
+		}
+		keys, err := r.keys(commit)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			group, exists := r.groupMap[key]
+			if !exists {
+				group = &RollupGroup{Key: key}
+				r.groupMap[key] = group
+			}
+			group.Sum += value
+			group.Commits++
+		}
+	}
+	return nil
+}
+
+// keys returns the distinct groups of the commit.
+func (r *rollup) keys(commit *CommitReport) ([]string, error) {
+	switch r.dimension {
+	case RollupAuthor:
+		author := commit.Commit.Author
+		if author == nil {
+			return []string{""}, nil
+		}
+		if author.Email != "" {
+			return []string{author.Email}, nil
+		}
+		return []string{author.Name}, nil
+	default:
+		paths, err := commit.ChangedPaths()
+		if err != nil {
+			return nil, err
+		}
+		keyMap := map[string]struct{}{}
+		keys := []string{}
+		for _, p := range paths {
+			key := path.Ext(p)
+			if r.dimension == RollupDirectory {
+				key = "."
+				if idx := strings.Index(p, "/"); idx > 0 {
+					key = p[:idx]
+				}
+			}
+			if _, exists := keyMap[key]; exists {
+				continue
+			}
+			keyMap[key] = struct{}{}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+}
+
+func (r *rollup) groups() []*RollupGroup {
+	groups := make([]*RollupGroup, 0, len(r.groupMap))
+	for _, group := range r.groupMap {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Sum != groups[j].Sum {
+			return groups[i].Sum > groups[j].Sum
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups
+}
+
+// metricValue returns the number of the field of the result. The 64-bit integers are encoded as the strings in JSON.
+func metricValue(data map[string]interface{}, metric string) (float64, bool) {
+	var v interface{} = data
+	for _, field := range strings.Split(metric, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		if v, ok = m[field]; !ok {
+			return 0, false
+		}
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, false
+		}
+		return f, true
+	}
+	return 0, false
+}
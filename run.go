@@ -0,0 +1,280 @@
+package treport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// Run is the metadata of a scan. It has the effective config and the plugin set, so the results can be regenerated later.
+type Run struct {
+	ID         string       `json:"id"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	ConfigHash string       `json:"configHash"`
+	Config     string       `json:"config"`
+	Plugins    []*RunPlugin `json:"plugins"`
+	Error      string       `json:"error,omitempty"`
+	// Interrupted reports that the run is canceled. The run which isn't finished is also interrupted by crash.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+type RunPlugin struct {
+	Name          string `json:"name"`
+	Repo          string `json:"repo,omitempty"`
+	Revision      string `json:"revision,omitempty"`
+	BinaryHash    string `json:"binaryHash,omitempty"`
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+}
+
+// normalize encodes the effective config to YAML. Comments, key order and shorthand notations of the source are dropped.
+func (c *Config) normalize() ([]byte, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode config")
+	}
+	return b, nil
+}
+
+// Hash returns the hash of the normalized config.
+func (c *Config) Hash() (string, error) {
+	b, err := c.normalize()
+	if err != nil {
+		return "", err
+	}
+	return configHash(b), nil
+}
+
+func configHash(normalized []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(normalized))
+}
+
+func newRun(cfg *Config, pipelines []*Pipeline) (*Run, error) {
+	normalized, err := cfg.normalize()
+	if err != nil {
+		return nil, err
+	}
+	plugins, err := runPlugins(pipelines)
+	if err != nil {
+		return nil, err
+	}
+	startedAt := time.Now()
+	hash := configHash(normalized)
+	return &Run{
+		ID:         fmt.Sprintf("%s-%s", startedAt.UTC().Format("20060102-150405"), hash[:8]),
+		StartedAt:  startedAt,
+		ConfigHash: hash,
+		Config:     string(normalized),
+		Plugins:    plugins,
+	}, nil
+}
+
+func runPlugins(pipelines []*Pipeline) ([]*RunPlugin, error) {
+	pluginMap := map[string]*RunPlugin{}
+	for _, pipeline := range pipelines {
+		for _, repo := range pipeline.Repos {
+			for _, step := range repo.Steps {
+				for _, plg := range step.Plugins {
+					if _, exists := pluginMap[plg.Name]; exists {
+						continue
+					}
+					runPlugin, err := newRunPlugin(plg)
+					if err != nil {
+						return nil, errors.Wrapf(err, "failed to get state of plugin %s", plg.Name)
+					}
+					pluginMap[plg.Name] = runPlugin
+				}
+			}
+		}
+	}
+	plugins := make([]*RunPlugin, 0, len(pluginMap))
+	for _, plg := range pluginMap {
+		plugins = append(plugins, plg)
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	return plugins, nil
+}
+
+func newRunPlugin(plg *Plugin) (*RunPlugin, error) {
+	runPlugin := &RunPlugin{Name: plg.Name}
+	if plg.Repo != nil && plg.Repo.Repository != nil {
+		runPlugin.Repo = plg.Repo.cfg.Repo
+		head, err := plg.Repo.Head()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get HEAD of plugin repository")
+		}
+		runPlugin.Revision = head.Hash().String()
+	}
+	if plg.Client != nil {
+		runPlugin.SchemaVersion = plg.Client.schemaVersion
+		if plg.Client.path != "" {
+			hash, err := fileHash(plg.Client.path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get hash of plugin binary")
+			}
+			runPlugin.BinaryHash = hash
+		}
+	}
+	return runPlugin, nil
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func (r *Run) finish(err error) {
+	r.FinishedAt = time.Now()
+	if err != nil {
+		r.Error = err.Error()
+		r.Interrupted = errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+	}
+}
+
+// Resumable reports whether the run is interrupted by cancellation or crash.
+func (r *Run) Resumable() bool {
+	return r.FinishedAt.IsZero() || r.Interrupted
+}
+
+// resume takes over the identity of the interrupted run.
+func (r *Run) resume(interrupted *Run) {
+	r.ID = interrupted.ID
+	r.StartedAt = interrupted.StartedAt
+}
+
+// lastInterruptedRun returns the last run of the config if it's interrupted.
+func lastInterruptedRun(cfg *Config, configHash string) (*Run, error) {
+	runs, err := Runs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].ConfigHash != configHash {
+			continue
+		}
+		if !runs[i].Resumable() {
+			return nil, nil
+		}
+		return runs[i], nil
+	}
+	return nil, nil
+}
+
+func (r *Run) save(runPath string) error {
+	if err := mkdirIfNotExists(runPath); err != nil {
+		return errors.Wrapf(err, "failed to create directory for run")
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode run %s", r.ID)
+	}
+	if err := ioutil.WriteFile(filepath.Join(runPath, r.ID+".json"), b, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write run %s", r.ID)
+	}
+	return nil
+}
+
+// LoadConfig decodes the recorded config of the run.
+func (r *Run) LoadConfig() (*Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(r.Config), &cfg); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode config of run %s", r.ID)
+	}
+	cfg.source = []byte(r.Config)
+	return &cfg, nil
+}
+
+// verify checks that the config and plugins are the same as recorded ones.
+func (r *Run) verify(configHash string, plugins []*RunPlugin) error {
+	if r.ConfigHash != configHash {
+		return ErrRunMismatch(r.ID, "config", r.ConfigHash, configHash)
+	}
+	current := map[string]*RunPlugin{}
+	for _, plg := range plugins {
+		current[plg.Name] = plg
+	}
+	for _, recorded := range r.Plugins {
+		plg, exists := current[recorded.Name]
+		if !exists {
+			return ErrRunMismatch(r.ID, recorded.Name, "exists", "not found")
+		}
+		delete(current, recorded.Name)
+		if recorded.Revision != plg.Revision {
+			return ErrRunMismatch(r.ID, recorded.Name+" revision", recorded.Revision, plg.Revision)
+		}
+		if recorded.BinaryHash != plg.BinaryHash {
+			return ErrRunMismatch(r.ID, recorded.Name+" binary", recorded.BinaryHash, plg.BinaryHash)
+		}
+		if recorded.SchemaVersion != plg.SchemaVersion {
+			return ErrRunMismatch(r.ID, recorded.Name+" schema version", recorded.SchemaVersion, plg.SchemaVersion)
+		}
+	}
+	for _, plg := range plugins {
+		if _, exists := current[plg.Name]; exists {
+			return ErrRunMismatch(r.ID, plg.Name, "not found", "exists")
+		}
+	}
+	return nil
+}
+
+func LoadRun(cfg *Config, id string) (*Run, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cfg.RunPath(), id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRunNotFound(id)
+		}
+		return nil, errors.Wrapf(err, "failed to read run %s", id)
+	}
+	var run Run
+	if err := json.Unmarshal(b, &run); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode run %s", id)
+	}
+	return &run, nil
+}
+
+// Runs returns all recorded runs in started order.
+func Runs(cfg *Config) ([]*Run, error) {
+	if !existsPath(cfg.RunPath()) {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(cfg.RunPath())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read runs")
+	}
+	runs := []*Run{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		run, err := LoadRun(cfg, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.Before(runs[j].StartedAt)
+	})
+	return runs, nil
+}
@@ -0,0 +1,147 @@
+package treport_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/treport"
+)
+
+func TestConfigHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "treport.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+pipelines:
+  - name: size
+    strategy: allMergeCommit
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+      - [size]
+      - name: size
+        args: [-v]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	hash, err := cfg.Hash()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded treport.Config
+	if err := yaml.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	decodedHash, err := decoded.Hash()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if hash != decodedHash {
+		t.Fatalf("hash is changed by the round trip of normalized config:\n%s", b)
+	}
+	if len(decoded.Pipelines[0].Steps) != 3 || decoded.Pipelines[0].Steps[2].Plugins[0].Args[0] != "-v" {
+		t.Fatalf("failed to decode steps:\n%s", b)
+	}
+}
+
+func TestScannerDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	loadConfig := func(name, text string) *treport.Config {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := treport.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		return cfg
+	}
+	previous := loadConfig("previous.yaml", `
+project:
+  path: `+dir+`
+pipelines:
+  - name: size
+    strategy: allCommit
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+      - name: size
+        args: [-v]
+  - name: removed
+    strategy: allCommit
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+`)
+	current := loadConfig("current.yaml", `
+project:
+  path: `+dir+`
+pipelines:
+  - name: size
+    strategy: allCommit
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+      - name: size
+        args: [-vv]
+  - name: added
+    strategy: firstParent
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+`)
+	diff, err := treport.NewScanner(current).Diff(context.Background(), previous)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(diff.Pipelines) != 3 {
+		t.Fatalf("unexpected pipelines %+v", diff.Pipelines)
+	}
+	argsChanged := diff.Pipelines[0]
+	if argsChanged.Status != treport.ConfigDiffChanged || len(argsChanged.Changes) != 1 || len(argsChanged.Warnings) != 0 || len(argsChanged.Dropped) != 2 || len(argsChanged.Rescanned) != 2 {
+		t.Fatalf("unexpected diff of size %+v", argsChanged)
+	}
+	added := diff.Pipelines[1]
+	if added.Name != "added" || added.Status != treport.ConfigDiffAdded || len(added.Rescanned) != 1 {
+		t.Fatalf("unexpected diff of added %+v", added)
+	}
+	removed := diff.Pipelines[2]
+	if removed.Name != "removed" || removed.Status != treport.ConfigDiffRemoved || len(removed.Dropped) != 1 {
+		t.Fatalf("unexpected diff of removed %+v", removed)
+	}
+	current.Pipelines[0].Steps[1].Plugins[0].Args = []string{"-v"}
+	current.Pipelines[0].Strategy = treport.FirstParent
+	diff, err = treport.NewScanner(current).Diff(context.Background(), previous)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	changed := diff.Pipelines[0]
+	if len(changed.Changes) != 1 || len(changed.Dropped) != 2 || len(changed.Rescanned) != 2 {
+		t.Fatalf("unexpected diff of size %+v", changed)
+	}
+}
@@ -0,0 +1,93 @@
+package treport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// SandboxConfig restricts the process of the plugin, because the plugins are built from the third-party repositories.
+// HOME and TMPDIR of the plugin are the working directory. denyNetwork, memory and cpu are supported only on Linux,
+// and the sandbox isn't supported on Windows.
+type SandboxConfig struct {
+	// Dir is the working directory of the plugin. The temporary directory removed when the plugin stops is used by default.
+	Dir string `yaml:"dir"`
+	// DenyNetwork runs the plugin in the new network namespace, so it can't connect to anything including the host by TCP.
+	// treport still talks to the plugin, because go-plugin connects them by the unix socket, which the namespace doesn't isolate.
+	// It requires the unprivileged user namespaces.
+	DenyNetwork bool `yaml:"denyNetwork"`
+	// Memory is the max bytes of the address space of the plugin.
+	// Memory and CPU are set by prlimit of util-linux before the plugin is executed, so it must be installed.
+	Memory int64 `yaml:"memory"`
+	// CPU is the max seconds of the CPU time of the plugin.
+	CPU int64 `yaml:"cpu"`
+}
+
+// pluginSandbox is the sandbox of the running plugin.
+type pluginSandbox struct {
+	cfg *SandboxConfig
+	dir string
+	// tempDir is the working directory created for the plugin.
+	tempDir string
+}
+
+// newPluginSandbox runs the command of the plugin in the working directory and the network namespace with the limits of the resources.
+func newPluginSandbox(name string, cfg *SandboxConfig, cmd *exec.Cmd) (*pluginSandbox, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("sandbox of plugin %s isn't supported on windows", name)
+	}
+	// the relative path of the command is resolved from the working directory.
+	path, err := filepath.Abs(cmd.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get path of plugin %s", name)
+	}
+	cmd.Path = path
+	sandbox := &pluginSandbox{cfg: cfg, dir: cfg.Dir}
+	if sandbox.dir == "" {
+		tempDir, err := ioutil.TempDir("", "treport-plugin-"+name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create working directory of plugin %s", name)
+		}
+		sandbox.dir = tempDir
+		sandbox.tempDir = tempDir
+	} else if err := mkdirIfNotExists(sandbox.dir); err != nil {
+		return nil, errors.Wrapf(err, "failed to create working directory of plugin %s", name)
+	}
+	cmd.Dir = sandbox.dir
+	if cfg.Memory > 0 || cfg.CPU > 0 {
+		if err := limitCommand(cmd, cfg.Memory, cfg.CPU); err != nil {
+			sandbox.cleanup()
+			return nil, errors.Wrapf(err, "failed to limit resources of plugin %s", name)
+		}
+	}
+	if cfg.DenyNetwork {
+		if err := denyNetwork(cmd); err != nil {
+			sandbox.cleanup()
+			return nil, errors.Wrapf(err, "failed to deny network of plugin %s", name)
+		}
+	}
+	return sandbox, nil
+}
+
+// env returns the environment variables overwritten by the sandbox.
+func (s *pluginSandbox) env() map[string]string {
+	if s == nil {
+		return nil
+	}
+	return map[string]string{"HOME": s.dir, "TMPDIR": s.dir}
+}
+
+func (s *pluginSandbox) cleanup() {
+	if s == nil || s.tempDir == "" {
+		return
+	}
+	os.RemoveAll(s.tempDir)
+}
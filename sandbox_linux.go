@@ -0,0 +1,43 @@
+package treport
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// denyNetwork runs the command in the new user and network namespaces. The user of the host is mapped to the same one.
+// The namespace has no interface but its own loopback, so the plugin can't connect even to the host by TCP,
+// and go-plugin works only because it connects the host and the plugin by the unix socket.
+func denyNetwork(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	return nil
+}
+
+// limitCommand runs the command by prlimit, which sets RLIMIT_AS by memory and RLIMIT_CPU by cpu
+// and executes the command, so the plugin runs with the limits from the start.
+func limitCommand(cmd *exec.Cmd, memory, cpu int64) error {
+	prlimit, err := exec.LookPath("prlimit")
+	if err != nil {
+		return errors.Wrapf(err, "prlimit is required for memory and cpu limits")
+	}
+	args := []string{prlimit}
+	if memory > 0 {
+		args = append(args, fmt.Sprintf("--as=%d", memory))
+	}
+	if cpu > 0 {
+		args = append(args, fmt.Sprintf("--cpu=%d", cpu))
+	}
+	args = append(args, "--", cmd.Path)
+	cmd.Args = append(args, cmd.Args[1:]...)
+	cmd.Path = prlimit
+	return nil
+}
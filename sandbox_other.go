@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package treport
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func denyNetwork(cmd *exec.Cmd) error {
+	return fmt.Errorf("denyNetwork is supported only on linux")
+}
+
+func limitCommand(cmd *exec.Cmd, memory, cpu int64) error {
+	return fmt.Errorf("memory and cpu limits are supported only on linux")
+}
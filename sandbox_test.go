@@ -0,0 +1,33 @@
+package treport_test
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestSandboxLimitsBeforeExec(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memory and cpu limits are supported only on linux")
+	}
+	if _, err := exec.LookPath("prlimit"); err != nil {
+		t.Skip("prlimit isn't installed")
+	}
+	cmd := exec.Command("sh", "-c", "ulimit -v; ulimit -t")
+	cleanup, err := treport.SandboxCommand("test", &treport.SandboxConfig{Memory: 1 << 30, CPU: 10}, cmd)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer cleanup()
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ulimit -v reports the address space by KiB.
+	if got := strings.Fields(string(out)); len(got) != 2 || got[0] != "1048576" || got[1] != "10" {
+		t.Fatalf("unexpected limits of the process %q", out)
+	}
+}
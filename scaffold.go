@@ -0,0 +1,239 @@
+package treport
+
+import (
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+// PluginScaffold is the project of the new scanner plugin generated by GeneratePlugin.
+type PluginScaffold struct {
+	Name string
+	// Module is the module path of the project. It's the name by default.
+	Module string
+	// Replace is the local directory of treport, which replaces the module to develop the plugin with the unreleased one.
+	Replace string
+}
+
+var pluginNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// pluginTemplateData is the names derived from the plugin name, which are valid in Go and proto.
+type pluginTemplateData struct {
+	Name    string
+	Module  string
+	Replace string
+	// Ident is the lower camel case of the name, and Message is the message of the result.
+	Ident   string
+	Message string
+	// Alias is the name of the import of the generated proto package.
+	Alias string
+	// Package is the proto package. The data of the plugins are keyed by the full name of the message, so it's the name of the plugin.
+	Package string
+}
+
+func (s *PluginScaffold) templateData() (*pluginTemplateData, error) {
+	if !pluginNamePattern.MatchString(s.Name) {
+		return nil, fmt.Errorf("invalid plugin name %q: it must be lower case letters, digits, '-' or '_'", s.Name)
+	}
+	words := strings.FieldsFunc(s.Name, func(r rune) bool { return r == '-' || r == '_' })
+	var upper string
+	for _, word := range words {
+		upper += strings.ToUpper(word[:1]) + word[1:]
+	}
+	module := s.Module
+	if module == "" {
+		module = s.Name
+	}
+	return &pluginTemplateData{
+		Name:    s.Name,
+		Module:  module,
+		Replace: filepath.ToSlash(s.Replace),
+		Ident:   strings.ToLower(upper[:1]) + upper[1:],
+		Message: upper + "Data",
+		Alias:   strings.ToLower(upper) + "proto",
+		Package: strings.Join(words, "_"),
+	}, nil
+}
+
+// pluginTemplates are the files of the project. The paths are templates too.
+var pluginTemplates = []struct {
+	path string
+	body string
+}{
+	{
+		path: "go.mod",
+		body: `module {{ .Module }}
+
+go 1.15
+{{- if .Replace }}
+
+require github.com/goccy/treport v0.0.0-00010101000000-000000000000
+
+replace github.com/goccy/treport => {{ .Replace }}
+{{- end }}
+`,
+	},
+	{
+		path: "proto/{{ .Name }}.proto",
+		body: `syntax = "proto3";
+
+package {{ .Package }};
+
+option go_package = "{{ .Module }}/proto";
+
+message {{ .Message }} {
+  int64 commits = 1;
+  int64 changes = 2;
+}
+`,
+	},
+	{
+		path: "main.go",
+		body: `package main
+
+import (
+	"os"
+
+	{{ .Alias }} "{{ .Module }}/proto"
+	"github.com/goccy/treport"
+	"github.com/hashicorp/go-hclog"
+)
+
+type {{ .Ident }}Scanner struct {
+	logger hclog.Logger
+}
+
+// Scan counts the commits and the changed files until the commit, continuing from the result of the previous commit.
+func (s *{{ .Ident }}Scanner) Scan(ctx *treport.ScanContext) (*treport.Response, error) {
+	var v {{ .Alias }}.{{ .Message }}
+	if err := ctx.Previous(&v); err != nil {
+		if err != treport.ErrNoData {
+			return nil, err
+		}
+	}
+	s.logger.Debug("scan", "commit", ctx.Commit.Hash, "changes", len(ctx.Changes))
+	return treport.ToResponse(&{{ .Alias }}.{{ .Message }}{
+		Commits: v.Commits + 1,
+		Changes: v.Changes + int64(len(ctx.Changes)),
+	})
+}
+
+// SchemaVersion must be changed when {{ .Message }} is changed, so the cached results are scanned again.
+func (s *{{ .Ident }}Scanner) SchemaVersion() string {
+	return "1"
+}
+
+//go:generate protoc -Iproto proto/{{ .Name }}.proto --go_out=paths=source_relative:proto
+func main() {
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Trace,
+		Output:     os.Stderr,
+		JSONFormat: true,
+	})
+	treport.Serve(&{{ .Ident }}Scanner{logger: logger}, logger)
+}
+`,
+	},
+	{
+		path: "main_test.go",
+		body: `package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	{{ .Alias }} "{{ .Module }}/proto"
+	"github.com/goccy/treport/treporttest"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "{{ .Name }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scanner := &{{ .Ident }}Scanner{logger: hclog.NewNullLogger()}
+	results, err := treporttest.RunScanner(context.Background(), dir, &treporttest.RepositoryOptions{
+		Commits:          10,
+		Files:            20,
+		ChangesPerCommit: 3,
+	}, scanner)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for idx, result := range results {
+		var v {{ .Alias }}.{{ .Message }}
+		if err := result.Decode(&v); err != nil {
+			t.Fatalf("failed to decode result of %s: %+v", result.Commit.Hash, err)
+		}
+		if v.Commits != int64(idx+1) {
+			t.Fatalf("unexpected commits of %s: expected %d but got %d", result.Commit.Hash, idx+1, v.Commits)
+		}
+	}
+}
+`,
+	},
+}
+
+// GeneratePlugin writes the project of the new scanner plugin to dir, and returns the paths of the written files.
+// The generated code of the proto file is written by go generate. It fails before writing any file if one of them exists.
+func GeneratePlugin(dir string, scaffold *PluginScaffold) ([]string, error) {
+	data, err := scaffold.templateData()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	paths := make([]string, 0, len(pluginTemplates))
+	for _, tmpl := range pluginTemplates {
+		name, err := renderPluginTemplate(tmpl.path, data)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(string(name)))
+		if existsPath(path) {
+			return nil, fmt.Errorf("%s already exists", path)
+		}
+		body, err := renderPluginTemplate(tmpl.body, data)
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Ext(path) == ".go" {
+			// the import of the proto package is placed by the module path.
+			if body, err = format.Source(body); err != nil {
+				return nil, errors.Wrapf(err, "failed to format %s", path)
+			}
+		}
+		files[path] = body
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		if err := mkdirIfNotExists(filepath.Dir(path)); err != nil {
+			return nil, errors.Wrapf(err, "failed to create directory for %s", path)
+		}
+		if err := ioutil.WriteFile(path, files[path], 0644); err != nil {
+			return nil, errors.Wrapf(err, "failed to write %s", path)
+		}
+	}
+	return paths, nil
+}
+
+func renderPluginTemplate(text string, data *pluginTemplateData) ([]byte, error) {
+	tmpl, err := template.New("").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse plugin template")
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, errors.Wrapf(err, "failed to render plugin template")
+	}
+	return []byte(b.String()), nil
+}
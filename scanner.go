@@ -2,17 +2,50 @@ package treport
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/goccy/treport/internal/errors"
-	"golang.org/x/sync/errgroup"
+	treportproto "github.com/goccy/treport/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Scanner struct {
-	cfg *Config
+	cfg            *Config
+	progress       func(*ProgressEvent)
+	progressMu     sync.Mutex
+	tracerProvider trace.TracerProvider
+	logger         Logger
+	summary        *ScanSummary
+	subscribers    *subscribers
+	// orderedSubscribers receive the events buffered by ordered while the scan runs.
+	orderedSubscribers *subscribers
+	ordered            *orderedDelivery
+	// pluginPool keeps the plugins between the scans of daemon mode.
+	pluginPool *pluginPool
+	preparedMu sync.Mutex
+	prepared   *preparedScan
+}
+
+// preparedScan is the pipelines set up by Prepare and the lock of the mount paths held until they're scanned.
+type preparedScan struct {
+	pipelines []*Pipeline
+	unlock    func()
+}
+
+func (p *preparedScan) release(s *Scanner) {
+	for _, pipeline := range p.pipelines {
+		pipeline.Cleanup()
+		s.log().Debug("cleaned up plugins", "pipeline", pipeline.Config.Name)
+	}
+	p.unlock()
 }
 
 func NewScanner(cfg *Config) *Scanner {
-	return &Scanner{cfg: cfg}
+	return &Scanner{cfg: cfg, subscribers: &subscribers{}, orderedSubscribers: &subscribers{}}
 }
 
 func (s *Scanner) setupMountPoint() error {
@@ -22,120 +55,730 @@ func (s *Scanner) setupMountPoint() error {
 	return nil
 }
 
+// lockMounts locks the mount paths, so another process doesn't scan them at the same time.
+// The scratch directories left by the crashed runs are removed when the process takes the lock of the mount path.
+func (s *Scanner) lockMounts(ctx context.Context) (func(), error) {
+	mountPath, err := filepath.Abs(s.cfg.MountPath())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get mount path")
+	}
+	return s.cfg.lockMounts(ctx, func(path string) error {
+		if path != mountPath {
+			return nil
+		}
+		if err := os.RemoveAll(s.cfg.ScratchPath()); err != nil {
+			return errors.Wrapf(err, "failed to remove scratch directories of previous runs")
+		}
+		return nil
+	})
+}
+
+// setupScratch creates the scratch directory of the run, and the plugins write their temporary files to it.
+func (s *Scanner) setupScratch(run *Run, pipelines []*Pipeline) (string, error) {
+	path := filepath.Join(s.cfg.ScratchPath(), run.ID)
+	if err := mkdirIfNotExists(path); err != nil {
+		return "", err
+	}
+	for _, pipeline := range pipelines {
+		for _, repo := range pipeline.Repos {
+			for _, step := range repo.Steps {
+				for _, plg := range step.Plugins {
+					plg.scratchPath = path
+				}
+			}
+		}
+	}
+	return path, nil
+}
+
 func (s *Scanner) Scan(ctx context.Context) error {
-	if err := s.setupMountPoint(); err != nil {
-		return errors.Wrapf(err, "failed to setup mount point")
+	return s.scan(ctx, nil, false)
+}
+
+// ScanPipeline scans only the pipeline. If repos are given, only the repositories of the pipeline are scanned.
+// The caches of the other pipelines and repositories are kept as is.
+func (s *Scanner) ScanPipeline(ctx context.Context, name string, repos ...string) error {
+	cfg, err := s.cfg.SelectPipeline(name, repos...)
+	if err != nil {
+		return errors.Stack(err)
 	}
-	pipelines, err := CreatePipelines(ctx, s.cfg)
+	scanner := NewScanner(cfg)
+	scanner.progress = s.progress
+	scanner.tracerProvider = s.tracerProvider
+	scanner.logger = s.logger
+	scanner.subscribers = s.subscribers
+	scanner.orderedSubscribers = s.orderedSubscribers
+	scanner.pluginPool = s.pluginPool
+	err = scanner.scan(ctx, nil, false)
+	s.progressMu.Lock()
+	s.summary = scanner.Summary()
+	s.progressMu.Unlock()
+	return err
+}
+
+// Reproduce re-executes the recorded run.
+// The scanner must be created by the config of the run, and it fails if the current plugins differ from the recorded ones.
+func (s *Scanner) Reproduce(ctx context.Context, run *Run) error {
+	return s.scan(ctx, run, false)
+}
+
+// Resume continues the last run of the config interrupted by cancellation or crash.
+// Each plugin continues from the last completed commit, and the results of the completed commits are restored from cache.
+// If the last run isn't interrupted, it's the same as Scan.
+func (s *Scanner) Resume(ctx context.Context) error {
+	return s.scan(ctx, nil, true)
+}
+
+// baseBranchSyncs are the strategies whose scans sync the base branch. Worktree scans the local repository as it is.
+var baseBranchSyncs = map[Strategy]struct{}{
+	AllMergeCommit:  {},
+	EachPullRequest: {},
+	AllCommit:       {},
+	FirstParent:     {},
+	Periodic:        {},
+	HeadOnly:        {},
+	Metadata:        {},
+}
+
+// Prepare clones and syncs the repositories, sets up the plugins and opens their caches before scanning,
+// so the next Scan, Resume or Reproduce only computes the results. It's useful to give the network and the compute phases
+// separate timeouts, because ctx is used only by Prepare. The mount paths are locked until the prepared pipelines are scanned
+// or Close is called.
+func (s *Scanner) Prepare(ctx context.Context) (e error) {
+	s.preparedMu.Lock()
+	defer s.preparedMu.Unlock()
+	if s.prepared != nil {
+		return nil
+	}
+	prepared, err := s.setupPipelines(ctx)
 	if err != nil {
-		return errors.Wrapf(err, "failed to create pipelines")
+		return err
 	}
 	defer func() {
-		for _, pipeline := range pipelines {
-			pipeline.Cleanup()
+		if e != nil {
+			prepared.release(s)
 		}
 	}()
-	var eg errgroup.Group
-	for _, pipeline := range pipelines {
-		pipeline := pipeline
-		eg.Go(func() error {
-			return s.scanWithPipeline(ctx, pipeline)
-		})
+	ctx = withLogger(ctx, s.log())
+	for _, pipeline := range prepared.pipelines {
+		_, syncs := baseBranchSyncs[pipeline.Config.Strategy]
+		for _, repo := range pipeline.Repos {
+			if syncs {
+				start := time.Now()
+				if _, err := s.syncBaseBranch(ctx, repo); err != nil {
+					return errors.Wrapf(err, "failed to sync %s", repo.cfg.Repo)
+				}
+				s.emit(&ProgressEvent{Type: ProgressSynced, Pipeline: pipeline.Config.Name, Repo: repo.cfg.Repo, Elapsed: time.Since(start)})
+			}
+			for _, step := range repo.Steps {
+				for _, plg := range step.Plugins {
+					if _, err := plg.cacheDB(); err != nil {
+						return errors.Wrapf(err, "failed to open cache of %s", plg.Name)
+					}
+				}
+			}
+		}
 	}
-	if err := eg.Wait(); err != nil {
-		return errors.Stack(err)
+	s.prepared = prepared
+	return nil
+}
+
+// Close releases the pipelines prepared by Prepare if they aren't scanned.
+func (s *Scanner) Close() error {
+	s.preparedMu.Lock()
+	defer s.preparedMu.Unlock()
+	if s.prepared != nil {
+		s.prepared.release(s)
+		s.prepared = nil
 	}
 	return nil
 }
 
+// setupPipelines locks the mount paths and creates the pipelines.
+func (s *Scanner) setupPipelines(ctx context.Context) (*preparedScan, error) {
+	if err := s.setupMountPoint(); err != nil {
+		return nil, errors.Wrapf(err, "failed to setup mount point")
+	}
+	ctx = withLogger(ctx, s.log())
+	unlock, err := s.lockMounts(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to lock mount paths")
+	}
+	pipelines, err := CreatePipelines(withPluginPool(s.withCloneProgress(ctx), s.pluginPool), s.cfg)
+	if err != nil {
+		unlock()
+		return nil, errors.Wrapf(err, "failed to create pipelines")
+	}
+	return &preparedScan{pipelines: pipelines, unlock: unlock}, nil
+}
+
+// takePrepared returns the pipelines prepared by Prepare, or sets up them if Prepare isn't called.
+func (s *Scanner) takePrepared(ctx context.Context) (*preparedScan, error) {
+	s.preparedMu.Lock()
+	prepared := s.prepared
+	s.prepared = nil
+	s.preparedMu.Unlock()
+	if prepared != nil {
+		return prepared, nil
+	}
+	return s.setupPipelines(ctx)
+}
+
+func (s *Scanner) scan(ctx context.Context, recorded *Run, resume bool) error {
+	prepared, err := s.takePrepared(ctx)
+	if err != nil {
+		return err
+	}
+	defer prepared.release(s)
+	ctx = withLogger(ctx, s.log())
+	pipelines := prepared.pipelines
+	s.progressMu.Lock()
+	s.summary = newScanSummary(pipelines)
+	s.ordered = newOrderedDelivery(pipelines)
+	s.progressMu.Unlock()
+	defer s.finishOrdered()
+	run, err := newRun(s.cfg, pipelines)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create run")
+	}
+	if recorded != nil {
+		if err := recorded.verify(run.ConfigHash, run.Plugins); err != nil {
+			return errors.Stack(err)
+		}
+	}
+	if resume {
+		interrupted, err := lastInterruptedRun(s.cfg, run.ConfigHash)
+		if err != nil {
+			return errors.Wrapf(err, "failed to find interrupted run")
+		}
+		if interrupted != nil {
+			if err := interrupted.verify(run.ConfigHash, run.Plugins); err != nil {
+				return errors.Stack(err)
+			}
+			run.resume(interrupted)
+		}
+	}
+	scratchPath, err := s.setupScratch(run, pipelines)
+	if err != nil {
+		return errors.Wrapf(err, "failed to setup scratch directory")
+	}
+	defer os.RemoveAll(scratchPath)
+	// save the run before scanning, so the run interrupted by crash can be resumed.
+	if err := run.save(s.cfg.RunPath()); err != nil {
+		return errors.Wrapf(err, "failed to save run")
+	}
+	pipelineErrs := make([]error, len(pipelines))
+	if err := s.scanPipelines(ctx, pipelines, pipelineErrs); err != nil {
+		return s.finish(ctx, run, pipelines, pipelineErrs, err)
+	}
+	if err := WriteReports(s.cfg.Reports, NewReport(pipelines)); err != nil {
+		return s.finish(ctx, run, pipelines, pipelineErrs, errors.Wrapf(err, "failed to write reports"))
+	}
+	return s.finish(ctx, run, pipelines, pipelineErrs, nil)
+}
+
+// scanPipelines scans all pipelines, and stores the error of each pipeline to pipelineErrs.
+// It returns ScanErrors which has the failures of all pipelines including the ones continued by onError.
+func (s *Scanner) scanPipelines(ctx context.Context, pipelines []*Pipeline, pipelineErrs []error) error {
+	var (
+		wg   sync.WaitGroup
+		errs scanErrorCollector
+	)
+	for i, pipeline := range pipelines {
+		i := i
+		pipeline := pipeline
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.emit(&ProgressEvent{Type: ProgressPipelineStarted, Pipeline: pipeline.Config.Name})
+			ctx, span := s.tracer().Start(ctx, "treport.pipeline", trace.WithAttributes(
+				attribute.String("treport.pipeline", pipeline.Config.Name),
+				attribute.String("treport.strategy", string(pipeline.Config.Strategy)),
+			))
+			err := runPipelineHooks(ctx, pipeline, func() error {
+				return s.scanWithPipeline(ctx, pipeline)
+			})
+			endSpan(span, err)
+			s.emit(&ProgressEvent{Type: ProgressPipelineFinished, Pipeline: pipeline.Config.Name, Err: err})
+			var failures scanErrorCollector
+			if err != nil {
+				failures.add(err, ScanError{Pipeline: pipeline.Config.Name})
+			}
+			if continued := pipeline.failures(); len(continued) > 0 {
+				// the pipeline has partial results, but the other pipelines are continued.
+				failures.add(continued, ScanError{})
+			}
+			if err := failures.err(); err != nil {
+				pipelineErrs[i] = err
+				errs.add(err, ScanError{})
+			}
+		}()
+	}
+	wg.Wait()
+	return errs.err()
+}
+
+// finish records the run and notifies the result of each pipeline.
+func (s *Scanner) finish(ctx context.Context, run *Run, pipelines []*Pipeline, pipelineErrs []error, scanErr error) error {
+	s.progressMu.Lock()
+	s.summary.finish(pipelines)
+	s.progressMu.Unlock()
+	s.logSummary()
+	runErr := s.finishRun(run, scanErr)
+	records := newScanRecords(run, s.Summary(), pipelines, pipelineErrs, scanErr)
+	if err := saveScanRecords(s.cfg.HistoryPath(), records); err != nil && runErr == nil {
+		runErr = errors.Wrapf(err, "failed to save scan history")
+	}
+	report := NewReport(pipelines)
+	for i, pipeline := range pipelines {
+		data := &NotificationData{
+			Pipeline:   pipeline.Config.Name,
+			Desc:       pipeline.Config.Desc,
+			Status:     NotifySuccess,
+			RunID:      run.ID,
+			StartedAt:  run.StartedAt,
+			FinishedAt: run.FinishedAt,
+			Elapsed:    run.FinishedAt.Sub(run.StartedAt),
+			Report:     report.Pipelines[i],
+		}
+		if pipelineErrs[i] != nil {
+			data.Status = NotifyFailure
+			data.Error = pipelineErrs[i].Error()
+		}
+		if err := s.cfg.notify(ctx, data, pipeline.Config); err != nil && runErr == nil {
+			runErr = errors.Wrapf(err, "failed to notify result of pipeline %s", pipeline.Config.Name)
+		}
+	}
+	return runErr
+}
+
+// finishRun records the run with the result of the scan.
+func (s *Scanner) finishRun(run *Run, scanErr error) error {
+	run.finish(scanErr)
+	if err := run.save(s.cfg.RunPath()); err != nil {
+		if scanErr != nil {
+			return scanErr
+		}
+		return errors.Wrapf(err, "failed to save run")
+	}
+	return scanErr
+}
+
 func (s *Scanner) scanWithPipeline(ctx context.Context, pipeline *Pipeline) error {
-	var eg errgroup.Group
+	var (
+		wg   sync.WaitGroup
+		errs scanErrorCollector
+	)
 	for _, repo := range pipeline.Repos {
 		repo := repo
-		eg.Go(func() error {
-			return s.scanWithPipelineAndRepo(ctx, pipeline, repo)
-		})
-	}
-	if err := eg.Wait(); err != nil {
-		return errors.Stack(err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, span := startSpan(ctx, "treport.repository",
+				attribute.String("treport.repository", repo.cfg.Repo),
+				attribute.String("treport.branch", repo.scanBranch),
+			)
+			err := s.scanWithPipelineAndRepo(ctx, pipeline, repo)
+			endSpan(span, err)
+			s.repoScanned(repo)
+			if err == nil {
+				return
+			}
+			if repo.cfg.OnError == OnErrorContinue && !isPluginError(err, PluginErrorFatal) {
+				var continued scanErrorCollector
+				continued.add(err, ScanError{Pipeline: pipeline.Config.Name, Repo: repo.cfg.Repo})
+				for _, failure := range continued.errs {
+					repo.results.fail(failure)
+				}
+				return
+			}
+			errs.add(err, ScanError{Pipeline: pipeline.Config.Name, Repo: repo.cfg.Repo})
+		}()
 	}
-	return nil
+	wg.Wait()
+	return errs.err()
 }
 
+// scanWithPipelineAndRepo runs each step after the steps it depends on.
+// The steps which don't depend on each other run in parallel, and the steps depending on the failed step
+// directly or indirectly are skipped and reported by StepSkippedError. The other steps run to the end.
 func (s *Scanner) scanWithPipelineAndRepo(ctx context.Context, pipeline *Pipeline, repo *PipelineRepository) error {
+	done := make([]chan struct{}, len(repo.Steps))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	// failedSteps are the names of the failed steps which the step failed or was skipped by,
+	// and they are written before done of the step is closed.
+	failedSteps := make([]string, len(repo.Steps))
+	var (
+		wg   sync.WaitGroup
+		errs scanErrorCollector
+	)
 	for _, step := range repo.Steps {
-		var eg errgroup.Group
-		for _, plg := range step.Plugins {
-			plg := plg
-			eg.Go(func() error {
-				switch pipeline.Config.Strategy {
-				case AllMergeCommit:
-					if err := s.scanAllMergeCommits(ctx, plg, repo); err != nil {
-						return errors.Wrapf(err, "failed to scan all merge commit")
-					}
-				case AllCommit:
-					if err := s.scanAllCommits(ctx, plg, repo); err != nil {
-						return errors.Wrapf(err, "failed to scan all commit")
-					}
-				case HeadOnly:
-					if err := s.scanHeadOnly(ctx, plg, repo); err != nil {
-						return errors.Wrapf(err, "failed to scan head only")
-					}
+		step := step
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[step.Idx])
+			for _, dep := range step.Deps {
+				select {
+				case <-done[dep.Idx]:
+				case <-ctx.Done():
+					failedSteps[step.Idx] = pipeline.Config.stepName(step.Idx)
+					errs.add(ctx.Err(), ScanError{Pipeline: pipeline.Config.Name, Repo: repo.cfg.Repo})
+					return
 				}
-				return nil
-			})
+				if failed := failedSteps[dep.Idx]; failed != "" {
+					// the failure of the dependency is reported by it.
+					failedSteps[step.Idx] = failed
+					errs.add(
+						ErrStepSkipped(pipeline.Config.stepName(step.Idx), failed),
+						ScanError{Pipeline: pipeline.Config.Name, Repo: repo.cfg.Repo},
+					)
+					return
+				}
+			}
+			if err := runStepHooks(ctx, pipeline, repo, step, func() error {
+				return s.scanWithStep(ctx, pipeline, repo, step)
+			}); err != nil {
+				failedSteps[step.Idx] = pipeline.Config.stepName(step.Idx)
+				errs.add(err, ScanError{Pipeline: pipeline.Config.Name, Repo: repo.cfg.Repo})
+			}
+		}()
+	}
+	wg.Wait()
+	return errs.err()
+}
+
+func (s *Scanner) scanWithStep(ctx context.Context, pipeline *Pipeline, repo *PipelineRepository, step *Step) error {
+	var (
+		wg   sync.WaitGroup
+		errs scanErrorCollector
+	)
+	for _, plg := range step.Plugins {
+		plg := plg
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.scanWithPlugin(ctx, pipeline, repo, plg)
+			if err == nil {
+				return
+			}
+			if plg.OnError == OnErrorContinue && !isPluginError(err, PluginErrorFatal) {
+				repo.results.fail(&ScanError{Pipeline: pipeline.Config.Name, Repo: repo.cfg.Repo, Plugin: plg.Name, Err: err})
+				return
+			}
+			errs.add(err, ScanError{Pipeline: pipeline.Config.Name, Repo: repo.cfg.Repo, Plugin: plg.Name})
+		}()
+	}
+	wg.Wait()
+	return errs.err()
+}
+
+func (s *Scanner) scanWithPlugin(ctx context.Context, pipeline *Pipeline, repo *PipelineRepository, plg *Plugin) error {
+	progress := &pluginProgress{
+		scanner:  s,
+		pipeline: pipeline.Config.Name,
+		repo:     repo.cfg.identity(),
+		branch:   repo.scanBranch,
+		plugin:   plg.Name,
+		clock:    pipeline.Config.ResultClock(),
+	}
+	s.emit(&ProgressEvent{Type: ProgressPluginStarted, Pipeline: progress.pipeline, Repo: progress.repo, Branch: progress.branch, Plugin: progress.plugin})
+	start := time.Now()
+	err := s.scanWithStrategy(ctx, pipeline, repo, plg, progress)
+	if flushErr := plg.flushCache(); flushErr != nil && err == nil {
+		err = errors.Wrapf(flushErr, "failed to write cache of %s", plg.Name)
+	}
+	if err != nil {
+		s.emit(&ProgressEvent{Type: ProgressPluginFailed, Pipeline: progress.pipeline, Repo: progress.repo, Branch: progress.branch, Plugin: progress.plugin, Err: err})
+	}
+	s.emit(&ProgressEvent{
+		Type:     ProgressPluginFinished,
+		Pipeline: progress.pipeline,
+		Repo:     progress.repo,
+		Branch:   progress.branch,
+		Plugin:   progress.plugin,
+		Scanned:  progress.scanned,
+		Total:    progress.total,
+		Elapsed:  time.Since(start),
+		Err:      err,
+	})
+	return err
+}
+
+func (s *Scanner) scanWithStrategy(ctx context.Context, pipeline *Pipeline, repo *PipelineRepository, plg *Plugin, progress *pluginProgress) error {
+	if plg.Stage == StageAggregate {
+		if err := s.aggregate(ctx, plg, repo, progress); err != nil {
+			return errors.Wrapf(err, "failed to aggregate")
 		}
-		if err := eg.Wait(); err != nil {
-			return errors.Stack(err)
+		return nil
+	}
+	switch pipeline.Config.Strategy {
+	case AllMergeCommit:
+		if err := s.scanAllMergeCommits(ctx, plg, repo, pipeline.Config.WalkOptions(), progress); err != nil {
+			return errors.Wrapf(err, "failed to scan all merge commit")
+		}
+	case AllCommit:
+		if err := s.scanAllCommits(ctx, plg, repo, pipeline.Config.WalkOptions(), progress); err != nil {
+			return errors.Wrapf(err, "failed to scan all commit")
+		}
+	case FirstParent:
+		if err := s.scanFirstParentCommits(ctx, plg, repo, pipeline.Config.WalkOptions(), progress); err != nil {
+			return errors.Wrapf(err, "failed to scan first parent commit")
+		}
+	case Periodic:
+		if err := s.scanPeriodicCommits(ctx, plg, repo, pipeline.Config.WalkOptions(), progress); err != nil {
+			return errors.Wrapf(err, "failed to scan periodic commit")
+		}
+	case EachPullRequest:
+		if err := s.scanPullRequests(ctx, plg, repo, pipeline.Config.WalkOptions(), progress); err != nil {
+			return errors.Wrapf(err, "failed to scan pull requests")
+		}
+	case Metadata:
+		if err := s.scanMetadataCommits(ctx, plg, repo, pipeline.Config.WalkOptions(), progress); err != nil {
+			return errors.Wrapf(err, "failed to scan metadata of commit")
 		}
+	case HeadOnly:
+		if err := s.scanHeadOnly(ctx, plg, repo, progress); err != nil {
+			return errors.Wrapf(err, "failed to scan head only")
+		}
+	case Worktree:
+		if err := s.scanWorktree(ctx, plg, repo, progress); err != nil {
+			return errors.Wrapf(err, "failed to scan worktree")
+		}
+	default:
+		return ErrUnknownStrategy(pipeline.Config.Name, pipeline.Config.Strategy)
 	}
 	return nil
 }
 
-func (s *Scanner) scanAllMergeCommits(ctx context.Context, plg *Plugin, repo *PipelineRepository) error {
-	branchCfg, err := repo.Repository.BaseBranch()
-	if err != nil {
+func (s *Scanner) scanAllMergeCommits(ctx context.Context, plg *Plugin, repo *PipelineRepository, opt *WalkOptions, progress *pluginProgress) error {
+	if skip, err := s.syncBaseBranch(ctx, repo); err != nil || skip {
+		return err
+	}
+	return s.walkSinceHighWaterMark(ctx, plg, repo, opt, progress, repo.Repository.AllMergeCommits)
+}
+
+func (s *Scanner) scanPullRequests(ctx context.Context, plg *Plugin, repo *PipelineRepository, opt *WalkOptions, progress *pluginProgress) error {
+	if skip, err := s.syncBaseBranch(ctx, repo); err != nil || skip {
 		return err
 	}
-	if err := repo.Sync(ctx, branchCfg.Merge); err != nil {
-		return errors.Wrapf(err, "failed to sync repository")
+	return s.walkSinceHighWaterMark(ctx, plg, repo, opt, progress, repo.Repository.PullRequests)
+}
+
+func (s *Scanner) scanAllCommits(ctx context.Context, plg *Plugin, repo *PipelineRepository, opt *WalkOptions, progress *pluginProgress) error {
+	if skip, err := s.syncBaseBranch(ctx, repo); err != nil || skip {
+		return err
 	}
-	return repo.Repository.AllMergeCommits(ctx, func(scanctx *ScanContext) error {
-		if err := plg.Scan(ctx, scanctx); err != nil {
-			return errors.Wrapf(err, "failed to scan by %s", plg.Name)
+	return s.walkSinceHighWaterMark(ctx, plg, repo, opt, progress, repo.Repository.AllCommits)
+}
+
+func (s *Scanner) scanFirstParentCommits(ctx context.Context, plg *Plugin, repo *PipelineRepository, opt *WalkOptions, progress *pluginProgress) error {
+	if skip, err := s.syncBaseBranch(ctx, repo); err != nil || skip {
+		return err
+	}
+	return s.walkSinceHighWaterMark(ctx, plg, repo, opt, progress, repo.Repository.FirstParentCommits)
+}
+
+func (s *Scanner) scanPeriodicCommits(ctx context.Context, plg *Plugin, repo *PipelineRepository, opt *WalkOptions, progress *pluginProgress) error {
+	if skip, err := s.syncBaseBranch(ctx, repo); err != nil || skip {
+		return err
+	}
+	return s.walkSinceHighWaterMark(ctx, plg, repo, opt, progress, repo.Repository.PeriodicCommits)
+}
+
+func (s *Scanner) scanMetadataCommits(ctx context.Context, plg *Plugin, repo *PipelineRepository, opt *WalkOptions, progress *pluginProgress) error {
+	if skip, err := s.syncBaseBranch(ctx, repo); err != nil || skip {
+		return err
+	}
+	return s.walkSinceHighWaterMark(ctx, plg, repo, opt, progress, repo.Repository.AllCommits)
+}
+
+type walkFunc func(context.Context, *WalkOptions, func(*ScanContext) error) error
+
+// walkSinceHighWaterMark scans the commits after the last scanned commit of the plugin,
+// and restores the results of the scanned commits from cache without diffing them.
+// If some of them aren't in cache, it walks all commits again.
+// The mark is updated for each commit, so the interrupted scan continues from the last completed commit.
+func (s *Scanner) walkSinceHighWaterMark(ctx context.Context, plg *Plugin, repo *PipelineRepository, opt *WalkOptions, progress *pluginProgress, walk walkFunc) error {
+	mark, err := plg.HighWaterMark()
+	if err != nil {
+		return errors.Stack(err)
+	}
+	walkOpt := *opt
+	walkOpt.Since = mark
+	walkOpt.Started = progress.started
+	walkOpt.Cached = plg.hasCache
+	// previous is the result of the plugin for the last commit of the walk, which is passed to the plugin with the next one.
+	// The commits restored from cache carry it too, so the resumed walk passes the result of the last scanned commit.
+	var previous *treportproto.ScanResponse
+	walkOpt.Scanned = func(scanctx *ScanContext) error {
+		scanctx.Branch = repo.scanBranch
+		scanctx.Repository = repo.Repository
+		timing := &ScanTiming{}
+		cached, err := plg.loadCache(scanctx, timing)
+		if err != nil {
+			return errors.Stack(err)
 		}
+		if !cached {
+			quarantined, err := plg.isQuarantined(scanctx.Commit.Hash)
+			if err != nil {
+				return errors.Stack(err)
+			}
+			if plg.SkipEmptyChanges || quarantined {
+				// the commit before the mark without the result was skipped as the empty change or quarantined.
+				progress.commitScanned(scanctx, true, 0, timing)
+				return nil
+			}
+			return ErrCacheNotFound(plg.Name, scanctx.Commit.Hash)
+		}
+		// the commit skipped by the plugin doesn't have the result, and previous is kept.
+		if res, exists := scanctx.pluginResponse(plg.Name); exists {
+			previous = res
+		}
+		repo.results.record(plg.Name, scanctx)
+		progress.commitScanned(scanctx, true, 0, timing)
 		return nil
-	})
+	}
+	scan := func(scanctx *ScanContext) (e error) {
+		scanctx.Branch = repo.scanBranch
+		// the repository reads the ignore files of the commit.
+		scanctx.Repository = repo.Repository
+		skipped, err := plg.skipsQuarantinedCommit(ctx, scanctx.Commit.Hash)
+		if err == nil && !skipped {
+			skipped, err = plg.skipsEmptyChanges(scanctx)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to scan by %s", plg.Name)
+		}
+		if skipped {
+			// previous is kept, so the next commit is passed the result of the last scanned one.
+			progress.commitScanned(scanctx, false, 0, nil)
+			if opt.NewestFirst {
+				return nil
+			}
+			return plg.SetHighWaterMark(scanctx.Commit.Hash)
+		}
+		ctx, span := startCommitSpan(ctx, plg, scanctx)
+		defer func() { endSpan(span, e) }()
+		start := time.Now()
+		timing := &ScanTiming{}
+		if !opt.NewestFirst {
+			scanctx.previous = previous
+		}
+		cached, err := plg.scan(ctx, scanctx, timing)
+		if err != nil {
+			return errors.Wrapf(err, "failed to scan by %s", plg.Name)
+		}
+		if res, exists := scanctx.pluginResponse(plg.Name); exists {
+			previous = res
+		}
+		repo.results.record(plg.Name, scanctx)
+		if cached {
+			progress.commitScanned(scanctx, true, 0, timing)
+		} else {
+			progress.commitScanned(scanctx, false, time.Since(start), timing)
+		}
+		if opt.NewestFirst {
+			// the older commits may not be scanned yet, so the mark is kept for the walk from the oldest one.
+			return nil
+		}
+		return plg.SetHighWaterMark(scanctx.Commit.Hash)
+	}
+	err = walk(ctx, &walkOpt, scan)
+	var notFoundErr *CacheNotFoundError
+	if errors.As(err, &notFoundErr) {
+		walkOpt.Since = ""
+		previous = nil
+		err = walk(ctx, &walkOpt, scan)
+	}
+	return err
 }
 
-func (s *Scanner) scanAllCommits(ctx context.Context, plg *Plugin, repo *PipelineRepository) error {
-	branchCfg, err := repo.Repository.BaseBranch()
-	if err != nil {
+func (s *Scanner) scanHeadOnly(ctx context.Context, plg *Plugin, repo *PipelineRepository, progress *pluginProgress) error {
+	if skip, err := s.syncBaseBranch(ctx, repo); err != nil || skip {
 		return err
 	}
-	if err := repo.Sync(ctx, branchCfg.Merge); err != nil {
-		return errors.Wrapf(err, "failed to sync repository")
-	}
-	return repo.Repository.AllCommits(ctx, func(scanctx *ScanContext) error {
-		if err := plg.Scan(ctx, scanctx); err != nil {
+	return s.scanSingle(ctx, plg, repo, progress, repo.Repository.HeadOnly)
+}
+
+func (s *Scanner) scanWorktree(ctx context.Context, plg *Plugin, repo *PipelineRepository, progress *pluginProgress) error {
+	// the working tree is scanned as it is, because syncing overwrites the uncommitted changes.
+	return s.scanSingle(ctx, plg, repo, progress, repo.Repository.WorkingTree)
+}
+
+// scanSingle scans the only context passed by walk. The high water mark isn't updated.
+func (s *Scanner) scanSingle(ctx context.Context, plg *Plugin, repo *PipelineRepository, progress *pluginProgress, walk func(context.Context, func(*ScanContext) error) error) error {
+	progress.started(1)
+	return walk(ctx, func(scanctx *ScanContext) (e error) {
+		scanctx.Branch = repo.scanBranch
+		scanctx.Repository = repo.Repository
+		skipped, err := plg.skipsQuarantinedCommit(ctx, scanctx.Commit.Hash)
+		if err != nil {
+			return errors.Wrapf(err, "failed to scan by %s", plg.Name)
+		}
+		if skipped {
+			progress.commitScanned(scanctx, false, 0, nil)
+			return nil
+		}
+		ctx, span := startCommitSpan(ctx, plg, scanctx)
+		defer func() { endSpan(span, e) }()
+		start := time.Now()
+		timing := &ScanTiming{}
+		cached, err := plg.scan(ctx, scanctx, timing)
+		if err != nil {
 			return errors.Wrapf(err, "failed to scan by %s", plg.Name)
 		}
+		repo.results.record(plg.Name, scanctx)
+		if cached {
+			progress.commitScanned(scanctx, true, 0, timing)
+		} else {
+			progress.commitScanned(scanctx, false, time.Since(start), timing)
+		}
 		return nil
 	})
 }
 
-func (s *Scanner) scanHeadOnly(ctx context.Context, plg *Plugin, repo *PipelineRepository) error {
+// withCloneProgress returns the context which reports the clone progress as ProgressClone events too.
+func (s *Scanner) withCloneProgress(ctx context.Context) context.Context {
+	fn := cloneProgressFunc(ctx)
+	return WithCloneProgress(ctx, func(p *CloneProgress) {
+		if fn != nil {
+			fn(p)
+		}
+		s.emit(&ProgressEvent{Type: ProgressClone, Repo: p.Repo, Clone: p})
+	})
+}
+
+// syncBaseBranch syncs the base branch of the repository. The strategies read the trees from the object database,
+// so the worktree is checked out only if a plugin of the repository needs it.
+// If the repository is empty, it reports that the repository should be skipped.
+func (s *Scanner) syncBaseBranch(ctx context.Context, repo *PipelineRepository) (bool, error) {
+	if repo.cfg.IsLocal() {
+		return false, nil
+	}
+	start := time.Now()
+	ctx = s.withCloneProgress(ctx)
+	// the remote is fetched first, because the base branch is found by HEAD of the remote.
+	if err := repo.syncRemoteBranches(ctx); err != nil {
+		var emptyErr *EmptyRepositoryError
+		if errors.As(err, &emptyErr) {
+			return true, nil
+		}
+		return false, errors.Wrapf(err, "failed to sync repository")
+	}
 	branchCfg, err := repo.Repository.BaseBranch()
 	if err != nil {
-		return err
+		return false, err
 	}
-	if err := repo.Sync(ctx, branchCfg.Merge); err != nil {
-		return errors.Wrapf(err, "failed to sync repository")
+	syncBranch := repo.Sync
+	if !repo.needsWorktree() {
+		syncBranch = repo.SyncRefs
 	}
-	return repo.Repository.HeadOnly(ctx, func(scanctx *ScanContext) error {
-		if err := plg.Scan(ctx, scanctx); err != nil {
-			return errors.Wrapf(err, "failed to scan by %s", plg.Name)
-		}
-		return nil
-	})
+	if err := syncBranch(ctx, branchCfg.Merge); err != nil {
+		return false, errors.Wrapf(err, "failed to sync repository")
+	}
+	s.log().Info("synced repository", "repo", repo.cfg.Repo, "branch", branchCfg.Merge.Short(), "elapsed", time.Since(start))
+	return false, nil
 }
@@ -0,0 +1,140 @@
+package treport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when the next scan of the pipeline starts.
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s *intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronSchedule is the standard 5 fields cron expression ( minute hour day-of-month month day-of-week ).
+// If both day-of-month and day-of-week are restricted, the time matches either of them like cron.
+type cronSchedule struct {
+	minute     map[int]bool
+	hour       map[int]bool
+	dayOfMonth map[int]bool
+	month      map[int]bool
+	dayOfWeek  map[int]bool
+	anyDay     bool
+}
+
+// maxCronSearchMinutes bounds the search of the next time ( about 5 years ) for the expression which never matches like `0 0 31 2 *`.
+const maxCronSearchMinutes = 5 * 366 * 24 * 60
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	for i := 0; i < maxCronSearchMinutes; i++ {
+		if s.month[int(next.Month())] &&
+			s.matchDay(next) &&
+			s.hour[next.Hour()] &&
+			s.minute[next.Minute()] {
+			return next
+		}
+		next = next.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	if s.anyDay {
+		return s.dayOfMonth[t.Day()] && s.dayOfWeek[int(t.Weekday())]
+	}
+	return s.dayOfMonth[t.Day()] || s.dayOfWeek[int(t.Weekday())]
+}
+
+// ParseSchedule parses the interval ( e.g. `30m` or `@every 1h` ) or the cron expression ( e.g. `0 3 * * *` ).
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		spec = strings.TrimSpace(strings.TrimPrefix(spec, "@every "))
+	}
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive: %s", spec)
+		}
+		return &intervalSchedule{interval: interval}, nil
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected interval or 5 fields cron expression", spec)
+	}
+	var (
+		s   cronSchedule
+		err error
+	)
+	for _, field := range []struct {
+		dst      *map[int]bool
+		src      string
+		min, max int
+	}{
+		{dst: &s.minute, src: fields[0], min: 0, max: 59},
+		{dst: &s.hour, src: fields[1], min: 0, max: 23},
+		{dst: &s.dayOfMonth, src: fields[2], min: 1, max: 31},
+		{dst: &s.month, src: fields[3], min: 1, max: 12},
+		{dst: &s.dayOfWeek, src: fields[4], min: 0, max: 7},
+	} {
+		*field.dst, err = parseCronField(field.src, field.min, field.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+	}
+	s.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+	if s.dayOfWeek[7] {
+		// both 0 and 7 are Sunday.
+		s.dayOfWeek[0] = true
+	}
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			v, err := strconv.Atoi(part[idx+1:])
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part)
+			}
+			step = v
+			part = part[:idx]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			v, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			start, end = v, v
+			if len(bounds) == 2 {
+				v, err := strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value %q", part)
+				}
+				end = v
+			} else if step != 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("value %q is out of range [%d, %d]", part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,38 @@
+package treport_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goccy/treport"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2021, 4, 1, 10, 30, 15, 0, time.UTC) // Thursday
+	for _, test := range []struct {
+		spec     string
+		expected time.Time
+	}{
+		{spec: "1h", expected: base.Add(time.Hour)},
+		{spec: "@every 30m", expected: base.Add(30 * time.Minute)},
+		{spec: "* * * * *", expected: time.Date(2021, 4, 1, 10, 31, 0, 0, time.UTC)},
+		{spec: "0 3 * * *", expected: time.Date(2021, 4, 2, 3, 0, 0, 0, time.UTC)},
+		{spec: "*/20 * * * *", expected: time.Date(2021, 4, 1, 10, 40, 0, 0, time.UTC)},
+		{spec: "0 9 * * 1-5", expected: time.Date(2021, 4, 2, 9, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", expected: time.Date(2021, 4, 4, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 15 * 1", expected: time.Date(2021, 4, 5, 0, 0, 0, 0, time.UTC)},
+	} {
+		schedule, err := treport.ParseSchedule(test.spec)
+		if err != nil {
+			t.Fatalf("%s: %+v", test.spec, err)
+		}
+		if next := schedule.Next(base); !next.Equal(test.expected) {
+			t.Errorf("%s: expected %s but got %s", test.spec, test.expected, next)
+		}
+	}
+	for _, spec := range []string{"", "-1h", "* * *", "60 * * * *", "* * * 0 *", "*/0 * * * *"} {
+		if _, err := treport.ParseSchedule(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
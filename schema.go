@@ -0,0 +1,126 @@
+package treport
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(Strategy("")):            {string(AllMergeCommit), string(AllCommit), string(HeadOnly), string(FirstParent), string(Worktree), string(EachPullRequest), string(Periodic), string(Metadata)},
+	reflect.TypeOf(Period("")):              {string(PeriodDay), string(PeriodWeek), string(PeriodMonth)},
+	reflect.TypeOf(DiffMode("")):            {string(DiffPrevious), string(DiffFirstParent), string(DiffMergeBase), string(DiffCombined)},
+	reflect.TypeOf(OnError("")):             {string(OnErrorFail), string(OnErrorContinue)},
+	reflect.TypeOf(SchemaPolicy("")):        {string(SchemaPolicyInvalidate), string(SchemaPolicyKeep)},
+	reflect.TypeOf(StorageType("")):         {string(StorageFilesystem), string(StorageMemory)},
+	reflect.TypeOf(SubmoduleMode("")):       {string(SubmoduleOpaque), string(SubmodulePointer), string(SubmoduleFiles)},
+	reflect.TypeOf(AuthSource("")):          {string(AuthEnv), string(AuthNetrc), string(AuthCredentialHelper)},
+	reflect.TypeOf(PullRequestProvider("")): {string(PullRequestRefs), string(PullRequestGitHub), string(PullRequestGitLab)},
+	reflect.TypeOf(NotificationType("")):    {string(SlackNotification), string(EmailNotification), string(WebhookNotification)},
+	reflect.TypeOf(NotificationEvent("")):   {string(NotifySuccess), string(NotifyFailure)},
+	reflect.TypeOf(Clock("")):               {string(ClockCommit), string(ClockAuthor), string(ClockScan)},
+	reflect.TypeOf(LimitAction("")):         {string(LimitTruncate), string(LimitSkip), string(LimitFail)},
+	reflect.TypeOf(StepStage("")):           {string(StageCommit), string(StageAggregate)},
+}
+
+type jsonSchema map[string]interface{}
+
+type schemaGenerator struct {
+	definitions map[string]jsonSchema
+}
+
+// JSONSchema returns the JSON Schema of the config file for editors to validate and complete it.
+// The shorthand notations like the plugin name as the repository and the steps written as the plugin names are included.
+func (c *Config) JSONSchema() ([]byte, error) {
+	g := &schemaGenerator{definitions: map[string]jsonSchema{}}
+	root := g.object(reflect.TypeOf(Config{}))
+	root["$schema"] = jsonSchemaDraft
+	root["title"] = "treport config"
+	root["definitions"] = g.definitions
+	return json.MarshalIndent(root, "", "  ")
+}
+
+func (g *schemaGenerator) schema(typ reflect.Type) jsonSchema {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		schema := jsonSchema{"type": "string"}
+		if enum, exists := schemaEnums[typ]; exists {
+			schema["enum"] = enum
+		}
+		return schema
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if typ == reflect.TypeOf(HookCommands{}) {
+			// the command only.
+			return jsonSchema{"anyOf": []jsonSchema{{"type": "string"}, {"type": "array", "items": jsonSchema{"type": "string"}}}}
+		}
+		return jsonSchema{"type": "array", "items": g.schema(typ.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": g.schema(typ.Elem())}
+	case reflect.Struct:
+		return g.ref(typ)
+	}
+	return jsonSchema{}
+}
+
+// ref returns the reference to the definition of the struct, and defines it at the first time.
+func (g *schemaGenerator) ref(typ reflect.Type) jsonSchema {
+	name := typ.Name()
+	ref := jsonSchema{"$ref": "#/definitions/" + name}
+	if _, exists := g.definitions[name]; exists {
+		return ref
+	}
+	// reserve the name before generating the fields for the recursive types like StepConfig.
+	g.definitions[name] = jsonSchema{}
+	var def jsonSchema
+	switch typ {
+	case reflect.TypeOf(RepositoryConfig{}):
+		// the plugin name only.
+		def = jsonSchema{"anyOf": []jsonSchema{{"type": "string"}, g.object(typ)}}
+	case reflect.TypeOf(AuthConfig{}):
+		// the name of the auth profile.
+		def = jsonSchema{"anyOf": []jsonSchema{{"type": "string"}, g.object(typ)}}
+	case stepConfigType:
+		plugin := g.ref(pluginExecConfigType)
+		def = jsonSchema{"anyOf": []jsonSchema{
+			{"type": "string"},
+			{"type": "array", "items": jsonSchema{"anyOf": []jsonSchema{{"type": "string"}, plugin}}},
+			g.ref(stepDefinitionType),
+			plugin,
+		}}
+	default:
+		def = g.object(typ)
+	}
+	g.definitions[name] = def
+	return ref
+}
+
+func (g *schemaGenerator) object(typ reflect.Type) jsonSchema {
+	properties := jsonSchema{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := yamlFieldName(field)
+		if name == "-" {
+			continue
+		}
+		properties[name] = g.schema(field.Type)
+	}
+	return jsonSchema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
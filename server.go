@@ -0,0 +1,454 @@
+package treport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/treport/internal/errors"
+)
+
+type ScanJobStatus string
+
+const (
+	ScanJobRunning   ScanJobStatus = "running"
+	ScanJobSucceeded ScanJobStatus = "succeeded"
+	ScanJobFailed    ScanJobStatus = "failed"
+)
+
+// ScanJob is the state of the scan triggered by Server.
+type ScanJob struct {
+	ID         string        `json:"id"`
+	Pipeline   string        `json:"pipeline"`
+	Status     ScanJobStatus `json:"status"`
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	Error      string        `json:"error,omitempty"`
+	// ErrorCode is the classification of Error like config, auth, git, plugin and cache.
+	ErrorCode string `json:"errorCode,omitempty"`
+	updated   chan struct{}
+}
+
+func (j *ScanJob) finished() bool {
+	return j.Status != ScanJobRunning
+}
+
+// Server serves HTTP API to list pipelines, trigger scans and fetch results.
+//
+//	GET  /pipelines
+//	POST /pipelines/{name}/scans
+//	GET  /scans
+//	GET  /scans/{id}
+//	GET  /scans/{id}/events
+//	GET  /results?repo={repo}&plugin={plugin}
+//	POST /webhooks/github ( if webhook is configured )
+//	POST /webhooks/gitlab ( if webhook is configured )
+type Server struct {
+	cfg    *Config
+	ctx    context.Context
+	cancel func()
+	mux    *http.ServeMux
+	mu     sync.Mutex
+	jobs   []*ScanJob
+	// pending has the configs to rescan after the running scan of the pipeline, which are triggered by webhooks.
+	pending map[string]*Config
+	wg      sync.WaitGroup
+}
+
+func NewServer(cfg *Config) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cfg:     cfg,
+		ctx:     ctx,
+		cancel:  cancel,
+		mux:     http.NewServeMux(),
+		pending: map[string]*Config{},
+	}
+	s.mux.HandleFunc("/pipelines", s.handlePipelines)
+	s.mux.HandleFunc("/pipelines/", s.handlePipeline)
+	s.mux.HandleFunc("/scans", s.handleScans)
+	s.mux.HandleFunc("/scans/", s.handleScan)
+	s.mux.HandleFunc("/results", s.handleResults)
+	s.mux.HandleFunc("/history", s.handleHistory)
+	if cfg.Webhook != nil {
+		s.mux.Handle("/webhooks/", newWebhookHandler(cfg, s.scanByWebhook))
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close cancels running scans and waits for them to finish.
+func (s *Server) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+type pipelineInfo struct {
+	Name         string   `json:"name"`
+	Desc         string   `json:"desc"`
+	Strategy     Strategy `json:"strategy"`
+	Enabled      bool     `json:"enabled"`
+	Tags         []string `json:"tags,omitempty"`
+	Repositories []string `json:"repositories"`
+}
+
+func (s *Server) handlePipelines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.pipelines())
+}
+
+func (s *Server) pipelines() []*pipelineInfo {
+	pipelines := make([]*pipelineInfo, 0, len(s.cfg.Pipelines))
+	for _, pipelineCfg := range s.cfg.Pipelines {
+		repos := make([]string, 0, len(pipelineCfg.Repository))
+		for _, repoCfg := range pipelineCfg.Repository {
+			repos = append(repos, repoCfg.Repo)
+		}
+		pipelines = append(pipelines, &pipelineInfo{
+			Name:         pipelineCfg.Name,
+			Desc:         pipelineCfg.Desc,
+			Strategy:     pipelineCfg.Strategy,
+			Enabled:      pipelineCfg.IsEnabled(),
+			Tags:         pipelineCfg.Tags,
+			Repositories: repos,
+		})
+	}
+	return pipelines
+}
+
+func (s *Server) handlePipeline(w http.ResponseWriter, r *http.Request) {
+	paths := strings.Split(strings.TrimPrefix(r.URL.Path, "/pipelines/"), "/")
+	if len(paths) != 2 || paths[1] != "scans" {
+		writeHTTPError(w, http.StatusNotFound, fmt.Errorf("%s is not found", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	job, err := s.startScan(paths[0])
+	if err != nil {
+		var notFoundErr *PipelineNotFoundError
+		if errors.As(err, &notFoundErr) {
+			writeHTTPError(w, http.StatusNotFound, err)
+			return
+		}
+		var runningErr *PipelineRunningError
+		if errors.As(err, &runningErr) {
+			writeHTTPError(w, http.StatusConflict, err)
+			return
+		}
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, s.snapshot(job))
+}
+
+func (s *Server) handleScans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	s.mu.Lock()
+	jobs := make([]ScanJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	paths := strings.Split(strings.TrimPrefix(r.URL.Path, "/scans/"), "/")
+	job := s.findJob(paths[0])
+	if job == nil {
+		writeHTTPError(w, http.StatusNotFound, fmt.Errorf("scan %s is not found", paths[0]))
+		return
+	}
+	switch {
+	case len(paths) == 1:
+		writeJSON(w, http.StatusOK, s.snapshot(job))
+	case len(paths) == 2 && paths[1] == "events":
+		s.streamEvents(w, r, job)
+	default:
+		writeHTTPError(w, http.StatusNotFound, fmt.Errorf("%s is not found", r.URL.Path))
+	}
+}
+
+// streamEvents writes the state of the job as newline delimited JSON whenever it is changed until the job finishes.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, job *ScanJob) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	_ = s.watch(r.Context(), job, func(state ScanJob) error {
+		if err := enc.Encode(state); err != nil {
+			return err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// watch calls fn with the state of the job whenever it is changed until the job finishes.
+func (s *Server) watch(ctx context.Context, job *ScanJob, fn func(ScanJob) error) error {
+	for {
+		s.mu.Lock()
+		state := *job
+		updated := job.updated
+		s.mu.Unlock()
+		if err := fn(state); err != nil {
+			return err
+		}
+		if state.finished() {
+			return nil
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	repo := r.URL.Query().Get("repo")
+	plugin := r.URL.Query().Get("plugin")
+	if (repo == "") != (plugin == "") {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("both repo and plugin must be specified"))
+		return
+	}
+	results, err := s.results(repo, plugin)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// results returns all results of the plugin for the repository, or all results if both are empty.
+func (s *Server) results(repo, plugin string) ([]*Result, error) {
+	db, err := OpenResultDB(s.cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open result db")
+	}
+	defer db.Close()
+	if repo != "" {
+		return db.Commits(repo, plugin)
+	}
+	return db.Range(time.Time{}, time.Time{})
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	db, err := OpenResultDB(s.cfg)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, errors.Wrapf(err, "failed to open result db"))
+		return
+	}
+	defer db.Close()
+	records, err := db.History(r.URL.Query().Get("pipeline"), r.URL.Query().Get("repo"))
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+func (s *Server) startScan(pipelineName string) (*ScanJob, error) {
+	cfg, err := s.cfg.withPipeline(pipelineName)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startJob(pipelineName, cfg)
+}
+
+// scanByWebhook starts the scan of the pipeline. If the pipeline is being scanned,
+// the scan starts after the running one finishes, so the pushed commits are not missed.
+func (s *Server) scanByWebhook(pipelineName string, cfg *Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.startJob(pipelineName, cfg); err != nil {
+		var runningErr *PipelineRunningError
+		if !errors.As(err, &runningErr) {
+			return err
+		}
+		if pending, exists := s.pending[pipelineName]; exists {
+			cfg = mergePipelineRepositories(pending, cfg)
+		}
+		s.pending[pipelineName] = cfg
+	}
+	return nil
+}
+
+// startJob starts the scan of the pipeline by cfg. s.mu must be locked.
+func (s *Server) startJob(pipelineName string, cfg *Config) (*ScanJob, error) {
+	for _, job := range s.jobs {
+		if job.Pipeline == pipelineName && !job.finished() {
+			return nil, ErrPipelineRunning(pipelineName, job.ID)
+		}
+	}
+	job := &ScanJob{
+		ID:        fmt.Sprint(len(s.jobs) + 1),
+		Pipeline:  pipelineName,
+		Status:    ScanJobRunning,
+		StartedAt: time.Now(),
+		updated:   make(chan struct{}),
+	}
+	s.jobs = append(s.jobs, job)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := NewScanner(cfg).Scan(s.ctx)
+		s.finishJob(job, err)
+	}()
+	return job, nil
+}
+
+func (s *Server) finishJob(job *ScanJob, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.FinishedAt = time.Now()
+	if err != nil {
+		job.Status = ScanJobFailed
+		job.Error = err.Error()
+		job.ErrorCode = string(errors.Code(err))
+	} else {
+		job.Status = ScanJobSucceeded
+	}
+	close(job.updated)
+	job.updated = make(chan struct{})
+	if cfg, exists := s.pending[job.Pipeline]; exists && s.ctx.Err() == nil {
+		delete(s.pending, job.Pipeline)
+		_, _ = s.startJob(job.Pipeline, cfg)
+	}
+}
+
+func (s *Server) findJob(id string) *ScanJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func (s *Server) snapshot(job *ScanJob) ScanJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *job
+}
+
+// withPipeline returns the copy of the config which has only the pipeline.
+func (c *Config) withPipeline(name string) (*Config, error) {
+	for _, pipelineCfg := range c.Pipelines {
+		if pipelineCfg.Name == name {
+			if !pipelineCfg.IsEnabled() {
+				// the disabled pipeline is scanned when it's explicitly selected.
+				enabled := *pipelineCfg
+				enabled.Enabled = nil
+				pipelineCfg = &enabled
+			}
+			return c.selectPipelines(pipelineCfg), nil
+		}
+	}
+	return nil, ErrPipelineNotFound(name)
+}
+
+// SelectTags returns the copy of the config which has only the enabled pipelines having any of the tags.
+func (c *Config) SelectTags(tags ...string) *Config {
+	pipelines := []*PipelineConfig{}
+	for _, pipelineCfg := range c.Pipelines {
+		if pipelineCfg.IsEnabled() && pipelineCfg.HasTag(tags...) {
+			pipelines = append(pipelines, pipelineCfg)
+		}
+	}
+	return c.selectPipelines(pipelines...)
+}
+
+// SelectPipeline returns the copy of the config which has only the pipeline.
+// If repos are given, the pipeline has only the repositories. The repository is matched by the URL ignoring the scheme and .git suffix.
+func (c *Config) SelectPipeline(name string, repos ...string) (*Config, error) {
+	cfg, err := c.withPipeline(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(repos) == 0 {
+		return cfg, nil
+	}
+	pipelineCfg := *cfg.Pipelines[0]
+	pipelineCfg.Repository = nil
+	for _, repo := range repos {
+		var found *RepositoryConfig
+		for _, repoCfg := range cfg.Pipelines[0].Repository {
+			if normalizeRepoURL(repoCfg.Repo) == normalizeRepoURL(repo) {
+				found = repoCfg
+				break
+			}
+		}
+		if found == nil {
+			return nil, ErrRepositoryNotFound(name, repo)
+		}
+		pipelineCfg.Repository = append(pipelineCfg.Repository, found)
+	}
+	cfg.Pipelines = []*PipelineConfig{&pipelineCfg}
+	return cfg, nil
+}
+
+// mergePipelineRepositories returns the copy of a which also has the repositories of b.
+// Both configs must have only the same pipeline.
+func mergePipelineRepositories(a, b *Config) *Config {
+	pipelineCfg := *a.Pipelines[0]
+	pipelineCfg.Repository = append([]*RepositoryConfig{}, pipelineCfg.Repository...)
+	for _, repoCfg := range b.Pipelines[0].Repository {
+		exists := false
+		for _, r := range pipelineCfg.Repository {
+			if r == repoCfg {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			pipelineCfg.Repository = append(pipelineCfg.Repository, repoCfg)
+		}
+	}
+	cfg := *a
+	cfg.Pipelines = []*PipelineConfig{&pipelineCfg}
+	return &cfg
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error(), "code": string(errors.Code(err))})
+}
@@ -0,0 +1,220 @@
+package treport_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestServer(t *testing.T) {
+	server := treport.NewServer(&treport.Config{
+		Pipelines: []*treport.PipelineConfig{
+			{
+				Name:     "repo-size",
+				Strategy: treport.HeadOnly,
+				Repository: []*treport.RepositoryConfig{
+					{Repo: "https://github.com/goccy/go-json"},
+				},
+			},
+		},
+	})
+	defer server.Close()
+
+	t.Run("list pipelines", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pipelines", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+		}
+		var pipelines []struct {
+			Name         string   `json:"name"`
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &pipelines); err != nil {
+			t.Fatal(err)
+		}
+		if len(pipelines) != 1 || pipelines[0].Name != "repo-size" || pipelines[0].Repositories[0] != "https://github.com/goccy/go-json" {
+			t.Fatalf("unexpected pipelines: %s", rec.Body)
+		}
+	})
+	t.Run("scan unknown pipeline", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/pipelines/unknown/scans", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("unknown scan", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/scans/1", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestWebhook(t *testing.T) {
+	os.Setenv("TREPORT_TEST_WEBHOOK_SECRET", "secret")
+	defer os.Unsetenv("TREPORT_TEST_WEBHOOK_SECRET")
+	server := treport.NewServer(&treport.Config{
+		Pipelines: []*treport.PipelineConfig{
+			{
+				Name:     "repo-size",
+				Strategy: treport.HeadOnly,
+				Repository: []*treport.RepositoryConfig{
+					{Repo: "https://github.com/goccy/go-json", Branch: "master"},
+				},
+			},
+		},
+		Webhook: &treport.WebhookConfig{SecretEnv: "TREPORT_TEST_WEBHOOK_SECRET"},
+	})
+	defer server.Close()
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	t.Run("invalid signature", func(t *testing.T) {
+		body := `{"ref":"refs/heads/master","repository":{"clone_url":"https://github.com/goccy/go-json.git"}}`
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", sign("invalid"))
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("too large body", func(t *testing.T) {
+		body := `{"ref":"refs/heads/master","padding":"` + strings.Repeat("a", 5<<20) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", sign(body))
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("push to other branch", func(t *testing.T) {
+		body := `{"ref":"refs/heads/feature","repository":{"clone_url":"https://github.com/goccy/go-json.git"}}`
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", sign(body))
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"pipelines":[]}` {
+			t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("push to unknown repository", func(t *testing.T) {
+		body := `{"object_kind":"push","ref":"refs/heads/master","project":{"git_http_url":"https://gitlab.com/goccy/go-json.git"}}`
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/gitlab", strings.NewReader(body))
+		req.Header.Set("X-Gitlab-Event", "Push Hook")
+		req.Header.Set("X-Gitlab-Token", "secret")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"pipelines":[]}` {
+			t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	pipelines := []*treport.PipelineConfig{
+		{
+			Name:     "repo-size",
+			Strategy: treport.HeadOnly,
+			Repository: []*treport.RepositoryConfig{
+				{Repo: "https://github.com/goccy/go-json", Branch: "master"},
+			},
+		},
+	}
+	body := `{"ref":"refs/heads/master","repository":{"clone_url":"https://github.com/goccy/go-json.git"}}`
+	post := func(server *treport.Server) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+	t.Run("not configured", func(t *testing.T) {
+		server := treport.NewServer(&treport.Config{Pipelines: pipelines})
+		defer server.Close()
+		if rec := post(server); rec.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("secret is not set", func(t *testing.T) {
+		server := treport.NewServer(&treport.Config{
+			Pipelines: pipelines,
+			Webhook:   &treport.WebhookConfig{SecretEnv: "TREPORT_TEST_UNDEFINED_WEBHOOK_SECRET"},
+		})
+		defer server.Close()
+		if rec := post(server); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestSelectPipeline(t *testing.T) {
+	cfg := &treport.Config{
+		Pipelines: []*treport.PipelineConfig{
+			{Name: "a", Repository: []*treport.RepositoryConfig{{Repo: "https://github.com/goccy/go-json"}, {Repo: "https://github.com/goccy/go-yaml"}}},
+			{Name: "b", Repository: []*treport.RepositoryConfig{{Repo: "https://github.com/goccy/go-json"}}},
+		},
+	}
+	selected, err := cfg.SelectPipeline("a", "github.com/goccy/go-yaml.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected.Pipelines) != 1 || len(selected.Pipelines[0].Repository) != 1 || selected.Pipelines[0].Repository[0].Repo != "https://github.com/goccy/go-yaml" {
+		t.Fatalf("unexpected pipelines %+v", selected.Pipelines)
+	}
+	if len(cfg.Pipelines[0].Repository) != 2 {
+		t.Fatal("original config is modified")
+	}
+	var repoErr *treport.RepositoryNotFoundError
+	if _, err := cfg.SelectPipeline("b", "https://github.com/goccy/go-yaml"); !errors.As(err, &repoErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var pipelineErr *treport.PipelineNotFoundError
+	if _, err := cfg.SelectPipeline("c"); !errors.As(err, &pipelineErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestSelectTags(t *testing.T) {
+	disabled := false
+	cfg := &treport.Config{
+		Pipelines: []*treport.PipelineConfig{
+			{Name: "a", Tags: []string{"nightly"}},
+			{Name: "b", Tags: []string{"nightly", "experimental"}, Enabled: &disabled},
+			{Name: "c", Tags: []string{"hourly"}},
+		},
+	}
+	selected := cfg.SelectTags("nightly", "experimental")
+	if len(selected.Pipelines) != 1 || selected.Pipelines[0].Name != "a" {
+		t.Fatalf("unexpected pipelines %+v", selected.Pipelines)
+	}
+	explicit, err := cfg.SelectPipeline("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !explicit.Pipelines[0].IsEnabled() {
+		t.Fatal("explicitly selected pipeline is disabled")
+	}
+	if cfg.Pipelines[1].IsEnabled() {
+		t.Fatal("original config is modified")
+	}
+}
@@ -0,0 +1,37 @@
+package treport
+
+import (
+	"io/ioutil"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// loadKeyring reads the armored keyring of the config. It returns "" if keyring isn't configured.
+func loadKeyring(cfg *RepositoryConfig) (string, error) {
+	if cfg.Keyring == "" {
+		return "", nil
+	}
+	keyring, err := ioutil.ReadFile(cfg.Keyring)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read keyring %s", cfg.Keyring)
+	}
+	return string(keyring), nil
+}
+
+// toCommit converts the commit, and verifies the signature of it against the keyring if it's configured.
+// SignatureValid is false for the unsigned commits and the commits signed by the unknown keys.
+func (r *Repository) toCommit(src *object.Commit) *Commit {
+	commit := toCommit(src)
+	r.linkPullRequest(commit)
+	if r.keyring == "" || src.PGPSignature == "" {
+		return commit
+	}
+	entity, err := src.Verify(r.keyring)
+	if err != nil {
+		return commit
+	}
+	commit.SignatureValid = true
+	commit.SignerKeyID = entity.PrimaryKey.KeyIdString()
+	return commit
+}
@@ -0,0 +1,139 @@
+package treport
+
+import (
+	"sort"
+	"sync"
+
+	treportproto "github.com/goccy/treport/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// snapshotEncoder sends the snapshot to the plugin as the delta from the previous one.
+// It's used only for the plugins which report snapshotDelta by Info RPC. The plugins of the run sharing the process
+// encode their snapshots by the same encoder, so the sequence numbers are unique in the process.
+type snapshotEncoder struct {
+	mu  sync.Mutex
+	seq uint64
+	// entries is the snapshot the plugin has. It's nil if the next snapshot must be sent in full.
+	entries map[string]File
+}
+
+// encode sets the snapshot of the request. It's sent in full for the first time,
+// or if the delta isn't smaller than the snapshot.
+func (e *snapshotEncoder) encode(req *treportproto.ScanContext, snapshot *Snapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seq++
+	req.SnapshotSeq = e.seq
+	// the plugin reconstructs the entries in the order of the name, so the other order is sent in full.
+	sorted := sort.SliceIsSorted(snapshot.Entries, func(i, j int) bool {
+		return snapshot.Entries[i].Name < snapshot.Entries[j].Name
+	})
+	if e.entries == nil || !sorted {
+		req.Snapshot = snapshot.toProto()
+		e.entries = nil
+		if sorted {
+			e.entries = snapshotEntries(snapshot)
+		}
+		return
+	}
+	delta := &treportproto.SnapshotDelta{BaseSeq: e.seq - 1}
+	current := snapshotEntries(snapshot)
+	for _, entry := range snapshot.Entries {
+		if prev, exists := e.entries[entry.Name]; exists && prev == *entry {
+			continue
+		}
+		delta.Upserted = append(delta.Upserted, entry.toProto())
+	}
+	for name := range e.entries {
+		if _, exists := current[name]; !exists {
+			delta.Removed = append(delta.Removed, name)
+		}
+	}
+	e.entries = current
+	if len(delta.Upserted)+len(delta.Removed) >= len(snapshot.Entries) {
+		req.Snapshot = snapshot.toProto()
+		return
+	}
+	sort.Strings(delta.Removed)
+	req.Snapshot = &treportproto.Snapshot{Hash: snapshot.Hash}
+	req.SnapshotDelta = delta
+}
+
+// reset makes the next snapshot sent in full. It's called when the plugin may not have the previous snapshot.
+func (e *snapshotEncoder) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.entries = nil
+}
+
+func snapshotEntries(snapshot *Snapshot) map[string]File {
+	entries := make(map[string]File, len(snapshot.Entries))
+	for _, entry := range snapshot.Entries {
+		entries[entry.Name] = *entry
+	}
+	return entries
+}
+
+// snapshotDecoder reconstructs the snapshot from the delta on the plugin side.
+type snapshotDecoder struct {
+	mu      sync.Mutex
+	seq     uint64
+	entries []*treportproto.File
+}
+
+var errSnapshotBaseMismatch = status.Error(codes.FailedPrecondition, "base snapshot of delta is not found")
+
+// decode replaces the snapshot of the request which has the delta with the reconstructed one.
+func (d *snapshotDecoder) decode(req *treportproto.ScanContext) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if req.SnapshotDelta == nil {
+		if req.Snapshot != nil {
+			d.seq = req.SnapshotSeq
+			d.entries = req.Snapshot.Entries
+		}
+		return nil
+	}
+	delta := req.SnapshotDelta
+	if d.entries == nil || delta.BaseSeq != d.seq {
+		return errSnapshotBaseMismatch
+	}
+	removed := make(map[string]struct{}, len(delta.Removed))
+	for _, name := range delta.Removed {
+		removed[name] = struct{}{}
+	}
+	upserted := make(map[string]*treportproto.File, len(delta.Upserted))
+	for _, entry := range delta.Upserted {
+		upserted[entry.Name] = entry
+	}
+	added := []*treportproto.File{}
+	for _, entry := range delta.Upserted {
+		idx := sort.Search(len(d.entries), func(i int) bool { return d.entries[i].Name >= entry.Name })
+		if idx == len(d.entries) || d.entries[idx].Name != entry.Name {
+			added = append(added, entry)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Name < added[j].Name })
+	entries := make([]*treportproto.File, 0, len(d.entries)+len(added)-len(removed))
+	for _, entry := range d.entries {
+		for len(added) > 0 && added[0].Name < entry.Name {
+			entries = append(entries, added[0])
+			added = added[1:]
+		}
+		if _, exists := removed[entry.Name]; exists {
+			continue
+		}
+		if newEntry, exists := upserted[entry.Name]; exists {
+			entry = newEntry
+		}
+		entries = append(entries, entry)
+	}
+	entries = append(entries, added...)
+	d.seq = req.SnapshotSeq
+	d.entries = entries
+	req.Snapshot.Entries = entries
+	req.SnapshotDelta = nil
+	return nil
+}
@@ -0,0 +1,58 @@
+package treport
+
+import (
+	"fmt"
+	"io"
+
+	treportproto "github.com/goccy/treport/proto"
+)
+
+// snapshotPageSize is the number of the entries of the snapshot sent by a message of ScanStream.
+const snapshotPageSize = 5000
+
+var errSnapshotStreamRead = fmt.Errorf("entries of the streamed snapshot are already read")
+
+// snapshotStream reads the entries of the snapshot from ScanStream on the plugin side.
+type snapshotStream struct {
+	stream treportproto.Scanner_ScanStreamServer
+	read   bool
+}
+
+func (s *snapshotStream) each(fn func(*File) error) error {
+	if s.read {
+		return errSnapshotStreamRead
+	}
+	s.read = true
+	for {
+		chunk, err := s.stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range chunk.Entries {
+			if err := fn(protoToFile(entry)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Files calls fn for each entry of the snapshot in order, and returns the first error of fn.
+// If the plugin implements SnapshotStreamer, the entries are received while they are iterated,
+// so Files can be called only once for the commit.
+func (c *ScanContext) Files(fn func(*File) error) error {
+	if c.files != nil {
+		return c.files.each(fn)
+	}
+	if c.Snapshot == nil {
+		return nil
+	}
+	for _, entry := range c.Snapshot.Entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
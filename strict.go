@@ -0,0 +1,139 @@
+package treport
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+var (
+	stepConfigType       = reflect.TypeOf(StepConfig{})
+	stepDefinitionType   = reflect.TypeOf(stepDefinition{})
+	pluginExecConfigType = reflect.TypeOf(PluginExecConfig{})
+)
+
+// unknownKeys returns the errors of the keys in the source which don't match any field of the config.
+func unknownKeys(source []byte) (ValidationErrors, error) {
+	file, err := parser.ParseBytes(source, 0)
+	if err != nil {
+		return nil, err
+	}
+	var errs ValidationErrors
+	for _, doc := range file.Docs {
+		checkKeys(doc.Body, reflect.TypeOf(Config{}), "$", &errs)
+	}
+	return errs, nil
+}
+
+func checkKeys(node ast.Node, typ reflect.Type, path string, errs *ValidationErrors) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch n := node.(type) {
+	case nil:
+		return
+	case *ast.TagNode:
+		checkKeys(n.Value, typ, path, errs)
+		return
+	case *ast.AnchorNode:
+		checkKeys(n.Value, typ, path, errs)
+		return
+	case *ast.SequenceNode:
+		elemType := typ
+		switch {
+		case typ == stepConfigType:
+			// the list of plugin names or definitions.
+			elemType = pluginExecConfigType
+		case typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array:
+			elemType = typ.Elem()
+		default:
+			return
+		}
+		for idx, value := range n.Values {
+			checkKeys(value, elemType, fmt.Sprintf("%s[%d]", path, idx), errs)
+		}
+		return
+	}
+	values := mappingValues(node)
+	if values == nil {
+		return
+	}
+	switch typ.Kind() {
+	case reflect.Map:
+		for _, value := range values {
+			checkKeys(value.Value, typ.Elem(), path+"."+value.Key.GetToken().Value, errs)
+		}
+	case reflect.Struct:
+		fields := yamlFields(typ)
+		if typ == stepConfigType {
+			// the step definition or the plugin definition.
+			fields = yamlFields(stepDefinitionType)
+			for key, fieldType := range yamlFields(pluginExecConfigType) {
+				fields[key] = fieldType
+			}
+		}
+		for _, value := range values {
+			key := value.Key.GetToken()
+			fieldType, exists := fields[key.Value]
+			if !exists {
+				*errs = append(*errs, &ValidationError{
+					Path:    path,
+					Line:    key.Position.Line,
+					Column:  key.Position.Column,
+					Message: fmt.Sprintf("unknown key %q. available keys are %s", key.Value, strings.Join(sortedKeys(fields), ", ")),
+				})
+				continue
+			}
+			checkKeys(value.Value, fieldType, path+"."+key.Value, errs)
+		}
+	}
+}
+
+func mappingValues(node ast.Node) []*ast.MappingValueNode {
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		return n.Values
+	case *ast.MappingValueNode:
+		return []*ast.MappingValueNode{n}
+	}
+	return nil
+}
+
+// yamlFields returns the types of the fields by the yaml keys.
+func yamlFields(typ reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := yamlFieldName(field)
+		if name == "-" {
+			continue
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// yamlFieldName returns the key of the field in the config file.
+func yamlFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func sortedKeys(fields map[string]reflect.Type) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,323 @@
+package treport
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type SubmoduleMode string
+
+const (
+	// SubmoduleOpaque reports the submodules as they are ( default ).
+	// The snapshot doesn't have them, and the changes of them are the changes of files which have the commit hash of the submodule.
+	SubmoduleOpaque SubmoduleMode = "opaque"
+	// SubmodulePointer adds the submodules to the snapshot and reports the changes of them as SubmoduleUpdated.
+	SubmodulePointer SubmoduleMode = "pointer"
+	// SubmoduleFiles clones the submodules recursively, and the files of them are included in the snapshot and the changes.
+	// If the commit of the submodule isn't found, it's reported as SubmodulePointer does.
+	SubmoduleFiles SubmoduleMode = "files"
+)
+
+func (m SubmoduleMode) mode() SubmoduleMode {
+	if m == "" {
+		return SubmoduleOpaque
+	}
+	return m
+}
+
+func (m SubmoduleMode) valid() bool {
+	switch m {
+	case "", SubmoduleOpaque, SubmodulePointer, SubmoduleFiles:
+		return true
+	}
+	return false
+}
+
+// submoduleRepos caches the repositories of the submodules by the path.
+type submoduleRepos struct {
+	mu    sync.Mutex
+	repos map[string]*Repository
+}
+
+func newSubmoduleRepos() *submoduleRepos {
+	return &submoduleRepos{repos: map[string]*Repository{}}
+}
+
+func (s *submoduleRepos) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repos = map[string]*Repository{}
+}
+
+func isSubmoduleFile(f *File) bool {
+	return f != nil && f.Mode == FileMode(filemode.Submodule)
+}
+
+func (c *Change) isSubmodule() bool {
+	return isSubmoduleFile(c.From) || isSubmoduleFile(c.To)
+}
+
+// updateSubmodules clones or updates the submodules recursively to the commits recorded in the worktree.
+func (r *Repository) updateSubmodules(ctx context.Context, wt *git.Worktree) error {
+	subs, err := wt.Submodules()
+	if err != nil {
+		return err
+	}
+	if err := r.cfg.remote(ctx, func() error {
+		if err := subs.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+			Auth:              r.cfg.basicAuth(),
+		}); err != nil {
+			return gitError("update submodules", err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if r.submodules != nil {
+		r.submodules.reset()
+	}
+	return nil
+}
+
+// submodule returns the repository of the submodule at the path. It returns nil if the submodule isn't cloned.
+func (r *Repository) submodule(path string) (*Repository, error) {
+	if r.submodules == nil {
+		return nil, nil
+	}
+	r.submodules.mu.Lock()
+	defer r.submodules.mu.Unlock()
+	if repo, exists := r.submodules.repos[path]; exists {
+		return repo, nil
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	subs, err := wt.Submodules()
+	if err != nil {
+		return nil, err
+	}
+	var repo *Repository
+	for _, sub := range subs {
+		if sub.Config().Path != path {
+			continue
+		}
+		status, err := sub.Status()
+		if err != nil {
+			return nil, err
+		}
+		if status.Current.IsZero() {
+			// not initialized.
+			break
+		}
+		subRepo, err := sub.Repository()
+		if err != nil {
+			return nil, err
+		}
+		repo = &Repository{
+			Repository: subRepo,
+			cfg:        r.cfg,
+			submodules: newSubmoduleRepos(),
+			binaries:   newBinaryCache(),
+		}
+		break
+	}
+	r.submodules.repos[path] = repo
+	return repo, nil
+}
+
+// submoduleTree returns the tree of the commit of the submodule at the path.
+// It returns nil if the submodule or the commit isn't found.
+func (r *Repository) submoduleTree(path, hash string) (*Repository, *object.Tree, error) {
+	sub, err := r.submodule(path)
+	if err != nil || sub == nil {
+		return nil, nil, err
+	}
+	commit, err := sub.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		if err == plumbing.ErrObjectNotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	return sub, tree, nil
+}
+
+// snapshot converts the tree to the snapshot resolving the submodules by the mode of the config.
+func (r *Repository) snapshot(ctx context.Context, tree *object.Tree) (*Snapshot, error) {
+	snapshot, err := toSnapshot(ctx, tree)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.detectBinaries(ctx, snapshot.Entries); err != nil {
+		return nil, err
+	}
+	mode := r.cfg.Submodules.mode()
+	if mode == SubmoduleOpaque {
+		return snapshot, nil
+	}
+	subs, err := submoduleEntries(ctx, tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		if mode == SubmodulePointer {
+			snapshot.Entries = append(snapshot.Entries, sub)
+			continue
+		}
+		subRepo, subTree, err := r.submoduleTree(sub.Name, sub.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if subTree == nil {
+			snapshot.Entries = append(snapshot.Entries, sub)
+			continue
+		}
+		subSnapshot, err := subRepo.snapshot(ctx, subTree)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range subSnapshot.Entries {
+			entry.Name = sub.Name + "/" + entry.Name
+			snapshot.Entries = append(snapshot.Entries, entry)
+		}
+	}
+	return snapshot, nil
+}
+
+// submoduleEntries returns the submodules in the tree as files which have the commit hash of the submodule.
+func submoduleEntries(ctx context.Context, tree *object.Tree) ([]*File, error) {
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	subs := []*File{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name, entry, err := walker.Next()
+		if err != nil {
+			if err == io.EOF {
+				return subs, nil
+			}
+			return nil, err
+		}
+		if entry.Mode != filemode.Submodule {
+			continue
+		}
+		subs = append(subs, &File{Name: name, Mode: FileMode(entry.Mode), Hash: entry.Hash.String(), Type: SubmoduleFile})
+	}
+}
+
+// diffTree returns the changes between the trees resolving the submodules by the mode of the config.
+// If the repository has the diff cache, the changes are restored from it or stored to it.
+func (r *Repository) diffTree(ctx context.Context, from, to *object.Tree) (Changes, error) {
+	if r.diffs == nil {
+		return r.computeDiffTree(ctx, from, to)
+	}
+	key := diffCacheKey(r.cfg.Submodules.mode(), from, to)
+	changes, found, err := r.diffs.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return changes, nil
+	}
+	changes, err = r.computeDiffTree(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.diffs.put(key, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (r *Repository) computeDiffTree(ctx context.Context, from, to *object.Tree) (Changes, error) {
+	changes, err := diffTree(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		if err := r.detectBinaries(ctx, []*File{change.From, change.To}); err != nil {
+			return nil, err
+		}
+	}
+	mode := r.cfg.Submodules.mode()
+	if mode == SubmoduleOpaque {
+		return changes, nil
+	}
+	result := make(Changes, 0, len(changes))
+	for _, change := range changes {
+		if !change.isSubmodule() {
+			result = append(result, change)
+			continue
+		}
+		if mode == SubmoduleFiles {
+			subChanges, err := r.submoduleChanges(ctx, change)
+			if err != nil {
+				return nil, err
+			}
+			if subChanges != nil {
+				result = append(result, subChanges...)
+				continue
+			}
+		}
+		change.Action = SubmoduleUpdated
+		result = append(result, change)
+	}
+	return result, nil
+}
+
+// submoduleChanges returns the changes of the files in the submodule between the commits of the change.
+// It returns nil if the submodule or either commit isn't found.
+func (r *Repository) submoduleChanges(ctx context.Context, change *Change) (Changes, error) {
+	path := change.Path()
+	var (
+		subRepo          *Repository
+		fromTree, toTree *object.Tree
+	)
+	for _, f := range []struct {
+		file *File
+		tree **object.Tree
+	}{{change.From, &fromTree}, {change.To, &toTree}} {
+		if !isSubmoduleFile(f.file) {
+			continue
+		}
+		repo, tree, err := r.submoduleTree(path, f.file.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if tree == nil {
+			return nil, nil
+		}
+		subRepo = repo
+		*f.tree = tree
+	}
+	if subRepo == nil {
+		return nil, nil
+	}
+	subChanges, err := subRepo.diffTree(ctx, fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	for _, subChange := range subChanges {
+		for _, f := range []*File{subChange.From, subChange.To} {
+			if f != nil {
+				f.Name = path + "/" + f.Name
+			}
+		}
+	}
+	return subChanges, nil
+}
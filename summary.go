@@ -0,0 +1,203 @@
+package treport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ScanSummary is what the scan actually did. It's returned by Scanner.Summary after the scan.
+type ScanSummary struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Pipelines  []*PipelineSummary
+	// SlowestCommits is the commits which took the longest time to scan in descending order.
+	SlowestCommits []*CommitProfile
+}
+
+type PipelineSummary struct {
+	Name    string
+	Elapsed time.Duration
+	Err     error
+	Repos   []*RepositorySummary
+}
+
+type RepositorySummary struct {
+	Repo string
+	// Branch is the branch scanned as a separate stream. It's empty for the base branch.
+	Branch string
+	// BytesFetched is the growth of the git directory by the clone and the syncs.
+	// It's zero for the repositories in memory and the local repositories.
+	BytesFetched int64
+	Plugins      []*PluginSummary
+}
+
+type PluginSummary struct {
+	Name string
+	// Commits is the number of the visited commits including the ones restored from cache.
+	Commits   int
+	CacheHits int
+	Failures  int
+	Elapsed   time.Duration
+	// ScanTime, SerializeTime and CacheTime are the cumulative time of ScanTiming.
+	ScanTime      time.Duration
+	SerializeTime time.Duration
+	CacheTime     time.Duration
+}
+
+// Summary returns the summary of the last scan. It returns nil if nothing has been scanned.
+func (s *Scanner) Summary() *ScanSummary {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	return s.summary
+}
+
+func newScanSummary(pipelines []*Pipeline) *ScanSummary {
+	summary := &ScanSummary{StartedAt: time.Now()}
+	for _, pipeline := range pipelines {
+		pipelineSummary := &PipelineSummary{Name: pipeline.Config.Name}
+		for _, repo := range pipeline.Repos {
+			repoSummary := &RepositorySummary{Repo: repo.cfg.identity(), Branch: repo.scanBranch}
+			for _, step := range repo.Steps {
+				for _, plg := range step.Plugins {
+					repoSummary.Plugins = append(repoSummary.Plugins, &PluginSummary{Name: plg.Name})
+				}
+			}
+			pipelineSummary.Repos = append(pipelineSummary.Repos, repoSummary)
+		}
+		summary.Pipelines = append(summary.Pipelines, pipelineSummary)
+	}
+	return summary
+}
+
+func (s *ScanSummary) pipeline(name string) *PipelineSummary {
+	for _, pipeline := range s.Pipelines {
+		if pipeline.Name == name {
+			return pipeline
+		}
+	}
+	return nil
+}
+
+func (s *ScanSummary) plugin(ev *ProgressEvent) *PluginSummary {
+	pipeline := s.pipeline(ev.Pipeline)
+	if pipeline == nil {
+		return nil
+	}
+	for _, repo := range pipeline.Repos {
+		if repo.Repo != ev.Repo || repo.Branch != ev.Branch {
+			continue
+		}
+		for _, plg := range repo.Plugins {
+			if plg.Name == ev.Plugin {
+				return plg
+			}
+		}
+	}
+	return nil
+}
+
+// record counts the progress event. It's called with the lock of the progress.
+func (s *ScanSummary) record(ev *ProgressEvent) {
+	switch ev.Type {
+	case ProgressPipelineFinished:
+		if pipeline := s.pipeline(ev.Pipeline); pipeline != nil {
+			pipeline.Elapsed = ev.Time.Sub(s.StartedAt)
+			pipeline.Err = ev.Err
+		}
+	case ProgressCommitScanned:
+		if plg := s.plugin(ev); plg != nil {
+			plg.Commits++
+			if ev.CacheHit {
+				plg.CacheHits++
+			}
+			s.recordTiming(ev, plg)
+		}
+	case ProgressPluginFinished:
+		if plg := s.plugin(ev); plg != nil {
+			plg.Elapsed = ev.Elapsed
+			if ev.Err != nil {
+				plg.Failures++
+			}
+		}
+	}
+}
+
+// finish records the bytes fetched by the repositories of the pipelines.
+func (s *ScanSummary) finish(pipelines []*Pipeline) {
+	s.FinishedAt = time.Now()
+	for i, pipeline := range pipelines {
+		for j, repo := range pipeline.Repos {
+			s.Pipelines[i].Repos[j].BytesFetched = repo.bytesFetched()
+		}
+	}
+}
+
+func (s *Scanner) logSummary() {
+	summary := s.Summary()
+	for _, pipeline := range summary.Pipelines {
+		for _, repo := range pipeline.Repos {
+			for _, plg := range repo.Plugins {
+				s.log().Info("scan summary",
+					"pipeline", pipeline.Name, "repo", repo.Repo, "branch", repo.Branch, "plugin", plg.Name,
+					"commits", plg.Commits, "cacheHits", plg.CacheHits, "failures", plg.Failures,
+					"bytesFetched", repo.BytesFetched, "elapsed", plg.Elapsed,
+					"scanTime", plg.ScanTime, "serializeTime", plg.SerializeTime, "cacheTime", plg.CacheTime,
+				)
+			}
+		}
+	}
+	s.log().Info("scan finished", "elapsed", summary.FinishedAt.Sub(summary.StartedAt))
+}
+
+func (s *ScanSummary) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PIPELINE\tREPOSITORY\tPLUGIN\tCOMMITS\tCACHE HITS\tFAILURES\tFETCHED\tELAPSED")
+	for _, pipeline := range s.Pipelines {
+		for _, repo := range pipeline.Repos {
+			name := repoName(repo.Repo, repo.Branch)
+			for _, plg := range repo.Plugins {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n",
+					pipeline.Name, name, plg.Name, plg.Commits, plg.CacheHits, plg.Failures, repo.BytesFetched, plg.Elapsed,
+				)
+			}
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "total %s\n", s.FinishedAt.Sub(s.StartedAt))
+	return b.String()
+}
+
+// gitDirSize returns the total size of the files in the git directory of the repository.
+func gitDirSize(repoPath string) int64 {
+	return dirSize(gitDir(repoPath))
+}
+
+// gitDir returns the directory of the git objects of the repository.
+func gitDir(repoPath string) string {
+	dir := filepath.Join(repoPath, ".git")
+	if !existsPath(dir) {
+		// bare repository.
+		return repoPath
+	}
+	return dir
+}
+
+// dirSize returns the total size of the files under the directory.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,48 @@
+package treport
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/goccy/treport"
+
+// SetTracerProvider sets the provider of the spans of the pipelines, the repositories, the commits and the Scan calls of the plugins.
+// If it isn't set, the global provider registered by otel.SetTracerProvider is used, which doesn't record any spans by default.
+func (s *Scanner) SetTracerProvider(tp trace.TracerProvider) {
+	s.tracerProvider = tp
+}
+
+func (s *Scanner) tracer() trace.Tracer {
+	if s.tracerProvider != nil {
+		return s.tracerProvider.Tracer(tracerName)
+	}
+	return otel.Tracer(tracerName)
+}
+
+// startSpan starts the span by the provider of the span in ctx. The plugins don't know the scanner,
+// so the Scan call is recorded only if it's called in the span of the scanner.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+func startCommitSpan(ctx context.Context, plg *Plugin, scanctx *ScanContext) (context.Context, trace.Span) {
+	return startSpan(ctx, "treport.commit",
+		attribute.String("treport.commit", scanctx.Commit.Hash),
+		attribute.String("treport.plugin", plg.Name),
+	)
+}
+
+// endSpan records the error to the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
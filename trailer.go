@@ -0,0 +1,95 @@
+package treport
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+const (
+	SignedOffByTrailer  = "Signed-off-by"
+	CoAuthoredByTrailer = "Co-authored-by"
+	ReviewedByTrailer   = "Reviewed-by"
+)
+
+// Trailer is the "Key: value" line at the end of the commit message like Signed-off-by.
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// Identity is the name and the email of the person in the trailer.
+type Identity struct {
+	Name  string
+	Email string
+}
+
+var trailerMatcher = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*):\s*(.*)$`)
+
+// parseTrailers returns the trailers of the last paragraph of the message. As git interpret-trailers does, the paragraph
+// is the trailers only if all of the lines are trailers or their continuation lines, and the subject isn't the trailers.
+func parseTrailers(message string) []*Trailer {
+	paragraphs := strings.Split(strings.TrimSpace(strings.Replace(message, "\r\n", "\n", -1)), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+	trailers := []*Trailer{}
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(trailers) > 0 {
+			last := trailers[len(trailers)-1]
+			last.Value = strings.TrimSpace(last.Value + " " + strings.TrimSpace(line))
+			continue
+		}
+		matches := trailerMatcher.FindStringSubmatch(strings.TrimRight(line, " \t"))
+		if len(matches) != 3 {
+			return nil
+		}
+		trailers = append(trailers, &Trailer{Key: matches[1], Value: matches[2]})
+	}
+	return trailers
+}
+
+// Identities returns the persons of the trailers of the key, which is compared case-insensitively.
+// The value without the email like "Name <email>" is the name.
+func (c *Commit) Identities(key string) []*Identity {
+	identities := []*Identity{}
+	for _, trailer := range c.Trailers {
+		if !strings.EqualFold(trailer.Key, key) {
+			continue
+		}
+		if addr, err := mail.ParseAddress(trailer.Value); err == nil {
+			identities = append(identities, &Identity{Name: addr.Name, Email: addr.Address})
+			continue
+		}
+		identities = append(identities, &Identity{Name: trailer.Value})
+	}
+	return identities
+}
+
+// SignedOffBy returns the persons certifying the Developer Certificate of Origin by Signed-off-by.
+func (c *Commit) SignedOffBy() []*Identity {
+	return c.Identities(SignedOffByTrailer)
+}
+
+// CoAuthors returns the persons of Co-authored-by.
+func (c *Commit) CoAuthors() []*Identity {
+	return c.Identities(CoAuthoredByTrailer)
+}
+
+// ReviewedBy returns the persons of Reviewed-by.
+func (c *Commit) ReviewedBy() []*Identity {
+	return c.Identities(ReviewedByTrailer)
+}
+
+// SignedOffByAuthor reports whether the author signed off the commit, which is what the DCO check requires.
+func (c *Commit) SignedOffByAuthor() bool {
+	if c.Author == nil {
+		return false
+	}
+	for _, identity := range c.SignedOffBy() {
+		if strings.EqualFold(identity.Email, c.Author.Email) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package treport_test
+
+import (
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestCommitTrailers(t *testing.T) {
+	commit := &treport.Commit{
+		Author: &treport.Signature{Name: "Alice", Email: "alice@example.com"},
+		Trailers: treport.ParseTrailers(`Add scanner
+
+Signed-off-by: appears in the body: not a trailer
+
+Co-authored-by: Bob <bob@example.com>
+signed-off-by: Alice <ALICE@example.com>
+Reviewed-by: Carol
+  <carol@example.com>
+Reviewed-by: reviewers team
+`),
+	}
+	if len(commit.Trailers) != 4 {
+		t.Fatalf("unexpected trailers: %d", len(commit.Trailers))
+	}
+	if coAuthors := commit.CoAuthors(); len(coAuthors) != 1 || coAuthors[0].Name != "Bob" || coAuthors[0].Email != "bob@example.com" {
+		t.Fatalf("unexpected co-authors: %+v", coAuthors)
+	}
+	reviewers := commit.ReviewedBy()
+	if len(reviewers) != 2 || reviewers[0].Email != "carol@example.com" || reviewers[1].Name != "reviewers team" {
+		t.Fatalf("unexpected reviewers: %+v", reviewers)
+	}
+	if !commit.SignedOffByAuthor() {
+		t.Fatal("expected to be signed off by the author")
+	}
+	for _, message := range []string{
+		"Signed-off-by: Alice <alice@example.com>",
+		"Add scanner\n\nThis fixes the bug.\nSigned-off-by: Alice <alice@example.com>",
+	} {
+		if trailers := treport.ParseTrailers(message); len(trailers) != 0 {
+			t.Errorf("%q: unexpected trailers: %d", message, len(trailers))
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package treport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// TransportConfig is the HTTP(S) transport of the remote operations for the hosts behind the proxy
+// or with the certificates issued by the internal CA. It isn't applied to ssh.
+type TransportConfig struct {
+	// Proxy is the url of the proxy. The environment variables like HTTPS_PROXY are used by default.
+	Proxy string `yaml:"proxy"`
+	// CABundle is the path to the PEM encoded certificates trusted in addition to the system ones.
+	CABundle string `yaml:"caBundle"`
+	// InsecureSkipVerify doesn't verify the certificate of the server.
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+}
+
+func (c *TransportConfig) httpClient() (*http.Client, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse proxy %s", c.Proxy)
+		}
+		tr.Proxy = http.ProxyURL(proxy)
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CABundle != "" {
+		bundle, err := ioutil.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read caBundle %s", c.CABundle)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates are found in caBundle %s", c.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	tr.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: tr}, nil
+}
+
+// transportRegistry has the HTTP clients of the transport configs keyed by the repositories and their hosts.
+// The go-git transport of http and https is replaced by the one looking up the client by the endpoint,
+// because the transport of go-git is global.
+type transportRegistry struct {
+	mu      sync.RWMutex
+	clients map[*TransportConfig]*http.Client
+	keys    map[string]*http.Client
+	install sync.Once
+}
+
+var transports = &transportRegistry{
+	clients: map[*TransportConfig]*http.Client{},
+	keys:    map[string]*http.Client{},
+}
+
+// register makes the remote operations of the repository and the requests to its host use the transport config.
+func (r *transportRegistry) register(repo string, cfg *TransportConfig) error {
+	r.install.Do(func() {
+		tr := &registryTransport{registry: r}
+		client.InstallProtocol("http", tr)
+		client.InstallProtocol("https", tr)
+	})
+	u, err := ParseRepositoryURL(repo)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, exists := r.clients[cfg]
+	if !exists {
+		c, err = cfg.httpClient()
+		if err != nil {
+			return err
+		}
+		r.clients[cfg] = c
+	}
+	r.keys[u.DiskPath()] = c
+	if _, exists := r.keys[u.Host]; !exists {
+		r.keys[u.Host] = c
+	}
+	return nil
+}
+
+// lookup returns the client of the repository, or the client of the host. It returns nil if neither is registered.
+func (r *transportRegistry) lookup(rawurl string) *http.Client {
+	u, err := ParseRepositoryURL(rawurl)
+	if err != nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, exists := r.keys[u.DiskPath()]; exists {
+		return c
+	}
+	return r.keys[u.Host]
+}
+
+// client returns the client of the url for the requests except git.
+func (r *transportRegistry) client(u *url.URL) *http.Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, exists := r.keys[u.Hostname()]; exists {
+		return c
+	}
+	return http.DefaultClient
+}
+
+type registryTransport struct {
+	registry *transportRegistry
+}
+
+func (t *registryTransport) transport(ep *transport.Endpoint) transport.Transport {
+	if c := t.registry.lookup(ep.String()); c != nil {
+		return githttp.NewClient(c)
+	}
+	return githttp.DefaultClient
+}
+
+func (t *registryTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	return t.transport(ep).NewUploadPackSession(ep, auth)
+}
+
+func (t *registryTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	return t.transport(ep).NewReceivePackSession(ep, auth)
+}
@@ -0,0 +1,304 @@
+// Package treporttest generates synthetic git repositories and runs the scanners on them for the tests and the benchmarks of treport and its plugins.
+package treporttest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// RepositoryOptions is the shape of the generated repository. The zero values are replaced with the defaults.
+type RepositoryOptions struct {
+	// Commits is the number of the commits on the first-parent chain of master. 100 by default.
+	Commits int
+	// Files is the number of the files of the root commit. 100 by default.
+	Files int
+	// FilesPerDir is the number of the files in a directory. 100 by default.
+	FilesPerDir int
+	// ChangesPerCommit is the number of the files added, modified or deleted by each commit. 10 by default.
+	ChangesPerCommit int
+	// FileSize is the bytes of each file. 1024 by default.
+	FileSize int
+	// Seed makes the contents of the repository deterministic.
+	Seed int64
+}
+
+func (opt *RepositoryOptions) withDefaults() RepositoryOptions {
+	v := RepositoryOptions{}
+	if opt != nil {
+		v = *opt
+	}
+	if v.Commits <= 0 {
+		v.Commits = 100
+	}
+	if v.Files <= 0 {
+		v.Files = 100
+	}
+	if v.FilesPerDir <= 0 {
+		v.FilesPerDir = 100
+	}
+	if v.ChangesPerCommit <= 0 {
+		v.ChangesPerCommit = 10
+	}
+	if v.FileSize <= 0 {
+		v.FileSize = 1024
+	}
+	return v
+}
+
+// GenerateRepository creates the bare repository at dir.
+// The objects are generated in memory and written as a packfile like a cloned repository, without the worktree.
+func GenerateRepository(dir string, opt *RepositoryOptions) (*git.Repository, error) {
+	o := opt.withDefaults()
+	repo, err := git.PlainInit(dir, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to init repository %s", dir)
+	}
+	g := &generator{
+		opt:       o,
+		storer:    memory.NewStorage(),
+		rand:      rand.New(rand.NewSource(o.Seed)),
+		dirs:      map[string]map[string]plumbing.Hash{},
+		dirTree:   map[string]plumbing.Hash{},
+		dirty:     map[string]struct{}{},
+		committed: map[plumbing.Hash]struct{}{},
+	}
+	for i := 0; i < o.Files; i++ {
+		if err := g.writeFile(g.newFileName()); err != nil {
+			return nil, err
+		}
+	}
+	var parent plumbing.Hash
+	for i := 0; i < o.Commits; i++ {
+		if i > 0 {
+			if err := g.change(); err != nil {
+				return nil, err
+			}
+		}
+		hash, err := g.commit(fmt.Sprintf("commit %d", i), parent, time.Unix(int64(i)*60, 0))
+		if err != nil {
+			return nil, err
+		}
+		parent = hash
+	}
+	if err := g.pack(repo.Storer); err != nil {
+		return nil, errors.Wrapf(err, "failed to write packfile")
+	}
+	ref := plumbing.NewHashReference(plumbing.Master, parent)
+	if err := repo.Storer.SetReference(ref); err != nil {
+		return nil, errors.Wrapf(err, "failed to update %s", plumbing.Master)
+	}
+	return repo, nil
+}
+
+type generator struct {
+	opt    RepositoryOptions
+	storer *memory.Storage
+	rand   *rand.Rand
+	hashes []plumbing.Hash
+	// committed is the blobs in the trees. The blobs overwritten before the commit aren't packed.
+	committed map[plumbing.Hash]struct{}
+	// dirs is the blob hashes of the files by the directory.
+	dirs map[string]map[string]plumbing.Hash
+	// dirTree is the tree hash of the directory which isn't changed since the last commit.
+	dirTree map[string]plumbing.Hash
+	dirty   map[string]struct{}
+	files   []string
+	seq     int
+}
+
+func (g *generator) newFileName() string {
+	name := fmt.Sprintf("dir%05d/file%07d.txt", g.seq/g.opt.FilesPerDir, g.seq)
+	g.seq++
+	return name
+}
+
+func splitPath(path string) (string, string) {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '/' {
+			return path[:i], path[i+1:]
+		}
+	}
+	return "", path
+}
+
+// writeFile writes the random contents to the file. It adds the file if it doesn't exist.
+func (g *generator) writeFile(path string) error {
+	content := make([]byte, g.opt.FileSize)
+	g.rand.Read(content)
+	hash, err := g.writeBlob(content)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write blob of %s", path)
+	}
+	dir, name := splitPath(path)
+	files, exists := g.dirs[dir]
+	if !exists {
+		files = map[string]plumbing.Hash{}
+		g.dirs[dir] = files
+	}
+	if _, exists := files[name]; !exists {
+		g.files = append(g.files, path)
+	}
+	files[name] = hash
+	g.dirty[dir] = struct{}{}
+	return nil
+}
+
+func (g *generator) deleteFile(idx int) {
+	path := g.files[idx]
+	g.files[idx] = g.files[len(g.files)-1]
+	g.files = g.files[:len(g.files)-1]
+	dir, name := splitPath(path)
+	delete(g.dirs[dir], name)
+	if len(g.dirs[dir]) == 0 {
+		delete(g.dirs, dir)
+		delete(g.dirTree, dir)
+	}
+	g.dirty[dir] = struct{}{}
+}
+
+// change modifies the files mostly, and adds or deletes some of them.
+func (g *generator) change() error {
+	for i := 0; i < g.opt.ChangesPerCommit; i++ {
+		switch n := g.rand.Intn(10); {
+		case n == 0 || len(g.files) == 0:
+			if err := g.writeFile(g.newFileName()); err != nil {
+				return err
+			}
+		case n == 1 && len(g.files) > 1:
+			g.deleteFile(g.rand.Intn(len(g.files)))
+		default:
+			if err := g.writeFile(g.files[g.rand.Intn(len(g.files))]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *generator) commit(msg string, parent plumbing.Hash, when time.Time) (plumbing.Hash, error) {
+	for dir := range g.dirty {
+		files, exists := g.dirs[dir]
+		if !exists {
+			continue
+		}
+		entries := make([]object.TreeEntry, 0, len(files))
+		for name, hash := range files {
+			entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: hash})
+			g.committed[hash] = struct{}{}
+		}
+		hash, err := g.writeTree(entries)
+		if err != nil {
+			return plumbing.ZeroHash, errors.Wrapf(err, "failed to write tree of %s", dir)
+		}
+		g.dirTree[dir] = hash
+	}
+	g.dirty = map[string]struct{}{}
+	entries := make([]object.TreeEntry, 0, len(g.dirTree))
+	for dir, hash := range g.dirTree {
+		entries = append(entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash})
+	}
+	treeHash, err := g.writeTree(entries)
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "failed to write root tree")
+	}
+	sig := object.Signature{Name: "treport", Email: "treport@example.com", When: when}
+	commit := &object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   msg,
+		TreeHash:  treeHash,
+	}
+	if !parent.IsZero() {
+		commit.ParentHashes = []plumbing.Hash{parent}
+	}
+	hash, err := g.writeEncoded(commit)
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "failed to write commit %q", msg)
+	}
+	return hash, nil
+}
+
+// writeTree writes the tree of the entries. The names are unique in the tree and don't contain '/',
+// so the order of the name is the order of git.
+func (g *generator) writeTree(entries []object.TreeEntry) (plumbing.Hash, error) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return g.writeEncoded(&object.Tree{Entries: entries})
+}
+
+func (g *generator) writeEncoded(o interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	obj := g.storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return g.setObject(obj)
+}
+
+func (g *generator) writeBlob(content []byte) (plumbing.Hash, error) {
+	obj := g.storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return g.setObject(obj)
+}
+
+func (g *generator) setObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	if _, err := g.storer.EncodedObject(plumbing.AnyObject, obj.Hash()); err == nil {
+		return obj.Hash(), nil
+	}
+	hash, err := g.storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	g.hashes = append(g.hashes, hash)
+	return hash, nil
+}
+
+// pack writes all generated objects to the storage of the repository as a packfile.
+func (g *generator) pack(s storage.Storer) error {
+	packStorer, ok := s.(storer.PackfileWriter)
+	if !ok {
+		return fmt.Errorf("%T doesn't support packfile", s)
+	}
+	hashes := make([]plumbing.Hash, 0, len(g.hashes))
+	for _, hash := range g.hashes {
+		obj, err := g.storer.EncodedObject(plumbing.AnyObject, hash)
+		if err != nil {
+			return err
+		}
+		if _, exists := g.committed[hash]; exists || obj.Type() != plumbing.BlobObject {
+			hashes = append(hashes, hash)
+		}
+	}
+	w, err := packStorer.PackfileWriter()
+	if err != nil {
+		return err
+	}
+	if _, err := packfile.NewEncoder(w, g.storer, false).Encode(hashes, 10); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,21 @@
+package treporttest
+
+import (
+	"context"
+
+	"github.com/goccy/treport"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// RunScanner generates the repository at dir and scans all of its commits with the scanner in this process.
+// It's the harness for the tests of the plugins, which check the results without building the binary.
+func RunScanner(ctx context.Context, dir string, opt *RepositoryOptions, scanner treport.GRPCScanner) ([]*treport.ScanResult, error) {
+	if _, err := GenerateRepository(dir, opt); err != nil {
+		return nil, err
+	}
+	repo, err := treport.NewRepository(ctx, dir, &treport.RepositoryConfig{Path: dir})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open repository %s", dir)
+	}
+	return treport.RunScanner(ctx, repo, scanner, &treport.WalkOptions{IncludeRoot: true})
+}
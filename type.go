@@ -3,25 +3,254 @@ package treport
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/badger/v2"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/goccy/treport/internal/errors"
 	treportproto "github.com/goccy/treport/proto"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/protobuf/proto"
 )
 
 type ScanContext struct {
 	context.Context
-	Commit       *Commit
-	Snapshot     *Snapshot
-	Changes      Changes
-	Repository   *Repository
+	Commit     *Commit
+	Snapshot   *Snapshot
+	Changes    Changes
+	Repository *Repository
+	// PullRequest is the pull request scanned as the unit by the pullRequest strategy.
+	PullRequest *PullRequest
+	// Merge is the ancestry of the merge commit, so the changes are attributed to the merged branch.
+	// It's nil for the commits which have a parent.
+	Merge *MergeInfo
+	// Branch is the branch scanned as a separate stream by branches of the repository config.
+	// It's empty for the base branch.
+	Branch    string
+	DiffMode  DiffMode
+	TopoIndex int64
+	// Truncated reports that Snapshot or Changes has only the largest entries because of the limits of the pipeline.
+	// TotalSnapshotEntries and TotalChanges have the number of entries before truncation.
+	Truncated            bool
+	TotalSnapshotEntries int64
+	TotalChanges         int64
+	// WorktreePath is the directory which has the files of the commit, so the plugin can run the tools like go vet.
+	// It's set only to the plugin enabling checkout, and removed after the scan of the commit.
+	WorktreePath string
+	// Data is the results of the plugins for the commit. The plugin is passed only the results of its dependencies,
+	// which have completed before it, so the data doesn't depend on the order the plugins of a step finish.
 	Data         map[string]*treportproto.ScanResponse
 	pluginToType map[string]string
+	// dataMu guards Data and pluginToType, which the plugins sharing the context store their results to concurrently.
+	dataMu sync.Mutex
+	// previous is the result of the scanning plugin for the previous commit of the walk.
+	previous *treportproto.ScanResponse
+	// series is the results of the upstream plugins for all commits passed to the plugin of the aggregate stage.
+	series map[string]*treportproto.Series
+	// files is the entries of the snapshot streamed to the plugin implementing SnapshotStreamer.
+	files          *snapshotStream
+	preparedCommit string
+	// limitErr is the error of the limits for preparedCommit.
+	limitErr error
+}
+
+func (c *ScanContext) pluginResponse(pluginName string) (*treportproto.ScanResponse, bool) {
+	c.dataMu.Lock()
+	defer c.dataMu.Unlock()
+	typ, exists := c.pluginToType[pluginName]
+	if !exists {
+		return nil, false
+	}
+	res, exists := c.Data[typ]
+	return res, exists
+}
+
+// storeResult merges the result of the plugin to the context.
+// The repository of the context is set to the result, because the cached results of older versions don't have it.
+func (c *ScanContext) storeResult(pluginName string, result *treportproto.ScanResponse) {
+	c.dataMu.Lock()
+	defer c.dataMu.Unlock()
+	if c.Repository != nil {
+		result.RepositoryId = c.Repository.ID
+		if c.Repository.cfg != nil {
+			result.Repository = c.Repository.cfg.identity()
+		}
+	}
+	// the context may be constructed by the caller of Client.Scan without the maps.
+	if c.Data == nil {
+		c.Data = map[string]*treportproto.ScanResponse{}
+	}
+	if c.pluginToType == nil {
+		c.pluginToType = map[string]string{}
+	}
+	c.Data[result.Name] = result
+	if _, exists := c.pluginToType[pluginName]; !exists {
+		c.pluginToType[pluginName] = result.Name
+	}
+}
+
+// upstream returns the copy of the results of the plugins, which isn't changed by the plugins storing their results later.
+// If plugins is nil, all results are returned.
+func (c *ScanContext) upstream(plugins []string) (map[string]*treportproto.ScanResponse, map[string]string) {
+	c.dataMu.Lock()
+	defer c.dataMu.Unlock()
+	data := map[string]*treportproto.ScanResponse{}
+	pluginToType := map[string]string{}
+	if plugins == nil {
+		for name, res := range c.Data {
+			data[name] = res
+		}
+		for plugin, typ := range c.pluginToType {
+			pluginToType[plugin] = typ
+		}
+		return data, pluginToType
+	}
+	for _, plugin := range plugins {
+		typ, exists := c.pluginToType[plugin]
+		if !exists {
+			continue
+		}
+		if res, exists := c.Data[typ]; exists {
+			data[typ] = res
+			pluginToType[plugin] = typ
+		}
+	}
+	return data, pluginToType
+}
+
+// prepare removes the paths excluded by the ignore files of the repository, and applies the limits.
+// The walker reuses the context for each commit, so it's applied once per commit.
+func (c *ScanContext) prepare(ignore *IgnoreConfig, limits *LimitsConfig) error {
+	if c.Commit != nil && c.preparedCommit == c.Commit.Hash {
+		return c.limitErr
+	}
+	if c.Repository != nil && c.Repository.Repository != nil && c.Commit != nil && (c.Snapshot != nil || len(c.Changes) > 0) {
+		ignorer, err := newPathIgnorer(c.Repository, c.Commit.Hash, ignore)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read ignore files of %s", c.Commit.Hash)
+		}
+		if ignorer != nil {
+			ignorer.apply(c)
+		}
+	}
+	if c.Commit != nil {
+		c.preparedCommit = c.Commit.Hash
+	}
+	c.limitErr = c.limit(limits)
+	return c.limitErr
+}
+
+// limit downgrades the context to the largest entries if it exceeds the limits.
+// If onExceed of the limits is skip or fail, it returns the error instead.
+func (c *ScanContext) limit(limits *LimitsConfig) error {
+	c.Truncated = false
+	if c.Snapshot != nil {
+		c.TotalSnapshotEntries = int64(len(c.Snapshot.Entries))
+	}
+	c.TotalChanges = int64(len(c.Changes))
+	if limits == nil {
+		return nil
+	}
+	if limits.OnExceed == LimitSkip || limits.OnExceed == LimitFail {
+		if reason := c.exceededLimit(limits); reason != "" {
+			var commit string
+			if c.Commit != nil {
+				commit = c.Commit.Hash
+			}
+			err := ErrLimitExceeded(commit, reason)
+			if limits.OnExceed == LimitSkip {
+				return ErrSkipCommit(err)
+			}
+			return err
+		}
+		return nil
+	}
+	if limits.MaxFileSize > 0 {
+		if c.Snapshot != nil {
+			if entries := smallFiles(c.Snapshot.Entries, limits.MaxFileSize); len(entries) != len(c.Snapshot.Entries) {
+				c.Snapshot = &Snapshot{Hash: c.Snapshot.Hash, Entries: entries}
+				c.Truncated = true
+			}
+		}
+		if changes := c.Changes.smallerThan(limits.MaxFileSize); len(changes) != len(c.Changes) {
+			c.Changes = changes
+			c.Truncated = true
+		}
+	}
+	if c.Snapshot != nil && limits.MaxSnapshotEntries > 0 && len(c.Snapshot.Entries) > limits.MaxSnapshotEntries {
+		c.Snapshot = &Snapshot{
+			Hash:    c.Snapshot.Hash,
+			Entries: largestFiles(c.Snapshot.Entries, limits.MaxSnapshotEntries),
+		}
+		c.Truncated = true
+	}
+	if limits.MaxChanges > 0 && len(c.Changes) > limits.MaxChanges {
+		c.Changes = c.Changes.largest(limits.MaxChanges)
+		c.Truncated = true
+	}
+	return nil
+}
+
+// exceededLimit returns the reason why the context exceeds the limits, or empty string if it doesn't.
+func (c *ScanContext) exceededLimit(limits *LimitsConfig) string {
+	if c.Snapshot != nil && limits.MaxSnapshotEntries > 0 && len(c.Snapshot.Entries) > limits.MaxSnapshotEntries {
+		return fmt.Sprintf("%d snapshot entries exceed maxSnapshotEntries %d", len(c.Snapshot.Entries), limits.MaxSnapshotEntries)
+	}
+	if limits.MaxChanges > 0 && len(c.Changes) > limits.MaxChanges {
+		return fmt.Sprintf("%d changes exceed maxChanges %d", len(c.Changes), limits.MaxChanges)
+	}
+	if limits.MaxFileSize <= 0 {
+		return ""
+	}
+	if c.Snapshot != nil {
+		for _, file := range c.Snapshot.Entries {
+			if file.Size > limits.MaxFileSize {
+				return fmt.Sprintf("%s of %d bytes exceeds maxFileSize %d", file.Name, file.Size, limits.MaxFileSize)
+			}
+		}
+	}
+	for _, change := range c.Changes {
+		if size := change.size(); size > limits.MaxFileSize {
+			return fmt.Sprintf("%s of %d bytes exceeds maxFileSize %d", change.Path(), size, limits.MaxFileSize)
+		}
+	}
+	return ""
+}
+
+// smallFiles returns the files which aren't larger than maxSize.
+func smallFiles(files []*File, maxSize int64) []*File {
+	result := make([]*File, 0, len(files))
+	for _, file := range files {
+		if file.Size <= maxSize {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// largestFiles returns top n files by size in the original order.
+func largestFiles(files []*File, n int) []*File {
+	indexes := make([]int, len(files))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return files[indexes[i]].Size > files[indexes[j]].Size
+	})
+	indexes = indexes[:n]
+	sort.Ints(indexes)
+	result := make([]*File, 0, n)
+	for _, idx := range indexes {
+		result = append(result, files[idx])
+	}
+	return result
 }
 
 type ActionType int
@@ -34,6 +263,8 @@ func (t ActionType) String() string {
 		return "Added"
 	case Updated:
 		return "Updated"
+	case SubmoduleUpdated:
+		return "SubmoduleUpdated"
 	default:
 		return "Updated"
 	}
@@ -43,6 +274,8 @@ const (
 	Deleted ActionType = iota
 	Added
 	Updated
+	// SubmoduleUpdated is the change of the commit of the submodule reported by SubmodulePointer.
+	SubmoduleUpdated
 )
 
 type Changes []*Change
@@ -51,6 +284,62 @@ type Change struct {
 	From   *File
 	To     *File
 	Action ActionType
+	// ModeChanged reports whether the mode of the updated file is changed like 100644 to 100755.
+	// OldMode and NewMode are the modes of From and To, so the plugins can check them without reading the files.
+	ModeChanged bool
+	OldMode     FileMode
+	NewMode     FileMode
+}
+
+// largest returns top n changes by size of the changed file in the original order.
+func (c Changes) largest(n int) Changes {
+	indexes := make([]int, len(c))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return c[indexes[i]].size() > c[indexes[j]].size()
+	})
+	indexes = indexes[:n]
+	sort.Ints(indexes)
+	result := make(Changes, 0, n)
+	for _, idx := range indexes {
+		result = append(result, c[idx])
+	}
+	return result
+}
+
+// smallerThan returns the changes whose files aren't larger than maxSize.
+func (c Changes) smallerThan(maxSize int64) Changes {
+	result := make(Changes, 0, len(c))
+	for _, change := range c {
+		if change.size() <= maxSize {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+func (c *Change) size() int64 {
+	var size int64
+	if c.From != nil {
+		size = c.From.Size
+	}
+	if c.To != nil && c.To.Size > size {
+		size = c.To.Size
+	}
+	return size
+}
+
+// Path returns the path of the changed file.
+func (c *Change) Path() string {
+	if c.To != nil {
+		return c.To.Name
+	}
+	if c.From != nil {
+		return c.From.Name
+	}
+	return ""
 }
 
 type FileMode uint32
@@ -60,11 +349,73 @@ type File struct {
 	Mode FileMode
 	Size int64
 	Hash string
+	Type FileType
+	// IsBinary reports whether the content of the regular file is binary. It's always false for the symlinks and the submodules.
+	IsBinary bool
 }
 
+// IsExecutable reports whether the file has the executable bit.
+func (f *File) IsExecutable() bool {
+	return f.Mode == FileMode(filemode.Executable)
+}
+
+type FileType int
+
+func (t FileType) String() string {
+	switch t {
+	case RegularFile:
+		return "Regular"
+	case SymlinkFile:
+		return "Symlink"
+	case SubmoduleFile:
+		return "Submodule"
+	default:
+		return "Regular"
+	}
+}
+
+const (
+	RegularFile FileType = iota
+	// SymlinkFile is the symbolic link. The content of it is the path of the target.
+	SymlinkFile
+	// SubmoduleFile is the submodule. The hash of it is the commit in the submodule.
+	SubmoduleFile
+)
+
 type Snapshot struct {
 	Hash    string
 	Entries []*File
+	// Dirs are the directories of Entries sorted by the name. They are set only for the plugins enabling dirs.
+	Dirs []*Dir
+}
+
+// Dir is the directory of the snapshot. Size and Files are the totals of the entries under it including the subdirectories.
+type Dir struct {
+	Name  string
+	Size  int64
+	Files int64
+}
+
+// dirs aggregates the entries by their directories. The entries at the root aren't counted by any directory.
+func (s *Snapshot) dirs() []*Dir {
+	dirs := map[string]*Dir{}
+	for _, entry := range s.Entries {
+		for dir := path.Dir(entry.Name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			d, exists := dirs[dir]
+			if !exists {
+				d = &Dir{Name: dir}
+				dirs[dir] = d
+			}
+			d.Size += entry.Size
+			d.Files++
+		}
+	}
+	list := make([]*Dir, 0, len(dirs))
+	for _, d := range dirs {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
 }
 
 type Commit struct {
@@ -75,6 +426,28 @@ type Commit struct {
 	Message      string
 	TreeHash     string
 	ParentHashes []string
+	// SignatureValid reports whether PGPSignature is verified by the keyring of the repository config.
+	// It's always false if the keyring isn't configured.
+	SignatureValid bool
+	// SignerKeyID is the key id of the verified signer.
+	SignerKeyID string
+	// PullRequestNumber is the number of the pull request which merged the commit. It's written by GitHub or GitLab
+	// in the message of the merge commit or the subject of the squashed commit, or found by linkCommits of the pull request config.
+	PullRequestNumber int64
+	// References are the issue and pull request numbers referenced by the message like #123 or GH-123 except PullRequestNumber.
+	References []int64
+	// Trailers are the trailers at the end of the message like Signed-off-by and Co-authored-by.
+	Trailers []*Trailer
+}
+
+// MergeInfo is the parents of the merge commit. The first parent is the mainline the head is merged into
+// as git log --first-parent follows, and the other parents of the octopus merge are in Commit.ParentHashes.
+type MergeInfo struct {
+	Mainline string
+	Head     string
+	// MergeBase is the commit where the head forked from the mainline. It's empty if they have no common ancestor,
+	// or the parents are missing in the shallow history.
+	MergeBase string
 }
 
 type Signature struct {
@@ -92,6 +465,15 @@ type Pipeline struct {
 	CachePath string
 }
 
+// failures returns the failures of all repositories continued by onError.
+func (p *Pipeline) failures() ScanErrors {
+	var errs ScanErrors
+	for _, repo := range p.Repos {
+		errs = append(errs, repo.results.failures()...)
+	}
+	return errs
+}
+
 func (p *Pipeline) Cleanup() {
 	for _, repo := range p.Repos {
 		repo.Cleanup()
@@ -102,18 +484,92 @@ type PipelineRepository struct {
 	*Repository
 	Steps     []*Step
 	CachePath string
+	results   resultCollector
+	// clonePath is the clone protected from the eviction by the disk quota until Cleanup.
+	clonePath string
+}
+
+// needsWorktree reports whether a plugin of the steps works on the files on the disk by checkout.
+// The base branch is checked out to the worktree only for them.
+func (r *PipelineRepository) needsWorktree() bool {
+	for _, step := range r.Steps {
+		for _, plg := range step.Plugins {
+			if plg.Checkout {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (r *PipelineRepository) Cleanup() {
 	for _, step := range r.Steps {
 		step.Cleanup()
 	}
+	if r.diffs != nil {
+		r.diffs.Close()
+		r.diffs = nil
+	}
+	if r.blobs != nil {
+		r.blobs.Close()
+		r.blobs = nil
+	}
+	if r.clonePath != "" {
+		releaseClone(r.clonePath)
+		r.clonePath = ""
+	}
 }
 
 type Step struct {
 	Idx       int
 	Plugins   []*Plugin
 	CachePath string
+	// Deps are the steps which must finish before the step.
+	Deps []*Step
+}
+
+// resolveDependencies sets the dependencies of each step by the indexes returned by PipelineConfig.StepDependencies,
+// and passes the plugins of the steps it depends on directly or indirectly to the plugins of the step.
+func (r *PipelineRepository) resolveDependencies(deps [][]int) {
+	for idx, step := range r.Steps {
+		for _, dep := range deps[idx] {
+			step.Deps = append(step.Deps, r.Steps[dep])
+		}
+	}
+	for _, step := range r.Steps {
+		depPlugins := step.dependencyPlugins()
+		for _, plg := range step.Plugins {
+			plg.deps = depPlugins
+		}
+	}
+}
+
+// dependencyPlugins returns the plugins of all steps the step depends on ordered by the step.
+func (s *Step) dependencyPlugins() []*Plugin {
+	visited := map[int]*Step{}
+	var visit func(*Step)
+	visit = func(step *Step) {
+		for _, dep := range step.Deps {
+			if _, exists := visited[dep.Idx]; exists {
+				continue
+			}
+			visited[dep.Idx] = dep
+			visit(dep)
+		}
+	}
+	visit(s)
+	steps := make([]*Step, 0, len(visited))
+	for _, step := range visited {
+		steps = append(steps, step)
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].Idx < steps[j].Idx
+	})
+	var plugins []*Plugin
+	for _, step := range steps {
+		plugins = append(plugins, step.Plugins...)
+	}
+	return plugins
 }
 
 func (s *Step) Cleanup() {
@@ -138,65 +594,394 @@ func (s *Step) PluginIDs() []string {
 	return ids
 }
 
+func (s *Step) legacyPluginIDs() []string {
+	ids := make([]string, 0, len(s.Plugins))
+	for _, plg := range s.Plugins {
+		ids = append(ids, plg.Repo.legacyID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 type PluginID string
 
+// Plugin is the plugin of the step scanning the commits of the repository.
+// Setup starts it before the scan, and Cleanup shuts it down after the scan. Both are safe to call more than once.
 type Plugin struct {
-	Name      string
-	Args      []string
-	Repo      *Repository
-	CachePath string
-	Client    *Client
-	cache     *badger.DB
-	setup     func([]string) error
+	Name         string
+	Args         []string
+	Repo         *Repository
+	CachePath    string
+	Client       *Client
+	SchemaPolicy SchemaPolicy
+	OnError      OnError
+	Retry        *RetryConfig
+	Limits       *LimitsConfig
+	Ignore       *IgnoreConfig
+	Env          *PluginEnvConfig
+	Sandbox      *SandboxConfig
+	// Checkout writes the files of each commit to the temporary directory passed as ScanContext.WorktreePath.
+	Checkout bool
+	// SkipEmptyChanges doesn't pass the walked commits which have no changes to the plugin.
+	SkipEmptyChanges bool
+	// Dirs passes the directories of the snapshot to the plugin.
+	Dirs bool
+	// Quarantine skips the commits which consistently fail the plugin.
+	Quarantine *QuarantineConfig
+	// Stage is the stage of the step. The plugin of the aggregate stage runs once per repository after the walk.
+	Stage StepStage
+	// scratchPath is the directory of the temporary files of the run. The system one is used if it's empty.
+	scratchPath string
+	cache       *badger.DB
+	cacheMu     sync.Mutex
+	cacheCfg    *CacheConfig
+	// pending are the results not written to the cache DB yet, and pendingMark is the high-water mark written with them.
+	pending     map[string][]byte
+	pendingMark string
+	// savedTypes are the result types whose descriptors are written with the cache.
+	savedTypes map[string]struct{}
+	// warmPath is the cache of the pipeline named by cacheFrom. The results missing in the cache are read from it.
+	// warm is the plugin of the same run which opens it, or warmDB is opened as read-only.
+	warmPath   string
+	warm       *Plugin
+	warmDB     *badger.DB
+	warmOpened bool
+	setup      func([]string) error
+	// quarantine is loaded by the first commit checked by Quarantine.
+	quarantine   *quarantine
+	quarantineMu sync.Mutex
+	// deps are the plugins whose results of the same commit are passed to the plugin.
+	deps []*Plugin
+	// processes shares the process of the client with the other plugins of the run. processKey identifies the process.
+	processes  *pluginProcesses
+	processKey string
+	// cacheClosed reports that the cache is closed by Cleanup, so it isn't opened again.
+	cacheClosed bool
+	// clonePath is the clone of the plugin repository protected from the eviction by the disk quota until Cleanup.
+	clonePath string
+	// lifecycle guards Setup and Cleanup, so the plugin acquires one process at most.
+	lifecycle   pluginLifecycle
+	lifecycleMu sync.Mutex
 }
 
 func (p *Plugin) DeleteCache() error {
 	if err := os.RemoveAll(p.CachePath); err != nil {
 		return errors.Wrapf(err, "failed to remove step cache %s", p.CachePath)
 	}
+	if err := os.RemoveAll(p.highWaterMarkPath()); err != nil {
+		return errors.Wrapf(err, "failed to remove high-water mark %s", p.highWaterMarkPath())
+	}
+	if err := os.RemoveAll(p.quarantinePath()); err != nil {
+		return errors.Wrapf(err, "failed to remove quarantine %s", p.quarantinePath())
+	}
+	if err := os.RemoveAll(p.descriptorsPath()); err != nil {
+		return errors.Wrapf(err, "failed to remove descriptors %s", p.descriptorsPath())
+	}
 	return nil
 }
 
-func (p *Plugin) Cleanup() {
-	p.Client.Stop()
+// descriptorsPath is the directory of the descriptors of the result types which aren't compiled into the host.
+// The results are decoded by them without the plugin.
+func (p *Plugin) descriptorsPath() string {
+	return descriptorsPath(p.CachePath)
 }
 
-func (p *Plugin) Setup(args []string) error {
-	p.Args = args
-	return p.setup(args)
+func descriptorsPath(cachePath string) string {
+	return cachePath + ".descriptors"
 }
 
-func (p *Plugin) Scan(ctx context.Context, scanctx *ScanContext) error {
-	data, err := p.GetCache(scanctx.Commit.Hash)
+// highWaterMark is the last scanned commit of the plugin.
+// It's stored outside of the cache DB, because the cache DB has only scan results keyed by commit hash.
+type highWaterMark struct {
+	Commit string `json:"commit"`
+}
+
+func (p *Plugin) highWaterMarkPath() string {
+	return p.CachePath + ".mark.json"
+}
+
+// HighWaterMark returns the last scanned commit, or empty string if the plugin has never scanned.
+// The in-memory cache doesn't have the results of the previous runs, so it always returns empty string.
+func (p *Plugin) HighWaterMark() (string, error) {
+	if p.cacheCfg.inMemory() {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(p.highWaterMarkPath())
 	if err != nil {
-		return errors.Wrapf(err, "failed to get cache")
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "failed to read high-water mark")
+	}
+	var mark highWaterMark
+	if err := json.Unmarshal(b, &mark); err != nil {
+		return "", errors.Wrapf(err, "failed to decode high-water mark")
 	}
-	if data != nil {
-		p.Client.storeResult(data, scanctx)
+	return mark.Commit, nil
+}
+
+// SetHighWaterMark records the last scanned commit. If the results of the commits are batched,
+// it's written when they are written to the cache DB.
+func (p *Plugin) SetHighWaterMark(commitHash string) error {
+	if p.cacheCfg.inMemory() {
 		return nil
 	}
-	data, err = p.Client.Scan(ctx, scanctx)
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	if len(p.pending) > 0 {
+		p.pendingMark = commitHash
+		return nil
+	}
+	return p.writeHighWaterMark(commitHash)
+}
+
+func (p *Plugin) writeHighWaterMark(commitHash string) error {
+	if err := mkdirIfNotExists(filepath.Dir(p.highWaterMarkPath())); err != nil {
+		return errors.Wrapf(err, "failed to create directory for high-water mark")
+	}
+	b, err := json.Marshal(&highWaterMark{Commit: commitHash})
 	if err != nil {
-		return errors.Stack(err)
+		return errors.Wrapf(err, "failed to encode high-water mark")
+	}
+	if err := ioutil.WriteFile(p.highWaterMarkPath(), b, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write high-water mark")
 	}
-	if err := p.StoreCache(scanctx.Commit.Hash, data); err != nil {
+	return nil
+}
+
+func (p *Plugin) Scan(ctx context.Context, scanctx *ScanContext) error {
+	_, err := p.scan(ctx, scanctx, nil)
+	return err
+}
+
+// skipsEmptyChanges reports whether the walked commit isn't passed to the plugin, because it has no changes
+// after the ignore files are applied. The root commit is passed as the initial snapshot.
+func (p *Plugin) skipsEmptyChanges(scanctx *ScanContext) (bool, error) {
+	if !p.SkipEmptyChanges || len(scanctx.Commit.ParentHashes) == 0 {
+		return false, nil
+	}
+	if err := scanctx.prepare(p.Ignore, p.Limits); err != nil {
+		if isPluginError(err, PluginErrorSkipCommit) {
+			// the commit exceeding the limits is skipped by scan.
+			return false, nil
+		}
+		return false, errors.Stack(err)
+	}
+	return len(scanctx.Changes) == 0, nil
+}
+
+func (p *Plugin) scanOptions(worktreePath string) *scanOptions {
+	return &scanOptions{upstream: p.depNames(), worktreePath: worktreePath, dirs: p.Dirs}
+}
+
+// scan scans the commit, and reports whether the result is restored from cache.
+// The time taken by each phase is added to timing if it isn't nil.
+func (p *Plugin) scan(ctx context.Context, scanctx *ScanContext, timing *ScanTiming) (bool, error) {
+	logger := loggerFrom(ctx)
+	client, err := p.client()
+	if err != nil {
+		return false, errors.Stack(err)
+	}
+	if err := scanctx.prepare(p.Ignore, p.Limits); err != nil {
+		if isPluginError(err, PluginErrorSkipCommit) {
+			return false, p.skipCommit(ctx, client, scanctx, err, timing)
+		}
+		return false, errors.Stack(err)
+	}
+	cached, err := p.loadCache(scanctx, timing)
+	if err != nil {
+		return false, errors.Stack(err)
+	}
+	if cached {
+		logger.Debug("restored result from cache", "plugin", p.Name, "commit", scanctx.Commit.Hash)
+		return true, nil
+	}
+	logger.Debug("cache miss", "plugin", p.Name, "commit", scanctx.Commit.Hash)
+	start := time.Now()
+	if err := p.loadDependencies(scanctx, timing); err != nil {
+		if isPluginError(err, PluginErrorSkipCommit) {
+			return false, p.skipCommit(ctx, client, scanctx, err, timing)
+		}
+		return false, errors.Stack(err)
+	}
+	var worktreePath string
+	if p.Checkout {
+		dir, err := scanctx.checkout(ctx, p.scratchPath)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to checkout %s for plugin %s", scanctx.Commit.Hash, p.Name)
+		}
+		defer os.RemoveAll(dir)
+		worktreePath = dir
+	}
+	var data *treportproto.ScanResponse
+	if err := p.Retry.do(ctx, func() (e error) {
+		ctx, span := startSpan(ctx, "treport.plugin.Scan",
+			attribute.String("treport.plugin", p.Name),
+			attribute.String("treport.commit", scanctx.Commit.Hash),
+		)
+		defer func() { endSpan(span, e) }()
+		client, err := p.client()
+		if err != nil {
+			return err
+		}
+		res, err := client.scan(ctx, scanctx, p.scanOptions(worktreePath), timing)
+		if err != nil {
+			return err
+		}
+		data = res
+		return nil
+	}); err != nil {
+		if isPluginError(err, PluginErrorSkipCommit) {
+			return false, p.skipCommit(ctx, client, scanctx, err, timing)
+		}
+		if !isPluginError(err, PluginErrorFatal) {
+			if err := p.recordFailure(ctx, scanctx.Commit.Hash, err); err != nil {
+				logger.Warn("failed to record failure", "plugin", p.Name, "commit", scanctx.Commit.Hash, "error", err)
+			}
+		}
+		return false, errors.Stack(err)
+	}
+	if err := p.clearFailure(scanctx.Commit.Hash); err != nil {
+		return false, errors.Stack(err)
+	}
+	for _, warning := range data.Warnings {
+		logger.Warn(warning, "plugin", p.Name, "commit", scanctx.Commit.Hash)
+	}
+	if err := p.storeCache(scanctx.Commit.Hash, data, timing); err != nil {
+		return false, errors.Wrapf(err, "failed to store cache")
+	}
+	logger.Debug("scanned commit", "plugin", p.Name, "commit", scanctx.Commit.Hash, "elapsed", time.Since(start))
+	return false, nil
+}
+
+// skipCommit stores the marker of the commit skipped by the plugin instead of the result,
+// so the commit isn't scanned again until the cache is invalidated.
+func (p *Plugin) skipCommit(ctx context.Context, client *Client, scanctx *ScanContext, err error, timing *ScanTiming) error {
+	loggerFrom(ctx).Warn("skipped commit", "plugin", p.Name, "commit", scanctx.Commit.Hash, "error", err)
+	data := &treportproto.ScanResponse{
+		SchemaVersion: client.schemaVersion,
+		PluginVersion: client.version,
+		Skipped:       true,
+		Warnings:      []string{err.Error()},
+	}
+	if err := p.storeCache(scanctx.Commit.Hash, data, timing); err != nil {
 		return errors.Wrapf(err, "failed to store cache")
 	}
 	return nil
 }
 
+// loadCache stores the cached result of the commit to scanctx, and reports whether the compatible cache exists.
+// The commit skipped by the plugin has the cache without the result.
+func (p *Plugin) loadCache(scanctx *ScanContext, timing *ScanTiming) (bool, error) {
+	client, err := p.client()
+	if err != nil {
+		return false, errors.Stack(err)
+	}
+	data, err := p.getCache(scanctx.Commit.Hash, timing)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get cache")
+	}
+	if data == nil || !isCompatibleCache(p.SchemaPolicy, client, data) {
+		return false, nil
+	}
+	if !data.Skipped {
+		client.storeResult(data, scanctx)
+	}
+	return true, nil
+}
+
+// loadDependencies stores the results of the dependency plugins for the commit to scanctx.
+// The dependency plugins have already scanned all commits, so the results are read from their cache.
+func (p *Plugin) loadDependencies(scanctx *ScanContext, timing *ScanTiming) error {
+	for _, dep := range p.deps {
+		data, err := dep.getCache(scanctx.Commit.Hash, timing)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get result of %s", dep.Name)
+		}
+		if data == nil {
+			if quarantined, err := dep.isQuarantined(scanctx.Commit.Hash); err == nil && quarantined {
+				return ErrSkipCommit(fmt.Errorf("%s quarantined the commit", dep.Name))
+			}
+			return ErrDependencyNotFound(p.Name, dep.Name, scanctx.Commit.Hash)
+		}
+		if data.Skipped {
+			return ErrSkipCommit(fmt.Errorf("%s skipped the commit", dep.Name))
+		}
+		scanctx.storeResult(dep.Name, data)
+	}
+	return nil
+}
+
+// depNames returns the names of the plugins whose results are passed to the plugin.
+func (p *Plugin) depNames() []string {
+	names := make([]string, 0, len(p.deps))
+	for _, dep := range p.deps {
+		names = append(names, dep.Name)
+	}
+	return names
+}
+
+// isCompatibleCache reports whether the cached result can be used as the result of the plugin running by client.
+func isCompatibleCache(policy SchemaPolicy, client *Client, data *treportproto.ScanResponse) bool {
+	if policy == SchemaPolicyKeep {
+		return true
+	}
+	return data.SchemaVersion == client.schemaVersion
+}
+
 func (p *Plugin) open() (*badger.DB, error) {
-	if err := mkdirIfNotExists(filepath.Dir(p.CachePath)); err != nil {
-		return nil, errors.Wrapf(err, "failed to create directory for plugin cache")
+	if !p.cacheCfg.inMemory() {
+		if err := mkdirIfNotExists(filepath.Dir(p.CachePath)); err != nil {
+			return nil, errors.Wrapf(err, "failed to create directory for plugin cache")
+		}
+		// the cached results are decoded before the plugin publishes the descriptors.
+		if err := loadSavedDescriptors(p.descriptorsPath()); err != nil {
+			return nil, err
+		}
 	}
-	db, err := badger.Open(badger.DefaultOptions(p.CachePath))
+	db, err := badger.Open(p.cacheCfg.options(p.CachePath))
 	if err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
-func (p *Plugin) GetCache(commitID string) (*treportproto.ScanResponse, error) {
+// cachedCommits returns the number of commits which have the cache.
+// The cache DB is opened as read-only, and it returns 0 if the cache DB doesn't exist.
+func (p *Plugin) cachedCommits(commitIDs []string) (int, error) {
+	if !existsPath(p.CachePath) {
+		return 0, nil
+	}
+	db, err := badger.Open(badger.DefaultOptions(p.CachePath).WithReadOnly(true))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open cache DB")
+	}
+	defer db.Close()
+	cached := 0
+	if err := db.View(func(tx *badger.Txn) error {
+		for _, commitID := range commitIDs {
+			if _, err := tx.Get([]byte(commitID)); err != nil {
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+				return err
+			}
+			cached++
+		}
+		return nil
+	}); err != nil {
+		return 0, errors.Wrapf(err, "failed to read cache DB")
+	}
+	return cached, nil
+}
+
+// cacheDB opens the cache DB at the first call. It's called by the dependent plugins concurrently.
+func (p *Plugin) cacheDB() (*badger.DB, error) {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	if p.cacheClosed {
+		return nil, fmt.Errorf("cache of plugin %s is already closed by cleanup", p.Name)
+	}
 	if p.cache == nil {
 		cache, err := p.open()
 		if err != nil {
@@ -204,41 +989,199 @@ func (p *Plugin) GetCache(commitID string) (*treportproto.ScanResponse, error) {
 		}
 		p.cache = cache
 	}
-	var cache treportproto.ScanResponse
-	if err := p.cache.View(func(tx *badger.Txn) error {
-		item, err := tx.Get([]byte(commitID))
-		if err != nil {
+	return p.cache, nil
+}
+
+// hasCache reports whether the commit has the cache compatible with the plugin.
+func (p *Plugin) hasCache(commitID string) (bool, error) {
+	client, err := p.client()
+	if err != nil {
+		return false, errors.Stack(err)
+	}
+	data, err := p.getCache(commitID, nil)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get cache")
+	}
+	return data != nil && isCompatibleCache(p.SchemaPolicy, client, data), nil
+}
+
+func (p *Plugin) GetCache(commitID string) (*treportproto.ScanResponse, error) {
+	return p.getCache(commitID, nil)
+}
+
+func (p *Plugin) getCache(commitID string, timing *ScanTiming) (*treportproto.ScanResponse, error) {
+	start := time.Now()
+	db, err := p.cacheDB()
+	if err != nil {
+		return nil, err
+	}
+	// the results batched by storeCache aren't written to the cache DB yet.
+	p.cacheMu.Lock()
+	v, pending := p.pending[commitID]
+	p.cacheMu.Unlock()
+	if !pending {
+		if err := db.View(func(tx *badger.Txn) error {
+			item, err := tx.Get([]byte(commitID))
+			if err != nil {
+				return err
+			}
+			v, err = item.ValueCopy(nil)
 			return err
+		}); err != nil {
+			if err != badger.ErrKeyNotFound {
+				timing.addCache(start)
+				return nil, err
+			}
+			timing.addCache(start)
+			cache, err := p.getWarmCache(commitID)
+			if err != nil || cache == nil {
+				return nil, err
+			}
+			// the result is kept in the own cache, so the reports and the next runs read it without the pipeline.
+			if err := p.storeCache(commitID, cache, timing); err != nil {
+				return nil, err
+			}
+			return cache, nil
 		}
-		v, err := item.ValueCopy(nil)
+	}
+	timing.addCache(start)
+	start = time.Now()
+	defer timing.addSerialize(start)
+	var cache treportproto.ScanResponse
+	if err := proto.Unmarshal(v, &cache); err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
+
+// getWarmCache reads the result from the cache of cacheFrom. It's read-only, so the results aren't written to it.
+// If the cache is opened by another process scanning the pipeline, the results are scanned by the plugin.
+func (p *Plugin) getWarmCache(commitID string) (*treportproto.ScanResponse, error) {
+	if p.warm != nil {
+		return p.warm.getCache(commitID, nil)
+	}
+	if p.warmPath == "" || p.cacheCfg.inMemory() {
+		return nil, nil
+	}
+	p.cacheMu.Lock()
+	if !p.warmOpened {
+		// it's tried once, because the cache isn't created or unlocked until the end of the run.
+		p.warmOpened = true
+		if existsPath(p.warmPath) {
+			if db, err := badger.Open(badger.DefaultOptions(p.warmPath).WithReadOnly(true)); err == nil {
+				p.warmDB = db
+			}
+		}
+	}
+	db := p.warmDB
+	p.cacheMu.Unlock()
+	if db == nil {
+		return nil, nil
+	}
+	var v []byte
+	if err := db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get([]byte(commitID))
 		if err != nil {
 			return err
 		}
-		return proto.Unmarshal(v, &cache)
+		v, err = item.ValueCopy(nil)
+		return err
 	}); err != nil {
 		if err == badger.ErrKeyNotFound {
 			return nil, nil
 		}
 		return nil, err
 	}
+	var cache treportproto.ScanResponse
+	if err := proto.Unmarshal(v, &cache); err != nil {
+		return nil, err
+	}
 	return &cache, nil
 }
 
 func (p *Plugin) StoreCache(commitID string, cache *treportproto.ScanResponse) error {
+	return p.storeCache(commitID, cache, nil)
+}
+
+func (p *Plugin) storeCache(commitID string, cache *treportproto.ScanResponse, timing *ScanTiming) error {
+	start := time.Now()
+	if cache.Json == "" {
+		// the reports read the JSON from the cache, so it's stored even if the plugin doesn't return it.
+		if json, err := responseJSON(cache); err == nil {
+			cache.Json = json
+		}
+	}
 	b, err := proto.Marshal(cache)
+	timing.addSerialize(start)
 	if err != nil {
 		return err
 	}
-	if p.cache == nil {
-		cache, err := p.open()
-		if err != nil {
-			return errors.Wrapf(err, "failed to open cache DB")
+	start = time.Now()
+	defer timing.addCache(start)
+	if _, err := p.cacheDB(); err != nil {
+		return err
+	}
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	if err := p.saveDescriptorsLocked(cache.Name); err != nil {
+		return err
+	}
+	if p.pending == nil {
+		p.pending = map[string][]byte{}
+	}
+	p.pending[commitID] = b
+	if len(p.pending) < p.cacheCfg.writeBatch() {
+		return nil
+	}
+	return p.flushCacheLocked()
+}
+
+func (p *Plugin) saveDescriptorsLocked(name string) error {
+	if name == "" || p.cacheCfg.inMemory() {
+		return nil
+	}
+	if _, saved := p.savedTypes[name]; saved {
+		return nil
+	}
+	if err := saveDescriptors(p.descriptorsPath(), name); err != nil {
+		return err
+	}
+	if p.savedTypes == nil {
+		p.savedTypes = map[string]struct{}{}
+	}
+	p.savedTypes[name] = struct{}{}
+	return nil
+}
+
+// flushCache writes the batched results and the high-water mark. It's called at the end of the walk even if it fails,
+// so the next run continues from the results already scanned.
+func (p *Plugin) flushCache() error {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	return p.flushCacheLocked()
+}
+
+func (p *Plugin) flushCacheLocked() error {
+	if len(p.pending) == 0 || p.cache == nil {
+		return nil
+	}
+	wb := p.cache.NewWriteBatch()
+	defer wb.Cancel()
+	for commitID, b := range p.pending {
+		if err := wb.SetEntry(badger.NewEntry([]byte(commitID), b)); err != nil {
+			return err
 		}
-		p.cache = cache
 	}
-	return p.cache.Update(func(txn *badger.Txn) error {
-		return txn.SetEntry(badger.NewEntry([]byte(commitID), b))
-	})
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	p.pending = nil
+	if p.pendingMark == "" {
+		return nil
+	}
+	mark := p.pendingMark
+	p.pendingMark = ""
+	return p.writeHighWaterMark(mark)
 }
 
 type PluginVersion struct {
@@ -251,19 +1194,27 @@ type PluginVersionDB struct {
 	db *badger.DB
 }
 
+func (db *PluginVersionDB) Close() error {
+	return db.db.Close()
+}
+
 func (db *PluginVersionDB) IsUpdated(plg *Plugin) (bool, error) {
-	ver, err := db.readVersion(plg)
+	return db.isUpdated(plg.Name, plg.Client.mtime)
+}
+
+func (db *PluginVersionDB) isUpdated(name string, mtime time.Time) (bool, error) {
+	ver, err := db.readVersion(name)
 	if err != nil {
 		return false, errors.Wrapf(err, "failed to read plugin version")
 	}
 	if ver == nil {
 		return true, nil
 	}
-	return plg.Client.mtime.After(ver.LastUpdatedTime), nil
+	return mtime.After(ver.LastUpdatedTime), nil
 }
 
 func (db *PluginVersionDB) Update(plg *Plugin) error {
-	ver, err := db.readVersion(plg)
+	ver, err := db.readVersion(plg.Name)
 	if err != nil {
 		return errors.Wrapf(err, "failed to update plugin version")
 	}
@@ -279,10 +1230,20 @@ func (db *PluginVersionDB) Update(plg *Plugin) error {
 	return db.writeVersion(ver)
 }
 
-func (db *PluginVersionDB) readVersion(plg *Plugin) (*PluginVersion, error) {
+// pluginVersionKey is the key of the version of the plugin binary for the platform of the host.
+func pluginVersionKey(name string) string {
+	return platform() + "/" + name
+}
+
+// readVersion reads the version of the plugin for the platform. The version recorded by older versions,
+// which is keyed only by the name, is used until the version for the platform is written.
+func (db *PluginVersionDB) readVersion(name string) (*PluginVersion, error) {
 	var ver PluginVersion
 	if err := db.db.View(func(tx *badger.Txn) error {
-		item, err := tx.Get([]byte(plg.Name))
+		item, err := tx.Get([]byte(pluginVersionKey(name)))
+		if err == badger.ErrKeyNotFound {
+			item, err = tx.Get([]byte(name))
+		}
 		if err != nil {
 			return err
 		}
@@ -306,6 +1267,6 @@ func (db *PluginVersionDB) writeVersion(ver *PluginVersion) error {
 		return err
 	}
 	return db.db.Update(func(txn *badger.Txn) error {
-		return txn.SetEntry(badger.NewEntry([]byte(ver.Name), b))
+		return txn.SetEntry(badger.NewEntry([]byte(pluginVersionKey(ver.Name)), b))
 	})
 }
@@ -2,10 +2,13 @@ package treport
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 )
 
@@ -22,13 +25,87 @@ func mkdirIfNotExists(path string) error {
 }
 
 func mkdirForClone(repoPath string) error {
-	paths := strings.Split(repoPath, string(os.PathSeparator))
-	cloneDir := filepath.Join(append([]string{"/"}, paths[:len(paths)-1]...)...)
-	return mkdirIfNotExists(cloneDir)
+	return mkdirIfNotExists(filepath.Dir(repoPath))
 }
 
-func makeHashID(src string) string {
+// executableName returns the file name of the binary, which has .exe on Windows.
+func executableName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
+
+// platform is the OS and the architecture of the plugin binaries like linux_amd64.
+// The binaries and their records are separated by it, so the hosts of the different platforms share the mount path.
+func platform() string {
+	return runtime.GOOS + "_" + runtime.GOARCH
+}
+
+// IDScheme generates IDs of repositories, plugins and pipelines. They are used as directory names of the cache.
+type IDScheme interface {
+	// Version is prefixed to the ID, so IDs of different schemes never collide.
+	Version() int
+	ID(parts ...string) string
+}
+
+var (
+	// DefaultIDScheme is SHA-256 of length-prefixed parts.
+	DefaultIDScheme IDScheme = &sha256IDScheme{}
+	// LegacyIDScheme is SHA-1 of parts joined by ":". It's used only to migrate caches created by older versions.
+	LegacyIDScheme IDScheme = &sha1IDScheme{}
+)
+
+type sha256IDScheme struct{}
+
+func (s *sha256IDScheme) Version() int {
+	return 2
+}
+
+func (s *sha256IDScheme) ID(parts ...string) string {
+	hash := sha256.New()
+	var size [binary.MaxVarintLen64]byte
+	for _, part := range parts {
+		n := binary.PutUvarint(size[:], uint64(len(part)))
+		hash.Write(size[:n])
+		io.WriteString(hash, part)
+	}
+	return fmt.Sprintf("v%d-%x", s.Version(), hash.Sum(nil))
+}
+
+type sha1IDScheme struct{}
+
+func (s *sha1IDScheme) Version() int {
+	return 1
+}
+
+func (s *sha1IDScheme) ID(parts ...string) string {
 	hash := sha1.New()
-	io.WriteString(hash, src)
+	io.WriteString(hash, strings.Join(parts, ":"))
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
+
+func makeHashID(parts ...string) string {
+	return DefaultIDScheme.ID(parts...)
+}
+
+// migrateLegacyPath moves the directory named by legacy ID to the current one if the current one doesn't exist.
+func migrateLegacyPath(legacyPath, path string) error {
+	if legacyPath == path || existsPath(path) || !existsPath(legacyPath) {
+		return nil
+	}
+	if err := mkdirIfNotExists(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return os.Rename(legacyPath, path)
+}
+
+// isHex reports whether s has only the hexadecimal digits like the prefix of the commit hash.
+func isHex(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,650 @@
+package treport
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/treport/internal/errors"
+)
+
+// ValidationError is a problem of the config found by Validate.
+// Line and Column are available if the config is loaded from the file.
+type ValidationError struct {
+	Path    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e ValidationErrors) ErrorCode() errors.ErrorCode {
+	return errors.CodeConfig
+}
+
+type configValidator struct {
+	cfg    *Config
+	file   *ast.File
+	errors ValidationErrors
+}
+
+// Validate checks the config before any cloning starts.
+// It returns ValidationErrors which has all problems of the config.
+func (c *Config) Validate() error {
+	v := &configValidator{cfg: c}
+	if len(c.source) != 0 {
+		if file, err := parser.ParseBytes(c.source, 0); err == nil {
+			v.file = file
+		}
+	}
+	v.validate()
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return v.errors
+}
+
+func (v *configValidator) addError(path, format string, args ...interface{}) {
+	line, column := v.position(path)
+	v.errors = append(v.errors, &ValidationError{
+		Path:    path,
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// position returns the position of the nearest node of the path.
+func (v *configValidator) position(path string) (int, int) {
+	if v.file == nil {
+		return 0, 0
+	}
+	for path != "" && path != "$" {
+		if p, err := yaml.PathString(path); err == nil {
+			if node, err := p.FilterFile(v.file); err == nil && node != nil {
+				pos := node.GetToken().Position
+				return pos.Line, pos.Column
+			}
+		}
+		idx := strings.LastIndexAny(path, ".[")
+		if idx < 0 {
+			break
+		}
+		path = path[:idx]
+	}
+	return 0, 0
+}
+
+func (v *configValidator) validate() {
+	if v.cfg.Project.DiskQuota < 0 {
+		v.addError("$.project.diskQuota", "diskQuota must be positive")
+	}
+	v.validateTransport("$.project.transport", v.cfg.Project.Transport)
+	if timeout, err := v.cfg.Project.Lock.timeout(); err != nil {
+		v.addError("$.project.lock.timeout", "invalid timeout %q", v.cfg.Project.Lock.Timeout)
+	} else if timeout < 0 {
+		v.addError("$.project.lock.timeout", "timeout must be positive")
+	}
+	if rateLimit := v.cfg.Project.RateLimit; rateLimit != nil {
+		if rateLimit.Rate < 0 {
+			v.addError("$.project.rateLimit.rate", "rate must be positive")
+		}
+		if rateLimit.Burst < 0 {
+			v.addError("$.project.rateLimit.burst", "burst must be positive")
+		}
+		if rateLimit.Concurrency < 0 {
+			v.addError("$.project.rateLimit.concurrency", "concurrency must be positive")
+		}
+	}
+	pluginNames := map[string]struct{}{}
+	for _, name := range BuiltinPluginNames {
+		pluginNames[name] = struct{}{}
+	}
+	if v.cfg.Plugin != nil {
+		for i, repoCfg := range v.cfg.Plugin.Scanner {
+			pluginNames[repoCfg.Name] = struct{}{}
+			if !IsBuiltinPlugin(repoCfg.Name) {
+				v.validateRepository(fmt.Sprintf("$.plugin.scanner[%d]", i), repoCfg)
+			}
+		}
+		for i, repoCfg := range v.cfg.Plugin.Storer {
+			pluginNames[repoCfg.Name] = struct{}{}
+			if !IsBuiltinPlugin(repoCfg.Name) {
+				v.validateRepository(fmt.Sprintf("$.plugin.storer[%d]", i), repoCfg)
+			}
+		}
+		v.validateGRPC("$.plugin.grpc", v.cfg.Plugin.GRPC)
+		v.validateKeepalive("$.plugin.keepalive", v.cfg.Plugin.Keepalive)
+		v.validateStartup("$.plugin.startup", v.cfg.Plugin.Startup)
+	}
+	pipelineNames := map[string]struct{}{}
+	for i, pipelineCfg := range v.cfg.Pipelines {
+		path := fmt.Sprintf("$.pipelines[%d]", i)
+		if pipelineCfg.Name == "" {
+			v.addError(path, "pipeline name is required")
+		} else if _, exists := pipelineNames[pipelineCfg.Name]; exists {
+			v.addError(path+".name", "duplicate pipeline name %q", pipelineCfg.Name)
+		}
+		pipelineNames[pipelineCfg.Name] = struct{}{}
+		if !pipelineCfg.Strategy.Valid() {
+			v.addError(path+".strategy", "unknown strategy %q", pipelineCfg.Strategy)
+		}
+		switch pipelineCfg.DiffMode {
+		case "", DiffPrevious, DiffFirstParent, DiffMergeBase, DiffCombined:
+		default:
+			v.addError(path+".diffMode", "unknown diff mode %q", pipelineCfg.DiffMode)
+		}
+		if pipelineCfg.Strategy == Metadata && pipelineCfg.SkipEmptyChanges {
+			v.addError(path+".skipEmptyChanges", "skipEmptyChanges isn't supported by metadata strategy, whose commits have no changes")
+		}
+		if pipelineCfg.Strategy == Periodic && !pipelineCfg.Period.Valid() {
+			v.addError(path+".period", "period must be day, week or month for periodic strategy")
+		} else if pipelineCfg.Strategy != Periodic && pipelineCfg.Period != "" {
+			v.addError(path+".period", "period is supported only by periodic strategy")
+		}
+		if pipelineCfg.CacheFrom != "" {
+			fromCfg := v.cfg.pipelineConfig(pipelineCfg.CacheFrom)
+			switch {
+			case fromCfg == nil:
+				v.addError(path+".cacheFrom", "pipeline %q is not found", pipelineCfg.CacheFrom)
+			case fromCfg == pipelineCfg:
+				v.addError(path+".cacheFrom", "pipeline can't read its own cache")
+			case fromCfg.CacheFrom != "":
+				v.addError(path+".cacheFrom", "pipeline %q reads the cache of another pipeline", pipelineCfg.CacheFrom)
+			}
+		}
+		if pipelineCfg.Clock != "" && !pipelineCfg.Clock.Valid() {
+			v.addError(path+".clock", "unknown clock %q", pipelineCfg.Clock)
+		}
+		if pipelineCfg.Schedule != "" {
+			if _, err := ParseSchedule(pipelineCfg.Schedule); err != nil {
+				v.addError(path+".schedule", "%s", err)
+			}
+		}
+		if pipelineCfg.DiffWorkers < 0 {
+			v.addError(path+".diffWorkers", "diffWorkers must be positive")
+		}
+		switch pipelineCfg.Order {
+		case "", OldestFirst:
+		case NewestFirst:
+			if pipelineCfg.Strategy != AllCommit && pipelineCfg.Strategy != FirstParent {
+				v.addError(path+".order", "newestFirst is supported only by allCommit and firstParent strategies")
+			}
+		default:
+			v.addError(path+".order", "unknown order %q", pipelineCfg.Order)
+		}
+		if pipelineCfg.MaxUncached < 0 {
+			v.addError(path+".maxUncached", "maxUncached must be positive")
+		}
+		if pipelineCfg.Limits != nil {
+			if pipelineCfg.Limits.MaxSnapshotEntries < 0 {
+				v.addError(path+".limits.maxSnapshotEntries", "maxSnapshotEntries must be positive")
+			}
+			if pipelineCfg.Limits.MaxChanges < 0 {
+				v.addError(path+".limits.maxChanges", "maxChanges must be positive")
+			}
+			if pipelineCfg.Limits.MaxFileSize < 0 {
+				v.addError(path+".limits.maxFileSize", "maxFileSize must be positive")
+			}
+			if !pipelineCfg.Limits.OnExceed.valid() {
+				v.addError(path+".limits.onExceed", "unknown onExceed %q", pipelineCfg.Limits.OnExceed)
+			}
+		}
+		if pipelineCfg.Filter != nil {
+			for j, pattern := range pipelineCfg.Filter.SkipAuthors {
+				if !validBranchPattern(pattern) {
+					v.addError(fmt.Sprintf("%s.filter.skipAuthors[%d]", path, j), "malformed author pattern %q", pattern)
+				}
+			}
+			for j, pattern := range pipelineCfg.Filter.SkipMessages {
+				if _, err := regexp.Compile(pattern); err != nil {
+					v.addError(fmt.Sprintf("%s.filter.skipMessages[%d]", path, j), "invalid message pattern: %s", err)
+				}
+			}
+		}
+		if len(pipelineCfg.Repository) == 0 {
+			v.addError(path, "repository is required")
+		}
+		if pipelineCfg.Notification != nil {
+			v.validateMessages(path+".notification.messages", pipelineCfg.Notification.Messages)
+		}
+		for j, repoCfg := range pipelineCfg.Repository {
+			v.validateRepository(fmt.Sprintf("%s.repository[%d]", path, j), repoCfg)
+		}
+		stepDeps, err := pipelineCfg.StepDependencies()
+		if err != nil {
+			v.addError(path+".steps", "%s", err)
+		}
+		v.validateHooks(path, pipelineCfg.Before, pipelineCfg.After)
+		stepNames := map[string]struct{}{}
+		for j, stepCfg := range pipelineCfg.Steps {
+			stepPath := fmt.Sprintf("%s.steps[%d]", path, j)
+			if stepCfg.Name != "" {
+				if _, exists := stepNames[stepCfg.Name]; exists {
+					v.addError(stepPath+".name", "duplicate step name %q", stepCfg.Name)
+				}
+				stepNames[stepCfg.Name] = struct{}{}
+			}
+			v.validateHooks(stepPath, stepCfg.Before, stepCfg.After)
+			if !stepCfg.Stage.valid() {
+				v.addError(stepPath+".stage", "unknown stage %q", stepCfg.Stage)
+			}
+			if stepDeps != nil && stepCfg.Stage != StageAggregate {
+				for _, dep := range stepDeps[j] {
+					if pipelineCfg.Steps[dep].Stage == StageAggregate {
+						v.addError(stepPath, "step of commit stage can't depend on steps[%d] of aggregate stage, which has the result only for the last commit", dep)
+					}
+				}
+			}
+			for _, pluginExecCfg := range stepCfg.Plugins {
+				if _, exists := pluginNames[pluginExecCfg.Name]; !exists {
+					v.addError(stepPath, "plugin %q isn't defined in plugin section", pluginExecCfg.Name)
+				}
+				switch pluginExecCfg.SchemaPolicy {
+				case "", SchemaPolicyInvalidate, SchemaPolicyKeep:
+				default:
+					v.addError(stepPath, "unknown schema policy %q", pluginExecCfg.SchemaPolicy)
+				}
+				if !pluginExecCfg.OnError.valid() {
+					v.addError(stepPath, "unknown onError %q", pluginExecCfg.OnError)
+				}
+				v.validateRetry(stepPath, pluginExecCfg.Retry)
+				v.validateQuarantine(stepPath+".quarantine", pluginExecCfg.Quarantine)
+				v.validatePluginEnv(stepPath+".env", pluginExecCfg.Env)
+				v.validateSandbox(stepPath+".sandbox", pluginExecCfg.Sandbox)
+				if pluginExecCfg.Checkout && pipelineCfg.Strategy == Worktree {
+					v.addError(stepPath+".checkout", "checkout isn't supported by worktree strategy, which scans the files of the repository as they are")
+				}
+				if pipelineCfg.Strategy == Metadata {
+					if pluginExecCfg.Checkout {
+						v.addError(stepPath+".checkout", "checkout isn't supported by metadata strategy, which doesn't read the files of the commits")
+					}
+					if pluginExecCfg.Dirs {
+						v.addError(stepPath+".dirs", "dirs isn't supported by metadata strategy, which doesn't read the files of the commits")
+					}
+				}
+			}
+		}
+	}
+	for i, notificationCfg := range v.cfg.Notifications {
+		v.validateNotification(fmt.Sprintf("$.notifications[%d]", i), notificationCfg)
+	}
+	v.validateMessages("$.messages", v.cfg.Messages)
+	v.validateCache("$.cache", v.cfg.Cache)
+	if v.cfg.Webhook != nil {
+		switch {
+		case v.cfg.Webhook.SecretEnv == "":
+			v.addError("$.webhook.secret", "secret is required to verify the events")
+		case v.cfg.Webhook.Secret() == "":
+			v.addError("$.webhook.secret", "environment variable %s is not set", v.cfg.Webhook.SecretEnv)
+		}
+	}
+	for i, reportCfg := range v.cfg.Reports {
+		path := fmt.Sprintf("$.reports[%d]", i)
+		switch {
+		case reportCfg.Template == "" && reportCfg.Format == "":
+			v.addError(path, "template or format is required")
+		case reportCfg.Template != "" && reportCfg.Format != "":
+			v.addError(path+".format", "format can't be used with template")
+		case reportCfg.Format != "" && !reportCfg.Format.valid():
+			v.addError(path+".format", "unknown format %q", reportCfg.Format)
+		}
+	}
+}
+
+func (v *configValidator) validateNotification(path string, cfg *NotificationConfig) {
+	switch cfg.Type {
+	case SlackNotification, WebhookNotification:
+		if cfg.URLEnv == "" {
+			v.addError(path, "url is required")
+		} else if cfg.URL() == "" {
+			v.addError(path+".url", "environment variable %s is not set", cfg.URLEnv)
+		}
+	case EmailNotification:
+		if cfg.Email == nil {
+			v.addError(path, "email is required")
+			break
+		}
+		if cfg.Email.Addr == "" || cfg.Email.From == "" || len(cfg.Email.To) == 0 {
+			v.addError(path+".email", "addr, from and to are required")
+		}
+	default:
+		v.addError(path+".type", "unknown notification type %q", cfg.Type)
+	}
+	for j, on := range cfg.On {
+		switch on {
+		case NotifySuccess, NotifyFailure:
+		default:
+			v.addError(fmt.Sprintf("%s.on[%d]", path, j), "unknown notification event %q", on)
+		}
+	}
+}
+
+func (v *configValidator) validateMessages(path string, messages map[string]*MessageTemplates) {
+	locales := make([]string, 0, len(messages))
+	for locale := range messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	for _, locale := range locales {
+		msgs := messages[locale]
+		if msgs == nil {
+			continue
+		}
+		for _, msg := range []struct {
+			status string
+			tmpl   *MessageTemplate
+		}{
+			{status: "success", tmpl: msgs.Success},
+			{status: "failure", tmpl: msgs.Failure},
+		} {
+			if msg.tmpl == nil {
+				continue
+			}
+			for _, field := range []struct {
+				key  string
+				text string
+			}{
+				{key: "subject", text: msg.tmpl.Subject},
+				{key: "body", text: msg.tmpl.Body},
+			} {
+				if _, err := template.New(field.key).Funcs(templateFuncs).Parse(field.text); err != nil {
+					v.addError(fmt.Sprintf("%s.%s.%s.%s", path, locale, msg.status, field.key), "invalid template: %s", err)
+				}
+			}
+		}
+	}
+}
+
+func (v *configValidator) validateRetry(path string, cfg *RetryConfig) {
+	if cfg == nil {
+		return
+	}
+	if cfg.Attempts < 0 {
+		v.addError(path+".attempts", "attempts must be positive")
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		v.addError(path+".jitter", "jitter must be between 0 and 1")
+	}
+	if _, _, err := cfg.backoff(); err != nil {
+		v.addError(path, "invalid backoff: %s", err)
+	}
+}
+
+func (v *configValidator) validateQuarantine(path string, cfg *QuarantineConfig) {
+	if cfg == nil {
+		return
+	}
+	if cfg.After < 0 {
+		v.addError(path+".after", "after must be positive")
+	}
+	for idx, commit := range cfg.Commits {
+		if len(commit) < 4 || !isHex(commit) {
+			v.addError(fmt.Sprintf("%s.commits[%d]", path, idx), "commit must be the hash or its prefix of 4 characters at least")
+		}
+	}
+}
+
+func (v *configValidator) validatePluginEnv(path string, cfg *PluginEnvConfig) {
+	if cfg == nil {
+		return
+	}
+	for idx, key := range cfg.Allow {
+		if key == "" || strings.Contains(key, "=") {
+			v.addError(fmt.Sprintf("%s.allow[%d]", path, idx), "invalid environment variable name %q", key)
+		}
+	}
+	for key := range cfg.Set {
+		if key == "" || strings.Contains(key, "=") {
+			v.addError(path+".set", "invalid environment variable name %q", key)
+		}
+	}
+}
+
+func (v *configValidator) validateSandbox(path string, cfg *SandboxConfig) {
+	if cfg == nil {
+		return
+	}
+	if runtime.GOOS == "windows" {
+		v.addError(path, "sandbox isn't supported on windows")
+		return
+	}
+	if cfg.Memory < 0 {
+		v.addError(path+".memory", "memory must be positive")
+	}
+	if cfg.CPU < 0 {
+		v.addError(path+".cpu", "cpu must be positive")
+	}
+	if runtime.GOOS != "linux" && (cfg.DenyNetwork || cfg.Memory > 0 || cfg.CPU > 0) {
+		v.addError(path, "denyNetwork, memory and cpu are supported only on linux")
+	}
+}
+
+func (v *configValidator) validateTransport(path string, cfg *TransportConfig) {
+	if cfg == nil {
+		return
+	}
+	if cfg.Proxy != "" {
+		if u, err := url.Parse(cfg.Proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			v.addError(path+".proxy", "malformed proxy url %q", cfg.Proxy)
+		}
+	}
+	if cfg.CABundle != "" && !existsPath(cfg.CABundle) {
+		v.addError(path+".caBundle", "caBundle %s is not found", cfg.CABundle)
+	}
+}
+
+func (v *configValidator) validateCache(path string, cfg *CacheConfig) {
+	if cfg == nil {
+		return
+	}
+	switch cfg.Preset {
+	case "", CachePresetDefault, CachePresetLowMemory:
+	default:
+		v.addError(path+".preset", "unknown preset %q", cfg.Preset)
+	}
+	switch cfg.Compression {
+	case "", CacheCompressionNone, CacheCompressionSnappy:
+	case CacheCompressionZSTD:
+		if !y.CgoEnabled {
+			v.addError(path+".compression", "zstd requires treport built with cgo")
+		}
+	default:
+		v.addError(path+".compression", "unknown compression %q", cfg.Compression)
+	}
+	if cfg.MemTableSize < 0 {
+		v.addError(path+".memTableSize", "memTableSize must be positive")
+	}
+	if cfg.ValueThreshold < 0 {
+		v.addError(path+".valueThreshold", "valueThreshold must be positive")
+	}
+	if cfg.WriteBatch < 0 {
+		v.addError(path+".writeBatch", "writeBatch must be positive")
+	}
+	if cfg.Blob != nil && cfg.Blob.MemorySize < 0 {
+		v.addError(path+".blob.memorySize", "memorySize must be positive")
+	}
+	if cfg.Blob != nil && cfg.Blob.DiskSize < 0 {
+		v.addError(path+".blob.diskSize", "diskSize must be positive")
+	}
+	if cfg.ValueThreshold > maxCacheValueThreshold {
+		v.addError(path+".valueThreshold", "valueThreshold must be less than or equal to %d", maxCacheValueThreshold)
+	}
+	// badger writes a transaction by the batch of 15% of the memtable, so the value must fit in the batch.
+	opts := cfg.options("")
+	if int64(opts.ValueThreshold) > opts.MaxTableSize*15/100 {
+		v.addError(path+".valueThreshold", "valueThreshold must be less than 15%% of memTableSize")
+	}
+}
+
+func (v *configValidator) validateGRPC(path string, cfg *GRPCConfig) {
+	if cfg == nil {
+		return
+	}
+	if cfg.MaxMessageSize < 0 {
+		v.addError(path+".maxMessageSize", "maxMessageSize must be positive")
+	}
+	switch cfg.Compression {
+	case "", GRPCCompressionNone, GRPCCompressionGzip:
+	default:
+		v.addError(path+".compression", "unknown compression %q", cfg.Compression)
+	}
+}
+
+func (v *configValidator) validateKeepalive(path string, cfg *KeepaliveConfig) {
+	if cfg == nil {
+		return
+	}
+	if cfg.Interval != "" {
+		if interval, err := time.ParseDuration(cfg.Interval); err != nil {
+			v.addError(path+".interval", "invalid interval %q", cfg.Interval)
+		} else if interval <= 0 {
+			v.addError(path+".interval", "interval must be positive")
+		}
+	}
+	if cfg.IdleTimeout != "" {
+		if timeout, err := time.ParseDuration(cfg.IdleTimeout); err != nil {
+			v.addError(path+".idleTimeout", "invalid idleTimeout %q", cfg.IdleTimeout)
+		} else if timeout <= 0 {
+			v.addError(path+".idleTimeout", "idleTimeout must be positive")
+		}
+	}
+}
+
+func (v *configValidator) validateStartup(path string, cfg *StartupConfig) {
+	if cfg == nil {
+		return
+	}
+	for _, timeout := range []struct {
+		name  string
+		value string
+	}{
+		{"handshakeTimeout", cfg.HandshakeTimeout},
+		{"protocolTimeout", cfg.ProtocolTimeout},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(timeout.value); err != nil {
+			v.addError(path+"."+timeout.name, "invalid %s %q", timeout.name, timeout.value)
+		} else if d <= 0 {
+			v.addError(path+"."+timeout.name, "%s must be positive", timeout.name)
+		}
+	}
+}
+
+func (v *configValidator) validateRepository(path string, cfg *RepositoryConfig) {
+	if cfg.Repo != "" {
+		if _, err := ParseRepositoryURL(cfg.Repo); err != nil {
+			v.addError(path+".repo", "malformed repository url %q", cfg.Repo)
+		}
+	}
+	if !cfg.OnError.valid() {
+		v.addError(path+".onError", "unknown onError %q", cfg.OnError)
+	}
+	v.validateRetry(path+".retry", cfg.Retry)
+	if !cfg.Submodules.valid() {
+		v.addError(path+".submodules", "unknown submodules %q", cfg.Submodules)
+	}
+	if !cfg.Storage.valid() {
+		v.addError(path+".storage", "unknown storage %q", cfg.Storage)
+	}
+	if cfg.Submodules == SubmoduleFiles && cfg.isBare() {
+		v.addError(path+".submodules", "submodules files requires the worktree, but the repository is cloned without it")
+	}
+	if cfg.Keyring != "" && !existsPath(cfg.Keyring) {
+		v.addError(path+".keyring", "keyring %s is not found", cfg.Keyring)
+	}
+	if cfg.Transport != v.cfg.Project.Transport {
+		v.validateTransport(path+".transport", cfg.Transport)
+	}
+	for idx, pattern := range cfg.Branches {
+		if !validBranchPattern(pattern) {
+			v.addError(fmt.Sprintf("%s.branches[%d]", path, idx), "malformed branch pattern %q", pattern)
+		}
+	}
+	if cfg.PullRequest != nil {
+		if !cfg.PullRequest.Provider.valid() {
+			v.addError(path+".pullRequest.provider", "unknown pull request provider %q", cfg.PullRequest.Provider)
+		}
+		if !cfg.PullRequest.Refs.valid() {
+			v.addError(path+".pullRequest.refs", "unknown pull request refs %q", cfg.PullRequest.Refs)
+		}
+		if cfg.PullRequest.TokenEnv != "" && cfg.PullRequest.Token() == "" {
+			v.addError(path+".pullRequest.token", "environment variable %s is not set", cfg.PullRequest.TokenEnv)
+		}
+		if cfg.PullRequest.LinkCommits && cfg.PullRequest.provider() == PullRequestRefs {
+			v.addError(path+".pullRequest.linkCommits", "linkCommits requires github or gitlab provider")
+		}
+	}
+	if cfg.Clone != nil {
+		if cfg.Clone.BatchSize < 0 {
+			v.addError(path+".clone.batchSize", "batchSize must be positive")
+		}
+		if _, err := cfg.Clone.interval(); err != nil {
+			v.addError(path+".clone.interval", "invalid interval %q", cfg.Clone.Interval)
+		}
+		for idx, spec := range cfg.Clone.RefSpecs {
+			if err := config.RefSpec(spec).Validate(); err != nil {
+				v.addError(fmt.Sprintf("%s.clone.refspecs[%d]", path, idx), "invalid refspec %q", spec)
+			}
+		}
+	}
+	if cfg.Auth == nil {
+		return
+	}
+	if cfg.Auth.Profile != "" {
+		v.addError(path+".auth", "unknown auth profile %q", cfg.Auth.Profile)
+		return
+	}
+	if !cfg.Auth.Source.valid() {
+		v.addError(path+".auth.source", "unknown auth source %q", cfg.Auth.Source)
+		return
+	}
+	if cfg.Auth.Source != "" && cfg.Auth.Source != AuthEnv {
+		// the credentials are found on cloning.
+		return
+	}
+	for _, env := range []struct {
+		key  string
+		name string
+	}{
+		{key: "user", name: cfg.Auth.UserEnv},
+		{key: "password", name: cfg.Auth.PasswordEnv},
+	} {
+		if env.name == "" {
+			v.addError(path+".auth", "auth.%s is required", env.key)
+			continue
+		}
+		if os.Getenv(env.name) == "" {
+			v.addError(fmt.Sprintf("%s.auth.%s", path, env.key), "environment variable %s is not set", env.name)
+		}
+	}
+}
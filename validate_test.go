@@ -0,0 +1,159 @@
+package treport_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goccy/treport"
+)
+
+func TestConfigValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "treport.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+pipelines:
+  - name: size
+    strategy: allMergeCommits
+    repository:
+      - repo: github.com/goccy/go-json
+        auth:
+          user: TREPORT_TEST_UNDEFINED_USER
+          password: TREPORT_TEST_UNDEFINED_PASSWORD
+    steps:
+      - unknown
+webhook:
+  secret: ""
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	err = cfg.Validate()
+	errs, ok := err.(treport.ValidationErrors)
+	if !ok {
+		t.Fatalf("unexpected error type %T", err)
+	}
+	expected := []string{
+		"4:15: $.pipelines[0].strategy: unknown strategy \"allMergeCommits\"",
+		"6:15: $.pipelines[0].repository[0].repo: malformed repository url \"github.com/goccy/go-json\"",
+		"8:17: $.pipelines[0].repository[0].auth.user: environment variable TREPORT_TEST_UNDEFINED_USER is not set",
+		"9:21: $.pipelines[0].repository[0].auth.password: environment variable TREPORT_TEST_UNDEFINED_PASSWORD is not set",
+		"11:9: $.pipelines[0].steps[0]: plugin \"unknown\" isn't defined in plugin section",
+		"13:11: $.webhook.secret: secret is required to verify the events",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors but got %d: %s", len(expected), len(errs), errs)
+	}
+	for i, e := range expected {
+		if errs[i].Error() != e {
+			t.Errorf("expected %q but got %q", e, errs[i].Error())
+		}
+	}
+}
+
+func TestStepDependencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "treport.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+pipelines:
+  - name: summary
+    strategy: allCommit
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+      - name: loc
+        dependsOn: []
+        plugins: [ size ]
+      - name: summary
+        dependsOn: [ loc ]
+        plugins:
+          - name: size
+            args: [ -v ]
+      - size
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	steps := cfg.Pipelines[0].Steps
+	if len(steps) != 4 || steps[2].Name != "summary" || len(steps[2].Plugins) != 1 || steps[2].Plugins[0].Args[0] != "-v" {
+		t.Fatalf("unexpected steps %+v", steps)
+	}
+	deps, err := cfg.Pipelines[0].StepDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]int{{}, {}, {1}, {0, 1, 2}}
+	for i := range expected {
+		if len(deps[i]) != len(expected[i]) {
+			t.Fatalf("unexpected dependencies of steps[%d]: %v", i, deps[i])
+		}
+		for j := range expected[i] {
+			if deps[i][j] != expected[i][j] {
+				t.Fatalf("unexpected dependencies of steps[%d]: %v", i, deps[i])
+			}
+		}
+	}
+	steps[2].DependsOn = []string{"unknown"}
+	if _, err := cfg.Pipelines[0].StepDependencies(); err == nil {
+		t.Fatal("expected error for unknown step")
+	}
+}
+
+func TestAggregateStage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "treport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "treport.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+pipelines:
+  - name: trend
+    strategy: allCommit
+    repository:
+      - repo: https://github.com/goccy/go-json
+    steps:
+      - size
+      - name: trend
+        stage: aggregate
+        plugins: [ size ]
+      - size
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := treport.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stage := cfg.Pipelines[0].Steps[1].Stage; stage != treport.StageAggregate {
+		t.Fatalf("unexpected stage %q", stage)
+	}
+	errs, ok := cfg.Validate().(treport.ValidationErrors)
+	if !ok {
+		t.Fatal("expected validation errors")
+	}
+	expected := "12:9: $.pipelines[0].steps[2]: step of commit stage can't depend on steps[1] of aggregate stage, which has the result only for the last commit"
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Fatalf("unexpected errors %s", errs)
+	}
+	cfg.Pipelines[0].Steps[2].DependsOn = []string{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package treport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const zeroCommitHash = "0000000000000000000000000000000000000000"
+
+// maxWebhookBodySize is the max bytes of the event read before the signature is verified.
+// The push and pull request events are far smaller, because they have 20 commits at most.
+const maxWebhookBodySize = 5 << 20
+
+// webhookEvent is the push or pull request event which is common to GitHub and GitLab.
+type webhookEvent struct {
+	// repos are the urls of the pushed repository ( e.g. clone url and web url ).
+	repos  []string
+	branch string
+}
+
+// webhookHandler accepts push and pull request webhooks of GitHub and GitLab,
+// and triggers the scan of the pipelines which have the pushed repository.
+// The config passed to trigger has only the pipeline and the matched repositories.
+// Already scanned commits are skipped by cache, so only the pushed commits are scanned.
+// It's registered only if the webhook is configured, and the events are rejected unless they are signed with the secret.
+//
+//	POST /webhooks/github
+//	POST /webhooks/gitlab
+type webhookHandler struct {
+	cfg     *Config
+	trigger func(name string, cfg *Config) error
+}
+
+func newWebhookHandler(cfg *Config, trigger func(string, *Config) error) *webhookHandler {
+	return &webhookHandler{cfg: cfg, trigger: trigger}
+}
+
+type webhookResponse struct {
+	Pipelines []string `json:"pipelines"`
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil && len(body) == maxWebhookBodySize {
+		writeHTTPError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("body exceeds %d bytes", maxWebhookBodySize))
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("failed to read body: %w", err))
+		return
+	}
+	var event *webhookEvent
+	switch strings.TrimPrefix(r.URL.Path, "/webhooks/") {
+	case "github":
+		if !h.verifyGitHub(r, body) {
+			writeHTTPError(w, http.StatusUnauthorized, fmt.Errorf("invalid signature"))
+			return
+		}
+		event, err = parseGitHubEvent(r.Header.Get("X-GitHub-Event"), body)
+	case "gitlab":
+		if !h.verifyGitLab(r) {
+			writeHTTPError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		event, err = parseGitLabEvent(r.Header.Get("X-Gitlab-Event"), body)
+	default:
+		writeHTTPError(w, http.StatusNotFound, fmt.Errorf("%s is not found", r.URL.Path))
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	res := &webhookResponse{Pipelines: []string{}}
+	if event == nil {
+		// the event isn't a push or pull request, or it doesn't add commits.
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	for _, pipelineCfg := range h.cfg.Pipelines {
+		if !pipelineCfg.IsEnabled() {
+			continue
+		}
+		cfg, ok := h.cfg.withEventRepositories(pipelineCfg, event)
+		if !ok {
+			continue
+		}
+		if err := h.trigger(pipelineCfg.Name, cfg); err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, err)
+			return
+		}
+		res.Pipelines = append(res.Pipelines, pipelineCfg.Name)
+	}
+	if len(res.Pipelines) == 0 {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, res)
+}
+
+func (h *webhookHandler) verifyGitHub(r *http.Request, body []byte) bool {
+	secret := h.cfg.Webhook.Secret()
+	if secret == "" {
+		// the unsigned events aren't accepted, because anyone could trigger the scans.
+		return false
+	}
+	signature := strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func (h *webhookHandler) verifyGitLab(r *http.Request) bool {
+	secret := h.cfg.Webhook.Secret()
+	if secret == "" {
+		// the unsigned events aren't accepted, because anyone could trigger the scans.
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+}
+
+type gitHubRepository struct {
+	CloneURL string `json:"clone_url"`
+	HTMLURL  string `json:"html_url"`
+}
+
+func parseGitHubEvent(kind string, body []byte) (*webhookEvent, error) {
+	switch kind {
+	case "push":
+		var v struct {
+			Ref        string           `json:"ref"`
+			After      string           `json:"after"`
+			Deleted    bool             `json:"deleted"`
+			Repository gitHubRepository `json:"repository"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("failed to decode push event: %w", err)
+		}
+		if v.Deleted || v.After == zeroCommitHash || !strings.HasPrefix(v.Ref, "refs/heads/") {
+			return nil, nil
+		}
+		return &webhookEvent{
+			repos:  []string{v.Repository.CloneURL, v.Repository.HTMLURL},
+			branch: strings.TrimPrefix(v.Ref, "refs/heads/"),
+		}, nil
+	case "pull_request":
+		var v struct {
+			Action     string           `json:"action"`
+			Repository gitHubRepository `json:"repository"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("failed to decode pull request event: %w", err)
+		}
+		switch v.Action {
+		case "opened", "reopened", "synchronize":
+		default:
+			return nil, nil
+		}
+		return &webhookEvent{
+			repos: []string{v.Repository.CloneURL, v.Repository.HTMLURL},
+		}, nil
+	}
+	return nil, nil
+}
+
+type gitLabProject struct {
+	GitHTTPURL string `json:"git_http_url"`
+	WebURL     string `json:"web_url"`
+}
+
+func parseGitLabEvent(kind string, body []byte) (*webhookEvent, error) {
+	switch kind {
+	case "Push Hook":
+		var v struct {
+			Ref     string        `json:"ref"`
+			After   string        `json:"after"`
+			Project gitLabProject `json:"project"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("failed to decode push event: %w", err)
+		}
+		if v.After == zeroCommitHash || !strings.HasPrefix(v.Ref, "refs/heads/") {
+			return nil, nil
+		}
+		return &webhookEvent{
+			repos:  []string{v.Project.GitHTTPURL, v.Project.WebURL},
+			branch: strings.TrimPrefix(v.Ref, "refs/heads/"),
+		}, nil
+	case "Merge Request Hook":
+		var v struct {
+			ObjectAttributes struct {
+				Action string `json:"action"`
+			} `json:"object_attributes"`
+			Project gitLabProject `json:"project"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("failed to decode merge request event: %w", err)
+		}
+		switch v.ObjectAttributes.Action {
+		case "open", "reopen", "update":
+		default:
+			return nil, nil
+		}
+		return &webhookEvent{
+			repos: []string{v.Project.GitHTTPURL, v.Project.WebURL},
+		}, nil
+	}
+	return nil, nil
+}
+
+// matchRepository reports whether the event is pushed to the repository.
+// Pushes to the skipped branches or the other branch than the configured one are ignored.
+func (e *webhookEvent) matchRepository(cfg *RepositoryConfig) bool {
+	matched := false
+	for _, repo := range e.repos {
+		if repo != "" && normalizeRepoURL(repo) == normalizeRepoURL(cfg.Repo) {
+			matched = true
+			break
+		}
+	}
+	if !matched || e.branch == "" {
+		return matched
+	}
+	if cfg.Branch != "" && cfg.Branch != e.branch {
+		return false
+	}
+	return !cfg.IsSkippedBranch(e.branch)
+}
+
+// normalizeRepoURL returns the url without the scheme, so the https url of the event matches the ssh url of the config.
+func normalizeRepoURL(url string) string {
+	if u, err := ParseRepositoryURL(url); err == nil {
+		return strings.ToLower(u.Host + "/" + u.Path)
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git"))
+}
+
+// withEventRepositories returns the copy of the config which has only the pipeline and the repositories matched to the event.
+func (c *Config) withEventRepositories(pipelineCfg *PipelineConfig, event *webhookEvent) (*Config, bool) {
+	repos := []*RepositoryConfig{}
+	for _, repoCfg := range pipelineCfg.Repository {
+		if event.matchRepository(repoCfg) {
+			repos = append(repos, repoCfg)
+		}
+	}
+	if len(repos) == 0 {
+		return nil, false
+	}
+	p := *pipelineCfg
+	p.Repository = repos
+	return c.selectPipelines(&p), true
+}
@@ -0,0 +1,186 @@
+package treport
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/goccy/treport/internal/errors"
+	treportproto "github.com/goccy/treport/proto"
+)
+
+// WorkingTree calls cb with the ScanContext of the working tree including the staged and unstaged changes relative to HEAD.
+// Commit of the context isn't a real commit. Its hash is derived from HEAD and the changes,
+// so the results of the same working tree are restored from the cache.
+func (r *Repository) WorkingTree(ctx context.Context, cb func(*ScanContext) error) error {
+	wt, err := r.Worktree()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get worktree")
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get status of worktree")
+	}
+	snapshot := &Snapshot{}
+	var parentHashes []string
+	head, err := r.Head()
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return errors.Wrapf(err, "failed to get head")
+	}
+	if err == nil {
+		commit, err := r.CommitObject(head.Hash())
+		if err != nil {
+			return errors.Wrapf(err, "failed to get commit object")
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return errors.Wrapf(err, "failed to get tree")
+		}
+		snapshot, err = r.snapshot(ctx, tree)
+		if err != nil {
+			return errors.Wrapf(err, "failed to convert snapshot")
+		}
+		parentHashes = []string{head.Hash().String()}
+	}
+
+	paths := make([]string, 0, len(status))
+	for path := range status {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	headFiles := map[string]*File{}
+	for _, entry := range snapshot.Entries {
+		headFiles[entry.Name] = entry
+	}
+	root := wt.Filesystem.Root()
+	changes := Changes{}
+	changedFiles := map[string]*File{}
+	idParts := append([]string{}, parentHashes...)
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		to, isDir, err := worktreeFile(root, path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+		if isDir {
+			// the submodule is scanned at the commit recorded in HEAD.
+			continue
+		}
+		from := headFiles[path]
+		var action ActionType
+		switch {
+		case from == nil && to == nil:
+			continue
+		case from == nil:
+			action = Added
+		case to == nil:
+			action = Deleted
+		case from.Hash == to.Hash && from.Mode == to.Mode:
+			// staged and then reverted in the working tree.
+			continue
+		default:
+			action = Updated
+		}
+		changes = append(changes, newChange(from, to, action))
+		changedFiles[path] = to
+		idParts = append(idParts, path)
+		if to != nil {
+			idParts = append(idParts, to.Hash)
+		}
+	}
+
+	entries := make([]*File, 0, len(snapshot.Entries))
+	for _, entry := range snapshot.Entries {
+		if to, exists := changedFiles[entry.Name]; exists {
+			if to != nil {
+				entries = append(entries, to)
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	for _, change := range changes {
+		if change.Action == Added {
+			entries = append(entries, change.To)
+		}
+	}
+
+	signature := &Signature{When: time.Now()}
+	if r.gitCfg != nil {
+		signature.Name = r.gitCfg.User.Name
+		signature.Email = r.gitCfg.User.Email
+	}
+	hash := makeHashID(idParts...)
+	scanctx := &ScanContext{
+		Commit: &Commit{
+			Hash:         hash,
+			Author:       signature,
+			Committer:    signature,
+			ParentHashes: parentHashes,
+		},
+		Snapshot:     &Snapshot{Hash: hash, Entries: entries},
+		Changes:      changes,
+		Data:         map[string]*treportproto.ScanResponse{},
+		pluginToType: map[string]string{},
+	}
+	if err := cb(scanctx); err != nil {
+		return errors.Stack(err)
+	}
+	return nil
+}
+
+// worktreeFile returns the file at the path in the working tree, and reports whether the path is a directory like a submodule.
+// It returns nil if the file doesn't exist.
+func worktreeFile(root, path string) (*File, bool, error) {
+	fullPath := filepath.Join(root, filepath.FromSlash(path))
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if info.IsDir() {
+		return nil, true, nil
+	}
+	mode, err := filemode.NewFromOSFileMode(info.Mode())
+	if err != nil {
+		return nil, false, err
+	}
+	var content []byte
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return nil, false, err
+		}
+		content = []byte(target)
+	} else {
+		content, err = ioutil.ReadFile(fullPath)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	file := &File{
+		Name: path,
+		Mode: FileMode(mode),
+		Size: int64(len(content)),
+		Hash: plumbing.ComputeHash(plumbing.BlobObject, content).String(),
+		Type: toFileType(mode),
+	}
+	if file.Type == RegularFile {
+		file.IsBinary, err = binary.IsBinary(bytes.NewReader(content))
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return file, false, nil
+}